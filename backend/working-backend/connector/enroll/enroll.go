@@ -2,151 +2,180 @@ package enroll
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"os"
+	"log"
 	"time"
 
+	"connector/internal/config"
+	"connector/internal/failover"
 	"connector/internal/tlsutil"
+	"controller/enrolloutput"
+	"controller/enrollresponse"
 	controllerpb "controller/gen/controllerpb"
+	"controller/spiffeid"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // Config controls enrollment behavior.
 type Config struct {
-	ControllerAddr string
-	ConnectorID    string
-	TrustDomain    string
-	Token          string
-	PrivateIP      string
-	Version        string
+	// ControllerAddrs are tried in order with failover (CONTROLLER_ADDR).
+	ControllerAddrs []string
+	ConnectorID     string
+	TrustDomain     string
+	Token           string
+	PrivateIP       string
+	Version         string
+	DNSNames        []string
+
+	// BootstrapAddrs are the controllers' enrollment-only listeners
+	// (CONTROLLER_BOOTSTRAP_ADDR); empty enrolls via ControllerAddrs.
+	BootstrapAddrs []string
+	// ControllerID, when set, is the exact SPIFFE ID the controller must
+	// present (EXPECTED_CONTROLLER_SPIFFE_ID).
+	ControllerID string
+	// ExtraSANs are requested at enrollment and renewal
+	// (CONNECTOR_EXTRA_SANS).
+	ExtraSANs ExtraSANs
+	// MetadataSource selects the instance metadata service queried for
+	// provisioning metadata at enrollment (CONNECTOR_IMDS).
+	MetadataSource string
+	// AttestationType selects the signed identity document sent at
+	// enrollment (CONNECTOR_ATTESTATION); empty sends none.
+	AttestationType string
+	// KeyAlgorithm is the workload key type (KEY_ALGORITHM).
+	KeyAlgorithm string
+	// CAPath is the controller CA file (CONTROLLER_CA_PATH), used when no
+	// CONTROLLER_CA credential is provided.
+	CAPath string
+	// BootstrapCertPath and BootstrapKeyPath name the client certificate
+	// presented at enrollment (BOOTSTRAP_CERT_PATH, BOOTSTRAP_KEY_PATH);
+	// empty presents none.
+	BootstrapCertPath string
+	BootstrapKeyPath  string
+	// ApprovalMode is set when the controller enrolls by operator approval
+	// (ENROLL_MODE=approval), in which case no token is required and a
+	// pending enrollment is retried every PollInterval.
+	ApprovalMode bool
+	PollInterval time.Duration
+	// ResponseMaxSkew bounds how far the controller's server_time may be
+	// from the local clock (ENROLL_RESPONSE_MAX_SKEW).
+	ResponseMaxSkew time.Duration
 }
 
-// Run performs one-time connector enrollment with the controller.
-func Run() error {
-	cfg, err := ConfigFromEnvEnroll()
+// Run performs one-time connector enrollment with the controller. args are
+// the enroll subcommand flags (see enrolloutput.ParseFlags).
+func Run(args []string, c *config.Config) error {
+	out, err := enrolloutput.ParseFlags(args, c.OutputPassword, ReadCredential)
 	if err != nil {
 		return err
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	cert, _, caPEM, spiffeID, err := Enroll(ctx, cfg)
+	cfg, err := NewConfig(c)
 	if err != nil {
 		return err
 	}
+	cfg.Token = c.EnrollmentToken
+	if cfg.Token == "" {
+		if cfg.Token, err = ReadCredential("ENROLLMENT_TOKEN"); err != nil {
+			return err
+		}
+	}
+	// An attested or bootstrap-certificate enrollment may not need a
+	// token; the controller decides.
+	if cfg.Token == "" && !cfg.TokenOptional() {
+		return fmt.Errorf("ENROLLMENT_TOKEN is not set")
+	}
 
-	_ = cert
-	_ = caPEM
-	fmt.Printf("Enrolled connector with SPIFFE ID: %s\n", spiffeID)
-	return nil
-}
-
-// ConfigFromEnvEnroll builds Config for enroll mode.
-func ConfigFromEnvEnroll() (Config, error) {
-	controllerAddr := os.Getenv("CONTROLLER_ADDR")
-	connectorID := os.Getenv("CONNECTOR_ID")
-	trustDomain := os.Getenv("TRUST_DOMAIN")
-	token := os.Getenv("ENROLLMENT_TOKEN")
-	if trustDomain == "" {
-		trustDomain = "mycorp.internal"
+	timeout := 15 * time.Second
+	if cfg.ApprovalMode {
+		// Leave room for an operator to approve the pending request.
+		timeout = 30 * time.Minute
 	}
-	trustDomain = normalizeTrustDomain(trustDomain)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if controllerAddr == "" {
-		return Config{}, fmt.Errorf("CONTROLLER_ADDR is not set")
+	cert, certPEM, caPEM, spiffeID, err := Enroll(ctx, cfg)
+	if err != nil {
+		return err
 	}
-	if connectorID == "" {
-		return Config{}, fmt.Errorf("CONNECTOR_ID is not set")
+
+	if err := out.Write(cert, certPEM, caPEM); err != nil {
+		return fmt.Errorf("write enrollment output: %w", err)
 	}
-	if token == "" {
-		cred, err := ReadCredential("ENROLLMENT_TOKEN")
-		if err != nil {
-			return Config{}, err
-		}
-		token = cred
+	if out.ToStdout() {
+		log.Printf("Enrolled connector with SPIFFE ID: %s", spiffeID)
+		return nil
 	}
-	if token == "" {
-		return Config{}, fmt.Errorf("ENROLLMENT_TOKEN is not set")
+	if out.Dir != "" {
+		log.Printf("wrote %s identity to %s", out.Format, out.Dir)
 	}
+	fmt.Printf("Enrolled connector with SPIFFE ID: %s\n", spiffeID)
+	return nil
+}
 
-	privateIP, err := ResolvePrivateIP(controllerAddr)
+// NewConfig builds Config from the connector configuration. Token is left
+// empty; the caller supplies it.
+func NewConfig(c *config.Config) (Config, error) {
+	controllerID, err := ExpectedControllerID(c.ExpectedControllerID, c.TrustDomain)
 	if err != nil {
 		return Config{}, err
 	}
-
-	version := ResolveVersion()
-
-	return Config{
-		ControllerAddr: controllerAddr,
-		ConnectorID:    connectorID,
-		TrustDomain:    trustDomain,
-		Token:          token,
-		PrivateIP:      privateIP,
-		Version:        version,
-	}, nil
-}
-
-// ConfigFromEnvRun builds Config for run mode.
-func ConfigFromEnvRun() (Config, error) {
-	controllerAddr := os.Getenv("CONTROLLER_ADDR")
-	connectorID := os.Getenv("CONNECTOR_ID")
-	trustDomain := os.Getenv("TRUST_DOMAIN")
-	if trustDomain == "" {
-		trustDomain = "mycorp.internal"
-	}
-	trustDomain = normalizeTrustDomain(trustDomain)
-
-	if controllerAddr == "" {
-		return Config{}, fmt.Errorf("CONTROLLER_ADDR is not set")
-	}
-	if connectorID == "" {
-		return Config{}, fmt.Errorf("CONNECTOR_ID is not set")
+	extraSANs, err := ResolveExtraSANs(c.ExtraSANs)
+	if err != nil {
+		return Config{}, err
 	}
-
-	privateIP, err := ResolvePrivateIP(controllerAddr)
+	privateIP, err := ResolvePrivateIP(c.ControllerAddrs, c.PrivateIP, c.IPFamily)
 	if err != nil {
 		return Config{}, err
 	}
 
-	version := ResolveVersion()
-
 	return Config{
-		ControllerAddr: controllerAddr,
-		ConnectorID:    connectorID,
-		TrustDomain:    trustDomain,
-		PrivateIP:      privateIP,
-		Version:        version,
+		ControllerAddrs: c.ControllerAddrs,
+		BootstrapAddrs:  c.BootstrapAddrs,
+		ConnectorID:     c.ConnectorID,
+		TrustDomain:     c.TrustDomain,
+		ControllerID:    controllerID,
+		PrivateIP:       privateIP,
+		Version:         ResolveVersion(c.Version),
+		DNSNames:        c.DNSNames,
+		ExtraSANs:       extraSANs,
+		MetadataSource:  c.MetadataSource,
+
+		AttestationType: c.AttestationType,
+		KeyAlgorithm:    c.KeyAlgorithm,
+		CAPath:          c.CAPath,
+
+		BootstrapCertPath: c.BootstrapCertPath,
+		BootstrapKeyPath:  c.BootstrapKeyPath,
+		ApprovalMode:      c.ApprovalMode,
+		PollInterval:      c.PollInterval,
+		ResponseMaxSkew:   c.ResponseMaxSkew,
 	}, nil
 }
 
+// TokenOptional reports whether enrollment may proceed without a token:
+// the controller then authorizes by approval, attestation or bootstrap
+// certificate.
+func (c Config) TokenOptional() bool {
+	return c.ApprovalMode || c.AttestationType != "" || c.BootstrapCertPath != ""
+}
+
 // Enroll performs enrollment and returns the issued workload certificate.
 func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, string, error) {
 	// ---- generate key pair (in-memory only) ----
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	privKey, pubPEM, err := GenerateKey(cfg.KeyAlgorithm)
 	if err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to generate key pair: %w", err)
 	}
 
-	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
-	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to marshal public key: %w", err)
-	}
-
-	pubPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubDER,
-	})
-
-	localCAPEM, err := loadExplicitCA()
+	localCAPEM, err := loadExplicitCA(cfg.CAPath)
 	if err != nil {
 		return tls.Certificate{}, nil, nil, "", err
 	}
@@ -160,32 +189,60 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		MinVersion: tls.VersionTLS13,
 		RootCAs:    rootPool,
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, cfg.TrustDomain, "controller")
+			return tlsutil.VerifyControllerSPIFFE(rawCerts, verifiedChains, cfg.TrustDomain, cfg.ControllerID)
 		},
 	}
+	if cfg.BootstrapCertPath != "" {
+		bootstrapCert, err := tls.LoadX509KeyPair(cfg.BootstrapCertPath, cfg.BootstrapKeyPath)
+		if err != nil {
+			return tls.Certificate{}, nil, nil, "", fmt.Errorf("load bootstrap certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{bootstrapCert}
+	}
 
-	// ---- connect to controller ----
-	conn, err := grpc.DialContext(
-		ctx,
-		cfg.ControllerAddr,
-		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
-	)
+	nonce, err := enrollresponse.NewNonce()
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to connect to controller: %w", err)
+		return tls.Certificate{}, nil, nil, "", err
 	}
-	defer conn.Close()
-
-	client := controllerpb.NewEnrollmentServiceClient(conn)
-
-	resp, err := client.EnrollConnector(ctx, &controllerpb.EnrollRequest{
+	attestation, err := ResolveAttestation(ctx, cfg.AttestationType)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, "", err
+	}
+	req := &controllerpb.EnrollRequest{
 		Id:        cfg.ConnectorID,
 		PublicKey: pubPEM,
 		Token:     cfg.Token,
 		PrivateIp: cfg.PrivateIP,
 		Version:   cfg.Version,
+		DnsNames:  cfg.DNSNames,
+		Nonce:     nonce,
+
+		ExtraUris:      cfg.ExtraSANs.URIs,
+		EmailAddresses: cfg.ExtraSANs.Emails,
+
+		Metadata:    ResolveMetadata(ctx, cfg.MetadataSource),
+		Attestation: attestation,
+	}
+
+	// ---- connect to controller ----
+	addrs := cfg.ControllerAddrs
+	if len(cfg.BootstrapAddrs) > 0 {
+		addrs = cfg.BootstrapAddrs
+	}
+	var resp *controllerpb.EnrollResponse
+	err = failover.New(addrs).Do(ctx, func(addr string) error {
+		resp, err = enrollAt(ctx, addr, tlsConfig, req, cfg.PollInterval)
+		if failover.Retryable(err) && len(addrs) > 1 {
+			log.Printf("controller %s unavailable for enrollment, trying the next address: %v", addr, err)
+		}
+		return err
 	})
 	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("enrollment RPC failed: %w", err)
+		return tls.Certificate{}, nil, nil, "", explainEnrollError(err)
+	}
+
+	if err := enrollresponse.Check(resp, nonce, localCAPEM, cfg.ResponseMaxSkew); err != nil {
+		return tls.Certificate{}, nil, nil, "", err
 	}
 
 	if len(resp.Certificate) == 0 {
@@ -210,7 +267,8 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to parse issued certificate: %w", err)
 	}
 
-	if len(cert.URIs) != 1 {
+	uri, err := spiffeid.URIOf(cert)
+	if err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("issued certificate must contain exactly one SPIFFE ID")
 	}
 
@@ -219,5 +277,52 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		PrivateKey:  privKey,
 	}
 
-	return workloadCert, resp.Certificate, resp.CaCertificate, cert.URIs[0].String(), nil
+	return workloadCert, resp.Certificate, resp.CaCertificate, uri.String(), nil
+}
+
+// enrollAt sends req to the controller at addr. The same key pair is reused
+// while polling so that the operator's approval stays bound to the public
+// key they reviewed.
+func enrollAt(ctx context.Context, addr string, tlsConfig *tls.Config, req *controllerpb.EnrollRequest, pollInterval time.Duration) (*controllerpb.EnrollResponse, error) {
+	conn, err := grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		failover.DialOption(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to controller: %w", err)
+	}
+	defer conn.Close()
+
+	client := controllerpb.NewEnrollmentServiceClient(conn)
+	for {
+		resp, err := client.EnrollConnector(ctx, req)
+		if !isPendingApproval(err) {
+			return resp, err
+		}
+		log.Printf("enrollment pending operator approval, retrying in %s", pollInterval)
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("enrollment not approved: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// isPendingApproval reports whether err is the controller's "pending
+// approval" response from ENROLL_MODE=approval.
+func isPendingApproval(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetReason() == "PENDING_APPROVAL" {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,92 @@
+package enroll
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// enrollError is a rejected EnrollConnector call together with what the
+// operator provisioning the connector should do about it.
+type enrollError struct {
+	guidance string
+	err      error
+}
+
+func (e *enrollError) Error() string {
+	return fmt.Sprintf("%s (controller: %s)", e.guidance, status.Convert(e.err).Message())
+}
+
+func (e *enrollError) Unwrap() error { return e.err }
+
+// explainEnrollError maps an EnrollConnector error to actionable guidance.
+// Errors that are not gRPC statuses, or that have no guidance, are returned
+// wrapped as before.
+func explainEnrollError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Errorf("enrollment RPC failed: %w", err)
+	}
+	guidance := enrollGuidance(st)
+	if guidance == "" {
+		return fmt.Errorf("enrollment RPC failed: %w", err)
+	}
+	return &enrollError{guidance: guidance, err: err}
+}
+
+func enrollGuidance(st *status.Status) string {
+	msg := st.Message()
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetReason() != "" {
+			msg = info.GetReason() + ": " + msg
+		}
+	}
+	switch st.Code() {
+	case codes.PermissionDenied:
+		switch {
+		case strings.Contains(msg, "invalid enrollment token"):
+			return "Enrollment token was rejected; it may be expired, already used or issued for a different CONNECTOR_ID. Generate a new one with POST /api/admin/tokens and set ENROLLMENT_TOKEN"
+		case strings.Contains(msg, "enrollment rejected"):
+			return "An operator rejected this enrollment request. Check with the controller operator before retrying"
+		case strings.Contains(msg, "attestation rejected"):
+			return "The controller could not verify this host's identity document. Check CONNECTOR_ATTESTATION and the controller's ENROLL_ATTESTOR settings"
+		default:
+			return "The controller refused this enrollment. Check CONNECTOR_ID and ENROLLMENT_TOKEN"
+		}
+	case codes.InvalidArgument:
+		switch {
+		case strings.Contains(msg, "missing private ip"):
+			return "The connector did not report a private IP. Set CONNECTOR_PRIVATE_IP"
+		case strings.Contains(msg, "missing version"):
+			return "The connector did not report a version. Set CONNECTOR_VERSION"
+		case strings.Contains(msg, "missing enrollment token"):
+			return "No enrollment token was sent. Set ENROLLMENT_TOKEN, or ENROLL_MODE=approval if the controller enrolls by approval"
+		case strings.Contains(msg, "public key"):
+			return "The controller does not accept this key type. Set KEY_ALGORITHM to ecdsa or ed25519"
+		case strings.Contains(msg, "extra san"):
+			return "The controller rejected the requested extra SANs. Check CONNECTOR_EXTRA_SANS against the controller's EXTRA_SAN_POLICY"
+		case strings.Contains(msg, "dns name"):
+			return "The controller rejected the requested DNS names. Check CONNECTOR_DNS_NAMES against the controller's ALLOWED_DNS_SUFFIXES"
+		default:
+			return "The controller rejected the enrollment request. Check CONNECTOR_ID, CONNECTOR_PRIVATE_IP, CONNECTOR_VERSION and CONNECTOR_DNS_NAMES"
+		}
+	case codes.ResourceExhausted:
+		return "The controller is not issuing certificates right now (issuance limit or daily quota reached). Retry later, or ask the operator to raise the limit"
+	case codes.FailedPrecondition:
+		return "The controller cannot enroll connectors in its current mode. Check that ENROLL_MODE matches the controller's"
+	case codes.Unauthenticated:
+		return "The controller requires a client certificate on this address. Point CONTROLLER_BOOTSTRAP_ADDR at the controller's enrollment listener"
+	case codes.Unavailable:
+		return "The controller is unreachable. Check CONTROLLER_ADDR, network access and that CONTROLLER_CA matches the controller's CA"
+	case codes.DeadlineExceeded:
+		return "Enrollment timed out. Check that the controller is reachable at CONTROLLER_ADDR"
+	case codes.Unimplemented:
+		return "The controller does not support connector enrollment at this address. Check CONTROLLER_ADDR and the controller version"
+	case codes.Internal:
+		return "The controller failed to issue a certificate. Check the controller logs"
+	}
+	return ""
+}
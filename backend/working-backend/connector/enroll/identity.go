@@ -0,0 +1,226 @@
+package enroll
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	identityDirName = "identity"
+	identityOldName = "identity.old"
+	identityTmpGlob = ".identity-*"
+
+	identityCertFile = "cert.pem"
+	identityKeyFile  = "key.pem"
+	identityCAFile   = "ca.pem"
+)
+
+var (
+	// ErrNoIdentity means no identity has been persisted yet.
+	ErrNoIdentity = errors.New("no persisted identity")
+	// ErrCorruptIdentity means an identity exists on disk but is incomplete
+	// or unreadable. The bad files are moved aside before it is returned.
+	ErrCorruptIdentity = errors.New("corrupt persisted identity")
+)
+
+// Identity is a workload certificate, its private key and the internal CA.
+type Identity struct {
+	Cert    tls.Certificate
+	CertPEM []byte
+	CAPEM   []byte
+	Leaf    *x509.Certificate
+}
+
+// PersistIdentity writes cert, key and CA into a fresh staging directory
+// under stateDir and renames it into place, so readers never observe a mix
+// of old and new files.
+func PersistIdentity(stateDir string, certPEM []byte, key crypto.Signer, caPEM []byte) error {
+	keyPEM, err := marshalKeyPEM(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(stateDir, identityTmpGlob)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{identityCertFile, certPEM},
+		{identityKeyFile, keyPEM},
+		{identityCAFile, caPEM},
+	}
+	for _, f := range files {
+		if err := writeFileSync(filepath.Join(tmp, f.name), f.data); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(tmp); err != nil {
+		return err
+	}
+
+	current := filepath.Join(stateDir, identityDirName)
+	old := filepath.Join(stateDir, identityOldName)
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(current, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, current); err != nil {
+		return err
+	}
+	if err := syncDir(stateDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// WriteIdentityBundle writes the certificate, private key and CA as one PEM
+// file at path, for tools that take a single identity file. The file is
+// written beside path and renamed over it, so readers never see a partial
+// bundle.
+func WriteIdentityBundle(path string, certPEM []byte, key crypto.Signer, caPEM []byte) error {
+	keyPEM, err := marshalKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	bundle := make([]byte, 0, len(certPEM)+len(keyPEM)+len(caPEM))
+	bundle = append(bundle, certPEM...)
+	bundle = append(bundle, keyPEM...)
+	bundle = append(bundle, caPEM...)
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := writeFileSync(tmp.Name(), bundle); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// LoadIdentity reads the identity persisted under stateDir. It returns
+// ErrNoIdentity when nothing has been persisted, and ErrCorruptIdentity
+// (after moving the bad directory aside) when files are missing or invalid.
+// An interrupted PersistIdentity is recovered from the previous identity.
+func LoadIdentity(stateDir string) (*Identity, error) {
+	cleanupStaging(stateDir)
+
+	current := filepath.Join(stateDir, identityDirName)
+	old := filepath.Join(stateDir, identityOldName)
+	if _, err := os.Stat(current); os.IsNotExist(err) {
+		if _, err := os.Stat(old); err != nil {
+			return nil, ErrNoIdentity
+		}
+		log.Printf("identity: recovering previous identity after interrupted write")
+		if err := os.Rename(old, current); err != nil {
+			return nil, fmt.Errorf("recover identity: %w", err)
+		}
+	}
+
+	id, err := readIdentity(current)
+	if err != nil {
+		aside := fmt.Sprintf("%s.corrupt-%d", current, time.Now().Unix())
+		if renameErr := os.Rename(current, aside); renameErr != nil {
+			log.Printf("identity: failed to move corrupt identity aside: %v", renameErr)
+		} else {
+			log.Printf("identity: moved corrupt identity to %s", aside)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrCorruptIdentity, err)
+	}
+	return id, nil
+}
+
+func readIdentity(dir string) (*Identity, error) {
+	var missing []string
+	read := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || len(data) == 0 {
+			missing = append(missing, name)
+			return nil
+		}
+		return data
+	}
+	certPEM := read(identityCertFile)
+	keyPEM := read(identityKeyFile)
+	caPEM := read(identityCAFile)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing or empty %s", strings.Join(missing, ", "))
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("cert/key: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse cert: %w", err)
+	}
+	if block, _ := pem.Decode(caPEM); block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid CA PEM")
+	}
+	return &Identity{Cert: cert, CertPEM: certPEM, CAPEM: caPEM, Leaf: leaf}, nil
+}
+
+func cleanupStaging(stateDir string) {
+	matches, _ := filepath.Glob(filepath.Join(stateDir, identityTmpGlob))
+	for _, m := range matches {
+		_ = os.RemoveAll(m)
+	}
+}
+
+func marshalKeyPEM(key crypto.Signer) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,175 @@
+package enroll
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testIdentity returns a self-signed certificate, its key and the same
+// certificate as the CA PEM.
+func testIdentity(t *testing.T) ([]byte, crypto.Signer, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "identity test"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return certPEM, key, certPEM
+}
+
+func TestPersistAndLoadIdentity(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadIdentity(dir); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("LoadIdentity of an empty state dir = %v, want ErrNoIdentity", err)
+	}
+
+	certPEM, key, caPEM := testIdentity(t)
+	if err := PersistIdentity(dir, certPEM, key, caPEM); err != nil {
+		t.Fatalf("PersistIdentity: %v", err)
+	}
+	id, err := LoadIdentity(dir)
+	if err != nil {
+		t.Fatalf("LoadIdentity: %v", err)
+	}
+	if string(id.CertPEM) != string(certPEM) || string(id.CAPEM) != string(caPEM) || id.Leaf == nil {
+		t.Fatal("loaded identity differs from the persisted one")
+	}
+
+	// Overwriting leaves only the new identity behind.
+	certPEM2, key2, _ := testIdentity(t)
+	if err := PersistIdentity(dir, certPEM2, key2, caPEM); err != nil {
+		t.Fatalf("PersistIdentity over an existing identity: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != identityDirName {
+		t.Fatalf("state dir holds %v, want only %s", entries, identityDirName)
+	}
+	if id, err := LoadIdentity(dir); err != nil || string(id.CertPEM) != string(certPEM2) {
+		t.Fatalf("LoadIdentity after overwrite = %v", err)
+	}
+}
+
+func TestLoadIdentityRecoversInterruptedWrite(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, key, caPEM := testIdentity(t)
+	if err := PersistIdentity(dir, certPEM, key, caPEM); err != nil {
+		t.Fatal(err)
+	}
+	// A write interrupted between moving the current identity aside and
+	// renaming the staged one into place leaves identity.old and a staging
+	// directory.
+	if err := os.Rename(filepath.Join(dir, identityDirName), filepath.Join(dir, identityOldName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".identity-123"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := LoadIdentity(dir)
+	if err != nil {
+		t.Fatalf("LoadIdentity: %v", err)
+	}
+	if string(id.CertPEM) != string(certPEM) {
+		t.Fatal("recovered identity differs from the previous one")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, identityTmpGlob)); len(matches) != 0 {
+		t.Fatalf("staging directories left behind: %v", matches)
+	}
+}
+
+func TestLoadIdentityPartialState(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, key, caPEM := testIdentity(t)
+	if err := PersistIdentity(dir, certPEM, key, caPEM); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, identityDirName, identityKeyFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadIdentity(dir); !errors.Is(err, ErrCorruptIdentity) {
+		t.Fatalf("LoadIdentity with a missing key = %v, want ErrCorruptIdentity", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, identityDirName+".corrupt-*")); len(matches) != 1 {
+		t.Fatalf("corrupt identity not moved aside: %v", matches)
+	}
+	// With the bad files aside the connector enrolls afresh.
+	if _, err := LoadIdentity(dir); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("LoadIdentity after moving the corrupt identity aside = %v, want ErrNoIdentity", err)
+	}
+
+	// A key that does not match the certificate is corrupt as well.
+	_, otherKey, _ := testIdentity(t)
+	if err := PersistIdentity(dir, certPEM, otherKey, caPEM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIdentity(dir); !errors.Is(err, ErrCorruptIdentity) {
+		t.Fatalf("LoadIdentity with a mismatched key = %v, want ErrCorruptIdentity", err)
+	}
+}
+
+func TestWriteIdentityBundle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "identity.pem")
+	certPEM, key, caPEM := testIdentity(t)
+	if err := WriteIdentityBundle(path, certPEM, key, caPEM); err != nil {
+		t.Fatalf("WriteIdentityBundle: %v", err)
+	}
+	// Rewriting replaces the bundle in place.
+	certPEM, key, _ = testIdentity(t)
+	if err := WriteIdentityBundle(path, certPEM, key, caPEM); err != nil {
+		t.Fatalf("WriteIdentityBundle over an existing bundle: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("bundle mode = %v, want 0600", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("bundle dir holds %d entries, want only the bundle", len(entries))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		t.Fatalf("bundle is not a usable key pair: %v", err)
+	}
+	if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pair.PrivateKey.(crypto.Signer).Public()) {
+		t.Error("bundle holds another private key")
+	}
+	// The leaf comes first, then the CA.
+	if len(pair.Certificate) != 2 || string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Certificate[0]})) != string(certPEM) {
+		t.Errorf("bundle holds %d certificates, want the leaf then the CA", len(pair.Certificate))
+	}
+}
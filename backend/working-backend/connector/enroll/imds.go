@@ -0,0 +1,228 @@
+package enroll
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// Values of CONNECTOR_IMDS.
+const (
+	MetadataAuto  = "auto"
+	MetadataOff   = "off"
+	MetadataAWS   = "aws"
+	MetadataGCP   = "gcp"
+	MetadataAzure = "azure"
+)
+
+const (
+	// imdsTimeout bounds the whole metadata lookup; off-cloud hosts must
+	// not delay enrollment noticeably.
+	imdsTimeout = 2 * time.Second
+	// imdsMaxBody bounds each metadata response read.
+	imdsMaxBody = 4096
+	// maxMetadataField matches the controller's per-field limit.
+	maxMetadataField = 128
+)
+
+// imdsBaseURL is the link-local instance metadata endpoint shared by AWS,
+// GCP and Azure.
+var imdsBaseURL = "http://169.254.169.254"
+
+// ResolveMetadata queries the instance metadata service selected by source
+// and returns the provisioning metadata to report at enrollment, or nil when
+// none is available. In auto mode every provider is probed concurrently and
+// the first answer wins. Failures are not errors: the metadata is optional.
+func ResolveMetadata(ctx context.Context, source string) *controllerpb.EnrollMetadata {
+	var probes []func(context.Context, *http.Client) (*controllerpb.EnrollMetadata, error)
+	switch source {
+	case MetadataOff:
+		return nil
+	case MetadataAWS:
+		probes = append(probes, probeAWS)
+	case MetadataGCP:
+		probes = append(probes, probeGCP)
+	case MetadataAzure:
+		probes = append(probes, probeAzure)
+	default:
+		probes = append(probes, probeAWS, probeGCP, probeAzure)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	// Metadata endpoints must be reached directly, never through a proxy.
+	client := &http.Client{Transport: &http.Transport{Proxy: nil}}
+	defer client.CloseIdleConnections()
+
+	results := make(chan *controllerpb.EnrollMetadata, len(probes))
+	for _, probe := range probes {
+		go func() {
+			md, err := probe(ctx, client)
+			if err != nil {
+				md = nil
+			}
+			results <- md
+		}()
+	}
+	for range probes {
+		if md := <-results; md != nil {
+			return md
+		}
+	}
+	return nil
+}
+
+// ResolveAttestation fetches the signed identity document of the given
+// type. Unlike metadata, a configured attestation is required: the
+// controller refuses an attested enrollment without it.
+func ResolveAttestation(ctx context.Context, typ string) (*controllerpb.Attestation, error) {
+	if typ == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	client := &http.Client{Transport: &http.Transport{Proxy: nil}}
+	defer client.CloseIdleConnections()
+
+	token, err := awsIMDSToken(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("attestation: %w", err)
+	}
+	hdr := map[string]string{"X-aws-ec2-metadata-token": token}
+	doc, err := imdsGetRaw(ctx, client, http.MethodGet, "/latest/dynamic/instance-identity/document", hdr)
+	if err != nil {
+		return nil, fmt.Errorf("attestation: %w", err)
+	}
+	sig, err := imdsGet(ctx, client, http.MethodGet, "/latest/dynamic/instance-identity/signature", hdr)
+	if err != nil {
+		return nil, fmt.Errorf("attestation: %w", err)
+	}
+	return &controllerpb.Attestation{Type: "aws-iid", Document: doc, Signature: []byte(sig)}, nil
+}
+
+// awsIMDSToken obtains an IMDSv2 session token.
+func awsIMDSToken(ctx context.Context, client *http.Client) (string, error) {
+	return imdsGet(ctx, client, http.MethodPut, "/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+}
+
+// probeAWS reads the instance id and region from EC2 IMDSv2.
+func probeAWS(ctx context.Context, client *http.Client) (*controllerpb.EnrollMetadata, error) {
+	token, err := awsIMDSToken(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	hdr := map[string]string{"X-aws-ec2-metadata-token": token}
+	id, err := imdsGet(ctx, client, http.MethodGet, "/latest/meta-data/instance-id", hdr)
+	if err != nil {
+		return nil, err
+	}
+	region, err := imdsGet(ctx, client, http.MethodGet, "/latest/meta-data/placement/region", hdr)
+	if err != nil {
+		return nil, err
+	}
+	return newMetadata(MetadataAWS, id, region), nil
+}
+
+// probeGCP reads the instance id and zone from the GCE metadata server. The
+// region is the zone without its trailing "-<letter>".
+func probeGCP(ctx context.Context, client *http.Client) (*controllerpb.EnrollMetadata, error) {
+	hdr := map[string]string{"Metadata-Flavor": "Google"}
+	id, err := imdsGet(ctx, client, http.MethodGet, "/computeMetadata/v1/instance/id", hdr)
+	if err != nil {
+		return nil, err
+	}
+	zone, err := imdsGet(ctx, client, http.MethodGet, "/computeMetadata/v1/instance/zone", hdr)
+	if err != nil {
+		return nil, err
+	}
+	// The zone is reported as projects/<number>/zones/<zone>.
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return newMetadata(MetadataGCP, id, region), nil
+}
+
+// probeAzure reads the VM id and location from the Azure instance metadata
+// service.
+func probeAzure(ctx context.Context, client *http.Client) (*controllerpb.EnrollMetadata, error) {
+	hdr := map[string]string{"Metadata": "true"}
+	const compute = "/metadata/instance/compute/%s?api-version=2021-02-01&format=text"
+	id, err := imdsGet(ctx, client, http.MethodGet, fmt.Sprintf(compute, "vmId"), hdr)
+	if err != nil {
+		return nil, err
+	}
+	location, err := imdsGet(ctx, client, http.MethodGet, fmt.Sprintf(compute, "location"), hdr)
+	if err != nil {
+		return nil, err
+	}
+	return newMetadata(MetadataAzure, id, location), nil
+}
+
+// imdsGet returns a metadata value with surrounding whitespace removed.
+func imdsGet(ctx context.Context, client *http.Client, method, path string, header map[string]string) (string, error) {
+	body, err := imdsGetRaw(ctx, client, method, path, header)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(body))
+	if value == "" {
+		return "", fmt.Errorf("%s %s: empty response", method, path)
+	}
+	return value, nil
+}
+
+// imdsGetRaw returns a metadata response body unmodified, as needed for
+// signed documents.
+func imdsGetRaw(ctx context.Context, client *http.Client, method, path string, header map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, imdsBaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, imdsMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return body, nil
+}
+
+// newMetadata builds the request metadata, dropping values the controller
+// would reject so a malformed response never blocks enrollment.
+func newMetadata(provider, instanceID, region string) *controllerpb.EnrollMetadata {
+	return &controllerpb.EnrollMetadata{
+		Provider:   provider,
+		InstanceId: metadataValue(instanceID),
+		Region:     metadataValue(region),
+	}
+}
+
+func metadataValue(v string) string {
+	if len(v) > maxMetadataField {
+		return ""
+	}
+	for _, r := range v {
+		if r <= ' ' || r > '~' {
+			return ""
+		}
+	}
+	return v
+}
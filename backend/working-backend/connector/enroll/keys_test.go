@@ -0,0 +1,38 @@
+package enroll
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	for _, alg := range []string{"", "ecdsa", "ECDSA-P256", " ed25519 "} {
+		key, pubPEM, err := GenerateKey(alg)
+		if err != nil {
+			t.Fatalf("GenerateKey(%q): %v", alg, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PrivateKey, ed25519.PrivateKey:
+		default:
+			t.Fatalf("GenerateKey(%q) returned %T", alg, key)
+		}
+		block, _ := pem.Decode(pubPEM)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			t.Fatalf("GenerateKey(%q) public key is not a PUBLIC KEY PEM block", alg)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("GenerateKey(%q) public key: %v", alg, err)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+			t.Errorf("GenerateKey(%q) public key does not match the private key", alg)
+		}
+	}
+	if _, _, err := GenerateKey("rsa"); err == nil {
+		t.Error("GenerateKey accepted an unsupported algorithm")
+	}
+}
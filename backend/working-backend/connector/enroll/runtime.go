@@ -3,21 +3,30 @@ package enroll
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"connector/internal/buildinfo"
+	"connector/internal/config"
+	"connector/internal/failover"
+	"controller/dialaddr"
+	"controller/spiffeid"
 )
 
 const (
 	privateIPEnv = "CONNECTOR_PRIVATE_IP"
-	versionEnv   = "CONNECTOR_VERSION"
+	extraSANsEnv = "CONNECTOR_EXTRA_SANS"
+
+	expectedControllerEnv = "EXPECTED_CONTROLLER_SPIFFE_ID"
 )
 
-func ResolveVersion() string {
-	if v := strings.TrimSpace(os.Getenv(versionEnv)); v != "" {
+// ResolveVersion returns the version reported to the controller: the
+// CONNECTOR_VERSION override, else the build version.
+func ResolveVersion(override string) string {
+	if v := strings.TrimSpace(override); v != "" {
 		return v
 	}
 	if v := strings.TrimSpace(buildinfo.Version); v != "" {
@@ -26,23 +35,103 @@ func ResolveVersion() string {
 	return "unknown"
 }
 
-func ResolvePrivateIP(controllerAddr string) (string, error) {
-	if ip := strings.TrimSpace(os.Getenv(privateIPEnv)); ip != "" {
-		return ip, nil
+// ExtraSANs are additional non-SPIFFE URI and email SANs requested at
+// enrollment and every renewal.
+type ExtraSANs struct {
+	URIs   []string
+	Emails []string
+}
+
+// ResolveExtraSANs parses CONNECTOR_EXTRA_SANS, a comma-separated list of
+// uri:<uri> and email:<address> entries. The controller only grants SANs its
+// EXTRA_SAN_POLICY allows.
+func ResolveExtraSANs(spec string) (ExtraSANs, error) {
+	var sans ExtraSANs
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		typ, value, _ := strings.Cut(entry, ":")
+		switch {
+		case value == "":
+			return ExtraSANs{}, fmt.Errorf("%s: expected uri:<uri> or email:<address>, got %q", extraSANsEnv, entry)
+		case typ == "uri":
+			sans.URIs = append(sans.URIs, value)
+		case typ == "email":
+			sans.Emails = append(sans.Emails, value)
+		default:
+			return ExtraSANs{}, fmt.Errorf("%s: unknown SAN type %q (expected uri or email)", extraSANsEnv, typ)
+		}
 	}
-	ip, err := discoverPrivateIP(controllerAddr)
-	if err != nil {
-		return "", err
+	return sans, nil
+}
+
+// ResolvePrivateIP returns the connector's private IP in canonical form.
+// override (CONNECTOR_PRIVATE_IP) replaces discovery; otherwise the route to
+// the first reachable controller decides, and the address family follows
+// family (CONNECTOR_IP_FAMILY, ipv4|ipv6) or, if empty, the family that
+// controller's address resolves to. config.NoPrivateIP resolves to "".
+func ResolvePrivateIP(controllerAddrs []string, override, family string) (string, error) {
+	if strings.TrimSpace(override) == config.NoPrivateIP {
+		return "", nil
 	}
-	return ip, nil
+	if v := strings.TrimSpace(override); v != "" {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return "", fmt.Errorf("%s=%q is not an IP address", privateIPEnv, v)
+		}
+		if family != "" && ipFamily(ip) != family {
+			return "", fmt.Errorf("%s=%s is not an %s address", privateIPEnv, v, family)
+		}
+		return ip.String(), nil
+	}
+	return discoverPrivateIP(firstReachable(controllerAddrs), family)
 }
 
-func discoverPrivateIP(controllerAddr string) (string, error) {
+// firstReachable returns the first of addrs that accepts a TCP connection
+// within failover.ConnectTimeout, or the first address when none does. A
+// single address is returned without probing.
+func firstReachable(addrs []string) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	if len(addrs) == 1 {
+		return addrs[0]
+	}
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, failover.ConnectTimeout)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		log.Printf("controller %s unreachable for private IP discovery: %v", addr, err)
+	}
+	return addrs[0]
+}
+
+func discoverPrivateIP(controllerAddr, family string) (string, error) {
 	host, err := controllerHost(controllerAddr)
 	if err != nil {
 		return "", err
 	}
-	conn, err := net.Dial("udp", net.JoinHostPort(host, "53"))
+	network := "udp"
+	if ip := net.ParseIP(host); ip != nil {
+		// An IP literal fixes the family; an explicit setting must agree.
+		if family != "" && ipFamily(ip) != family {
+			return "", fmt.Errorf("CONTROLLER_ADDR %s is not reachable over %s", host, family)
+		}
+		family = ipFamily(ip)
+	}
+	switch family {
+	case "ipv4":
+		network = "udp4"
+	case "ipv6":
+		network = "udp6"
+	}
+
+	// No packets are sent; connecting a UDP socket only selects the route.
+	conn, err := net.Dial(network, net.JoinHostPort(host, "53"))
 	if err != nil {
 		return "", fmt.Errorf("failed to determine private IP: %w", err)
 	}
@@ -52,33 +141,54 @@ func discoverPrivateIP(controllerAddr string) (string, error) {
 	if !ok || localAddr.IP == nil {
 		return "", fmt.Errorf("failed to determine private IP")
 	}
+	if family != "" && ipFamily(localAddr.IP) != family {
+		return "", fmt.Errorf("discovered private IP %s is not an %s address", localAddr.IP, family)
+	}
+	// UDPAddr.IP never carries the zone, so String() yields a form that
+	// net.ParseIP on the controller round-trips into the IP SAN.
 	return localAddr.IP.String(), nil
 }
 
-func controllerHost(controllerAddr string) (string, error) {
-	if strings.Contains(controllerAddr, "://") {
-		return "", fmt.Errorf("CONTROLLER_ADDR must be host:port")
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
 	}
-	if host, _, err := net.SplitHostPort(controllerAddr); err == nil && host != "" {
-		return host, nil
+	return "ipv6"
+}
+
+func controllerHost(controllerAddr string) (string, error) {
+	addr, err := dialaddr.Parse("CONTROLLER_ADDR", controllerAddr)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("CONTROLLER_ADDR must be host:port")
+	return dialaddr.Host(addr), nil
 }
 
-func normalizeTrustDomain(v string) string {
+// ExpectedControllerID validates EXPECTED_CONTROLLER_SPIFFE_ID, the exact
+// SPIFFE ID the controller must present. It must be a controller ID in
+// trustDomain; empty accepts any controller in the trust domain.
+func ExpectedControllerID(v, trustDomain string) (string, error) {
 	v = strings.TrimSpace(v)
-	v = strings.TrimSuffix(v, ".")
-	return v
+	if v == "" {
+		return "", nil
+	}
+	id, err := spiffeid.Parse(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", expectedControllerEnv, err)
+	}
+	if id.Role != "controller" || id.TrustDomain != trustDomain {
+		return "", fmt.Errorf("%s must be a controller ID in trust domain %s, got %q", expectedControllerEnv, trustDomain, v)
+	}
+	return id.String(), nil
 }
 
-func loadExplicitCA() ([]byte, error) {
+func loadExplicitCA(caPath string) ([]byte, error) {
 	if cred, err := ReadCredential("CONTROLLER_CA"); err != nil {
 		return nil, err
 	} else if cred != "" {
 		return []byte(cred), nil
 	}
 
-	caPath := strings.TrimSpace(os.Getenv("CONTROLLER_CA_PATH"))
 	if caPath == "" {
 		return nil, fmt.Errorf(
 			"CONTROLLER_CA_PATH is not set (explicit controller trust is required)",
@@ -0,0 +1,62 @@
+package enroll
+
+import (
+	"strings"
+	"testing"
+
+	"connector/internal/config"
+)
+
+func TestResolvePrivateIP(t *testing.T) {
+	controller := []string{"127.0.0.1:8443"}
+	tests := []struct {
+		name     string
+		override string
+		family   string
+		want     string
+		wantErr  string
+	}{
+		{name: "override canonicalized", override: " FD00:0:0::7 ", want: "fd00::7"},
+		{name: "override ipv4", override: "10.0.0.7", family: "ipv4", want: "10.0.0.7"},
+		{name: "override wrong family", override: "10.0.0.7", family: "ipv6", wantErr: "is not an ipv6 address"},
+		{name: "override invalid", override: "10.0.0", wantErr: "is not an IP address"},
+		{name: "no private ip", override: config.NoPrivateIP, want: ""},
+		{name: "discovered from controller family", want: "127.0.0.1"},
+		{name: "discovered ipv4", family: "ipv4", want: "127.0.0.1"},
+		{name: "controller literal of another family", family: "ipv6", wantErr: "not reachable over ipv6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolvePrivateIP(controller, tt.override, tt.family)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ResolvePrivateIP = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectedControllerID(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: " spiffe://example.org/controller/ctl-a ", want: "spiffe://example.org/controller/ctl-a"},
+		{value: "spiffe://example.org/connector/ctl-a", wantErr: true},
+		{value: "spiffe://other.org/controller/ctl-a", wantErr: true},
+		{value: "spiffe://example.org/controller", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ExpectedControllerID(tt.value, "example.org")
+		if tt.wantErr != (err != nil) || got != tt.want {
+			t.Errorf("ExpectedControllerID(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -1,4 +1,17 @@
 package buildinfo
 
-// Version is set at build time with -ldflags "-X connector/internal/buildinfo.Version=...".
-var Version = "dev"
+import "fmt"
+
+// Build metadata, set at build time with -ldflags, e.g.
+//
+//	-X connector/internal/buildinfo.Version=v1.2.3 -X connector/internal/buildinfo.Commit=$(git rev-parse --short HEAD) -X connector/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// String formats the build metadata for the version command and logs.
+func String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, Date)
+}
@@ -0,0 +1,247 @@
+// Package config reads the connector configuration from the environment
+// and, optionally, a KEY=VALUE file named by CONNECTOR_CONFIG_FILE. The
+// environment takes precedence over the file. Variable names are the ones
+// documented in docs/connector.md.
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"controller/dialaddr"
+	"controller/envconfig"
+	"controller/spiffeid"
+)
+
+// FileEnv names the optional config file.
+const FileEnv = "CONNECTOR_CONFIG_FILE"
+
+// NoPrivateIP as CONNECTOR_PRIVATE_IP enrolls without a private IP, for
+// controllers with REQUIRE_PRIVATE_IP=false.
+const NoPrivateIP = "none"
+
+// Config is the connector configuration shared by the enroll and run
+// commands. Load fills in defaults and validates every value; list settings
+// with their own syntax (backends, grants, extra SANs) are parsed by the
+// packages that use them.
+type Config struct {
+	// ControllerAddrs lists equivalent controllers, tried in order with
+	// failover.
+	ControllerAddrs []string
+	// BootstrapAddrs are the controllers' enrollment-only listeners; empty
+	// enrolls via ControllerAddrs.
+	BootstrapAddrs []string
+	ConnectorID    string
+	TrustDomain    string
+	SPIFFEPolicy   spiffeid.Policy
+	// ExpectedControllerID is the raw EXPECTED_CONTROLLER_SPIFFE_ID.
+	ExpectedControllerID string
+	// CAPath is CONTROLLER_CA_PATH, used when no CONTROLLER_CA systemd
+	// credential is provided.
+	CAPath string
+	// BootstrapCertPath and BootstrapKeyPath name the client certificate
+	// presented at enrollment when the controller runs with ENROLL_AUTH.
+	BootstrapCertPath string
+	BootstrapKeyPath  string
+
+	// EnrollmentToken may also come from the ENROLLMENT_TOKEN credential.
+	EnrollmentToken string
+	// ApprovalMode is set by ENROLL_MODE=approval: no token is required
+	// and a pending enrollment is polled every PollInterval.
+	ApprovalMode    bool
+	PollInterval    time.Duration
+	ResponseMaxSkew time.Duration
+	// OutputPassword protects --output-format=pkcs12 output.
+	OutputPassword string
+
+	// Version overrides the build version reported to the controller.
+	Version string
+	// PrivateIP overrides discovery; NoPrivateIP enrolls without one.
+	// IPFamily is "ipv4", "ipv6" or "" to follow the controller address.
+	PrivateIP string
+	IPFamily  string
+	DNSNames  []string
+	// ExtraSANs is the raw CONNECTOR_EXTRA_SANS list.
+	ExtraSANs string
+	// KeyAlgorithm is "ecdsa" or "ed25519".
+	KeyAlgorithm string
+	// MetadataSource is CONNECTOR_IMDS; AttestationType is "aws" or "" for
+	// none.
+	MetadataSource  string
+	AttestationType string
+
+	// ListenAddr is empty to listen on the private IP.
+	ListenAddr string
+	StateDir   string
+	// IdentityBundlePath is a single PEM file (cert, key, CA) rewritten
+	// with the identity; IdentityReloadPIDFile names a process to SIGHUP
+	// after each write.
+	IdentityBundlePath    string
+	IdentityReloadPIDFile string
+	MetricsAddr           string
+	Compression           string
+	RenewReuseKey         bool
+	ControlPlaneStrict    bool
+	TunnelerIdleTimeout   time.Duration
+	// HealthProbe is set by CONNECTOR_CLIENT_AUTH=health-probe.
+	HealthProbe bool
+	FailClosed  bool
+	FailGrace   time.Duration
+
+	RenewalMaxFailures    int
+	RenewalReenrollWithin time.Duration
+	RenewalRPCTimeout     time.Duration
+
+	// HeartbeatInterval, MaxTunnelers and LogLevel are the baseline of the
+	// settings the controller can push; zero values mean the defaults.
+	HeartbeatInterval time.Duration
+	MaxTunnelers      int
+	LogLevel          string
+
+	// Backends, GateBackends and TargetAllowlist are the raw
+	// CONNECTOR_BACKENDS, CONNECTOR_GATE_BACKENDS and
+	// CONNECTOR_TARGET_ALLOWLIST lists.
+	Backends             string
+	GateBackends         string
+	TargetAllowlist      string
+	BackendCheckInterval time.Duration
+
+	rendered string
+	// values holds the rendered value of each variable that was set, for
+	// Changed.
+	values map[string]string
+}
+
+// Load reads and validates the configuration. The error lists every invalid
+// variable. CONTROLLER_ADDR and CONNECTOR_ID are required.
+func Load() (*Config, error) {
+	l, err := envconfig.New(FileEnv)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+
+	c.ControllerAddrs = l.Addrs("CONTROLLER_ADDR")
+	l.Require("CONTROLLER_ADDR")
+	c.BootstrapAddrs = l.Addrs("CONTROLLER_BOOTSTRAP_ADDR")
+	c.ConnectorID = l.Str("CONNECTOR_ID", "")
+	l.Require("CONNECTOR_ID")
+	c.TrustDomain = strings.TrimSuffix(l.Str("TRUST_DOMAIN", "mycorp.internal"), ".")
+	c.SPIFFEPolicy = spiffeid.Policy{
+		MaxLength: l.Int("SPIFFE_ID_MAX_LENGTH", spiffeid.DefaultMaxLength),
+		Strict:    l.Bool("SPIFFE_ID_STRICT", false),
+	}
+	if n := c.SPIFFEPolicy.MaxLength; n < 1 || n > spiffeid.DefaultMaxLength {
+		l.Fail("SPIFFE_ID_MAX_LENGTH", fmt.Errorf("must be between 1 and %d, got %d", spiffeid.DefaultMaxLength, n))
+	}
+	c.ExpectedControllerID = l.Str("EXPECTED_CONTROLLER_SPIFFE_ID", "")
+	c.CAPath = l.Str("CONTROLLER_CA_PATH", "")
+	c.BootstrapCertPath = l.Str("BOOTSTRAP_CERT_PATH", "")
+	c.BootstrapKeyPath = l.Str("BOOTSTRAP_KEY_PATH", "")
+	if (c.BootstrapCertPath == "") != (c.BootstrapKeyPath == "") {
+		l.Fail("BOOTSTRAP_CERT_PATH", fmt.Errorf("BOOTSTRAP_CERT_PATH and BOOTSTRAP_KEY_PATH must be set together"))
+	}
+	// The controller only trusts bootstrap certificates on its
+	// enrollment-only listener.
+	if c.BootstrapCertPath != "" && len(c.BootstrapAddrs) == 0 {
+		l.Fail("BOOTSTRAP_CERT_PATH", fmt.Errorf("requires CONTROLLER_BOOTSTRAP_ADDR"))
+	}
+
+	c.EnrollmentToken = l.Raw("ENROLLMENT_TOKEN", true)
+	c.ApprovalMode = l.Str("ENROLL_MODE", "") == "approval"
+	c.PollInterval = l.Positive("ENROLL_POLL_INTERVAL", 15*time.Second)
+	c.ResponseMaxSkew = l.Positive("ENROLL_RESPONSE_MAX_SKEW", 5*time.Minute)
+	c.OutputPassword = l.Raw("ENROLL_OUTPUT_PASSWORD", true)
+
+	c.Version = l.Str("CONNECTOR_VERSION", "")
+	if c.PrivateIP = l.Str("CONNECTOR_PRIVATE_IP", ""); c.PrivateIP != "" && c.PrivateIP != NoPrivateIP && net.ParseIP(c.PrivateIP) == nil {
+		l.Fail("CONNECTOR_PRIVATE_IP", fmt.Errorf("%q is not an IP address", c.PrivateIP))
+	}
+	c.IPFamily = l.OneOf("CONNECTOR_IP_FAMILY", "", "", "ipv4", "ipv6")
+	c.DNSNames = l.List("CONNECTOR_DNS_NAMES")
+	c.ExtraSANs = l.Str("CONNECTOR_EXTRA_SANS", "")
+	if c.KeyAlgorithm = l.OneOf("KEY_ALGORITHM", "ecdsa", "ecdsa", "ecdsa-p256", "ed25519"); c.KeyAlgorithm == "ecdsa-p256" {
+		c.KeyAlgorithm = "ecdsa"
+	}
+	c.MetadataSource = l.OneOf("CONNECTOR_IMDS", "auto", "auto", "off", "aws", "gcp", "azure")
+	if c.AttestationType = l.OneOf("CONNECTOR_ATTESTATION", "off", "off", "aws"); c.AttestationType == "off" {
+		c.AttestationType = ""
+	}
+
+	if c.ListenAddr = l.Str("CONNECTOR_LISTEN_ADDR", ""); strings.HasPrefix(c.ListenAddr, dialaddr.UnixPrefix) {
+		if c.ListenAddr, err = dialaddr.ParseUnix("CONNECTOR_LISTEN_ADDR", c.ListenAddr); err != nil {
+			l.Add(err)
+		}
+	}
+	c.StateDir = l.Str("CONNECTOR_STATE_DIR", "")
+	c.IdentityBundlePath = l.Str("CONNECTOR_IDENTITY_BUNDLE", "")
+	c.IdentityReloadPIDFile = l.Str("CONNECTOR_IDENTITY_RELOAD_PIDFILE", "")
+	if c.IdentityReloadPIDFile != "" && c.StateDir == "" && c.IdentityBundlePath == "" {
+		l.Fail("CONNECTOR_IDENTITY_RELOAD_PIDFILE", fmt.Errorf("requires CONNECTOR_STATE_DIR or CONNECTOR_IDENTITY_BUNDLE"))
+	}
+	c.MetricsAddr = l.Str("CONNECTOR_METRICS_ADDR", "")
+	if c.Compression = l.OneOf("CONTROL_PLANE_COMPRESSION", "none", "none", "gzip"); c.Compression == "none" {
+		c.Compression = ""
+	}
+	c.RenewReuseKey = l.Bool("RENEW_REUSE_KEY", false)
+	c.ControlPlaneStrict = l.Bool("CONTROL_PLANE_STRICT", false)
+	c.TunnelerIdleTimeout = l.Duration("CONNECTOR_TUNNELER_IDLE_TIMEOUT", time.Minute)
+	if d := c.TunnelerIdleTimeout; d != 0 && d < time.Second {
+		l.Fail("CONNECTOR_TUNNELER_IDLE_TIMEOUT", fmt.Errorf("must be 0 or at least 1s, got %s", d))
+	}
+	c.HealthProbe = l.OneOf("CONNECTOR_CLIENT_AUTH", "require", "require", "health-probe") == "health-probe"
+	c.FailClosed = l.OneOf("CONTROL_PLANE_FAIL_MODE", "open", "open", "closed") == "closed"
+	c.FailGrace = l.Positive("CONTROL_PLANE_FAIL_GRACE", 2*time.Minute)
+
+	if c.RenewalMaxFailures = l.Int("RENEWAL_MAX_FAILURES", 5); c.RenewalMaxFailures < 0 {
+		l.Fail("RENEWAL_MAX_FAILURES", fmt.Errorf("must not be negative"))
+	}
+	if c.RenewalReenrollWithin = l.Duration("RENEWAL_REENROLL_WITHIN", 5*time.Minute); c.RenewalReenrollWithin < 0 {
+		l.Fail("RENEWAL_REENROLL_WITHIN", fmt.Errorf("must not be negative"))
+	}
+	c.RenewalRPCTimeout = l.Positive("RENEWAL_RPC_TIMEOUT", 30*time.Second)
+
+	c.HeartbeatInterval = l.Duration("CONNECTOR_HEARTBEAT_INTERVAL", 0)
+	c.MaxTunnelers = l.Int("CONNECTOR_MAX_TUNNELERS", 0)
+	c.LogLevel = l.Str("CONNECTOR_LOG_LEVEL", "")
+
+	c.Backends = l.Str("CONNECTOR_BACKENDS", "")
+	c.GateBackends = l.Str("CONNECTOR_GATE_BACKENDS", "")
+	c.TargetAllowlist = l.Str("CONNECTOR_TARGET_ALLOWLIST", "")
+	c.BackendCheckInterval = l.Positive("CONNECTOR_BACKEND_CHECK_INTERVAL", 10*time.Second)
+
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	c.rendered = l.Render()
+	c.values = l.Values()
+	return c, nil
+}
+
+// String lists the variables that were set as NAME=value pairs, with
+// secrets such as the enrollment token replaced by envconfig.Redacted, for logging.
+func (c *Config) String() string {
+	return c.rendered
+}
+
+// Changed returns the sorted names of the variables whose value differs
+// between prev and c, including ones set in only one of them. Secrets are
+// compared in their redacted form, so a changed secret is not reported.
+func (c *Config) Changed(prev *Config) []string {
+	var names []string
+	for name, v := range c.values {
+		if old, ok := prev.values[name]; !ok || old != v {
+			names = append(names, name)
+		}
+	}
+	for name := range prev.values {
+		if _, ok := c.values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,118 @@
+// Package failover spreads controller RPCs over the addresses listed in
+// CONTROLLER_ADDR. Addresses are tried in order, starting with the one that
+// last worked; an address that just failed is tried last until Cooldown
+// passes.
+package failover
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Cooldown is how long a failed address is moved to the end of Order.
+	Cooldown = 30 * time.Second
+	// ConnectTimeout bounds connecting to one address, so an unreachable
+	// controller fails over instead of using up the caller's deadline.
+	ConnectTimeout = 5 * time.Second
+)
+
+// Endpoints is a list of equivalent controller addresses. It is safe for
+// concurrent use.
+type Endpoints struct {
+	addrs []string
+
+	mu     sync.Mutex
+	last   string
+	failed map[string]time.Time
+}
+
+// New returns the endpoints for addrs, in configured order.
+func New(addrs []string) *Endpoints {
+	return &Endpoints{addrs: addrs, failed: make(map[string]time.Time)}
+}
+
+// Addrs returns the addresses in configured order.
+func (e *Endpoints) Addrs() []string {
+	return e.addrs
+}
+
+func (e *Endpoints) String() string {
+	return strings.Join(e.addrs, ",")
+}
+
+// Order returns the addresses to try: the last one that worked, then the
+// others in configured order, with those that failed within Cooldown last.
+func (e *Endpoints) Order() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var healthy, failing []string
+	for _, addr := range e.addrs {
+		switch {
+		case time.Since(e.failed[addr]) < Cooldown:
+			failing = append(failing, addr)
+		case addr == e.last:
+			healthy = append([]string{addr}, healthy...)
+		default:
+			healthy = append(healthy, addr)
+		}
+	}
+	return append(healthy, failing...)
+}
+
+// Succeeded records that addr answered.
+func (e *Endpoints) Succeeded(addr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = addr
+	delete(e.failed, addr)
+}
+
+// Failed records that addr could not be reached.
+func (e *Endpoints) Failed(addr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failed[addr] = time.Now()
+	if e.last == addr {
+		e.last = ""
+	}
+}
+
+// Do calls fn with each address in Order until one does not fail with
+// Unavailable, the status gRPC reports when a controller cannot be reached
+// or its certificate is refused. It returns the last error.
+func (e *Endpoints) Do(ctx context.Context, fn func(addr string) error) error {
+	var err error
+	for _, addr := range e.Order() {
+		if err = fn(addr); !Retryable(err) {
+			e.Succeeded(addr)
+			return err
+		}
+		e.Failed(addr)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// Retryable reports whether err means the controller could not be reached,
+// so another address may succeed.
+func Retryable(err error) bool {
+	return err != nil && status.Code(err) == codes.Unavailable
+}
+
+// DialOption bounds each connection attempt by ConnectTimeout.
+func DialOption() grpc.DialOption {
+	return grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           backoff.DefaultConfig,
+		MinConnectTimeout: ConnectTimeout,
+	})
+}
@@ -0,0 +1,60 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDoFailsOver(t *testing.T) {
+	e := New([]string{"a:8443", "b:8443", "c:8443"})
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	var tried []string
+	err := e.Do(context.Background(), func(addr string) error {
+		tried = append(tried, addr)
+		if addr == "a:8443" {
+			return unavailable
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a:8443", "b:8443"}; !reflect.DeepEqual(tried, want) {
+		t.Fatalf("tried %v, want %v", tried, want)
+	}
+	// The address that worked goes first and the one that failed last.
+	if got, want := e.Order(), []string{"b:8443", "c:8443", "a:8443"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Order() = %v, want %v", got, want)
+	}
+
+	// An error other than Unavailable came from a controller, so it is
+	// returned without trying the others.
+	tried = nil
+	denied := status.Error(codes.PermissionDenied, "denied")
+	if err := e.Do(context.Background(), func(addr string) error {
+		tried = append(tried, addr)
+		return denied
+	}); !errors.Is(err, denied) || len(tried) != 1 {
+		t.Fatalf("Do returned %v after trying %v", err, tried)
+	}
+}
+
+func TestDoStopsWhenContextDone(t *testing.T) {
+	e := New([]string{"a:8443", "b:8443"})
+	ctx, cancel := context.WithCancel(context.Background())
+	var tried int
+	err := e.Do(ctx, func(string) error {
+		tried++
+		cancel()
+		return status.Error(codes.Unavailable, "deadline")
+	})
+	if status.Code(err) != codes.Unavailable || tried != 1 {
+		t.Fatalf("Do returned %v after %d attempts, want one Unavailable attempt", err, tried)
+	}
+}
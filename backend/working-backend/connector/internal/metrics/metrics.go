@@ -0,0 +1,271 @@
+// Package metrics is a minimal in-process metrics registry that renders the
+// Prometheus text exposition format. It intentionally covers only what the
+// connector needs: counters, gauges and labelled counters. It mirrors
+// the controller's metrics package.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]collector)
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[c.name()]; exists {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	registry[c.name()] = c
+}
+
+// Handler serves all registered metrics in Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteTo(w)
+	})
+}
+
+// WriteTo renders all registered metrics, sorted by name.
+func WriteTo(w io.Writer) {
+	registryMu.Lock()
+	collectors := make([]collector, 0, len(registry))
+	for _, c := range registry {
+		collectors = append(collectors, c)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// value is a float64 updated atomically.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if v.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	v.bits.Store(math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	metricName string
+	help       string
+	v          value
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	register(c)
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add increments the counter by delta; negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+// Value returns the current counter value.
+func (c *Counter) Value() float64 { return c.v.get() }
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.metricName, formatValue(c.v.get()))
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	metricName string
+	help       string
+	v          value
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{metricName: name, help: help}
+	register(g)
+	return g
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(f float64) { g.v.set(f) }
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Value returns the current gauge value.
+func (g *Gauge) Value() float64 { return g.v.get() }
+
+func (g *Gauge) name() string { return g.metricName }
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.v.get()))
+}
+
+// GaugeFunc is a gauge whose value is computed at scrape time.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.fn()))
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*labelledValue
+}
+
+type labelledValue struct {
+	labelValues []string
+	v           value
+}
+
+// NewCounterVec creates and registers a labelled counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     make(map[string]*labelledValue),
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the series identified by labelValues.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the series identified by labelValues by delta.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta <= 0 {
+		return
+	}
+	if len(labelValues) != len(c.labels) {
+		panic("metrics: label cardinality mismatch for " + c.metricName)
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	lv, ok := c.series[key]
+	if !ok {
+		lv = &labelledValue{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = lv
+	}
+	c.mu.Unlock()
+	lv.v.add(delta)
+}
+
+// Value returns the current value of a series.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lv, ok := c.series[strings.Join(labelValues, "\xff")]; ok {
+		return lv.v.get()
+	}
+	return 0
+}
+
+func (c *CounterVec) name() string { return c.metricName }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.series))
+	for k := range c.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]*labelledValue, 0, len(keys))
+	for _, k := range keys {
+		series = append(series, c.series[k])
+	}
+	c.mu.Unlock()
+
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, lv := range series {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.metricName, formatLabels(c.labels, lv.labelValues), formatValue(lv.v.get()))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		parts[i] = n + `="` + v + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatValue(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
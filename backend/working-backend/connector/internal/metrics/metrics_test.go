@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerRendersMetrics(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.")
+	g := NewGauge("test_in_flight", "In flight.")
+	NewGaugeFunc("test_computed", "Computed.", func() float64 { return 2.5 })
+	v := NewCounterVec("test_errors_total", "Errors by class.", "class")
+
+	c.Inc()
+	c.Add(2)
+	c.Add(-5) // ignored
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	v.Inc("transient")
+	v.Add(3, `say "hi"`)
+	v.Add(0, "ignored")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	want := `# HELP test_computed Computed.
+# TYPE test_computed gauge
+test_computed 2.5
+# HELP test_errors_total Errors by class.
+# TYPE test_errors_total counter
+test_errors_total{class="say \"hi\""} 3
+test_errors_total{class="transient"} 1
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight 1
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total 3
+`
+	if string(body) != want {
+		t.Errorf("rendered metrics:\n%s\nwant:\n%s", body, want)
+	}
+	if got := v.Value("ignored"); got != 0 {
+		t.Errorf("zero Add created a series with value %g", got)
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	NewGauge("test_duplicate", "First.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate metric did not panic")
+		}
+	}()
+	NewCounter("test_duplicate", "Second.")
+}
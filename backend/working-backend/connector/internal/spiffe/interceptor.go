@@ -3,7 +3,8 @@ package spiffe
 import (
 	"context"
 	"errors"
-	"strings"
+
+	"controller/spiffeid"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -21,6 +22,13 @@ type Allowlist interface {
 	Allowed(spiffeID string) bool
 }
 
+// AdmissionGate can refuse tunneler requests after identity and allowlist
+// checks pass, e.g. while a required backend is unhealthy. Admit returns nil
+// to admit or a gRPC status error to refuse.
+type AdmissionGate interface {
+	Admit() error
+}
+
 // UnaryInterceptor enforces SPIFFE identity on unary RPCs.
 func UnaryInterceptor(trustDomain string, allowedRoles ...string) grpc.UnaryServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
@@ -44,7 +52,10 @@ func UnaryInterceptor(trustDomain string, allowedRoles ...string) grpc.UnaryServ
 }
 
 // UnaryInterceptorWithAllowlist enforces SPIFFE identity and allowlist checks.
-func UnaryInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, allowedRoles ...string) grpc.UnaryServerInterceptor {
+// A non-nil gate is consulted for tunnelers after the allowlist. Methods in
+// unauthenticatedMethods skip every check, for servers that accept
+// connections without a client certificate.
+func UnaryInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, gate AdmissionGate, unauthenticatedMethods map[string]struct{}, allowedRoles ...string) grpc.UnaryServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
 	return func(
 		ctx context.Context,
@@ -52,6 +63,9 @@ func UnaryInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, allo
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
+		if _, ok := unauthenticatedMethods[info.FullMethod]; ok {
+			return handler(ctx, req)
+		}
 		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles)
 		if err != nil {
 			return nil, err
@@ -59,6 +73,11 @@ func UnaryInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, allo
 		if role == "tunneler" && allowlist != nil && !allowlist.Allowed(spiffeID) {
 			return nil, errors.New("tunneler not allowed")
 		}
+		if role == "tunneler" && gate != nil {
+			if err := gate.Admit(); err != nil {
+				return nil, err
+			}
+		}
 		ctx = context.WithValue(ctx, spiffeIDContextKey, spiffeID)
 		ctx = context.WithValue(ctx, roleContextKey, role)
 		return handler(ctx, req)
@@ -94,7 +113,8 @@ func StreamInterceptor(trustDomain string, allowedRoles ...string) grpc.StreamSe
 }
 
 // StreamInterceptorWithAllowlist enforces SPIFFE identity and allowlist checks.
-func StreamInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, allowedRoles ...string) grpc.StreamServerInterceptor {
+// A non-nil gate is consulted for tunnelers after the allowlist.
+func StreamInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, gate AdmissionGate, allowedRoles ...string) grpc.StreamServerInterceptor {
 	roles := makeRoleSet(allowedRoles)
 	return func(
 		srv interface{},
@@ -109,6 +129,11 @@ func StreamInterceptorWithAllowlist(trustDomain string, allowlist Allowlist, all
 		if role == "tunneler" && allowlist != nil && !allowlist.Allowed(spiffeID) {
 			return errors.New("tunneler not allowed")
 		}
+		if role == "tunneler" && gate != nil {
+			if err := gate.Admit(); err != nil {
+				return err
+			}
+		}
 		wrapped := &wrappedStream{
 			ServerStream: ss,
 			ctx: context.WithValue(
@@ -168,34 +193,22 @@ func extractAndVerifySPIFFE(ctx context.Context, trustDomain string, allowedRole
 
 	cert := tlsInfo.State.PeerCertificates[0]
 
-	if len(cert.URIs) != 1 {
-		return "", "", errors.New("exactly one SPIFFE ID is required")
+	id, err := spiffeid.FromLeaf(cert)
+	if err != nil {
+		return "", "", err
 	}
 
-	uri := cert.URIs[0]
-
-	if uri.Scheme != "spiffe" {
-		return "", "", errors.New("SPIFFE ID must use spiffe:// scheme")
-	}
-
-	if uri.Host != trustDomain {
+	if id.TrustDomain != trustDomain {
 		return "", "", errors.New("SPIFFE trust domain mismatch")
 	}
 
-	path := strings.TrimPrefix(uri.Path, "/")
-	parts := strings.Split(path, "/")
-	if len(parts) != 2 {
-		return "", "", errors.New("invalid SPIFFE path format")
-	}
-
-	role := parts[0]
 	if len(allowedRoles) > 0 {
-		if _, ok := allowedRoles[role]; !ok {
+		if _, ok := allowedRoles[id.Role]; !ok {
 			return "", "", errors.New("invalid SPIFFE role")
 		}
 	}
 
-	return uri.String(), role, nil
+	return id.String(), id.Role, nil
 }
 
 func makeRoleSet(roles []string) map[string]struct{} {
@@ -1,57 +1,132 @@
 package tlsutil
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
-	"net/url"
-	"strings"
+	"fmt"
+	"net"
 	"sync"
 	"time"
+
+	"controller/spiffeid"
 )
 
+// CertOverlap is how long the previous certificate stays available after
+// Update, so handshakes negotiated against it still complete.
+const CertOverlap = time.Minute
+
 // CertStore keeps the current workload certificate in memory for rotation.
+// Each certificate is stored behind its own pointer and never modified, so
+// a handshake holding a certificate is unaffected by a concurrent Update.
 type CertStore struct {
 	mu       sync.RWMutex
-	cert     tls.Certificate
+	cert     *tls.Certificate
 	certPEM  []byte
 	notAfter time.Time
+
+	// prev is the certificate replaced by the last Update; it is served
+	// only until overlapUntil and only to peers that cannot use cert or
+	// would reject it for the name they verify.
+	prev         *tls.Certificate
+	overlapUntil time.Time
 }
 
 // NewCertStore initializes a new CertStore.
 func NewCertStore(cert tls.Certificate, certPEM []byte, notAfter time.Time) *CertStore {
-	return &CertStore{cert: cert, certPEM: certPEM, notAfter: notAfter}
+	withLeaf(&cert)
+	return &CertStore{cert: &cert, certPEM: certPEM, notAfter: notAfter}
 }
 
-// Update replaces the certificate in memory.
+// Update replaces the certificate in memory. The replaced certificate is
+// kept for CertOverlap.
 func (s *CertStore) Update(cert tls.Certificate, certPEM []byte, notAfter time.Time) {
+	withLeaf(&cert)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.cert = cert
+	s.prev = s.cert
+	s.overlapUntil = time.Now().Add(CertOverlap)
+	s.cert = &cert
 	s.certPEM = certPEM
 	s.notAfter = notAfter
 }
 
-// NotAfter returns the current certificate expiry.
+// NotAfter returns the expiry of the active certificate.
 func (s *CertStore) NotAfter() time.Time {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.notAfter
 }
 
-// GetCertificate returns the current certificate for server-side handshakes.
-func (s *CertStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+// PrivateKey returns the private key of the active certificate, or nil if
+// it is not a crypto.Signer.
+func (s *CertStore) PrivateKey() crypto.Signer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, _ := s.cert.PrivateKey.(crypto.Signer)
+	return key
+}
+
+// GetCertificate returns the current certificate for server-side handshakes,
+// or the previous one during the overlap window if the client cannot use
+// the current one or would reject it for the name it dialed, e.g. an IP SAN
+// dropped by the renewal.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return &s.cert, nil
+	if s.prev == nil || !time.Now().Before(s.overlapUntil) || hello == nil {
+		return s.cert, nil
+	}
+	if hello.SupportsCertificate(s.cert) != nil && hello.SupportsCertificate(s.prev) == nil {
+		return s.prev, nil
+	}
+	if name := dialedName(hello); name != "" && !coversName(s.cert, name) && coversName(s.prev, name) &&
+		hello.SupportsCertificate(s.prev) == nil {
+		return s.prev, nil
+	}
+	return s.cert, nil
 }
 
-// GetClientCertificate returns the current certificate for client-side handshakes.
-func (s *CertStore) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+// GetClientCertificate returns the current certificate for client-side
+// handshakes, or the previous one during the overlap window if the server
+// cannot accept the current one.
+func (s *CertStore) GetClientCertificate(req *tls.CertificateRequestInfo) (*tls.Certificate, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return &s.cert, nil
+	if s.prev != nil && time.Now().Before(s.overlapUntil) && req != nil &&
+		req.SupportsCertificate(s.cert) != nil && req.SupportsCertificate(s.prev) == nil {
+		return s.prev, nil
+	}
+	return s.cert, nil
+}
+
+// dialedName returns the name the client will check the server certificate
+// against: its SNI host name or, since clients send no SNI for IP targets,
+// the local IP it connected to. It returns "" when neither is known.
+func dialedName(hello *tls.ClientHelloInfo) string {
+	if hello.ServerName != "" {
+		return hello.ServerName
+	}
+	if hello.Conn == nil {
+		return ""
+	}
+	if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+func coversName(cert *tls.Certificate, name string) bool {
+	return cert.Leaf != nil && cert.Leaf.VerifyHostname(name) == nil
+}
+
+// withLeaf fills in cert.Leaf so handshakes need not parse it.
+func withLeaf(cert *tls.Certificate) {
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
 }
 
 // RootPoolFromPEM builds a cert pool from PEM bytes.
@@ -92,6 +167,26 @@ func EqualCAPEM(a, b []byte) bool {
 	return string(ab.Bytes) == string(bb.Bytes)
 }
 
+// VerifyControllerSPIFFE is VerifyPeerSPIFFE for the controller role. A
+// non-empty expectedID pins the controller: its SPIFFE ID must equal
+// expectedID exactly, so another validly signed controller is refused.
+func VerifyControllerSPIFFE(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, trustDomain, expectedID string) error {
+	if err := VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller"); err != nil {
+		return err
+	}
+	if expectedID == "" {
+		return nil
+	}
+	id, err := spiffeid.FromLeaf(verifiedChains[0][0])
+	if err != nil {
+		return err
+	}
+	if id.String() != expectedID {
+		return fmt.Errorf("controller SPIFFE ID %s does not match the expected %s", id, expectedID)
+	}
+	return nil
+}
+
 // VerifyPeerSPIFFE validates SPIFFE identity using verified chains.
 func VerifyPeerSPIFFE(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, trustDomain, expectedRole string) error {
 	if len(rawCerts) == 0 {
@@ -102,33 +197,14 @@ func VerifyPeerSPIFFE(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, t
 		return errors.New("peer verification failed")
 	}
 
-	leaf := verifiedChains[0][0]
-	if len(leaf.URIs) != 1 {
-		return errors.New("exactly one SPIFFE ID is required")
-	}
-
-	uri := leaf.URIs[0]
-	if err := verifySPIFFEURI(uri, trustDomain, expectedRole); err != nil {
+	id, err := spiffeid.FromLeaf(verifiedChains[0][0])
+	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func verifySPIFFEURI(uri *url.URL, trustDomain, expectedRole string) error {
-	if uri.Scheme != "spiffe" {
-		return errors.New("SPIFFE ID must use spiffe:// scheme")
-	}
-	if uri.Host != trustDomain {
+	if id.TrustDomain != trustDomain {
 		return errors.New("SPIFFE trust domain mismatch")
 	}
-	path := strings.TrimPrefix(uri.Path, "/")
-	parts := strings.Split(path, "/")
-	if len(parts) < 1 {
-		return errors.New("invalid SPIFFE path")
-	}
-	role := parts[0]
-	if expectedRole != "" && role != expectedRole {
+	if expectedRole != "" && id.Role != expectedRole {
 		return errors.New("unexpected SPIFFE role")
 	}
 	return nil
@@ -0,0 +1,247 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"controller/ca"
+)
+
+func newTestCA(t *testing.T) *ca.CA {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("tlsutil test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// issue returns a workload certificate for spiffeID with a key of the given
+// algorithm ("ecdsa" or "ed25519") and ips as IP SANs.
+func issue(t *testing.T, c *ca.CA, spiffeID, keyAlgorithm string, ips ...net.IP) tls.Certificate {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	if keyAlgorithm == "ed25519" {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := ca.IssueWorkloadCert(c, spiffeID, key.Public(), time.Hour, nil, ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestCertStoreOverlap(t *testing.T) {
+	c := newTestCA(t)
+	old := issue(t, c, "spiffe://example.org/connector/c1", "ecdsa")
+	store := NewCertStore(old, nil, old.Leaf.NotAfter)
+	if got, _ := store.GetCertificate(nil); got.Leaf != old.Leaf {
+		t.Fatal("GetCertificate did not return the initial certificate")
+	}
+
+	// The new certificate uses a key type some peers cannot verify.
+	renewed := issue(t, c, "spiffe://example.org/connector/c1", "ed25519")
+	store.Update(renewed, nil, renewed.Leaf.NotAfter)
+	if !store.NotAfter().Equal(renewed.Leaf.NotAfter) {
+		t.Error("NotAfter does not follow Update")
+	}
+	if _, ok := store.PrivateKey().(ed25519.PrivateKey); !ok {
+		t.Error("PrivateKey does not follow Update")
+	}
+
+	modern := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.Ed25519, tls.ECDSAWithP256AndSHA256},
+	}
+	ecdsaOnly := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	}
+	if got, _ := store.GetCertificate(modern); got.Leaf != renewed.Leaf {
+		t.Error("a peer that accepts the new certificate got the old one")
+	}
+	if got, _ := store.GetCertificate(ecdsaOnly); got.Leaf != old.Leaf {
+		t.Error("during the overlap a peer that cannot use the new certificate did not get the old one")
+	}
+	ecdsaServer := &tls.CertificateRequestInfo{
+		Version:          tls.VersionTLS13,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	}
+	if got, _ := store.GetClientCertificate(ecdsaServer); got.Leaf != old.Leaf {
+		t.Error("during the overlap a server that cannot use the new client certificate did not get the old one")
+	}
+	if got, _ := store.GetClientCertificate(nil); got.Leaf != renewed.Leaf {
+		t.Error("GetClientCertificate did not default to the new certificate")
+	}
+
+	// After the overlap only the new certificate is served.
+	store.mu.Lock()
+	store.overlapUntil = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+	if got, _ := store.GetCertificate(ecdsaOnly); got.Leaf != renewed.Leaf {
+		t.Error("the old certificate was served after the overlap window")
+	}
+}
+
+func TestCertStoreConcurrentHandshakes(t *testing.T) {
+	c := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(c.Cert)
+	loopback := net.IPv4(127, 0, 0, 1)
+	const serverID = "spiffe://example.org/connector/c1"
+	const clientID = "spiffe://example.org/tunneler/t1"
+	server := NewCertStore(issue(t, c, serverID, "ecdsa", loopback), nil, time.Time{})
+	client := NewCertStore(issue(t, c, clientID, "ecdsa"), nil, time.Time{})
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: server.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      roots,
+		MinVersion:     tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var (
+		mu       sync.Mutex
+		failures []error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		failures = append(failures, err)
+		mu.Unlock()
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					fail(err)
+				}
+			}()
+		}
+	}()
+
+	// Clients dial the IP and verify it against the server certificate.
+	dial := func() error {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			RootCAs:              roots,
+			ServerName:           "127.0.0.1",
+			GetClientCertificate: client.GetClientCertificate,
+			MinVersion:           tls.VersionTLS13,
+		})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	var (
+		stop       atomic.Bool
+		handshakes atomic.Int64
+		wg         sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if err := dial(); err != nil {
+					fail(err)
+				}
+				handshakes.Add(1)
+			}
+		}()
+	}
+
+	// Renew both sides repeatedly; the last server renewal drops the IP
+	// SAN the clients dial, which the overlap must bridge.
+	for i := 0; i < 20; i++ {
+		server.Update(issue(t, c, serverID, "ecdsa", loopback), nil, time.Time{})
+		client.Update(issue(t, c, clientID, "ecdsa"), nil, time.Time{})
+		time.Sleep(5 * time.Millisecond)
+	}
+	server.Update(issue(t, c, serverID, "ecdsa", net.IPv4(10, 0, 0, 9)), nil, time.Time{})
+	time.Sleep(50 * time.Millisecond)
+	stop.Store(true)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failures) > 0 {
+		t.Fatalf("%d of %d handshakes failed during updates, first: %v", len(failures), handshakes.Load(), failures[0])
+	}
+	if handshakes.Load() < 20 {
+		t.Fatalf("only %d handshakes ran", handshakes.Load())
+	}
+
+	// Without the overlap the dropped IP SAN fails verification.
+	server.mu.Lock()
+	server.overlapUntil = time.Now().Add(-time.Second)
+	server.mu.Unlock()
+	var hostErr x509.HostnameError
+	if err := dial(); !errors.As(err, &hostErr) {
+		t.Fatalf("dial after the overlap = %v, want a hostname error", err)
+	}
+}
+
+func TestVerifyControllerSPIFFE(t *testing.T) {
+	c := newTestCA(t)
+	chain := func(spiffeID string) ([][]byte, [][]*x509.Certificate) {
+		cert := issue(t, c, spiffeID, "ecdsa")
+		return cert.Certificate, [][]*x509.Certificate{{cert.Leaf, c.Cert}}
+	}
+	const pinned = "spiffe://example.org/controller/ctl-a"
+	tests := []struct {
+		name     string
+		peer     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "any controller", peer: "spiffe://example.org/controller/ctl-b"},
+		{name: "pinned controller", peer: pinned, expected: pinned},
+		{name: "another controller", peer: "spiffe://example.org/controller/ctl-b", expected: pinned, wantErr: true},
+		{name: "connector posing as controller", peer: "spiffe://example.org/connector/ctl-a", expected: pinned, wantErr: true},
+		{name: "other trust domain", peer: "spiffe://other.org/controller/ctl-a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, chains := chain(tt.peer)
+			err := VerifyControllerSPIFFE(raw, chains, "example.org", tt.expected)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("VerifyControllerSPIFFE err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,31 +1,62 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"connector/enroll"
+	"connector/internal/buildinfo"
+	"connector/internal/config"
 	"connector/run"
+	"controller/spiffeid"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("missing command: enroll | run")
+		log.Fatal("missing command: enroll | run | install-trust-bundle | version")
 	}
-
 	switch os.Args[1] {
 	case "enroll":
-		if err := enroll.Run(); err != nil {
+		if err := enroll.Run(os.Args[2:], loadConfig()); err != nil {
 			log.Fatalf("enrollment failed: %v", err)
 		}
 		log.Println("enrollment completed successfully")
 
 	case "run":
-		if err := run.Run(); err != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := run.Run(ctx, loadConfig())
+		stop()
+		if err != nil && !errors.Is(err, context.Canceled) {
 			log.Fatalf("connector run failed: %v", err)
 		}
+		log.Println("connector stopped")
+
+	case "install-trust-bundle":
+		if err := installTrustBundle(os.Args[2:]); err != nil {
+			log.Fatalf("trust bundle not installed: %v", err)
+		}
+
+	case "version", "--version":
+		fmt.Printf("connector %s\n", buildinfo.String())
 
 	default:
 		log.Fatalf("unknown command: %s", os.Args[1])
 	}
 }
+
+// loadConfig reads the configuration once for the enroll and run commands
+// and applies the process-wide SPIFFE ID policy.
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	log.Printf("configuration: %s", cfg)
+	spiffeid.Default = cfg.SPIFFEPolicy
+	return cfg
+}
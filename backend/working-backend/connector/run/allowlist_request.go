@@ -0,0 +1,46 @@
+package run
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// allowlistRequestInterval spaces out allowlist_request messages; the
+// controller also ignores requests that arrive faster than its own limit.
+const allowlistRequestInterval = 30 * time.Second
+
+// allowlistRequester asks the controller for the full tunneler allowlist
+// when a tunneler is refused as unknown, which is how a missed
+// tunneler_allow shows up. The reply is handled like any tunneler_allowlist.
+type allowlistRequester struct {
+	sendCh chan<- *controllerpb.ControlMessage
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newAllowlistRequester(sendCh chan<- *controllerpb.ControlMessage) *allowlistRequester {
+	return &allowlistRequester{sendCh: sendCh}
+}
+
+// request queues an allowlist_request unless one was sent within
+// allowlistRequestInterval or the send queue is full.
+func (r *allowlistRequester) request(spiffeID string) {
+	r.mu.Lock()
+	if !r.last.IsZero() && time.Since(r.last) < allowlistRequestInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.last = time.Now()
+	r.mu.Unlock()
+
+	select {
+	case r.sendCh <- &controllerpb.ControlMessage{Type: "allowlist_request"}:
+		allowlistRequests.Inc()
+		log.Printf("tunneler %s is not in the allowlist; requesting the current allowlist from the controller", spiffeID)
+	default:
+	}
+}
@@ -0,0 +1,220 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const backendCheckTimeout = 2 * time.Second
+
+// backendTarget is a backend service the connector fronts. When gate is set,
+// tunnelers are refused while the backend is failing its self-test.
+type backendTarget struct {
+	name string
+	addr string
+	gate bool
+}
+
+// backendHealth runs periodic TCP self-tests against configured backends and
+// gates tunneler admission on the ones marked as required.
+type backendHealth struct {
+	interval time.Duration
+	// recheck asks run to probe immediately after the targets change.
+	recheck chan struct{}
+
+	mu      sync.RWMutex
+	targets []backendTarget
+	healthy map[string]bool
+	lastErr map[string]string
+}
+
+// parseBackendTargets parses CONNECTOR_BACKENDS ("name=host:port,...") and
+// CONNECTOR_GATE_BACKENDS (comma-separated names). It returns nil when no
+// backends are configured.
+func parseBackendTargets(spec, gateSpec string) ([]backendTarget, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	gated := make(map[string]bool)
+	for _, name := range strings.Split(gateSpec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			gated[name] = true
+		}
+	}
+
+	var targets []backendTarget
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(item, "=")
+		name, addr = strings.TrimSpace(name), strings.TrimSpace(addr)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("CONNECTOR_BACKENDS: expected name=host:port, got %q", item)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("CONNECTOR_BACKENDS: backend %s: %v", name, err)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("CONNECTOR_BACKENDS: duplicate backend %s", name)
+		}
+		seen[name] = true
+		targets = append(targets, backendTarget{name: name, addr: addr, gate: gated[name]})
+	}
+	for name := range gated {
+		if !seen[name] {
+			return nil, fmt.Errorf("CONNECTOR_GATE_BACKENDS: unknown backend %s", name)
+		}
+	}
+	return targets, nil
+}
+
+// newBackendHealth builds the self-test for the given backends, probed every
+// CONNECTOR_BACKEND_CHECK_INTERVAL. It returns nil when there are none.
+func newBackendHealth(targets []backendTarget, interval time.Duration) *backendHealth {
+	if len(targets) == 0 {
+		return nil
+	}
+	return &backendHealth{
+		targets:  targets,
+		interval: interval,
+		recheck:  make(chan struct{}, 1),
+		healthy:  make(map[string]bool),
+		lastErr:  make(map[string]string),
+	}
+}
+
+// run probes all backends immediately and then every interval until ctx ends.
+func (h *backendHealth) run(ctx context.Context) {
+	h.checkAll(ctx)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkAll(ctx)
+		case <-h.recheck:
+			h.checkAll(ctx)
+		}
+	}
+}
+
+// setTargets replaces the probed backends. Results are kept for backends
+// whose address is unchanged; the rest are probed right away and, until
+// then, count as not yet checked.
+func (h *backendHealth) setTargets(targets []backendTarget) {
+	h.mu.Lock()
+	kept := make(map[string]bool, len(targets))
+	for _, old := range h.targets {
+		for _, t := range targets {
+			if t.name == old.name && t.addr == old.addr {
+				kept[t.name] = true
+			}
+		}
+	}
+	for name := range h.healthy {
+		if !kept[name] {
+			delete(h.healthy, name)
+			delete(h.lastErr, name)
+		}
+	}
+	h.targets = targets
+	h.mu.Unlock()
+
+	select {
+	case h.recheck <- struct{}{}:
+	default:
+	}
+}
+
+func (h *backendHealth) checkAll(ctx context.Context) {
+	h.mu.RLock()
+	targets := h.targets
+	h.mu.RUnlock()
+	for _, t := range targets {
+		dialCtx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+		var d net.Dialer
+		conn, err := d.DialContext(dialCtx, "tcp", t.addr)
+		cancel()
+		if conn != nil {
+			conn.Close()
+		}
+		h.record(t, err)
+	}
+}
+
+func (h *backendHealth) record(t backendTarget, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.current(t) {
+		// Removed or readdressed by a reload while being probed.
+		return
+	}
+	healthy := err == nil
+	prev, known := h.healthy[t.name]
+	h.healthy[t.name] = healthy
+	if err != nil {
+		h.lastErr[t.name] = err.Error()
+	} else {
+		delete(h.lastErr, t.name)
+	}
+	if !known || prev != healthy {
+		if healthy {
+			log.Printf("backend %s (%s) healthy", t.name, t.addr)
+		} else {
+			log.Printf("backend %s (%s) unhealthy: %v", t.name, t.addr, err)
+		}
+	}
+}
+
+func (h *backendHealth) current(t backendTarget) bool {
+	for _, c := range h.targets {
+		if c.name == t.name && c.addr == t.addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Admit implements spiffe.AdmissionGate. Gated backends that have not been
+// probed yet count as unhealthy.
+func (h *backendHealth) Admit() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, t := range h.targets {
+		if !t.gate || h.healthy[t.name] {
+			continue
+		}
+		reason := h.lastErr[t.name]
+		if reason == "" {
+			reason = "not yet checked"
+		}
+		log.Printf("tunneler admission refused: backend %s unhealthy (%s)", t.name, reason)
+		return status.Errorf(codes.Unavailable, "backend %s unavailable", t.name)
+	}
+	return nil
+}
+
+// failing reports whether backend name failed its most recent self-test.
+// Backends not yet probed are not considered failing.
+func (h *backendHealth) failing(name string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	healthy, known := h.healthy[name]
+	return known && !healthy
+}
@@ -0,0 +1,65 @@
+package run
+
+import (
+	"context"
+	"strings"
+
+	"connector/internal/metrics"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// compressionGzip is the only supported CONTROL_PLANE_COMPRESSION value.
+// Importing the gzip encoding registers it, so the connector always
+// advertises and accepts gzip; the setting only controls what it sends.
+const compressionGzip = gzip.Name
+
+var (
+	controlPlanePayloadBytes = metrics.NewCounter(
+		"connector_control_plane_payload_bytes_total",
+		"Uncompressed size of control messages received from the controller.",
+	)
+	controlPlaneCompressedBytes = metrics.NewCounter(
+		"connector_control_plane_compressed_bytes_total",
+		"Size of control messages received from the controller as sent (equal to the payload size when uncompressed).",
+	)
+)
+
+// compressionUnsupported reports whether err is a controller refusing a
+// compressed stream because it has no gzip decompressor.
+func compressionUnsupported(err error) bool {
+	st, ok := status.FromError(err)
+	return ok && st.Code() == codes.Unimplemented && strings.Contains(st.Message(), "Decompressor is not installed")
+}
+
+type methodKey struct{}
+
+// controlPlaneStats counts payload and compressed bytes received on the
+// ControlPlane.Connect stream.
+type controlPlaneStats struct{}
+
+func (controlPlaneStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (controlPlaneStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	in, ok := s.(*stats.InPayload)
+	if !ok {
+		return
+	}
+	if method, _ := ctx.Value(methodKey{}).(string); method != controllerpb.ControlPlane_Connect_FullMethodName {
+		return
+	}
+	controlPlanePayloadBytes.Add(float64(in.Length))
+	controlPlaneCompressedBytes.Add(float64(in.CompressedLength))
+}
+
+func (controlPlaneStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (controlPlaneStats) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,115 @@
+package run
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"connector/internal/config"
+)
+
+// Settings a SIGHUP applies without a restart. The backend settings can only
+// change when backends were configured at startup, since the tunnel service
+// and the backend self-test are set up then.
+var (
+	liveReloadable = map[string]bool{
+		"CONNECTOR_HEARTBEAT_INTERVAL": true,
+		"CONNECTOR_MAX_TUNNELERS":      true,
+		"CONNECTOR_LOG_LEVEL":          true,
+	}
+	backendReloadable = map[string]bool{
+		"CONNECTOR_BACKENDS":         true,
+		"CONNECTOR_GATE_BACKENDS":    true,
+		"CONNECTOR_TARGET_ALLOWLIST": true,
+	}
+)
+
+// configReloader re-reads the configuration on SIGHUP and applies the
+// hot-reloadable settings that changed.
+type configReloader struct {
+	running *config.Config
+	live    *liveConfig
+	tunnels *tunnelServer
+	health  *backendHealth
+}
+
+// run handles SIGHUP until ctx is canceled.
+func (r *configReloader) run(ctx context.Context) {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hupCh:
+			r.reload()
+		}
+	}
+}
+
+// reload loads and validates the whole configuration before changing
+// anything, so an invalid file leaves the running settings in place.
+// Settings that need a restart are logged and otherwise ignored.
+func (r *configReloader) reload() {
+	next, err := config.Load()
+	if err != nil {
+		log.Printf("config reload failed, keeping the running configuration: %v", err)
+		return
+	}
+	changed := next.Changed(r.running)
+	if len(changed) == 0 {
+		log.Println("config reload: no changes")
+		return
+	}
+
+	live := make(map[string]bool)
+	backends := false
+	var restart []string
+	for _, name := range changed {
+		switch {
+		case liveReloadable[name]:
+			live[name] = true
+		case backendReloadable[name] && r.tunnels != nil:
+			backends = true
+		default:
+			restart = append(restart, name)
+		}
+	}
+
+	nextLive, err := newLiveConfig(next)
+	if err != nil {
+		log.Printf("config reload failed, keeping the running configuration: %v", err)
+		return
+	}
+	var targets []backendTarget
+	var routes map[string]string
+	var grants map[string]map[string]bool
+	if backends {
+		if targets, err = parseBackendTargets(next.Backends, next.GateBackends); err == nil {
+			routes, grants, err = parseTunnelRoutes(targets, next.TargetAllowlist)
+		}
+		if err != nil {
+			log.Printf("config reload failed, keeping the running configuration: %v", err)
+			return
+		}
+	}
+
+	r.live.reload(nextLive, live)
+	if backends {
+		r.tunnels.setRoutes(routes, grants)
+		r.health.setTargets(targets)
+		names := make([]string, 0, len(targets))
+		for _, t := range targets {
+			names = append(names, t.name)
+		}
+		log.Printf("config reload: backends=%s", strings.Join(names, ","))
+	}
+	if len(restart) > 0 {
+		log.Printf("config reload: changes to %s take effect after a restart", strings.Join(restart, ", "))
+	}
+	r.running = next
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"io"
 	"log"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"connector/internal/spiffe"
 	controllerpb "controller/gen/controllerpb"
+	"controller/spiffeid"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,6 +20,11 @@ type controlPlaneServer struct {
 	controllerpb.UnimplementedControlPlaneServer
 	connectorID string
 	sendCh      chan<- *controllerpb.ControlMessage
+	live        *liveConfig
+	active      atomic.Int64
+	// idleTimeout closes a tunneler stream that has sent nothing for this
+	// long; 0 disables it.
+	idleTimeout time.Duration
 }
 
 func (s *controlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectServer) error {
@@ -26,39 +34,132 @@ func (s *controlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 	}
 
 	spiffeID, _ := spiffe.SPIFFEIDFromContext(stream.Context())
+	if connectorDrain.draining.Load() {
+		log.Printf("tunneler rejected: %s (connector draining)", spiffeID)
+		_ = stream.Send(disconnectMessage(disconnectDraining, "connector is draining"))
+		return status.Error(codes.Unavailable, "connector is draining")
+	}
+	if n := s.active.Add(1); s.live != nil && s.live.MaxTunnelers() > 0 && n > int64(s.live.MaxTunnelers()) {
+		s.active.Add(-1)
+		log.Printf("tunneler rejected: %s (max_tunnelers=%d reached)", spiffeID, s.live.MaxTunnelers())
+		_ = stream.Send(disconnectMessage(disconnectOverload, "connector at tunneler capacity"))
+		return status.Error(codes.ResourceExhausted, "connector at tunneler capacity")
+	}
+	defer s.active.Add(-1)
+	tunnelersConnected.Inc()
+	defer tunnelersConnected.Dec()
+	connectorDrain.tunnelers.Add(1)
+	defer connectorDrain.tunnelers.Add(-1)
 	log.Printf("tunneler connected: %s", spiffeID)
-	tunnelerID := parseTunnelerID(spiffeID)
 
+	t := &tunnelerStream{
+		server:     s,
+		stream:     stream,
+		spiffeID:   spiffeID,
+		tunnelerID: parseTunnelerID(spiffeID),
+	}
+	t.touch()
+	if s.idleTimeout <= 0 {
+		return t.recvLoop()
+	}
+
+	// The receive loop runs on its own goroutine so that an idle stream
+	// can be closed while Recv is blocked; returning from the handler
+	// cancels the stream and unblocks it.
+	errCh := make(chan error, 1)
+	go func() { errCh <- t.recvLoop() }()
+	check := time.NewTicker(idleCheckInterval(s.idleTimeout))
+	defer check.Stop()
 	for {
-		msg, err := stream.Recv()
+		select {
+		case err := <-errCh:
+			return err
+		case <-check.C:
+			idle := t.idle()
+			if idle < s.idleTimeout {
+				continue
+			}
+			tunnelerIdleClosed.Inc()
+			log.Printf("tunneler idle-closed: %s (no message for %s, timeout %s)", spiffeID, idle.Truncate(time.Second), s.idleTimeout)
+			_ = t.send(disconnectMessage(disconnectIdleTimeout, "no message within "+s.idleTimeout.String()))
+			return status.Error(codes.Unavailable, "tunneler stream idle timeout")
+		}
+	}
+}
+
+// tunnelerStream is one tunneler's control-plane stream.
+type tunnelerStream struct {
+	server     *controlPlaneServer
+	stream     controllerpb.ControlPlane_ConnectServer
+	spiffeID   string
+	tunnelerID string
+
+	// lastMessage is the UnixNano time of the last received message.
+	lastMessage atomic.Int64
+	// sendMu serializes Send between the receive loop and the idle check.
+	sendMu sync.Mutex
+}
+
+func (t *tunnelerStream) touch() { t.lastMessage.Store(time.Now().UnixNano()) }
+
+func (t *tunnelerStream) idle() time.Duration {
+	return time.Since(time.Unix(0, t.lastMessage.Load()))
+}
+
+func (t *tunnelerStream) send(msg *controllerpb.ControlMessage) error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	return t.stream.Send(msg)
+}
+
+func (t *tunnelerStream) recvLoop() error {
+	s := t.server
+	for {
+		msg, err := t.stream.Recv()
 		if err == io.EOF {
 			return nil
 		}
 		if err != nil {
 			return err
 		}
+		t.touch()
 
 		if msg.GetType() == "ping" {
-			if err := stream.Send(&controllerpb.ControlMessage{Type: "pong"}); err != nil {
+			if err := t.send(&controllerpb.ControlMessage{Type: "pong"}); err != nil {
 				return err
 			}
 		}
 		if msg.GetType() == "tunneler_heartbeat" && s.sendCh != nil {
+			// Relayed heartbeats always carry the authenticated identity;
+			// a payload claiming a different tunneler is dropped.
+			var claimed struct {
+				TunnelerID string `json:"tunneler_id"`
+				SPIFFEID   string `json:"spiffe_id"`
+			}
+			if len(msg.GetPayload()) > 0 && json.Unmarshal(msg.GetPayload(), &claimed) == nil &&
+				((claimed.TunnelerID != "" && claimed.TunnelerID != t.tunnelerID) || (claimed.SPIFFEID != "" && claimed.SPIFFEID != t.spiffeID)) {
+				log.Printf("tunneler_heartbeat dropped: claimed tunneler_id=%s spiffe_id=%s does not match %s", claimed.TunnelerID, claimed.SPIFFEID, t.spiffeID)
+				continue
+			}
 			payload := struct {
 				TunnelerID  string `json:"tunneler_id"`
 				SPIFFEID    string `json:"spiffe_id"`
 				Status      string `json:"status"`
 				ConnectorID string `json:"connector_id"`
 			}{
-				TunnelerID:  tunnelerID,
-				SPIFFEID:    spiffeID,
+				TunnelerID:  t.tunnelerID,
+				SPIFFEID:    t.spiffeID,
 				Status:      msg.GetStatus(),
 				ConnectorID: s.connectorID,
 			}
 			if data, err := json.Marshal(payload); err == nil {
+				if s.live != nil {
+					s.live.debugf("relaying tunneler_heartbeat tunneler=%s status=%s", t.tunnelerID, msg.GetStatus())
+				}
 				s.sendCh <- &controllerpb.ControlMessage{
 					Type:    "tunneler_heartbeat",
 					Payload: data,
+					Status:  msg.GetStatus(),
 				}
 			}
 		}
@@ -69,12 +170,9 @@ func parseTunnelerID(spiffeID string) string {
 	if spiffeID == "" {
 		return ""
 	}
-	parts := strings.Split(strings.TrimPrefix(spiffeID, "spiffe://"), "/")
-	if len(parts) < 3 {
-		return ""
-	}
-	if parts[1] != "tunneler" {
+	id, err := spiffeid.Parse(spiffeID)
+	if err != nil || id.Role != "tunneler" {
 		return ""
 	}
-	return parts[2]
+	return id.Name
 }
@@ -0,0 +1,37 @@
+package run
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Classes of control-plane session errors, the label of
+// connector_control_plane_errors_total.
+const (
+	errClassDisconnect = "disconnect"
+	errClassIdentity   = "identity"
+	errClassTransient  = "transient"
+)
+
+// maxIdentityRejections is how many consecutive sessions the controller may
+// refuse on identity grounds before the connector gives up. One re-enrollment
+// is attempted in between when a token is provisioned.
+const maxIdentityRejections = 5
+
+// classifyControlPlaneError sorts the error that ended a control-plane
+// session. PermissionDenied and Unauthenticated mean the controller no
+// longer accepts this connector's identity, e.g. because it was deleted;
+// retrying with the same certificate cannot succeed.
+func classifyControlPlaneError(err error) string {
+	var disc *disconnectError
+	if errors.As(err, &disc) {
+		return errClassDisconnect
+	}
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return errClassIdentity
+	}
+	return errClassTransient
+}
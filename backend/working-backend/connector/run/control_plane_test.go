@@ -0,0 +1,176 @@
+package run
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"testing"
+	"time"
+
+	"connector/internal/spiffe"
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// fakeTunnelerStream is a tunneler's ControlPlane.Connect stream as the
+// connector's server sees it.
+type fakeTunnelerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	recv chan *controllerpb.ControlMessage
+	sent chan *controllerpb.ControlMessage
+}
+
+func (f *fakeTunnelerStream) Context() context.Context { return f.ctx }
+
+func (f *fakeTunnelerStream) RecvMsg(m any) error {
+	select {
+	case msg, ok := <-f.recv:
+		if !ok {
+			return io.EOF
+		}
+		dst := m.(*controllerpb.ControlMessage)
+		dst.Type, dst.Payload, dst.Status = msg.GetType(), msg.GetPayload(), msg.GetStatus()
+		return nil
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	}
+}
+
+func (f *fakeTunnelerStream) SendMsg(m any) error {
+	f.sent <- m.(*controllerpb.ControlMessage)
+	return nil
+}
+
+// connectTunneler runs s.Connect for a tunneler presenting a certificate for
+// spiffeID, behind the SPIFFE stream interceptor, and returns its stream and
+// the handler's result.
+func connectTunneler(t *testing.T, s *controlPlaneServer, spiffeID string) (*fakeTunnelerStream, <-chan error) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("run test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caInst, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafPEM, err := ca.IssueWorkloadCert(caInst, spiffeID, &key.PublicKey, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(leafPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ctx = peer.NewContext(ctx, &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}},
+	})
+	stream := &fakeTunnelerStream{
+		ctx:  ctx,
+		recv: make(chan *controllerpb.ControlMessage, 16),
+		sent: make(chan *controllerpb.ControlMessage, 16),
+	}
+	desc := controllerpb.ControlPlane_ServiceDesc.Streams[0]
+	done := make(chan error, 1)
+	go func() {
+		done <- spiffe.StreamInterceptor("example.org", "tunneler")(s, stream,
+			&grpc.StreamServerInfo{FullMethod: controllerpb.ControlPlane_Connect_FullMethodName, IsClientStream: true, IsServerStream: true},
+			desc.Handler)
+	}()
+	return stream, done
+}
+
+func TestTunnelerIdleTimeout(t *testing.T) {
+	s := &controlPlaneServer{connectorID: "c1", idleTimeout: time.Second}
+
+	// A tunneler that keeps talking stays connected past the timeout.
+	active, activeDone := connectTunneler(t, s, "spiffe://example.org/tunneler/busy")
+	for i := 0; i < 6; i++ {
+		active.recv <- &controllerpb.ControlMessage{Type: "ping"}
+		time.Sleep(300 * time.Millisecond)
+	}
+	select {
+	case err := <-activeDone:
+		t.Fatalf("active tunneler stream closed: %v", err)
+	default:
+	}
+	close(active.recv)
+	if err := <-activeDone; err != nil {
+		t.Fatalf("stream closed by the tunneler returned %v", err)
+	}
+
+	// A silent one is closed with an idle_timeout disconnect.
+	idle, idleDone := connectTunneler(t, s, "spiffe://example.org/tunneler/quiet")
+	select {
+	case err := <-idleDone:
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("idle stream closed with %v, want Unavailable", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle tunneler stream was not closed")
+	}
+	var disconnect *controllerpb.ControlMessage
+	for len(idle.sent) > 0 {
+		if msg := <-idle.sent; msg.GetType() == "disconnect" {
+			disconnect = msg
+		}
+	}
+	var payload struct{ Reason string }
+	if disconnect == nil || json.Unmarshal(disconnect.GetPayload(), &payload) != nil || payload.Reason != disconnectIdleTimeout {
+		t.Fatalf("idle stream got disconnect %v, want reason %s", disconnect, disconnectIdleTimeout)
+	}
+	if got := s.active.Load(); got != 0 {
+		t.Fatalf("%d tunnelers still counted as active", got)
+	}
+}
+
+func TestTunnelerHeartbeatRelay(t *testing.T) {
+	sendCh := make(chan *controllerpb.ControlMessage, 4)
+	s := &controlPlaneServer{connectorID: "c1", sendCh: sendCh}
+	stream, done := connectTunneler(t, s, "spiffe://example.org/tunneler/t1")
+
+	spoofed, _ := json.Marshal(map[string]string{"tunneler_id": "t2"})
+	stream.recv <- &controllerpb.ControlMessage{Type: "tunneler_heartbeat", Payload: spoofed, Status: "ONLINE"}
+	stream.recv <- &controllerpb.ControlMessage{Type: "tunneler_heartbeat", Status: "ONLINE"}
+	close(stream.recv)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sendCh) != 1 {
+		t.Fatalf("relayed %d heartbeats, want only the unspoofed one", len(sendCh))
+	}
+	msg := <-sendCh
+	var payload struct {
+		TunnelerID  string `json:"tunneler_id"`
+		SPIFFEID    string `json:"spiffe_id"`
+		ConnectorID string `json:"connector_id"`
+	}
+	if err := json.Unmarshal(msg.GetPayload(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.TunnelerID != "t1" || payload.SPIFFEID != "spiffe://example.org/tunneler/t1" || payload.ConnectorID != "c1" || msg.GetStatus() != "ONLINE" {
+		t.Fatalf("relayed %+v status %q, want the authenticated tunneler on c1", payload, msg.GetStatus())
+	}
+}
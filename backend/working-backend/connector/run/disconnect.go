@@ -0,0 +1,65 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// Reason codes carried by a "disconnect" control message; they match the
+// controller's api.Disconnect* constants.
+const (
+	disconnectShutdown         = "shutdown"
+	disconnectDuplicateID      = "duplicate_id"
+	disconnectRevoked          = "revoked"
+	disconnectOverload         = "overload"
+	disconnectProtocolMismatch = "protocol_mismatch"
+
+	// disconnectIdleTimeout and disconnectDraining are sent only by the
+	// connector: to a tunneler stream that stayed silent past
+	// CONNECTOR_TUNNELER_IDLE_TIMEOUT, and to a new tunneler while draining.
+	disconnectIdleTimeout = "idle_timeout"
+	disconnectDraining    = "draining"
+)
+
+// disconnectError reports that the peer closed the control-plane stream with
+// a reason code.
+type disconnectError struct {
+	reason  string
+	message string
+	// cause is the stream error that followed the disconnect message; it
+	// may carry a RetryInfo detail.
+	cause error
+}
+
+func (e *disconnectError) Error() string {
+	return fmt.Sprintf("disconnected by peer: reason=%s message=%q", e.reason, e.message)
+}
+
+func (e *disconnectError) Unwrap() error { return e.cause }
+
+// terminal reports whether reconnecting cannot succeed without operator
+// action.
+func (e *disconnectError) terminal() bool {
+	return e.reason == disconnectRevoked || e.reason == disconnectProtocolMismatch
+}
+
+func parseDisconnect(msg *controllerpb.ControlMessage) *disconnectError {
+	var payload struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(msg.GetPayload(), &payload)
+	if payload.Reason == "" {
+		payload.Reason = "unknown"
+	}
+	return &disconnectError{reason: payload.Reason, message: payload.Message}
+}
+
+// disconnectMessage builds the final control message sent to a tunneler
+// before its stream is closed.
+func disconnectMessage(reason, message string) *controllerpb.ControlMessage {
+	payload, _ := json.Marshal(map[string]string{"reason": reason, "message": message})
+	return &controllerpb.ControlMessage{Type: "disconnect", Payload: payload}
+}
@@ -0,0 +1,72 @@
+package run
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// drainNotice is the drain payload sent by the controller.
+type drainNotice struct {
+	Drain bool `json:"drain"`
+}
+
+// drainReport is the heartbeat payload while draining; the controller
+// reports the count as the drain's remaining tunnelers.
+type drainReport struct {
+	Tunnelers int64 `json:"tunnelers"`
+}
+
+// connectorDrain is set while the controller has this connector draining:
+// new tunneler streams are refused and heartbeats carry the number of
+// tunnelers still connected. It is not kept across restarts; the controller
+// repeats the drain when the connector reconnects.
+var connectorDrain struct {
+	draining  atomic.Bool
+	tunnelers atomic.Int64
+}
+
+func handleDrain(payload []byte) {
+	var n drainNotice
+	if err := json.Unmarshal(payload, &n); err != nil {
+		log.Printf("drain ignored: invalid payload")
+		return
+	}
+	if connectorDrain.draining.Swap(n.Drain) == n.Drain {
+		return
+	}
+	if n.Drain {
+		drainingGauge.Set(1)
+		log.Printf("connector draining: refusing new tunnelers, %d connected", connectorDrain.tunnelers.Load())
+	} else {
+		drainingGauge.Set(0)
+		log.Printf("connector drain cancelled: accepting tunnelers")
+	}
+}
+
+// resetDrain clears the drain at the start of a control-plane session.
+func resetDrain() {
+	if connectorDrain.draining.Swap(false) {
+		drainingGauge.Set(0)
+	}
+}
+
+// heartbeatMessage builds a heartbeat; while draining its status is DRAINING
+// and its payload a drainReport.
+func heartbeatMessage(connectorID, privateIP, listenAddr, version string) *controllerpb.ControlMessage {
+	msg := &controllerpb.ControlMessage{
+		Type:        "heartbeat",
+		ConnectorId: connectorID,
+		PrivateIp:   privateIP,
+		Status:      "ONLINE",
+		ListenAddr:  listenAddr,
+		Version:     version,
+	}
+	if connectorDrain.draining.Load() {
+		msg.Status = "DRAINING"
+		msg.Payload, _ = json.Marshal(drainReport{Tunnelers: connectorDrain.tunnelers.Load()})
+	}
+	return msg
+}
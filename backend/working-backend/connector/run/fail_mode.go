@@ -0,0 +1,81 @@
+package run
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"connector/internal/spiffe"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// controlPlaneHealth tracks whether the control-plane session is up. In
+// closed fail mode it refuses tunnelers once the session has been down for
+// longer than grace, since the allowlist can no longer be kept current.
+type controlPlaneHealth struct {
+	failClosed bool
+	grace      time.Duration
+
+	mu        sync.Mutex
+	connected bool
+	since     time.Time // when connected last changed
+}
+
+// newControlPlaneHealth returns the tracker for CONTROL_PLANE_FAIL_MODE
+// (closed when failClosed) and CONTROL_PLANE_FAIL_GRACE.
+func newControlPlaneHealth(failClosed bool, grace time.Duration) *controlPlaneHealth {
+	return &controlPlaneHealth{failClosed: failClosed, grace: grace, since: time.Now()}
+}
+
+func (h *controlPlaneHealth) setConnected(connected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.connected != connected {
+		h.connected = connected
+		h.since = time.Now()
+	}
+}
+
+// Connected reports whether a control-plane session is currently up.
+func (h *controlPlaneHealth) Connected() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connected
+}
+
+// failing reports whether tunnelers are being refused, and for how long the
+// control plane has been unreachable.
+func (h *controlPlaneHealth) failing() (bool, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.connected {
+		return false, 0
+	}
+	down := time.Since(h.since)
+	return h.failClosed && down > h.grace, down
+}
+
+// Admit implements spiffe.AdmissionGate. Before the first session the grace
+// period runs from startup.
+func (h *controlPlaneHealth) Admit() error {
+	failing, down := h.failing()
+	if !failing {
+		return nil
+	}
+	log.Printf("tunneler admission refused: control plane unreachable for %s (CONTROL_PLANE_FAIL_MODE=closed)", down.Round(time.Second))
+	return status.Error(codes.Unavailable, "connector cannot reach the control plane")
+}
+
+// admissionGates admits a tunneler only if every gate does.
+type admissionGates []spiffe.AdmissionGate
+
+func (g admissionGates) Admit() error {
+	for _, gate := range g {
+		if err := gate.Admit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package run
+
+import (
+	"context"
+
+	"connector/internal/spiffe"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthProbeMethods are the RPCs a peer without a client certificate may
+// call when CONNECTOR_CLIENT_AUTH=health-probe. Watch is left out so an
+// unauthenticated peer cannot hold a stream open.
+var healthProbeMethods = map[string]struct{}{
+	healthpb.Health_Check_FullMethodName: {},
+}
+
+// healthServer answers grpc.health.v1 checks for the whole connector: it is
+// SERVING while tunnelers would be admitted, so a probe fails when the
+// admission gates (control plane in fail-closed mode, required backends)
+// would refuse real traffic.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	gate spiffe.AdmissionGate
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if req.GetService() != "" {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	if h.gate != nil && h.gate.Admit() != nil {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
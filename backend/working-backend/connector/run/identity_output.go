@@ -0,0 +1,104 @@
+package run
+
+import (
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"connector/enroll"
+)
+
+// identityOutput writes the workload identity for the next restart
+// (stateDir) and for external tools (bundlePath), and tells a reloading
+// process (reloadPIDFile) when the files change.
+type identityOutput struct {
+	stateDir      string
+	bundlePath    string
+	reloadPIDFile string
+}
+
+// write persists a new identity after enrollment or renewal, rewrites the
+// bundle and signals the reload process.
+func (o identityOutput) write(cert tls.Certificate, certPEM, caPEM []byte) {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		if o.stateDir != "" || o.bundlePath != "" {
+			log.Printf("identity not persisted: unsupported private key type %T", cert.PrivateKey)
+		}
+		return
+	}
+	wrote := false
+	if o.stateDir != "" {
+		if err := enroll.PersistIdentity(o.stateDir, certPEM, signer, caPEM); err != nil {
+			log.Printf("failed to persist identity: %v", err)
+		} else {
+			wrote = true
+		}
+	}
+	if o.bundle(signer, certPEM, caPEM) {
+		wrote = true
+	}
+	if wrote {
+		o.rotated(certPEM)
+	}
+}
+
+// writeBundle rewrites only the bundle, for an identity loaded from
+// stateDir at startup, so the bundle matches it even if the bundle path
+// changed.
+func (o identityOutput) writeBundle(cert tls.Certificate, certPEM, caPEM []byte) {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok || o.bundlePath == "" {
+		return
+	}
+	if o.bundle(signer, certPEM, caPEM) {
+		o.rotated(certPEM)
+	}
+}
+
+func (o identityOutput) bundle(signer crypto.Signer, certPEM, caPEM []byte) bool {
+	if o.bundlePath == "" {
+		return false
+	}
+	if err := enroll.WriteIdentityBundle(o.bundlePath, certPEM, signer, caPEM); err != nil {
+		log.Printf("failed to write identity bundle %s: %v", o.bundlePath, err)
+		return false
+	}
+	return true
+}
+
+// rotated logs the identity now on disk and sends SIGHUP to the process in
+// reloadPIDFile, so tools watching the files know to reload them.
+func (o identityOutput) rotated(certPEM []byte) {
+	if leaf, err := parseLeafCert(certPEM); err == nil {
+		log.Printf("identity written: serial=%x not_after=%s", leaf.SerialNumber, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if o.reloadPIDFile == "" {
+		return
+	}
+	if err := signalPIDFile(o.reloadPIDFile, syscall.SIGHUP); err != nil {
+		log.Printf("identity reload not signalled: %v", err)
+	}
+}
+
+func signalPIDFile(path string, sig os.Signal) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("%s: invalid pid %q", path, strings.TrimSpace(string(data)))
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
@@ -0,0 +1,86 @@
+package run
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/pem"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"connector/enroll"
+	"controller/ca"
+)
+
+func TestIdentityOutputSignalsReload(t *testing.T) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	pidFile := filepath.Join(t.TempDir(), "reload.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	identityOutput{reloadPIDFile: pidFile}.rotated(nil)
+	select {
+	case <-hup:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload process was not sent SIGHUP")
+	}
+
+	if err := os.WriteFile(pidFile, []byte("not a pid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := signalPIDFile(pidFile, syscall.SIGHUP); err == nil {
+		t.Fatal("invalid pid file accepted")
+	}
+}
+
+func TestIdentityOutputRenewalSurvivesRestart(t *testing.T) {
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("identity test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caInst, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caInst.Cert.Raw})
+	issue := func(ttl time.Duration) (tls.Certificate, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafPEM, err := ca.IssueWorkloadCert(caInst, "spiffe://example.org/connector/c1", &key.PublicKey, ttl, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{PrivateKey: key}, leafPEM
+	}
+
+	dir := t.TempDir()
+	out := identityOutput{stateDir: dir}
+	enrolled, enrolledPEM := issue(10 * time.Minute)
+	out.write(enrolled, enrolledPEM, caPEM)
+	renewed, renewedPEM := issue(20 * time.Minute)
+	out.write(renewed, renewedPEM, caPEM)
+
+	// A restart loads the renewed identity, not the enrolled one.
+	id, err := enroll.LoadIdentity(dir)
+	if err != nil {
+		t.Fatalf("LoadIdentity: %v", err)
+	}
+	want, err := parseLeafCert(renewedPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Leaf.SerialNumber.Cmp(want.SerialNumber) != 0 || !id.Leaf.NotAfter.Equal(want.NotAfter) {
+		t.Fatalf("loaded serial %x expiring %s, want the renewed %x expiring %s", id.Leaf.SerialNumber, id.Leaf.NotAfter, want.SerialNumber, want.NotAfter)
+	}
+}
@@ -0,0 +1,12 @@
+package run
+
+import "time"
+
+// idleCheckInterval bounds how late past the timeout an idle stream is
+// closed.
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	if d := timeout / 10; d > time.Second {
+		return d
+	}
+	return time.Second
+}
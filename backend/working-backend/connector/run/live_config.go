@@ -0,0 +1,174 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"connector/internal/config"
+)
+
+const (
+	defaultHeartbeatInterval = 10 * time.Second
+	minHeartbeatInterval     = time.Second
+	maxHeartbeatInterval     = 5 * time.Minute
+	maxTunnelersLimit        = 10000
+)
+
+// liveConfig holds settings the controller can change at runtime via a
+// config_update control message. Startup config values are the baseline;
+// pushed values override them until the connector restarts or the setting
+// is changed in a reloaded config file.
+type liveConfig struct {
+	mu                sync.RWMutex
+	heartbeatInterval time.Duration
+	maxTunnelers      int
+	logLevel          string
+}
+
+// configUpdate is the config_update payload. Unknown keys are ignored and
+// omitted fields are left unchanged.
+type configUpdate struct {
+	HeartbeatInterval *string `json:"heartbeat_interval"`
+	MaxTunnelers      *int    `json:"max_tunnelers"`
+	LogLevel          *string `json:"log_level"`
+}
+
+func newLiveConfig(cfg *config.Config) (*liveConfig, error) {
+	c := &liveConfig{
+		heartbeatInterval: defaultHeartbeatInterval,
+		logLevel:          "info",
+	}
+	if d := cfg.HeartbeatInterval; d != 0 {
+		if err := validateHeartbeatInterval(d); err != nil {
+			return nil, fmt.Errorf("CONNECTOR_HEARTBEAT_INTERVAL: %w", err)
+		}
+		c.heartbeatInterval = d
+	}
+	if n := cfg.MaxTunnelers; n != 0 {
+		if err := validateMaxTunnelers(n); err != nil {
+			return nil, fmt.Errorf("CONNECTOR_MAX_TUNNELERS: %w", err)
+		}
+		c.maxTunnelers = n
+	}
+	if v := cfg.LogLevel; v != "" {
+		if err := validateLogLevel(v); err != nil {
+			return nil, fmt.Errorf("CONNECTOR_LOG_LEVEL: %w", err)
+		}
+		c.logLevel = v
+	}
+	return c, nil
+}
+
+// apply validates and applies a config_update payload. Invalid fields are
+// logged and skipped; valid fields in the same update still apply.
+func (c *liveConfig) apply(payload []byte) {
+	var u configUpdate
+	if err := json.Unmarshal(payload, &u); err != nil {
+		log.Printf("config_update ignored: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u.HeartbeatInterval != nil {
+		if d, err := parseHeartbeatInterval(*u.HeartbeatInterval); err != nil {
+			log.Printf("config_update: heartbeat_interval rejected: %v", err)
+		} else {
+			c.heartbeatInterval = d
+			log.Printf("config_update: heartbeat_interval=%s", d)
+		}
+	}
+	if u.MaxTunnelers != nil {
+		if err := validateMaxTunnelers(*u.MaxTunnelers); err != nil {
+			log.Printf("config_update: max_tunnelers rejected: %v", err)
+		} else {
+			c.maxTunnelers = *u.MaxTunnelers
+			log.Printf("config_update: max_tunnelers=%d", c.maxTunnelers)
+		}
+	}
+	if u.LogLevel != nil {
+		if err := validateLogLevel(*u.LogLevel); err != nil {
+			log.Printf("config_update: log_level rejected: %v", err)
+		} else {
+			c.logLevel = *u.LogLevel
+			log.Printf("config_update: log_level=%s", c.logLevel)
+		}
+	}
+}
+
+// reload copies the settings named in changed from next, built from a
+// reloaded configuration, and logs each one.
+func (c *liveConfig) reload(next *liveConfig, changed map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if changed["CONNECTOR_HEARTBEAT_INTERVAL"] {
+		c.heartbeatInterval = next.heartbeatInterval
+		log.Printf("config reload: heartbeat_interval=%s", c.heartbeatInterval)
+	}
+	if changed["CONNECTOR_MAX_TUNNELERS"] {
+		c.maxTunnelers = next.maxTunnelers
+		log.Printf("config reload: max_tunnelers=%d", c.maxTunnelers)
+	}
+	if changed["CONNECTOR_LOG_LEVEL"] {
+		c.logLevel = next.logLevel
+		log.Printf("config reload: log_level=%s", c.logLevel)
+	}
+}
+
+func (c *liveConfig) HeartbeatInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.heartbeatInterval
+}
+
+// MaxTunnelers returns the tunneler stream limit; 0 means unlimited.
+func (c *liveConfig) MaxTunnelers() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxTunnelers
+}
+
+// debugf logs only when log_level is debug.
+func (c *liveConfig) debugf(format string, args ...interface{}) {
+	c.mu.RLock()
+	debug := c.logLevel == "debug"
+	c.mu.RUnlock()
+	if debug {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+func parseHeartbeatInterval(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateHeartbeatInterval(d); err != nil {
+		return 0, err
+	}
+	return d, nil
+}
+
+func validateHeartbeatInterval(d time.Duration) error {
+	if d < minHeartbeatInterval || d > maxHeartbeatInterval {
+		return fmt.Errorf("must be between %s and %s", minHeartbeatInterval, maxHeartbeatInterval)
+	}
+	return nil
+}
+
+func validateMaxTunnelers(n int) error {
+	if n < 0 || n > maxTunnelersLimit {
+		return fmt.Errorf("must be between 0 and %d", maxTunnelersLimit)
+	}
+	return nil
+}
+
+func validateLogLevel(v string) error {
+	if v != "info" && v != "debug" {
+		return fmt.Errorf("must be info or debug")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long Run waits for its loops to exit once the
+// context is canceled.
+const shutdownTimeout = 10 * time.Second
+
+// loopGroup runs the connector's long-lived loops so that Run can stop them
+// together. The first loop to fail cancels the others, and its error is the
+// one Run returns.
+type loopGroup struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]bool
+	err     error
+}
+
+func newLoopGroup(cancel context.CancelFunc) *loopGroup {
+	return &loopGroup{cancel: cancel, running: make(map[string]bool)}
+}
+
+// Go runs fn in its own goroutine. fn must return once the group's context
+// is canceled; a non-nil error other than context.Canceled is fatal.
+func (g *loopGroup) Go(name string, fn func() error) {
+	g.mu.Lock()
+	g.running[name] = true
+	g.mu.Unlock()
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := fn()
+		g.mu.Lock()
+		delete(g.running, name)
+		if err != nil && !errors.Is(err, context.Canceled) && g.err == nil {
+			g.err = err
+			g.cancel()
+		}
+		g.mu.Unlock()
+	}()
+}
+
+// Wait waits up to timeout for every loop to return and reports the first
+// fatal error. Loops still running at the deadline are logged and left
+// behind.
+func (g *loopGroup) Wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		g.mu.Lock()
+		names := make([]string, 0, len(g.running))
+		for name := range g.running {
+			names = append(names, name)
+		}
+		g.mu.Unlock()
+		sort.Strings(names)
+		log.Printf("shutdown: %s did not stop within %s", strings.Join(names, ", "), timeout)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
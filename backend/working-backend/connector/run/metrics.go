@@ -0,0 +1,136 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"connector/internal/metrics"
+	"connector/internal/tlsutil"
+)
+
+var (
+	tunnelersConnected = metrics.NewGauge(
+		"connector_tunnelers_connected",
+		"Tunneler control streams currently connected.",
+	)
+	tunnelsOpen = metrics.NewGauge(
+		"connector_tunnels_open",
+		"TunnelService streams currently proxied to a backend.",
+	)
+	certRenewals = metrics.NewCounter(
+		"connector_cert_renewals_total",
+		"Successful workload certificate renewals.",
+	)
+	certRenewalFailures = metrics.NewCounter(
+		"connector_cert_renewal_failures_total",
+		"Failed workload certificate renewal attempts.",
+	)
+	renewalAlarm = metrics.NewGauge(
+		"connector_cert_renewal_alarm",
+		"1 while certificate renewal has failed past the RENEWAL_MAX_FAILURES/RENEWAL_REENROLL_WITHIN threshold.",
+	)
+	reenrollments = metrics.NewCounter(
+		"connector_reenrollments_total",
+		"Successful re-enrollments after repeated renewal failures.",
+	)
+	reenrollFailures = metrics.NewCounter(
+		"connector_reenroll_failures_total",
+		"Failed re-enrollment attempts after repeated renewal failures.",
+	)
+	controlPlaneReconnects = metrics.NewCounter(
+		"connector_control_plane_reconnects_total",
+		"Control-plane sessions that ended and were re-established.",
+	)
+	controlPlaneErrors = metrics.NewCounterVec(
+		"connector_control_plane_errors_total",
+		"Control-plane sessions that ended with an error, by class (disconnect, identity or transient).",
+		"class",
+	)
+	upgradeAvailable = metrics.NewGauge(
+		"connector_upgrade_available",
+		"1 once the controller has announced a newer connector release, else 0.",
+	)
+	drainingGauge = metrics.NewGauge(
+		"connector_draining",
+		"1 while the controller has the connector draining, else 0.",
+	)
+	allowlistReconciliations = metrics.NewCounter(
+		"connector_allowlist_reconciliations_total",
+		"Full allowlist updates that changed the set, i.e. corrected a missed update.",
+	)
+	allowlistRequests = metrics.NewCounter(
+		"connector_allowlist_requests_total",
+		"allowlist_request messages sent after a tunneler was refused as unknown.",
+	)
+	tunnelerIdleTimeout = metrics.NewGauge(
+		"connector_tunneler_idle_timeout_seconds",
+		"CONNECTOR_TUNNELER_IDLE_TIMEOUT in seconds; 0 when disabled.",
+	)
+	tunnelerIdleClosed = metrics.NewCounter(
+		"connector_tunneler_idle_closed_total",
+		"Tunneler control streams closed for sending nothing within the idle timeout.",
+	)
+	controlPlaneUnknownMessages = metrics.NewCounter(
+		"connector_control_plane_unknown_messages_total",
+		"Control messages received from the controller with an unknown type.",
+	)
+	tlsHandshakeFailures = metrics.NewCounterVec(
+		"connector_tls_handshake_failures_total",
+		"Failed TLS handshakes on the tunneler-facing listener, by reason.",
+		"reason",
+	)
+)
+
+// registerRuntimeMetrics registers gauges computed from live connector state.
+// It must be called once per process.
+func registerRuntimeMetrics(store *tlsutil.CertStore, allowlist *tunnelerAllowlist, cpHealth *controlPlaneHealth) {
+	metrics.NewGaugeFunc(
+		"connector_cert_seconds_until_expiry",
+		"Seconds until the current workload certificate expires.",
+		func() float64 { return time.Until(store.NotAfter()).Seconds() },
+	)
+	metrics.NewGaugeFunc(
+		"connector_allowlist_size",
+		"Tunneler SPIFFE IDs currently in the allowlist.",
+		func() float64 { return float64(allowlist.Len()) },
+	)
+	metrics.NewGaugeFunc(
+		"connector_control_plane_connected",
+		"1 while the control-plane session is up, else 0.",
+		func() float64 { return boolGauge(cpHealth.Connected()) },
+	)
+	metrics.NewGaugeFunc(
+		"connector_control_plane_fail_closed",
+		"1 while tunnelers are refused because the control plane has been unreachable past CONTROL_PLANE_FAIL_GRACE (CONTROL_PLANE_FAIL_MODE=closed).",
+		func() float64 {
+			failing, _ := cpHealth.failing()
+			return boolGauge(failing)
+		},
+	)
+}
+
+// metricsServer serves /metrics on addr until ctx is canceled. It is only
+// started when CONNECTOR_METRICS_ADDR is set.
+func metricsServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	log.Printf("connector metrics listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("connector metrics server stopped: %v", err)
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package run
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"connector/enroll"
+	"connector/internal/config"
+	"connector/internal/tlsutil"
+)
+
+// reenrollBackoff spaces out re-enrollment attempts while renewal keeps
+// failing, so a missing or rejected token is not retried every 10s.
+const reenrollBackoff = time.Minute
+
+var (
+	errNoProvisionedToken = errors.New("no enrollment token provisioned")
+	errIdentityRejected   = errors.New("controller rejected the connector identity")
+)
+
+// renewalPolicy decides when repeated renewal failures escalate to an alarm
+// and a full re-enrollment.
+type renewalPolicy struct {
+	// maxFailures escalates after this many consecutive failures; 0 disables.
+	maxFailures int
+	// reenrollWithin escalates on any failure once the certificate expires
+	// within this window; 0 disables.
+	reenrollWithin time.Duration
+	// rpcTimeout bounds each renewal attempt, dial included, so a
+	// controller that accepts connections but never answers cannot stall
+	// the renewal loop past the renewal window.
+	rpcTimeout time.Duration
+	// token is ENROLLMENT_TOKEN, used for re-enrollment in preference to
+	// the systemd credential.
+	token string
+}
+
+func newRenewalPolicy(c *config.Config) renewalPolicy {
+	return renewalPolicy{
+		maxFailures:    c.RenewalMaxFailures,
+		reenrollWithin: c.RenewalReenrollWithin,
+		rpcTimeout:     c.RenewalRPCTimeout,
+		token:          c.EnrollmentToken,
+	}
+}
+
+func (p renewalPolicy) escalate(failures int, notAfter time.Time) bool {
+	if failures == 0 {
+		return false
+	}
+	if p.maxFailures > 0 && failures >= p.maxFailures {
+		return true
+	}
+	return p.reenrollWithin > 0 && time.Until(notAfter) <= p.reenrollWithin
+}
+
+// provisionedToken returns the enrollment token currently provisioned via
+// ENROLLMENT_TOKEN or the systemd credential. The credential is re-read on
+// every call because the token used at startup has normally been consumed.
+func provisionedToken(envToken string) (string, error) {
+	if envToken != "" {
+		return envToken, nil
+	}
+	return enroll.ReadCredential("ENROLLMENT_TOKEN")
+}
+
+// reenroll performs a full enrollment as a last resort when renewal keeps
+// failing. The controller must still present the CA the connector trusts.
+func reenroll(ctx context.Context, cfg enroll.Config, policy renewalPolicy, caPEM []byte) (tls.Certificate, []byte, error) {
+	token, err := provisionedToken(policy.token)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	if token == "" && !cfg.ApprovalMode && cfg.BootstrapCertPath == "" {
+		return tls.Certificate{}, nil, errNoProvisionedToken
+	}
+	cfg.Token = token
+	cert, certPEM, newCAPEM, _, err := enroll.Enroll(ctx, cfg)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	if !tlsutil.EqualCAPEM(caPEM, newCAPEM) {
+		return tls.Certificate{}, nil, errors.New("internal CA mismatch during re-enrollment")
+	}
+	return cert, certPEM, nil
+}
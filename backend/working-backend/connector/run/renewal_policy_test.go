@@ -0,0 +1,57 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"connector/enroll"
+)
+
+func TestRenewalPolicyEscalate(t *testing.T) {
+	p := renewalPolicy{maxFailures: 3, reenrollWithin: time.Hour}
+	later := time.Now().Add(24 * time.Hour)
+	soon := time.Now().Add(30 * time.Minute)
+	tests := []struct {
+		failures int
+		notAfter time.Time
+		want     bool
+	}{
+		{0, soon, false},
+		{1, later, false},
+		{2, later, false},
+		{3, later, true},
+		{1, soon, true},
+	}
+	for _, tt := range tests {
+		if got := p.escalate(tt.failures, tt.notAfter); got != tt.want {
+			t.Errorf("escalate(%d, expires in %s) = %v, want %v", tt.failures, time.Until(tt.notAfter).Round(time.Minute), got, tt.want)
+		}
+	}
+	if (renewalPolicy{}).escalate(100, soon) {
+		t.Error("escalated with both triggers disabled")
+	}
+}
+
+func TestProvisionedToken(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	if _, _, err := reenroll(context.Background(), enroll.Config{}, renewalPolicy{}, nil); !errors.Is(err, errNoProvisionedToken) {
+		t.Fatalf("reenroll without a token: %v, want errNoProvisionedToken", err)
+	}
+
+	// The credential is re-read, so a token provisioned after startup is used.
+	if err := os.WriteFile(filepath.Join(dir, "ENROLLMENT_TOKEN"), []byte("fresh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := provisionedToken(""); err != nil || got != "fresh" {
+		t.Fatalf("provisionedToken() = %q, %v; want the credential", got, err)
+	}
+	if got, _ := provisionedToken("env"); got != "env" {
+		t.Fatalf("provisionedToken(env) = %q; ENROLLMENT_TOKEN should win", got)
+	}
+}
@@ -2,9 +2,7 @@ package run
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -20,48 +18,67 @@ import (
 	"time"
 
 	"connector/enroll"
+	"connector/internal/config"
+	"connector/internal/failover"
 	"connector/internal/spiffe"
 	"connector/internal/tlsutil"
+	"controller/dialaddr"
 	controllerpb "controller/gen/controllerpb"
+	"controller/spiffeid"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
-// Run starts the long-running connector service.
-func Run() error {
-	cfg, err := configFromEnv()
+// Run starts the long-running connector service and blocks until ctx is
+// canceled or a loop fails. Either way it cancels the remaining loops and
+// waits up to shutdownTimeout for them to exit before returning.
+func Run(ctx context.Context, c *config.Config) error {
+	enrollCfg, err := enroll.NewConfig(c)
 	if err != nil {
 		return err
 	}
-
-	enrollCfg, err := enroll.ConfigFromEnvRun()
+	cfg := newRuntimeConfig(c, enrollCfg)
+	enrollCfg.Token, err = provisionedToken(c.EnrollmentToken)
 	if err != nil {
 		return err
 	}
-	enrollCfg.Token = os.Getenv("ENROLLMENT_TOKEN")
-	if enrollCfg.Token == "" {
-		cred, err := enroll.ReadCredential("ENROLLMENT_TOKEN")
-		if err != nil {
-			return err
-		}
-		enrollCfg.Token = cred
-	}
+	policy := newRenewalPolicy(c)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	loops := newLoopGroup(cancel)
 
 	if systemdWatchdogEnabled() {
-		go systemdWatchdogLoop(ctx)
+		loops.Go("systemd watchdog", func() error {
+			systemdWatchdogLoop(ctx)
+			return nil
+		})
 	}
 
-	if enrollCfg.Token == "" {
-		return fmt.Errorf("ENROLLMENT_TOKEN is required for enrollment")
-	}
-	cert, certPEM, caPEM, spiffeID, err := enroll.Enroll(ctx, enrollCfg)
-	if err != nil {
-		return err
+	var (
+		cert           tls.Certificate
+		certPEM, caPEM []byte
+		spiffeID       string
+	)
+	expectedSPIFFE := fmt.Sprintf("spiffe://%s/connector/%s", enrollCfg.TrustDomain, enrollCfg.ConnectorID)
+	if id := loadPersistedIdentity(cfg.stateDir, expectedSPIFFE); id != nil {
+		cert, certPEM, caPEM, spiffeID = id.Cert, id.CertPEM, id.CAPEM, expectedSPIFFE
+		log.Printf("loaded persisted identity from %s", cfg.stateDir)
+		cfg.identityOut.writeBundle(cert, certPEM, caPEM)
+	} else {
+		if enrollCfg.Token == "" && !enrollCfg.TokenOptional() {
+			return fmt.Errorf("ENROLLMENT_TOKEN is required for enrollment")
+		}
+		cert, certPEM, caPEM, spiffeID, err = enroll.Enroll(ctx, enrollCfg)
+		if err != nil {
+			return err
+		}
+		cfg.identityOut.write(cert, certPEM, caPEM)
 	}
 
 	certInfo, err := parseLeafCert(certPEM)
@@ -79,19 +96,76 @@ func Run() error {
 	if err != nil {
 		return err
 	}
+	identity := workloadIdentity{store: store, roots: rootPool, caPEM: caPEM}
+	live, err := newLiveConfig(c)
+	if err != nil {
+		return err
+	}
 	allowlist := newTunnelerAllowlist()
 	controllerSendCh := make(chan *controllerpb.ControlMessage, 16)
+	allowlist.onMiss = newAllowlistRequester(controllerSendCh).request
 
-	reloadCh := make(chan struct{}, 1)
-	go controlPlaneLoop(ctx, cfg.controllerAddr, cfg.trustDomain, cfg.connectorID, cfg.privateIP, store, rootPool, allowlist, controllerSendCh, reloadCh)
-	go renewalLoop(ctx, cfg.controllerAddr, cfg.connectorID, cfg.trustDomain, store, rootPool, caPEM, totalTTL)
+	backends, err := parseBackendTargets(c.Backends, c.GateBackends)
+	if err != nil {
+		return err
+	}
+	health := newBackendHealth(backends, c.BackendCheckInterval)
+	tunnels, err := newTunnelServer(backends, c.TargetAllowlist, health)
+	if err != nil {
+		return err
+	}
+	cpHealth := newControlPlaneHealth(c.FailClosed, c.FailGrace)
+	gates := admissionGates{cpHealth}
+	if health != nil {
+		loops.Go("backend health", func() error {
+			health.run(ctx)
+			return nil
+		})
+		gates = append(gates, health)
+	}
+	reloader := &configReloader{running: c, live: live, tunnels: tunnels, health: health}
+	loops.Go("config reload", func() error {
+		reloader.run(ctx)
+		return nil
+	})
+	registerRuntimeMetrics(store, allowlist, cpHealth)
+	tunnelerIdleTimeout.Set(cfg.tunnelerIdleTimeout.Seconds())
+	if cfg.metricsAddr != "" {
+		loops.Go("metrics server", func() error {
+			metricsServer(ctx, cfg.metricsAddr)
+			return nil
+		})
+	}
+
+	identityRejectedCh := make(chan struct{}, 1)
+	cpState := controlPlaneState{allowlist: allowlist, live: live, health: cpHealth, sendCh: controllerSendCh}
+	loops.Go("control plane", func() error {
+		return controlPlaneLoop(ctx, cfg, identity, cpState, identityRejectedCh)
+	})
+	if cfg.reuseKey {
+		log.Println("certificate renewal reuses the current private key (RENEW_REUSE_KEY)")
+	}
+	loops.Go("certificate renewal", func() error {
+		renewalLoop(ctx, cfg, identity, totalTTL, policy, enrollCfg, identityRejectedCh)
+		return nil
+	})
 
 	if cfg.listenAddr != "" {
-		go serverLoop(ctx, cfg.listenAddr, cfg.trustDomain, store, rootPool, allowlist, controllerSendCh, cfg.connectorID)
+		if cfg.healthProbe {
+			log.Println("connector server accepts unauthenticated grpc.health.v1 checks (CONNECTOR_CLIENT_AUTH=health-probe)")
+		}
+		loops.Go("connector server", func() error {
+			serverLoop(ctx, cfg, identity, allowlist, gates, live, tunnels, controllerSendCh)
+			return nil
+		})
 	}
 
 	<-ctx.Done()
-	return ctx.Err()
+	log.Println("connector shutting down")
+	if err := loops.Wait(shutdownTimeout); err != nil {
+		return err
+	}
+	return context.Cause(ctx)
 }
 
 func systemdWatchdogEnabled() bool {
@@ -158,48 +232,129 @@ func systemdNotify(socket, msg string) error {
 }
 
 type runtimeConfig struct {
-	controllerAddr string
-	connectorID    string
-	trustDomain    string
-	listenAddr     string
-	privateIP      string
+	// controllers are the CONTROLLER_ADDR entries shared by the control
+	// plane and renewal, so both fail over together.
+	controllers *failover.Endpoints
+	connectorID string
+	trustDomain string
+	// controllerID is the controller SPIFFE ID to require, or "" for any
+	// controller in the trust domain.
+	controllerID string
+	listenAddr   string
+	privateIP    string
+	version      string
+	// extraSANs and keyAlgorithm shape every renewal request.
+	extraSANs    enroll.ExtraSANs
+	keyAlgorithm string
+	// stateDir, when set, persists the workload identity across restarts.
+	stateDir string
+	// identityOut writes the workload identity to disk after enrollment
+	// and every renewal.
+	identityOut identityOutput
+	// metricsAddr, when set, serves Prometheus metrics at /metrics.
+	metricsAddr string
+	// compression is "gzip" to compress control messages sent to the
+	// controller, or "" for none.
+	compression string
+	// reuseKey renews the certificate for the current private key instead
+	// of a freshly generated one.
+	reuseKey bool
+	// strict ends the control-plane session on an unknown message type
+	// instead of logging and dropping it.
+	strict bool
+	// tunnelerIdleTimeout closes tunneler streams that send nothing for
+	// this long; 0 disables it.
+	tunnelerIdleTimeout time.Duration
+	// healthProbe lets peers without a client certificate call the gRPC
+	// health check on the tunneler-facing server.
+	healthProbe bool
 }
 
-func configFromEnv() (runtimeConfig, error) {
-	controllerAddr := os.Getenv("CONTROLLER_ADDR")
-	connectorID := os.Getenv("CONNECTOR_ID")
-	trustDomain := os.Getenv("TRUST_DOMAIN")
-	listenAddr := os.Getenv("CONNECTOR_LISTEN_ADDR")
-
-	if trustDomain == "" {
-		trustDomain = "mycorp.internal"
-	}
-	if controllerAddr == "" {
-		return runtimeConfig{}, fmt.Errorf("CONTROLLER_ADDR is not set")
-	}
-	if connectorID == "" {
-		return runtimeConfig{}, fmt.Errorf("CONNECTOR_ID is not set")
-	}
-
-	privateIP, err := enroll.ResolvePrivateIP(controllerAddr)
-	if err != nil {
-		return runtimeConfig{}, err
-	}
+func newRuntimeConfig(c *config.Config, enrollCfg enroll.Config) runtimeConfig {
+	privateIP := enrollCfg.PrivateIP
+	// Without a private IP (CONNECTOR_PRIVATE_IP=none) the default listens
+	// on all interfaces, and the controller cannot derive a dial address
+	// from it.
+	listenAddr := c.ListenAddr
 	if listenAddr == "" {
 		listenAddr = net.JoinHostPort(privateIP, "9443")
 	}
-
 	return runtimeConfig{
-		controllerAddr: controllerAddr,
-		connectorID:    connectorID,
-		trustDomain:    trustDomain,
-		listenAddr:     listenAddr,
-		privateIP:      privateIP,
-	}, nil
+		controllers:  failover.New(c.ControllerAddrs),
+		connectorID:  c.ConnectorID,
+		trustDomain:  c.TrustDomain,
+		controllerID: enrollCfg.ControllerID,
+		listenAddr:   listenAddr,
+		privateIP:    privateIP,
+		version:      enroll.ResolveVersion(c.Version),
+		extraSANs:    enrollCfg.ExtraSANs,
+		keyAlgorithm: enrollCfg.KeyAlgorithm,
+		stateDir:     c.StateDir,
+		identityOut: identityOutput{
+			stateDir:      c.StateDir,
+			bundlePath:    c.IdentityBundlePath,
+			reloadPIDFile: c.IdentityReloadPIDFile,
+		},
+		metricsAddr: c.MetricsAddr,
+		compression: c.Compression,
+		reuseKey:    c.RenewReuseKey,
+		strict:      c.ControlPlaneStrict,
+
+		tunnelerIdleTimeout: c.TunnelerIdleTimeout,
+		healthProbe:         c.HealthProbe,
+	}
+}
+
+// workloadIdentity is the connector's certificate store and the internal CA
+// it trusts, shared by every loop that speaks mTLS.
+type workloadIdentity struct {
+	store *tlsutil.CertStore
+	roots *x509.CertPool
+	caPEM []byte
+}
+
+// controlPlaneState is the connector state a control-plane session reads
+// and updates.
+type controlPlaneState struct {
+	allowlist *tunnelerAllowlist
+	live      *liveConfig
+	health    *controlPlaneHealth
+	// sendCh carries messages from other loops to the controller.
+	sendCh <-chan *controllerpb.ControlMessage
+}
+
+// loadPersistedIdentity returns a usable identity from stateDir, or nil if
+// the connector has to enroll. A corrupt identity is reported loudly since
+// re-enrolling consumes a token.
+func loadPersistedIdentity(stateDir, expectedSPIFFE string) *enroll.Identity {
+	if stateDir == "" {
+		return nil
+	}
+	id, err := enroll.LoadIdentity(stateDir)
+	switch {
+	case errors.Is(err, enroll.ErrNoIdentity):
+		log.Printf("no persisted identity in %s, enrolling", stateDir)
+		return nil
+	case errors.Is(err, enroll.ErrCorruptIdentity):
+		log.Printf("WARNING: %v; falling back to enrollment", err)
+		return nil
+	case err != nil:
+		log.Printf("WARNING: failed to load persisted identity: %v; falling back to enrollment", err)
+		return nil
+	}
+	if uri, err := spiffeid.URIOf(id.Leaf); err != nil || uri.String() != expectedSPIFFE {
+		log.Printf("persisted identity does not match %s, enrolling", expectedSPIFFE)
+		return nil
+	}
+	if !time.Now().Before(id.Leaf.NotAfter) {
+		log.Printf("persisted identity expired at %s, enrolling", id.Leaf.NotAfter.Format(time.RFC3339))
+		return nil
+	}
+	return id
 }
 
-func runConnectorServer(addr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, controllerSendCh chan<- *controllerpb.ControlMessage, connectorID string) error {
-	lis, err := net.Listen("tcp", addr)
+func runConnectorServer(ctx context.Context, cfg runtimeConfig, id workloadIdentity, allowlist *tunnelerAllowlist, gate spiffe.AdmissionGate, live *liveConfig, tunnels *tunnelServer, controllerSendCh chan<- *controllerpb.ControlMessage) error {
+	lis, err := listen(cfg.listenAddr)
 	if err != nil {
 		return err
 	}
@@ -207,26 +362,85 @@ func runConnectorServer(addr, trustDomain string, store *tlsutil.CertStore, root
 	tlsConfig := &tls.Config{
 		MinVersion:     tls.VersionTLS13,
 		ClientAuth:     tls.RequireAndVerifyClientCert,
-		ClientCAs:      roots,
-		GetCertificate: store.GetCertificate,
+		ClientCAs:      id.roots,
+		GetCertificate: id.store.GetCertificate,
+		// Reject certificates outside the trust domain during the
+		// handshake, so they are counted as TLS failures.
+		VerifyConnection: verifyPeerSPIFFE(cfg.trustDomain),
+	}
+	// With health probes enabled the handshake no longer demands a client
+	// certificate (one that is presented is still verified); the
+	// interceptors then refuse every method but the health check to peers
+	// without one.
+	var unauthenticatedMethods map[string]struct{}
+	if cfg.healthProbe {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		unauthenticatedMethods = healthProbeMethods
 	}
 
 	grpcServer := grpc.NewServer(
-		grpc.Creds(credentials.NewTLS(tlsConfig)),
-		grpc.UnaryInterceptor(spiffe.UnaryInterceptorWithAllowlist(trustDomain, allowlist, "tunneler")),
-		grpc.StreamInterceptor(spiffe.StreamInterceptorWithAllowlist(trustDomain, allowlist, "tunneler")),
+		grpc.Creds(observedCreds{credentials.NewTLS(tlsConfig)}),
+		grpc.UnaryInterceptor(spiffe.UnaryInterceptorWithAllowlist(cfg.trustDomain, allowlist, gate, unauthenticatedMethods, "tunneler")),
+		grpc.StreamInterceptor(spiffe.StreamInterceptorWithAllowlist(cfg.trustDomain, allowlist, gate, "tunneler")),
 	)
 
 	controllerpb.RegisterControlPlaneServer(grpcServer, &controlPlaneServer{
-		connectorID: connectorID,
+		connectorID: cfg.connectorID,
 		sendCh:      controllerSendCh,
+		live:        live,
+		idleTimeout: cfg.tunnelerIdleTimeout,
 	})
+	if tunnels != nil {
+		controllerpb.RegisterTunnelServiceServer(grpcServer, tunnels)
+	}
+	if cfg.healthProbe {
+		healthpb.RegisterHealthServer(grpcServer, &healthServer{gate: gate})
+	}
+
+	// Stop, not GracefulStop: tunneler streams are long-lived and would
+	// hold shutdown until Run's timeout.
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		select {
+		case <-ctx.Done():
+			grpcServer.Stop()
+		case <-served:
+		}
+	}()
 
-	log.Printf("connector server listening on %s", addr)
+	log.Printf("connector server listening on %s", cfg.listenAddr)
 	return grpcServer.Serve(lis)
 }
 
-func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, controllerSendCh chan<- *controllerpb.ControlMessage, connectorID string) {
+// listen opens the tunneler-facing listener on a host:port or unix: address.
+// A Unix socket is created with mode 0600, replacing a stale socket left by
+// a previous run; mTLS still authenticates every peer.
+func listen(addr string) (net.Listener, error) {
+	path, ok := dialaddr.UnixPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+func serverLoop(ctx context.Context, cfg runtimeConfig, id workloadIdentity, allowlist *tunnelerAllowlist, gate spiffe.AdmissionGate, live *liveConfig, tunnels *tunnelServer, controllerSendCh chan<- *controllerpb.ControlMessage) {
 	backoff := 2 * time.Second
 	for {
 		select {
@@ -235,7 +449,7 @@ func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.Ce
 		default:
 		}
 
-		if err := runConnectorServer(addr, trustDomain, store, roots, allowlist, controllerSendCh, connectorID); err != nil && !errors.Is(err, context.Canceled) {
+		if err := runConnectorServer(ctx, cfg, id, allowlist, gate, live, tunnels, controllerSendCh); err != nil && !errors.Is(err, context.Canceled) {
 			log.Printf("connector server stopped: %v", err)
 		}
 
@@ -252,83 +466,168 @@ func serverLoop(ctx context.Context, addr, trustDomain string, store *tlsutil.Ce
 	}
 }
 
-func controlPlaneLoop(ctx context.Context, controllerAddr, trustDomain, connectorID, privateIP string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, controllerSendCh <-chan *controllerpb.ControlMessage, reloadCh <-chan struct{}) {
+// controlPlaneFailoverDelay replaces the reconnect backoff when the next
+// attempt goes to another controller.
+const controlPlaneFailoverDelay = time.Second
+
+func controlPlaneLoop(ctx context.Context, cfg runtimeConfig, id workloadIdentity, st controlPlaneState, identityRejectedCh chan<- struct{}) error {
+	controllers := cfg.controllers
 	backoff := 2 * time.Second
+	compress := cfg.compression == compressionGzip
+	identityRejections := 0
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 		}
 
+		controllerAddr := controllers.Order()[0]
 		sessionCtx, cancel := context.WithCancel(ctx)
 		errCh := make(chan error, 1)
 		go func() {
-			errCh <- connectControlPlane(sessionCtx, controllerAddr, trustDomain, connectorID, privateIP, store, roots, allowlist, controllerSendCh)
+			errCh <- connectControlPlane(sessionCtx, cfg, id, st, controllerAddr, compress)
 		}()
 
+		var wait time.Duration
 		select {
 		case <-ctx.Done():
 			cancel()
 			<-errCh
-			return
-		case <-reloadCh:
-			cancel()
-			<-errCh
+			return nil
 		case err := <-errCh:
 			cancel()
+			failedOver := false
+			if failover.Retryable(err) {
+				controllers.Failed(controllerAddr)
+				if next := controllers.Order()[0]; next != controllerAddr {
+					log.Printf("controller %s unavailable, failing over to %s", controllerAddr, next)
+					wait = controlPlaneFailoverDelay
+					failedOver = true
+				}
+			} else if err != nil && !errors.Is(err, context.Canceled) {
+				controllers.Succeeded(controllerAddr)
+			}
+			class := classifyControlPlaneError(err)
 			if err != nil && !errors.Is(err, context.Canceled) {
-				log.Printf("control-plane connection ended: %v", err)
+				controlPlaneErrors.Inc(class)
+			}
+			if class == errClassIdentity {
+				identityRejections++
+				log.Printf("controller rejected this connector's identity (%d consecutive): %v", identityRejections, err)
+				if identityRejections >= maxIdentityRejections {
+					return fmt.Errorf("controller rejected connector %s %d times in a row (%s: %s); it was probably deleted or revoked. Re-enroll it with a new ENROLLMENT_TOKEN",
+						cfg.connectorID, identityRejections, status.Code(err), status.Convert(err).Message())
+				}
+				// Ask the renewal loop to re-enroll; without a
+				// provisioned token this only logs why it cannot.
+				select {
+				case identityRejectedCh <- struct{}{}:
+				default:
+				}
+				wait = 30 * time.Second
+				break
+			}
+			identityRejections = 0
+			var disc *disconnectError
+			if errors.As(err, &disc) {
+				log.Printf("controller closed the control plane: reason=%s message=%q", disc.reason, disc.message)
+				if disc.terminal() {
+					return fmt.Errorf("controller closed the control plane with terminal reason %s: %s", disc.reason, disc.message)
+				}
+				if disc.reason == disconnectDuplicateID {
+					log.Printf("another connector is using id %s; backing off", cfg.connectorID)
+					wait = 30 * time.Second
+				}
+			} else if compress && compressionUnsupported(err) {
+				// Older controllers cannot decompress; fall back for the
+				// rest of this process.
+				log.Printf("controller does not support %s control-plane compression, continuing uncompressed", cfg.compression)
+				compress = false
+			} else if err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("control-plane connection ended (transient, retrying): %v", err)
+			}
+			if retryAfter, ok := serverRetryAfter(err); ok && !failedOver {
+				log.Printf("controller requested retry after %s", retryAfter)
+				wait = retryAfter
 			}
 		}
+		controlPlaneReconnects.Inc()
 
-		timer := time.NewTimer(backoff)
+		if wait <= 0 {
+			wait = backoff
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+		timer := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
 			timer.Stop()
-			return
+			return nil
 		case <-timer.C:
 		}
-		if backoff < 30*time.Second {
-			backoff *= 2
-		}
 	}
 }
 
-func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, connectorID, privateIP string, store *tlsutil.CertStore, roots *x509.CertPool, allowlist *tunnelerAllowlist, controllerSendCh <-chan *controllerpb.ControlMessage) error {
-	tlsConfig := &tls.Config{
-		MinVersion:           tls.VersionTLS13,
-		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller")
-		},
+// serverRetryAfter extracts a controller-suggested retry delay (RetryInfo
+// status detail) from a control-plane error, if present.
+func serverRetryAfter(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			if delay := info.GetRetryDelay().AsDuration(); delay > 0 {
+				return delay, true
+			}
+		}
 	}
+	return 0, false
+}
+
+func connectControlPlane(ctx context.Context, cfg runtimeConfig, id workloadIdentity, st controlPlaneState, controllerAddr string, compress bool) error {
+	tlsConfig := controllerTLSConfig(cfg, id)
 
 	conn, err := grpc.DialContext(
 		ctx,
 		controllerAddr,
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		failover.DialOption(),
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time:                30 * time.Second,
 			Timeout:             10 * time.Second,
 			PermitWithoutStream: true,
 		}),
+		grpc.WithStatsHandler(controlPlaneStats{}),
 	)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
+	var callOpts []grpc.CallOption
+	if compress {
+		callOpts = append(callOpts, grpc.UseCompressor(compressionGzip))
+	}
 	client := controllerpb.NewControlPlaneClient(conn)
-	stream, err := client.Connect(ctx)
+	stream, err := client.Connect(ctx, callOpts...)
 	if err != nil {
 		return err
 	}
 
-	if err := stream.Send(&controllerpb.ControlMessage{Type: "connector_hello"}); err != nil {
+	if err := stream.Send(&controllerpb.ControlMessage{Type: "connector_hello", ClientTime: time.Now().UnixMilli(), Version: cfg.version}); err != nil {
 		return err
 	}
+	st.health.setConnected(true)
+	defer st.health.setConnected(false)
+	// A drain cancelled while disconnected would otherwise never end; the
+	// controller repeats a drain that is still pending right after the hello.
+	resetDrain()
 
 	recvCh := make(chan *controllerpb.ControlMessage, 1)
 	recvErr := make(chan error, 1)
@@ -343,99 +642,175 @@ func connectControlPlane(ctx context.Context, controllerAddr, trustDomain, conne
 		}
 	}()
 
-	ticker := time.NewTicker(10 * time.Second)
+	interval := st.live.HeartbeatInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// A disconnect message precedes the stream's final status; keep reading
+	// so the status (and any RetryInfo) is attached to it.
+	var disc *disconnectError
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case err := <-recvErr:
+			if disc != nil {
+				disc.cause = err
+				return disc
+			}
 			return err
 		case msg := <-recvCh:
-			handleControlMessage(msg, allowlist)
-		case msg := <-controllerSendCh:
+			if msg.GetType() == "disconnect" {
+				disc = parseDisconnect(msg)
+				continue
+			}
+			if err := handleControlMessage(msg, st.allowlist, st.live, cfg.strict); err != nil {
+				return err
+			}
+			if msg.GetType() == "drain" {
+				// Report progress at once rather than on the next tick.
+				hb := heartbeatMessage(cfg.connectorID, cfg.privateIP, cfg.listenAddr, cfg.version)
+				hb.ClientTime = time.Now().UnixMilli()
+				if err := stream.Send(hb); err != nil {
+					return err
+				}
+			}
+			if d := st.live.HeartbeatInterval(); d != interval {
+				interval = d
+				ticker.Reset(interval)
+			}
+		case msg := <-st.sendCh:
 			if msg != nil {
 				if err := stream.Send(msg); err != nil {
 					return err
 				}
 			}
 		case <-ticker.C:
-			if err := stream.Send(&controllerpb.ControlMessage{
-				Type:        "heartbeat",
-				ConnectorId: connectorID,
-				PrivateIp:   privateIP,
-				Status:      "ONLINE",
-			}); err != nil {
+			hb := heartbeatMessage(cfg.connectorID, cfg.privateIP, cfg.listenAddr, cfg.version)
+			hb.ClientTime = time.Now().UnixMilli()
+			if err := stream.Send(hb); err != nil {
 				return err
 			}
 		}
 	}
 }
 
-func renewalLoop(ctx context.Context, controllerAddr, connectorID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte, totalTTL time.Duration) {
+// renewalLoop renews the workload certificate before it expires and
+// escalates to re-enrollment after repeated failures, or at once when the
+// control-plane loop reports on identityRejectedCh that the controller
+// refuses the current identity.
+func renewalLoop(ctx context.Context, cfg runtimeConfig, id workloadIdentity, totalTTL time.Duration, policy renewalPolicy, enrollCfg enroll.Config, identityRejectedCh <-chan struct{}) {
+	store := id.store
+	var (
+		failures     int
+		lastReenroll time.Time
+	)
 	for {
 		next := nextRenewal(store.NotAfter(), totalTTL)
 		timer := time.NewTimer(time.Until(next))
+		rejected := false
 		select {
 		case <-ctx.Done():
 			timer.Stop()
 			return
 		case <-timer.C:
+		case <-identityRejectedCh:
+			timer.Stop()
+			rejected = true
 		}
 
-		cert, certPEM, notAfter, notBefore, err := renewOnce(ctx, controllerAddr, connectorID, trustDomain, store, roots, caPEM)
+		var (
+			cert                tls.Certificate
+			certPEM             []byte
+			notAfter, notBefore time.Time
+			err                 error
+		)
+		if rejected {
+			if time.Since(lastReenroll) < reenrollBackoff {
+				continue
+			}
+			log.Printf("controller rejected the connector identity on the control plane; attempting re-enrollment")
+			err = errIdentityRejected
+		} else {
+			// A failed or timed-out attempt is retried within 10s, as
+			// nextRenewal is already past the renewal point.
+			attemptCtx, cancel := context.WithTimeout(ctx, policy.rpcTimeout)
+			cert, certPEM, notAfter, notBefore, err = renewOnce(attemptCtx, cfg, id)
+			cancel()
+		}
 		if err != nil {
-			log.Printf("certificate renewal failed: %v", err)
-			continue
+			if !rejected {
+				certRenewalFailures.Inc()
+				failures++
+				log.Printf("certificate renewal failed (%d consecutive): %v", failures, err)
+				if !policy.escalate(failures, store.NotAfter()) || time.Since(lastReenroll) < reenrollBackoff {
+					continue
+				}
+				renewalAlarm.Set(1)
+				log.Printf("ALARM: certificate renewal failed %d consecutive times, certificate expires %s; attempting re-enrollment",
+					failures, store.NotAfter().Format(time.RFC3339))
+			}
+			lastReenroll = time.Now()
+			cert, certPEM, err = reenroll(ctx, enrollCfg, policy, id.caPEM)
+			if err != nil {
+				reenrollFailures.Inc()
+				log.Printf("ALARM: re-enrollment failed: %v; connector stops working at %s unless renewal recovers",
+					err, store.NotAfter().Format(time.RFC3339))
+				continue
+			}
+			leaf, err := parseLeafCert(certPEM)
+			if err != nil {
+				reenrollFailures.Inc()
+				log.Printf("ALARM: re-enrollment returned an invalid certificate: %v", err)
+				continue
+			}
+			notAfter, notBefore = leaf.NotAfter, leaf.NotBefore
+			reenrollments.Inc()
+			if rejected {
+				log.Printf("re-enrolled after the controller rejected the identity; certificate valid until %s", notAfter.Format(time.RFC3339))
+			} else {
+				log.Printf("re-enrolled after %d failed renewals; certificate valid until %s", failures, notAfter.Format(time.RFC3339))
+			}
+		} else {
+			certRenewals.Inc()
 		}
+		failures = 0
+		renewalAlarm.Set(0)
 
 		store.Update(cert, certPEM, notAfter)
 		totalTTL = notAfter.Sub(notBefore)
+		cfg.identityOut.write(cert, certPEM, id.caPEM)
 	}
 }
 
-func renewOnce(ctx context.Context, controllerAddr, connectorID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte) (tls.Certificate, []byte, time.Time, time.Time, error) {
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+func renewOnce(ctx context.Context, cfg runtimeConfig, id workloadIdentity) (tls.Certificate, []byte, time.Time, time.Time, error) {
+	privKey, pubPEM, err := renewalKey(id.store, cfg.reuseKey, cfg.keyAlgorithm)
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
 
-	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
-	if err != nil {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
+	tlsConfig := controllerTLSConfig(cfg, id)
+	req := &controllerpb.EnrollRequest{
+		Id:             cfg.connectorID,
+		PublicKey:      pubPEM,
+		ExtraUris:      cfg.extraSANs.URIs,
+		EmailAddresses: cfg.extraSANs.Emails,
 	}
-
-	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
-
-	tlsConfig := &tls.Config{
-		MinVersion:           tls.VersionTLS13,
-		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller")
-		},
-	}
-
-	conn, err := grpc.DialContext(
-		ctx,
-		controllerAddr,
-		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
-	)
-	if err != nil {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
-	}
-	defer conn.Close()
-
-	client := controllerpb.NewEnrollmentServiceClient(conn)
-	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{Id: connectorID, PublicKey: pubPEM})
+	var resp *controllerpb.EnrollResponse
+	err = cfg.controllers.Do(ctx, func(addr string) error {
+		resp, err = renewAt(ctx, addr, tlsConfig, req)
+		if failover.Retryable(err) && len(cfg.controllers.Addrs()) > 1 {
+			log.Printf("controller %s unavailable for renewal, trying the next address: %v", addr, err)
+		}
+		return err
+	})
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
 	if len(resp.CaCertificate) == 0 {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("empty CA certificate in renewal response")
 	}
-	if !tlsutil.EqualCAPEM(caPEM, resp.CaCertificate) {
+	if !tlsutil.EqualCAPEM(id.caPEM, resp.CaCertificate) {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, errors.New("internal CA mismatch during renewal")
 	}
 
@@ -453,18 +828,67 @@ func renewOnce(ctx context.Context, controllerAddr, connectorID, trustDomain str
 	return workloadCert, resp.Certificate, leaf.NotAfter, leaf.NotBefore, nil
 }
 
+// renewAt sends a Renew request to the controller at addr.
+// controllerTLSConfig authenticates to a controller with the current
+// workload certificate and accepts only a controller of the trust domain.
+func controllerTLSConfig(cfg runtimeConfig, id workloadIdentity) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS13,
+		GetClientCertificate: id.store.GetClientCertificate,
+		RootCAs:              id.roots,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return tlsutil.VerifyControllerSPIFFE(rawCerts, verifiedChains, cfg.trustDomain, cfg.controllerID)
+		},
+	}
+}
+
+func renewAt(ctx context.Context, addr string, tlsConfig *tls.Config, req *controllerpb.EnrollRequest) (*controllerpb.EnrollResponse, error) {
+	conn, err := grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		failover.DialOption(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return controllerpb.NewEnrollmentServiceClient(conn).Renew(ctx, req)
+}
+
+// renewalKey returns the key pair to renew with: the active certificate's key
+// when reuseKey is set, otherwise a fresh one of keyAlgorithm.
+func renewalKey(store *tlsutil.CertStore, reuseKey bool, keyAlgorithm string) (crypto.Signer, []byte, error) {
+	if !reuseKey {
+		return enroll.GenerateKey(keyAlgorithm)
+	}
+	privKey := store.PrivateKey()
+	if privKey == nil {
+		return nil, nil, errors.New("current certificate has no reusable private key")
+	}
+	pubPEM, err := enroll.PublicKeyPEM(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKey, pubPEM, nil
+}
+
+// renewalRetryDelay is the shortest wait before a renewal attempt, and so
+// the retry interval once the renewal point has passed.
+var renewalRetryDelay = 10 * time.Second
+
 func nextRenewal(notAfter time.Time, totalTTL time.Duration) time.Time {
 	remaining := time.Until(notAfter)
 	if remaining <= 0 {
-		return time.Now().Add(10 * time.Second)
+		return time.Now().Add(renewalRetryDelay)
 	}
 	if totalTTL <= 0 {
 		totalTTL = remaining
 	}
 	renewAt := totalTTL * 30 / 100
 	next := notAfter.Add(-renewAt)
-	if next.Before(time.Now().Add(10 * time.Second)) {
-		return time.Now().Add(10 * time.Second)
+	if next.Before(time.Now().Add(renewalRetryDelay)) {
+		return time.Now().Add(renewalRetryDelay)
 	}
 	return next
 }
@@ -480,6 +904,11 @@ func parseLeafCert(certPEM []byte) (*x509.Certificate, error) {
 type tunnelerAllowlist struct {
 	mu       sync.RWMutex
 	bySPIFFE map[string]struct{}
+	// synced is set once the first full allowlist has been applied.
+	synced bool
+	// onMiss, when set, is called outside the lock for every id Allowed
+	// refuses.
+	onMiss func(spiffeID string)
 }
 
 func newTunnelerAllowlist() *tunnelerAllowlist {
@@ -488,21 +917,41 @@ func newTunnelerAllowlist() *tunnelerAllowlist {
 
 func (a *tunnelerAllowlist) Allowed(spiffeID string) bool {
 	a.mu.RLock()
-	defer a.mu.RUnlock()
 	_, ok := a.bySPIFFE[spiffeID]
+	a.mu.RUnlock()
+	if !ok && a.onMiss != nil {
+		a.onMiss(spiffeID)
+	}
 	return ok
 }
 
-func (a *tunnelerAllowlist) Replace(items []tunnelerInfo) {
+// Replace swaps in a full allowlist and reports how many ids it added and
+// removed. drift is true when an earlier full list had been applied and the
+// set still changed, i.e. a delta was missed.
+func (a *tunnelerAllowlist) Replace(items []tunnelerInfo) (added, removed int, drift bool) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.bySPIFFE = make(map[string]struct{}, len(items))
+	next := make(map[string]struct{}, len(items))
 	for _, item := range items {
-		if item.SPIFFEID == "" {
+		if _, dup := next[item.SPIFFEID]; dup || item.SPIFFEID == "" {
 			continue
 		}
-		a.bySPIFFE[item.SPIFFEID] = struct{}{}
+		next[item.SPIFFEID] = struct{}{}
+		if _, ok := a.bySPIFFE[item.SPIFFEID]; !ok {
+			added++
+		}
 	}
+	removed = len(a.bySPIFFE) - (len(next) - added)
+	drift = a.synced && (added > 0 || removed > 0)
+	a.bySPIFFE = next
+	a.synced = true
+	return added, removed, drift
+}
+
+func (a *tunnelerAllowlist) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.bySPIFFE)
 }
 
 func (a *tunnelerAllowlist) Add(spiffeID string) {
@@ -519,20 +968,38 @@ type tunnelerInfo struct {
 	SPIFFEID   string `json:"spiffe_id"`
 }
 
-func handleControlMessage(msg *controllerpb.ControlMessage, allowlist *tunnelerAllowlist) {
+// handleControlMessage applies a message from the controller. It only fails
+// for an unknown message type in strict mode.
+func handleControlMessage(msg *controllerpb.ControlMessage, allowlist *tunnelerAllowlist, live *liveConfig, strict bool) error {
 	if msg == nil || allowlist == nil {
-		return
+		return nil
 	}
+	live.debugf("control message type=%s bytes=%d", msg.GetType(), len(msg.GetPayload()))
 	switch msg.GetType() {
 	case "tunneler_allowlist":
 		var items []tunnelerInfo
 		if err := json.Unmarshal(msg.GetPayload(), &items); err == nil {
-			allowlist.Replace(items)
+			// The controller re-sends the full list periodically; a change
+			// here means a tunneler_allow was missed.
+			if added, removed, drift := allowlist.Replace(items); drift {
+				allowlistReconciliations.Inc()
+				log.Printf("tunneler allowlist reconciled: added=%d removed=%d (missed update)", added, removed)
+			}
 		}
 	case "tunneler_allow":
 		var item tunnelerInfo
 		if err := json.Unmarshal(msg.GetPayload(), &item); err == nil {
 			allowlist.Add(item.SPIFFEID)
 		}
-	}
+	case "config_update":
+		live.apply(msg.GetPayload())
+	case "upgrade_available":
+		handleUpgradeAvailable(msg.GetPayload())
+	case "drain":
+		handleDrain(msg.GetPayload())
+	case "pong":
+	default:
+		return unknownMessage(msg.GetType(), strict)
+	}
+	return nil
 }
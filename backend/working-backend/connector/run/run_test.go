@@ -0,0 +1,190 @@
+package run
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"connector/enroll"
+	"connector/internal/failover"
+	"connector/internal/tlsutil"
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestRenewalKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := tlsutil.NewCertStore(tls.Certificate{PrivateKey: key}, nil, time.Now().Add(time.Hour))
+	wantPEM, err := enroll.PublicKeyPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reused, pubPEM, err := renewalKey(store, true, "")
+	if err != nil {
+		t.Fatalf("renewalKey with reuse: %v", err)
+	}
+	if reused != crypto.Signer(key) || string(pubPEM) != string(wantPEM) {
+		t.Error("RENEW_REUSE_KEY did not renew for the current key")
+	}
+
+	fresh, pubPEM, err := renewalKey(store, false, "ed25519")
+	if err != nil {
+		t.Fatalf("renewalKey without reuse: %v", err)
+	}
+	if fresh.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) || string(pubPEM) == string(wantPEM) {
+		t.Error("renewal without RENEW_REUSE_KEY reused the current key")
+	}
+
+	// A certificate whose key cannot sign cannot be renewed in place.
+	noKey := tlsutil.NewCertStore(tls.Certificate{}, nil, time.Now().Add(time.Hour))
+	if _, _, err := renewalKey(noKey, true, ""); err == nil {
+		t.Error("renewalKey reused a missing private key")
+	}
+}
+
+// blockingRenewServer accepts Renew calls and never answers them, like a
+// controller that is reachable but stuck.
+type blockingRenewServer struct {
+	controllerpb.UnimplementedEnrollmentServiceServer
+	calls chan time.Time
+}
+
+func (s *blockingRenewServer) Renew(ctx context.Context, _ *controllerpb.EnrollRequest) (*controllerpb.EnrollResponse, error) {
+	s.calls <- time.Now()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// serveBlockingRenew starts a controller whose Renew blocks and returns a
+// connector configuration and identity that can reach it over mTLS.
+func serveBlockingRenew(t *testing.T) (*blockingRenewServer, runtimeConfig, workloadIdentity) {
+	t.Helper()
+	caPEM, keyPEM, err := ca.GenerateSelfSignedCA("run test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caInst, err := ca.LoadCA(caPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caInst.Cert)
+	issue := func(id string, ips []net.IP) (tls.Certificate, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM, err := ca.IssueWorkloadCert(caInst, id, &key.PublicKey, time.Hour, nil, ips)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(certPEM)
+		return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key}, certPEM
+	}
+
+	serverCert, _ := issue("spiffe://example.org/controller/c1", []net.IP{net.IPv4(127, 0, 0, 1)})
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})))
+	s := &blockingRenewServer{calls: make(chan time.Time, 16)}
+	controllerpb.RegisterEnrollmentServiceServer(srv, s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	// The certificate has already expired, so renewal is due at once.
+	clientCert, clientPEM := issue("spiffe://example.org/connector/c1", nil)
+	store := tlsutil.NewCertStore(clientCert, clientPEM, time.Now().Add(-time.Second))
+	cfg := runtimeConfig{
+		controllers: failover.New([]string{lis.Addr().String()}),
+		connectorID: "c1",
+		trustDomain: "example.org",
+	}
+	return s, cfg, workloadIdentity{store: store, roots: roots, caPEM: caPEM}
+}
+
+func TestRenewOnceTimesOut(t *testing.T) {
+	s, cfg, id := serveBlockingRenew(t)
+	const rpcTimeout = 300 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	start := time.Now()
+	_, _, _, _, err := renewOnce(ctx, cfg, id)
+	elapsed := time.Since(start)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("renewOnce error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed > rpcTimeout+time.Second {
+		t.Errorf("renewOnce returned after %s, want about %s", elapsed, rpcTimeout)
+	}
+	select {
+	case <-s.calls:
+	default:
+		t.Error("renewOnce timed out before reaching Renew")
+	}
+}
+
+func TestRenewalLoopRetriesAfterTimeout(t *testing.T) {
+	if renewalRetryDelay != 10*time.Second {
+		t.Fatalf("renewalRetryDelay = %s, want 10s", renewalRetryDelay)
+	}
+	// Scaled down from the 10s retry so the test stays fast.
+	old := renewalRetryDelay
+	renewalRetryDelay = 200 * time.Millisecond
+	t.Cleanup(func() { renewalRetryDelay = old })
+
+	s, cfg, id := serveBlockingRenew(t)
+	policy := renewalPolicy{rpcTimeout: 100 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		renewalLoop(ctx, cfg, id, time.Hour, policy, enroll.Config{}, nil)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var calls []time.Time
+	for len(calls) < 2 {
+		select {
+		case at := <-s.calls:
+			calls = append(calls, at)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("saw %d renewal attempts, want 2", len(calls))
+		}
+	}
+	// The first attempt times out at most rpcTimeout after Renew was
+	// called (the dial used part of it); the retry follows
+	// renewalRetryDelay later.
+	gap := calls[1].Sub(calls[0])
+	want := policy.rpcTimeout + renewalRetryDelay
+	if gap < renewalRetryDelay || gap > want+time.Second {
+		t.Errorf("retry came %s after the first attempt, want about %s", gap, want)
+	}
+}
@@ -0,0 +1,122 @@
+package run
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"controller/spiffeid"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// handshakeLogInterval limits how often a handshake failure reason is
+// logged; connector_tls_handshake_failures_total counts every failure.
+const handshakeLogInterval = time.Minute
+
+var handshakeLog = struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}{last: make(map[string]time.Time)}
+
+// spiffeHandshakeError is returned by verifyPeerSPIFFE so the failure can be
+// told apart from the certificate chain errors of crypto/tls.
+type spiffeHandshakeError struct{ err error }
+
+func (e *spiffeHandshakeError) Error() string {
+	return "client certificate SPIFFE ID: " + e.err.Error()
+}
+
+func (e *spiffeHandshakeError) Unwrap() error { return e.err }
+
+// verifyPeerSPIFFE returns a tls.Config.VerifyConnection callback that fails
+// the handshake when the peer presents a certificate whose SPIFFE ID is
+// missing, malformed, or outside trustDomain. Peers without a certificate
+// (health probes) are left to the interceptors.
+func verifyPeerSPIFFE(trustDomain string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return nil
+		}
+		id, err := spiffeid.FromLeaf(cs.PeerCertificates[0])
+		if err != nil {
+			return &spiffeHandshakeError{err: err}
+		}
+		if id.TrustDomain != trustDomain {
+			return &spiffeHandshakeError{err: fmt.Errorf("trust domain %q, want %q", id.TrustDomain, trustDomain)}
+		}
+		return nil
+	}
+}
+
+// observedCreds counts and logs failed server handshakes of the wrapped
+// credentials.
+type observedCreds struct {
+	credentials.TransportCredentials
+}
+
+func (c observedCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ServerHandshake(rawConn)
+	if err != nil {
+		reason := handshakeReason(err)
+		tlsHandshakeFailures.Inc(reason)
+		handshakeLog.mu.Lock()
+		now := time.Now()
+		logIt := now.Sub(handshakeLog.last[reason]) >= handshakeLogInterval
+		if logIt {
+			handshakeLog.last[reason] = now
+		}
+		handshakeLog.mu.Unlock()
+		if logIt {
+			log.Printf("tls: handshake from %s failed: reason=%s: %v", rawConn.RemoteAddr(), reason, err)
+		}
+	}
+	return conn, info, err
+}
+
+func (c observedCreds) Clone() credentials.TransportCredentials {
+	return observedCreds{TransportCredentials: c.TransportCredentials.Clone()}
+}
+
+// handshakeReason classifies a server-side TLS handshake error for the
+// reason label of connector_tls_handshake_failures_total.
+func handshakeReason(err error) string {
+	var spiffeErr *spiffeHandshakeError
+	var invalid x509.CertificateInvalidError
+	var unknownCA x509.UnknownAuthorityError
+	var verifyErr *tls.CertificateVerificationError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &spiffeErr):
+		return "wrong_spiffe"
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return "expired"
+		}
+		return "bad_cert"
+	case errors.As(err, &unknownCA):
+		return "unknown_ca"
+	case errors.As(err, &verifyErr):
+		return "bad_cert"
+	case strings.Contains(err.Error(), "didn't provide a certificate"):
+		return "no_cert"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return "peer_rejected"
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed):
+		return "conn_closed"
+	}
+	return "protocol_error"
+}
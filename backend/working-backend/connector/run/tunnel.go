@@ -0,0 +1,204 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"connector/internal/spiffe"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tunnelDialTimeout = 5 * time.Second
+	tunnelBufferSize  = 32 * 1024
+)
+
+// tunnelServer proxies TunnelService.Open streams from tunnelers to the
+// backends configured in CONNECTOR_BACKENDS. A tunneler must be in the
+// controller allowlist (enforced by the interceptor) and granted the
+// requested target in CONNECTOR_TARGET_ALLOWLIST.
+type tunnelServer struct {
+	controllerpb.UnimplementedTunnelServiceServer
+	health *backendHealth
+
+	// mu guards backends and grants, which a config reload replaces.
+	mu       sync.RWMutex
+	backends map[string]string
+	// grants maps a backend name to the tunneler ids allowed to reach it;
+	// a "*" entry allows every allowlisted tunneler.
+	grants map[string]map[string]bool
+}
+
+// newTunnelServer returns nil when no backends are configured. grants is
+// CONNECTOR_TARGET_ALLOWLIST, "name=tunneler-a|tunneler-b,name2=*".
+func newTunnelServer(targets []backendTarget, grants string, health *backendHealth) (*tunnelServer, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	backends, granted, err := parseTunnelRoutes(targets, grants)
+	if err != nil {
+		return nil, err
+	}
+	return &tunnelServer{backends: backends, grants: granted, health: health}, nil
+}
+
+// parseTunnelRoutes maps backend names to addresses and parses grants
+// against them.
+func parseTunnelRoutes(targets []backendTarget, grants string) (map[string]string, map[string]map[string]bool, error) {
+	backends := make(map[string]string, len(targets))
+	granted := make(map[string]map[string]bool)
+	for _, t := range targets {
+		backends[t.name] = t.addr
+	}
+	for _, item := range strings.Split(grants, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, ids, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("CONNECTOR_TARGET_ALLOWLIST: expected name=tunneler|..., got %q", item)
+		}
+		if _, ok := backends[name]; !ok {
+			return nil, nil, fmt.Errorf("CONNECTOR_TARGET_ALLOWLIST: unknown backend %s", name)
+		}
+		if granted[name] == nil {
+			granted[name] = make(map[string]bool)
+		}
+		for _, id := range strings.Split(ids, "|") {
+			if id = strings.TrimSpace(id); id != "" {
+				granted[name][id] = true
+			}
+		}
+	}
+	return backends, granted, nil
+}
+
+// setRoutes replaces the backends and grants. Tunnels already open are not
+// affected.
+func (s *tunnelServer) setRoutes(backends map[string]string, grants map[string]map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backends = backends
+	s.grants = grants
+}
+
+// route returns the address of target and whether tunnelerID may reach it;
+// known is false for an unconfigured target.
+func (s *tunnelServer) route(target, tunnelerID string) (addr string, known, allowed bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addr, known = s.backends[target]
+	ids := s.grants[target]
+	return addr, known, ids["*"] || (tunnelerID != "" && ids[tunnelerID])
+}
+
+// Open reads the target from the first frame, dials the backend and copies
+// bytes in both directions until either side closes.
+func (s *tunnelServer) Open(stream controllerpb.TunnelService_OpenServer) error {
+	role, ok := spiffe.RoleFromContext(stream.Context())
+	if !ok || role != "tunneler" {
+		return status.Error(codes.PermissionDenied, "tunneler role required")
+	}
+	spiffeID, _ := spiffe.SPIFFEIDFromContext(stream.Context())
+	tunnelerID := parseTunnelerID(spiffeID)
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	target := first.GetTarget()
+	addr, known, allowed := s.route(target, tunnelerID)
+	if !known {
+		return status.Errorf(codes.NotFound, "unknown target %q", target)
+	}
+	if !allowed {
+		log.Printf("tunnel refused: %s is not allowed to reach %s", spiffeID, target)
+		return status.Errorf(codes.PermissionDenied, "not allowed to reach target %s", target)
+	}
+	if s.health.failing(target) {
+		return status.Errorf(codes.Unavailable, "backend %s unavailable", target)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, tunnelDialTimeout)
+	if err != nil {
+		log.Printf("tunnel to %s (%s) failed for %s: %v", target, addr, spiffeID, err)
+		return status.Errorf(codes.Unavailable, "backend %s unreachable", target)
+	}
+	defer conn.Close()
+	tunnelsOpen.Inc()
+	defer tunnelsOpen.Dec()
+	log.Printf("tunnel opened: %s -> %s (%s)", spiffeID, target, addr)
+
+	if len(first.GetData()) > 0 {
+		if _, err := conn.Write(first.GetData()); err != nil {
+			return status.Errorf(codes.Unavailable, "backend write failed: %v", err)
+		}
+	}
+
+	// Backend -> tunneler runs in its own goroutine; the stream is closed
+	// by returning from Open.
+	backendDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, tunnelBufferSize)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if sendErr := stream.Send(&controllerpb.TunnelFrame{Data: append([]byte(nil), buf[:n]...)}); sendErr != nil {
+					backendDone <- sendErr
+					return
+				}
+			}
+			if err != nil {
+				backendDone <- err
+				return
+			}
+		}
+	}()
+
+	recvDone := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvDone <- err
+				return
+			}
+			if _, err := conn.Write(frame.GetData()); err != nil {
+				recvDone <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err = <-recvDone:
+		if err == io.EOF {
+			// Tunneler finished sending; half-close and drain the backend.
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				_ = tcp.CloseWrite()
+			}
+			err = <-backendDone
+		} else {
+			// Stop the backend reader before returning so it never sends
+			// on a finished stream.
+			conn.Close()
+			<-backendDone
+		}
+	case err = <-backendDone:
+	}
+	log.Printf("tunnel closed: %s -> %s", spiffeID, target)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package run
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// unknownMessageLogInterval limits how often an unknown control message type
+// is logged.
+const unknownMessageLogInterval = time.Minute
+
+var unknownMessageLog = struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}{last: make(map[string]time.Time)}
+
+// unknownMessage records a control message of unknown type from the
+// controller. In strict mode it returns an error that ends the session;
+// otherwise the message is dropped and the type logged at most once a minute.
+func unknownMessage(msgType string, strict bool) error {
+	controlPlaneUnknownMessages.Inc()
+	if len(msgType) > 64 {
+		msgType = msgType[:64]
+	}
+	if strict {
+		return fmt.Errorf("protocol error: unknown control message type %q", msgType)
+	}
+	unknownMessageLog.mu.Lock()
+	now := time.Now()
+	logIt := now.Sub(unknownMessageLog.last[msgType]) >= unknownMessageLogInterval
+	if logIt {
+		if len(unknownMessageLog.last) >= 64 {
+			clear(unknownMessageLog.last)
+		}
+		unknownMessageLog.last[msgType] = now
+	}
+	unknownMessageLog.mu.Unlock()
+	if logIt {
+		log.Printf("control-plane: ignoring unknown message type %q", msgType)
+	}
+	return nil
+}
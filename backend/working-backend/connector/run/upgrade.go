@@ -0,0 +1,41 @@
+package run
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// upgradeNotice is the upgrade_available payload sent by the controller.
+type upgradeNotice struct {
+	TargetVersion  string `json:"target_version"`
+	CurrentVersion string `json:"current_version"`
+	DownloadURL    string `json:"download_url"`
+}
+
+// availableUpgrade remembers the last upgrade the controller announced so
+// it is logged once per target. The connector does not upgrade itself.
+var availableUpgrade struct {
+	mu     sync.Mutex
+	target string
+}
+
+func handleUpgradeAvailable(payload []byte) {
+	var n upgradeNotice
+	if err := json.Unmarshal(payload, &n); err != nil || n.TargetVersion == "" {
+		log.Printf("upgrade_available ignored: invalid payload")
+		return
+	}
+	availableUpgrade.mu.Lock()
+	changed := availableUpgrade.target != n.TargetVersion
+	availableUpgrade.target = n.TargetVersion
+	availableUpgrade.mu.Unlock()
+	upgradeAvailable.Set(1)
+	if changed {
+		if n.DownloadURL != "" {
+			log.Printf("connector upgrade available: running %s, target %s, download %s", n.CurrentVersion, n.TargetVersion, n.DownloadURL)
+		} else {
+			log.Printf("connector upgrade available: running %s, target %s", n.CurrentVersion, n.TargetVersion)
+		}
+	}
+}
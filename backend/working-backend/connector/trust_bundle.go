@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"controller/trustbundle"
+)
+
+// installTrustBundle implements "connector install-trust-bundle": it checks
+// a bundle from "controller export-trust-bundle" against a pinned CA
+// fingerprint or public key and only then writes its CA certificates to
+// --out, e.g. the CONTROLLER_CA_PATH file.
+func installTrustBundle(args []string) error {
+	fs := flag.NewFlagSet("install-trust-bundle", flag.ContinueOnError)
+	bundlePath := fs.String("bundle", "", "trust bundle file")
+	fingerprint := fs.String("fingerprint", "", "pinned SHA-256 fingerprint of the signing CA certificate")
+	publicKeyPath := fs.String("public-key", "", "PEM file with the pinned public key of the signing CA")
+	trustDomain := fs.String("trust-domain", "", "required trust domain of the bundle (default: any)")
+	out := fs.String("out", "", "file to write the CA certificates to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *bundlePath == "" || *out == "" || (*fingerprint == "") == (*publicKeyPath == "") || fs.NArg() > 0 {
+		return errors.New("usage: connector install-trust-bundle --bundle <file> (--fingerprint <sha256> | --public-key <file>) --out <file> [--trust-domain <domain>]")
+	}
+
+	pin := trustbundle.Pin{Fingerprint: *fingerprint}
+	if *publicKeyPath != "" {
+		data, err := os.ReadFile(*publicKeyPath)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return fmt.Errorf("%s: no PEM public key found", *publicKeyPath)
+		}
+		if pin.PublicKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return fmt.Errorf("%s: %w", *publicKeyPath, err)
+		}
+	}
+	data, err := os.ReadFile(*bundlePath)
+	if err != nil {
+		return err
+	}
+	payload, err := trustbundle.Verify(data, pin)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *bundlePath, err)
+	}
+	if td := strings.TrimSuffix(*trustDomain, "."); td != "" && payload.TrustDomain != td {
+		return fmt.Errorf("%s: bundle is for trust domain %q, not %q", *bundlePath, payload.TrustDomain, td)
+	}
+	cas, err := trustbundle.ParseCAs([]byte(payload.CAPEM))
+	if err != nil {
+		return err
+	}
+
+	// Write beside the target and rename so a running connector never
+	// reads a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(*out), "."+filepath.Base(*out)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(payload.CAPEM); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), *out); err != nil {
+		return err
+	}
+	log.Printf("installed %d CA certificate(s) for trust domain %s (bundle issued %s) to %s",
+		len(cas), payload.TrustDomain, payload.IssuedAt.Format(time.RFC3339), *out)
+	return nil
+}
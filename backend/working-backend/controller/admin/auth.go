@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthToken is a shared secret that can be rotated without a restart. After
+// a rotation the previous value stays valid for Grace so clients can switch
+// over.
+type AuthToken struct {
+	name string
+	file string
+
+	// Grace is how long the previous token is accepted after a rotation.
+	Grace time.Duration
+
+	mu        sync.RWMutex
+	current   string
+	prev      string
+	prevUntil time.Time
+}
+
+// NewAuthToken returns a token read from file when set, otherwise value.
+// name labels the token in errors and logs.
+func NewAuthToken(name, value, file string, grace time.Duration) (*AuthToken, error) {
+	t := &AuthToken{name: name, file: file, Grace: grace, current: value}
+	if file != "" {
+		v, err := readTokenFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		t.current = v
+	}
+	return t, nil
+}
+
+// Configured reports whether a token is set.
+func (t *AuthToken) Configured() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current != ""
+}
+
+// Valid reports whether presented matches the current token, or the
+// previous one during the grace period. Comparisons are constant-time.
+func (t *AuthToken) Valid(presented string) bool {
+	if t == nil || presented == "" {
+		return false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ok := t.current != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(t.current)) == 1
+	if t.prev != "" && time.Now().Before(t.prevUntil) {
+		ok = subtle.ConstantTimeCompare([]byte(presented), []byte(t.prev)) == 1 || ok
+	}
+	return ok
+}
+
+// Reload re-reads the token file and reports whether the token changed. A
+// token not backed by a file is left unchanged.
+func (t *AuthToken) Reload() (bool, error) {
+	if t == nil || t.file == "" {
+		return false, nil
+	}
+	v, err := readTokenFile(t.file)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", t.name, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if v == t.current {
+		return false, nil
+	}
+	t.prev, t.prevUntil = t.current, time.Now().Add(t.Grace)
+	t.current = v
+	log.Printf("%s rotated; previous token accepted until %s", t.name, t.prevUntil.UTC().Format(time.RFC3339))
+	return true, nil
+}
+
+func readTokenFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return v, nil
+}
+
+// ReloadAuth re-reads the admin, read-only admin, internal and break-glass
+// token files. A file that fails to load keeps its token's current value
+// but does not stop the others from reloading; the returned error names
+// every token that failed.
+func (s *Server) ReloadAuth() error {
+	var errs []error
+	for _, t := range []*AuthToken{s.AdminAuth, s.AdminReadOnlyAuth, s.InternalAuth, s.BreakGlass, s.BreakGlassFactor} {
+		if _, err := t.Reload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) handleReloadAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.ReloadAuth(); err != nil {
+		log.Printf("admin: auth token reload failed: %v", err)
+		http.Error(w, "auth token reload failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeToken(t *testing.T, path, value string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(value+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newFileToken(t *testing.T, name, value string) (*AuthToken, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), strings.ToLower(name))
+	writeToken(t, path, value)
+	tok, err := NewAuthToken(name, "", path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok, path
+}
+
+func TestReloadAuthReloadsEveryToken(t *testing.T) {
+	adminTok, adminPath := newFileToken(t, "ADMIN_AUTH_TOKEN", "admin-1")
+	readOnly, readOnlyPath := newFileToken(t, "ADMIN_READONLY_TOKEN", "ro-1")
+	internal, internalPath := newFileToken(t, "INTERNAL_API_TOKEN", "internal-1")
+	breakGlass, breakGlassPath := newFileToken(t, "BREAK_GLASS_TOKEN_FILE", "bg-1")
+	s := &Server{AdminAuth: adminTok, AdminReadOnlyAuth: readOnly, InternalAuth: internal, BreakGlass: breakGlass}
+
+	// The first and third files break; the others are rotated.
+	if err := os.Remove(adminPath); err != nil {
+		t.Fatal(err)
+	}
+	writeToken(t, internalPath, "")
+	writeToken(t, readOnlyPath, "ro-2")
+	writeToken(t, breakGlassPath, "bg-2")
+
+	err := s.ReloadAuth()
+	if err == nil {
+		t.Fatal("ReloadAuth succeeded with broken token files")
+	}
+	for _, name := range []string{"ADMIN_AUTH_TOKEN", "INTERNAL_API_TOKEN"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not name %s", err, name)
+		}
+	}
+	if !readOnly.Valid("ro-2") || !breakGlass.Valid("bg-2") {
+		t.Error("a failed token stopped later tokens from reloading")
+	}
+	if !readOnly.Valid("ro-1") {
+		t.Error("previous read-only token not accepted during its grace period")
+	}
+	if !adminTok.Valid("admin-1") || !internal.Valid("internal-1") {
+		t.Error("a token whose file failed to load lost its current value")
+	}
+
+	writeToken(t, adminPath, "admin-2")
+	writeToken(t, internalPath, "internal-2")
+	if err := s.ReloadAuth(); err != nil {
+		t.Fatalf("ReloadAuth after fixing the files: %v", err)
+	}
+	if !adminTok.Valid("admin-2") || !internal.Valid("internal-2") {
+		t.Error("fixed token files were not reloaded")
+	}
+}
@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+
+	"controller/metrics"
+	"controller/webhook"
+)
+
+// breakGlassFactorHeader carries the second factor for the break-glass
+// credential when BREAK_GLASS_FACTOR_FILE is set.
+const breakGlassFactorHeader = "X-Break-Glass-Factor"
+
+var breakGlassUses = metrics.NewCounterVec(
+	"controller_admin_break_glass_total",
+	"Admin API requests presenting the break-glass credential, by result.",
+	"result",
+)
+
+// breakGlass reports whether r may use the admin API with the break-glass
+// credential token. Every attempt with a valid break-glass token is logged
+// as an alarm and counted, whether or not the second factor matches.
+func (s *Server) breakGlass(r *http.Request, token string) bool {
+	if !s.BreakGlass.Valid(token) {
+		return false
+	}
+	if s.BreakGlassFactor.Configured() && !s.BreakGlassFactor.Valid(r.Header.Get(breakGlassFactorHeader)) {
+		breakGlassUses.Inc("rejected")
+		log.Printf("ALARM: break-glass admin credential presented without a valid %s: %s %s from %s", breakGlassFactorHeader, r.Method, r.URL.Path, r.RemoteAddr)
+		return false
+	}
+	breakGlassUses.Inc("accepted")
+	log.Printf("ALARM: break-glass admin credential used: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	if s.Events != nil {
+		s.Events.Notify(webhook.BreakGlassUsed, map[string]string{"method": r.Method, "path": r.URL.Path, "remote_addr": r.RemoteAddr})
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Limits for settings connectors accept in a config_update. Connectors
+// validate again on receipt.
+const (
+	minHeartbeatInterval = time.Second
+	maxHeartbeatInterval = 5 * time.Minute
+	maxTunnelersLimit    = 10000
+)
+
+// connectorConfig is the config_update payload. Omitted fields are left
+// unchanged on the connector.
+type connectorConfig struct {
+	HeartbeatInterval *string `json:"heartbeat_interval,omitempty"`
+	MaxTunnelers      *int    `json:"max_tunnelers,omitempty"`
+	LogLevel          *string `json:"log_level,omitempty"`
+}
+
+type reqPushConfig struct {
+	// ConnectorID targets a single connector; empty pushes to all.
+	ConnectorID string `json:"connector_id"`
+	connectorConfig
+}
+
+func (c connectorConfig) validate() error {
+	if c.HeartbeatInterval == nil && c.MaxTunnelers == nil && c.LogLevel == nil {
+		return fmt.Errorf("no settings to push")
+	}
+	if c.HeartbeatInterval != nil {
+		d, err := time.ParseDuration(*c.HeartbeatInterval)
+		if err != nil {
+			return fmt.Errorf("invalid heartbeat_interval: %v", err)
+		}
+		if d < minHeartbeatInterval || d > maxHeartbeatInterval {
+			return fmt.Errorf("heartbeat_interval must be between %s and %s", minHeartbeatInterval, maxHeartbeatInterval)
+		}
+	}
+	if c.MaxTunnelers != nil && (*c.MaxTunnelers < 0 || *c.MaxTunnelers > maxTunnelersLimit) {
+		return fmt.Errorf("max_tunnelers must be between 0 and %d", maxTunnelersLimit)
+	}
+	if c.LogLevel != nil && *c.LogLevel != "info" && *c.LogLevel != "debug" {
+		return fmt.Errorf("log_level must be info or debug")
+	}
+	return nil
+}
+
+func (s *Server) handlePushConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Config == nil {
+		http.Error(w, "config push unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req reqPushConfig
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := req.connectorConfig.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload, err := json.Marshal(req.connectorConfig)
+	if err != nil {
+		http.Error(w, "failed to encode config", http.StatusInternalServerError)
+		return
+	}
+
+	sent := s.Config.PushConfig(req.ConnectorID, payload)
+	if req.ConnectorID != "" && sent == 0 {
+		http.Error(w, "connector not connected", http.StatusNotFound)
+		return
+	}
+	log.Printf("admin: pushed config to %d connector(s) target=%q payload=%s", sent, req.ConnectorID, payload)
+	writeJSON(w, http.StatusOK, map[string]int{"connectors": sent})
+}
+
+func (s *Server) handleRebroadcastAllowlist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Allowlist == nil {
+		http.Error(w, "allowlist rebroadcast unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		// ConnectorID targets a single connector; empty sends to all.
+		ConnectorID string `json:"connector_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	sent := s.Allowlist.RebroadcastAllowlist(req.ConnectorID)
+	if req.ConnectorID != "" && sent == 0 {
+		http.Error(w, "connector not connected", http.StatusNotFound)
+		return
+	}
+	log.Printf("admin: rebroadcast tunneler allowlist to %d connector(s) target=%q", sent, req.ConnectorID)
+	writeJSON(w, http.StatusOK, map[string]int{"connectors": sent})
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"controller/state"
+)
+
+// drainStatus is the body of every /api/admin/connectors/{id}/drain reply.
+type drainStatus struct {
+	ConnectorID string     `json:"connector_id"`
+	Draining    bool       `json:"draining"`
+	Since       *time.Time `json:"since,omitempty"`
+	// RemainingTunnelers is null until the connector reports its count.
+	RemainingTunnelers *int       `json:"remaining_tunnelers"`
+	ReportedAt         *time.Time `json:"reported_at,omitempty"`
+	Drained            bool       `json:"drained"`
+	// Notified is set on POST and DELETE: whether the connector was
+	// connected and told at once.
+	Notified *bool `json:"notified,omitempty"`
+}
+
+func newDrainStatus(id string, d state.DrainStatus, draining bool) drainStatus {
+	resp := drainStatus{ConnectorID: id, Draining: draining}
+	if !draining {
+		return resp
+	}
+	resp.Since = &d.Since
+	if d.Reported {
+		resp.RemainingTunnelers = &d.Tunnelers
+		resp.ReportedAt = &d.ReportedAt
+	}
+	resp.Drained = d.Drained()
+	return resp
+}
+
+// handleConnectorDrain serves /api/admin/connectors/{id}/drain. POST marks
+// the connector draining: it refuses new tunnelers, is no longer resolved
+// for tunnelers, and reports its remaining tunnelers until none are left.
+// GET returns the progress and DELETE cancels the drain.
+func (s *Server) handleConnectorDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	if !validID(id) {
+		http.Error(w, "invalid connector id", http.StatusBadRequest)
+		return
+	}
+	if _, known := s.Reg.Get(id); !known {
+		http.Error(w, "connector not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		d, draining := s.Reg.Drain(id)
+		writeJSON(w, http.StatusOK, newDrainStatus(id, d, draining))
+	case http.MethodPost:
+		d, started := s.Reg.StartDrain(id)
+		notified := s.Drain != nil && s.Drain.SendDrain(id, true)
+		if started {
+			s.Reg.RecordEvent(id, state.EventDraining, "")
+			log.Printf("admin: connector drain started connector_id=%s notified=%t", id, notified)
+		}
+		resp := newDrainStatus(id, d, true)
+		resp.Notified = &notified
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodDelete:
+		if !s.Reg.StopDrain(id) {
+			http.Error(w, "connector is not draining", http.StatusConflict)
+			return
+		}
+		notified := s.Drain != nil && s.Drain.SendDrain(id, false)
+		s.Reg.RecordEvent(id, state.EventUndrained, "")
+		log.Printf("admin: connector drain cancelled connector_id=%s notified=%t", id, notified)
+		resp := newDrainStatus(id, state.DrainStatus{}, false)
+		resp.Notified = &notified
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"net/http"
+
+	"controller/state"
+)
+
+// handleConnectorEvents serves GET /api/admin/connectors/{id}/events: the
+// recorded history of one connector, oldest first.
+func (s *Server) handleConnectorEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	if !validID(id) {
+		http.Error(w, "invalid connector id", http.StatusBadRequest)
+		return
+	}
+	events, ok := s.Reg.Events(id)
+	if !ok {
+		if _, known := s.Reg.Get(id); !known {
+			http.Error(w, "connector not found", http.StatusNotFound)
+			return
+		}
+		events = []state.ConnectorEvent{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ConnectorID string                 `json:"connector_id"`
+		Events      []state.ConnectorEvent `json:"events"`
+	}{id, events})
+}
@@ -2,51 +2,167 @@ package admin
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"controller/api"
+	"controller/buildinfo"
+	"controller/ca"
+	"controller/metrics"
 	"controller/state"
+	"controller/webhook"
 )
 
 type Server struct {
 	Tokens    *state.TokenStore
 	Reg       *state.Registry
 	Tunnelers *state.TunnelerStatusRegistry
+	// TunnelerPreRegistry holds tunneler ids approved for enrollment.
+	TunnelerPreRegistry *state.TunnelerPreRegistry
+	Pending             *state.PendingStore
 
-	AdminAuthToken    string
-	InternalAuthToken string
+	// AdminAuth and InternalAuth guard the admin API and the internal
+	// token-consume API; both can be rotated via ReloadAuth.
+	AdminAuth    *AuthToken
+	InternalAuth *AuthToken
+	// AdminReadOnlyAuth, when set, is a second admin credential limited to
+	// the GET side of the routes registered with adminRead.
+	AdminReadOnlyAuth *AuthToken
+
+	// BreakGlass is an optional long-lived admin credential accepted in
+	// addition to AdminAuth. When BreakGlassFactor is set it must also be
+	// presented in the X-Break-Glass-Factor header.
+	BreakGlass       *AuthToken
+	BreakGlassFactor *AuthToken
+
+	// TargetConnectorVersion marks older connectors with needs_upgrade in
+	// GET /api/admin/connectors; empty disables it.
+	TargetConnectorVersion string
+
+	// TrustDomain, CAFingerprint and CANotAfter are reported by
+	// GET /api/admin/info.
+	TrustDomain   string
+	CAFingerprint string
+	CANotAfter    time.Time
+
+	// State and CA back the signed state export/import endpoints.
+	State state.Stores
+	CA    *ca.CA
+
+	// Events receives lifecycle events (e.g. token_consumed); may be nil.
+	Events interface {
+		Notify(eventType string, data map[string]string)
+	}
+	// Config pushes hot-reloadable settings to connected connectors.
+	Config interface {
+		PushConfig(connectorID string, payload []byte) int
+	}
+	// Allowlist re-sends the full tunneler allowlist to connected
+	// connectors.
+	Allowlist interface {
+		RebroadcastAllowlist(connectorID string) int
+	}
+	// Drain tells a connected connector to start or stop draining.
+	Drain interface {
+		SendDrain(connectorID string, drain bool) bool
+	}
+	// Streams lists and closes live control-plane streams.
+	Streams interface {
+		Streams() []api.StreamInfo
+		CloseStream(spiffeID string) bool
+	}
+	// Policy evaluates enrollment policies for POST /api/admin/policy/evaluate.
+	Policy interface {
+		EvaluatePolicy(req api.PolicyRequest) api.PolicyDecision
+	}
 }
 
+// RegisterRoutes mounts the admin API on mux. Routes registered with
+// adminRead also accept the read-only token for GET and HEAD; the state
+// export stays admin-only because it is a full backup of the controller.
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle("/api/admin/info", s.adminRead(http.HandlerFunc(s.handleInfo)))
 	mux.Handle("/api/admin/tokens", s.adminAuth(http.HandlerFunc(s.handleCreateToken)))
-	mux.Handle("/api/admin/connectors", s.adminAuth(http.HandlerFunc(s.handleListConnectors)))
-	mux.Handle("/api/admin/tunnelers", s.adminAuth(http.HandlerFunc(s.handleListTunnelers)))
+	mux.Handle("/api/admin/connectors", s.adminRead(http.HandlerFunc(s.handleListConnectors)))
+	mux.Handle("/api/admin/connectors/config", s.adminAuth(http.HandlerFunc(s.handlePushConfig)))
+	mux.Handle("/api/admin/connectors/rebroadcast-allowlist", s.adminAuth(http.HandlerFunc(s.handleRebroadcastAllowlist)))
+	mux.Handle("/api/admin/connectors/{id}/events", s.adminRead(http.HandlerFunc(s.handleConnectorEvents)))
+	mux.Handle("/api/admin/connectors/{id}/drain", s.adminRead(http.HandlerFunc(s.handleConnectorDrain)))
+	mux.Handle("/api/admin/streams", s.adminRead(http.HandlerFunc(s.handleListStreams)))
+	mux.Handle("/api/admin/streams/{id...}", s.adminAuth(http.HandlerFunc(s.handleCloseStream)))
+	mux.Handle("/api/admin/tunnelers", s.adminRead(http.HandlerFunc(s.handleTunnelers)))
+	mux.Handle("/api/admin/tunnelers/registered", s.adminRead(http.HandlerFunc(s.handleListRegisteredTunnelers)))
+	mux.Handle("/api/admin/policy/evaluate", s.adminAuth(http.HandlerFunc(s.handleEvaluatePolicy)))
+	mux.Handle("/api/admin/pending", s.adminRead(http.HandlerFunc(s.handleListPending)))
+	mux.Handle("/api/admin/pending/{id}/approve", s.adminAuth(http.HandlerFunc(s.handleApprovePending)))
+	mux.Handle("/api/admin/reload-auth", s.adminAuth(http.HandlerFunc(s.handleReloadAuth)))
+	mux.Handle("/api/admin/state/export", s.adminAuth(http.HandlerFunc(s.handleExportState)))
+	mux.Handle("/api/admin/state/import", s.adminAuth(http.HandlerFunc(s.handleImportState)))
+	mux.Handle("/metrics", s.adminRead(metrics.Handler()))
 	mux.Handle("/api/internal/consume-token", s.internalAuth(http.HandlerFunc(s.handleConsumeToken)))
 }
 
+// Admin API roles, as logged for each request.
+const (
+	roleAdmin      = "admin"
+	roleReadOnly   = "readonly"
+	roleBreakGlass = "break-glass"
+)
+
+// adminAuth admits only the full admin credentials.
 func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return s.authorizeAdmin(next, false)
+}
+
+// adminRead is adminAuth that also admits the read-only token for GET and
+// HEAD requests.
+func (s *Server) adminRead(next http.Handler) http.Handler {
+	return s.authorizeAdmin(next, true)
+}
+
+func (s *Server) authorizeAdmin(next http.Handler, readable bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.AdminAuthToken == "" {
+		if !s.AdminAuth.Configured() && !s.BreakGlass.Configured() {
 			http.Error(w, "admin auth not configured", http.StatusServiceUnavailable)
 			return
 		}
-		auth := r.Header.Get("Authorization")
-		if auth != "Bearer "+s.AdminAuthToken {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var role string
+		switch {
+		case !ok:
+		case s.AdminAuth.Valid(token):
+			role = roleAdmin
+		case s.AdminReadOnlyAuth.Valid(token):
+			role = roleReadOnly
+		case s.breakGlass(r, token):
+			role = roleBreakGlass
+		}
+		if role == "" {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if role == roleReadOnly && (!readable || r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			log.Printf("admin: %s %s denied for role=%s from %s", r.Method, r.URL.Path, role, r.RemoteAddr)
+			http.Error(w, "read-only admin token cannot use this endpoint", http.StatusForbidden)
+			return
+		}
+		log.Printf("admin: %s %s role=%s from %s", r.Method, r.URL.Path, role, r.RemoteAddr)
 		next.ServeHTTP(w, r)
 	})
 }
 
 func (s *Server) internalAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.InternalAuthToken == "" {
+		if !s.InternalAuth.Configured() {
 			http.Error(w, "internal auth not configured", http.StatusServiceUnavailable)
 			return
 		}
-		if r.Header.Get("X-Internal-Token") != s.InternalAuthToken {
+		if !s.InternalAuth.Valid(r.Header.Get("X-Internal-Token")) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -54,12 +170,64 @@ func (s *Server) internalAuth(next http.Handler) http.Handler {
 	})
 }
 
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"version":      buildinfo.Version,
+		"commit":       buildinfo.Commit,
+		"build_date":   buildinfo.Date,
+		"trust_domain": s.TrustDomain,
+		"ca_sha256":    s.CAFingerprint,
+		"ca_not_after": s.CANotAfter.UTC().Format(time.RFC3339),
+	})
+}
+
 func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	token, expires, err := s.Tokens.CreateToken()
+	// ?kind=tunneler creates a token that only enrolls tunnelers.
+	kind, err := state.ParseTokenKind(r.URL.Query().Get("kind"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		TTL string `json:"ttl"`
+		// BindIP restricts the token to connectors claiming a private IP
+		// in this address or CIDR.
+		BindIP string `json:"bind_ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	var requested time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			http.Error(w, "ttl must be a positive duration", http.StatusBadRequest)
+			return
+		}
+		requested = d
+	}
+	var bound string
+	if req.BindIP != "" {
+		var err error
+		if bound, err = state.ParseIPBinding(req.BindIP); err != nil {
+			http.Error(w, "bind_ip: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ttl, clamped := s.Tokens.EffectiveTTL(requested)
+	if clamped {
+		log.Printf("WARNING: enrollment token requested with ttl %s, above MAX_TOKEN_TTL; capped to %s", requested, ttl)
+	}
+	token, expires, err := s.Tokens.CreateBoundToken(kind, ttl, bound)
 	if err != nil {
 		http.Error(w, "failed to create token", http.StatusInternalServerError)
 		return
@@ -68,6 +236,14 @@ func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]string{
 		"token":      token,
 		"expires_at": expires.UTC().Format(time.RFC3339),
+		"ttl":        ttl.String(),
+		"kind":       kind,
+	}
+	if bound != "" {
+		resp["bind_ip"] = bound
+	}
+	if clamped {
+		resp["warning"] = fmt.Sprintf("requested ttl %s exceeds the maximum; capped to %s", requested, ttl)
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
@@ -80,6 +256,8 @@ func (s *Server) handleConsumeToken(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Token       string `json:"token"`
 		ConnectorID string `json:"connector_id"`
+		PrivateIP   string `json:"private_ip"`
+		Kind        string `json:"kind"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
@@ -89,18 +267,35 @@ func (s *Server) handleConsumeToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing connector_id", http.StatusBadRequest)
 		return
 	}
-	if err := s.Tokens.ConsumeToken(req.Token, req.ConnectorID); err != nil {
+	kind, err := state.ParseTokenKind(req.Kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.Tokens.ConsumeToken(req.Token, kind, req.ConnectorID, req.PrivateIP); err != nil {
 		http.Error(w, fmt.Sprintf("token invalid: %v", err), http.StatusUnauthorized)
 		return
 	}
+	if s.Events != nil {
+		s.Events.Notify(webhook.TokenConsumed, map[string]string{"id": req.ConnectorID, "kind": kind})
+	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// onlineWindow is how recently a connector or tunneler must have been seen
+// to be reported ONLINE.
+const onlineWindow = 30 * time.Second
+
 func (s *Server) handleListConnectors(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	records := s.Reg.List()
 	now := time.Now().UTC()
 	type respConnector struct {
@@ -109,34 +304,147 @@ func (s *Server) handleListConnectors(w http.ResponseWriter, r *http.Request) {
 		PrivateIP string `json:"private_ip"`
 		LastSeen  string `json:"last_seen"`
 		Version   string `json:"version"`
+
+		NeedsUpgrade bool `json:"needs_upgrade"`
+
+		ClockSkewMillis int64 `json:"clock_skew_ms"`
+		ClockSkewed     bool  `json:"clock_skewed"`
+
+		IssuedCerts    int  `json:"issued_certs"`
+		RenewalRate    int  `json:"renewal_rate"`
+		RenewalAnomaly bool `json:"renewal_anomaly"`
+
+		Provider   string `json:"provider,omitempty"`
+		InstanceID string `json:"instance_id,omitempty"`
+		Region     string `json:"region,omitempty"`
+
+		Draining           bool `json:"draining,omitempty"`
+		RemainingTunnelers *int `json:"remaining_tunnelers,omitempty"`
 	}
-	resp := make([]respConnector, 0, len(records))
+	online := 0
 	for _, rec := range records {
+		if now.Sub(rec.LastSeen) < onlineWindow {
+			online++
+		}
+	}
+	recs := paginate(records, limit, offset)
+	recs.Online = &online
+	resp := mapPage(recs, func(rec state.ConnectorRecord) respConnector {
 		status := "OFFLINE"
-		if now.Sub(rec.LastSeen) < 30*time.Second {
+		if now.Sub(rec.LastSeen) < onlineWindow {
 			status = "ONLINE"
 		}
-		resp = append(resp, respConnector{
+		issuance, _ := s.Reg.IssuanceStats(fmt.Sprintf("spiffe://%s/connector/%s", s.TrustDomain, rec.ID))
+		drain, draining := s.Reg.Drain(rec.ID)
+		var remaining *int
+		if drain.Reported {
+			remaining = &drain.Tunnelers
+		}
+		return respConnector{
 			ID:        rec.ID,
 			Status:    status,
 			PrivateIP: rec.PrivateIP,
 			LastSeen:  humanizeDuration(now.Sub(rec.LastSeen)),
 			Version:   rec.Version,
-		})
-	}
+
+			NeedsUpgrade: s.TargetConnectorVersion != "" && api.VersionBehind(rec.Version, s.TargetConnectorVersion),
+
+			ClockSkewMillis: rec.ClockSkew.Milliseconds(),
+			ClockSkewed:     rec.ClockSkewed,
+
+			IssuedCerts:    issuance.Total,
+			RenewalRate:    issuance.RatePerHour,
+			RenewalAnomaly: issuance.Anomalous,
+
+			Provider:   rec.Provisioning.Provider,
+			InstanceID: rec.Provisioning.InstanceID,
+			Region:     rec.Provisioning.Region,
+
+			Draining:           draining,
+			RemainingTunnelers: remaining,
+		}
+	})
 	writeJSON(w, http.StatusOK, resp)
 }
 
-func (s *Server) handleListTunnelers(w http.ResponseWriter, r *http.Request) {
+// handleTunnelers lists tunneler status on GET and pre-registers a tunneler
+// id for enrollment on POST.
+func (s *Server) handleTunnelers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListTunnelers(w, r)
+	case http.MethodPost:
+		s.handleRegisterTunneler(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type respTunnelerRegistration struct {
+	ID           string `json:"id"`
+	RegisteredAt string `json:"registered_at"`
+}
+
+func (s *Server) handleRegisterTunneler(w http.ResponseWriter, r *http.Request) {
+	if s.TunnelerPreRegistry == nil {
+		http.Error(w, "tunneler pre-registration unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !validID(req.ID) {
+		http.Error(w, "invalid tunneler id", http.StatusBadRequest)
+		return
+	}
+	reg, created := s.TunnelerPreRegistry.Register(req.ID)
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+		log.Printf("admin: pre-registered tunneler id=%s", reg.ID)
+	}
+	writeJSON(w, code, respTunnelerRegistration{
+		ID:           reg.ID,
+		RegisteredAt: reg.RegisteredAt.Format(time.RFC3339),
+	})
+}
+
+func (s *Server) handleListRegisteredTunnelers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if s.Tunnelers == nil {
-		writeJSON(w, http.StatusOK, []interface{}{})
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var regs []state.TunnelerPreRegistration
+	if s.TunnelerPreRegistry != nil {
+		regs = s.TunnelerPreRegistry.List()
+	}
+	writeJSON(w, http.StatusOK, mapPage(paginate(regs, limit, offset), func(reg state.TunnelerPreRegistration) respTunnelerRegistration {
+		return respTunnelerRegistration{
+			ID:           reg.ID,
+			RegisteredAt: reg.RegisteredAt.Format(time.RFC3339),
+		}
+	}))
+}
+
+func (s *Server) handleListTunnelers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	records := s.Tunnelers.List()
+	var records []state.TunnelerRecord
+	if s.Tunnelers != nil {
+		records = s.Tunnelers.List()
+	}
 	now := time.Now().UTC()
 	type respTunneler struct {
 		ID          string `json:"id"`
@@ -144,20 +452,101 @@ func (s *Server) handleListTunnelers(w http.ResponseWriter, r *http.Request) {
 		ConnectorID string `json:"connector_id"`
 		LastSeen    string `json:"last_seen"`
 	}
-	resp := make([]respTunneler, 0, len(records))
+	online := 0
 	for _, rec := range records {
+		if now.Sub(rec.LastSeen) < onlineWindow {
+			online++
+		}
+	}
+	recs := paginate(records, limit, offset)
+	recs.Online = &online
+	writeJSON(w, http.StatusOK, mapPage(recs, func(rec state.TunnelerRecord) respTunneler {
 		status := "OFFLINE"
-		if now.Sub(rec.LastSeen) < 30*time.Second {
+		if now.Sub(rec.LastSeen) < onlineWindow {
 			status = "ONLINE"
 		}
-		resp = append(resp, respTunneler{
+		return respTunneler{
 			ID:          rec.ID,
 			Status:      status,
 			ConnectorID: rec.ConnectorID,
 			LastSeen:    humanizeDuration(now.Sub(rec.LastSeen)),
-		})
+		}
+	}))
+}
+
+type respPending struct {
+	ID           string `json:"id"`
+	PublicKeySHA string `json:"public_key_sha256"`
+	PrivateIP    string `json:"private_ip"`
+	Version      string `json:"version"`
+	PeerAddr     string `json:"peer_addr"`
+	RequestedAt  string `json:"requested_at"`
+	Approved     bool   `json:"approved"`
+}
+
+func toRespPending(rec state.PendingEnrollment) respPending {
+	return respPending{
+		ID:           rec.ID,
+		PublicKeySHA: rec.PublicKeySHA,
+		PrivateIP:    rec.PrivateIP,
+		Version:      rec.Version,
+		PeerAddr:     rec.PeerAddr,
+		RequestedAt:  rec.RequestedAt.Format(time.RFC3339),
+		Approved:     rec.Approved,
 	}
-	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleListPending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var records []state.PendingEnrollment
+	if s.Pending != nil {
+		records = s.Pending.List()
+	}
+	writeJSON(w, http.StatusOK, mapPage(paginate(records, limit, offset), toRespPending))
+}
+
+func (s *Server) handleApprovePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Pending == nil {
+		http.Error(w, "approval mode not enabled", http.StatusConflict)
+		return
+	}
+	rec, err := s.Pending.Approve(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("admin: approved pending enrollment id=%s public_key_sha256=%s", rec.ID, rec.PublicKeySHA)
+	writeJSON(w, http.StatusOK, toRespPending(rec))
+}
+
+// validID mirrors the enrollment id rules: 1-128 characters of
+// [A-Za-z0-9._-].
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if (r >= 'a' && r <= 'z') ||
+			(r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') ||
+			r == '-' || r == '_' || r == '.' {
+			continue
+		}
+		return false
+	}
+	return true
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
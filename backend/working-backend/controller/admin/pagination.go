@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultPageLimit applies when a list request has no limit.
+	defaultPageLimit = 100
+	// maxPageLimit caps the limit a caller may ask for.
+	maxPageLimit = 1000
+)
+
+// page is the envelope returned by every admin list endpoint. Total and
+// Online describe the whole set, not just Items.
+type page[T any] struct {
+	Items  []T  `json:"items"`
+	Total  int  `json:"total"`
+	Online *int `json:"online,omitempty"`
+	Limit  int  `json:"limit"`
+	Offset int  `json:"offset"`
+}
+
+// pageParams reads ?limit= and ?offset=.
+func pageParams(r *http.Request) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// paginate slices the already-sorted items into a page.
+func paginate[T any](items []T, limit, offset int) page[T] {
+	p := page[T]{Items: []T{}, Total: len(items), Limit: limit, Offset: offset}
+	if offset < len(items) {
+		p.Items = items[offset:min(offset+limit, len(items))]
+	}
+	return p
+}
+
+// mapPage converts the items of p, keeping its totals.
+func mapPage[T, U any](p page[T], f func(T) U) page[U] {
+	out := page[U]{Items: make([]U, 0, len(p.Items)), Total: p.Total, Online: p.Online, Limit: p.Limit, Offset: p.Offset}
+	for _, item := range p.Items {
+		out.Items = append(out.Items, f(item))
+	}
+	return out
+}
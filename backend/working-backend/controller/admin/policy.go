@@ -0,0 +1,27 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"controller/api"
+)
+
+// handleEvaluatePolicy reports whether a hypothetical enrollment would pass
+// the enrollment policies. Nothing is issued or recorded.
+func (s *Server) handleEvaluatePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Policy == nil {
+		http.Error(w, "policy evaluation unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req api.PolicyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Policy.EvaluatePolicy(req))
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"controller/ca"
+	"controller/state"
+)
+
+const maxSnapshotBytes = 64 << 20
+
+// snapshotBundle is the exported state document. Signature is the CA's
+// signature (see ca.SignBlob) over the compact JSON encoding of Snapshot.
+type snapshotBundle struct {
+	SchemaVersion int             `json:"schema_version"`
+	Snapshot      json.RawMessage `json:"snapshot"`
+	Signature     string          `json:"signature"`
+}
+
+func (s *Server) handleExportState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.CA == nil {
+		http.Error(w, "state export unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	snap, err := s.State.Export(s.TrustDomain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	payload, err := json.Marshal(snap)
+	if err != nil {
+		http.Error(w, "failed to encode snapshot", http.StatusInternalServerError)
+		return
+	}
+	sig, err := ca.SignBlob(s.CA, payload)
+	if err != nil {
+		http.Error(w, "failed to sign snapshot", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("admin: exported state snapshot tokens=%d connectors=%d tunnelers=%d", len(snap.Tokens), len(snap.Connectors), len(snap.Tunnelers))
+	w.Header().Set("X-Snapshot-Version", strconv.Itoa(state.SnapshotVersion))
+	w.Header().Set("Content-Disposition", `attachment; filename="controller-state.json"`)
+	writeJSON(w, http.StatusOK, snapshotBundle{
+		SchemaVersion: state.SnapshotVersion,
+		Snapshot:      payload,
+		Signature:     base64.StdEncoding.EncodeToString(sig),
+	})
+}
+
+func (s *Server) handleImportState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.CA == nil {
+		http.Error(w, "state import unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var bundle snapshotBundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotBytes)).Decode(&bundle); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if bundle.SchemaVersion != state.SnapshotVersion {
+		http.Error(w, "unsupported snapshot schema version", http.StatusBadRequest)
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(bundle.Signature)
+	if err != nil || len(sig) == 0 {
+		http.Error(w, "invalid snapshot signature", http.StatusBadRequest)
+		return
+	}
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, bundle.Snapshot); err != nil {
+		http.Error(w, "invalid snapshot", http.StatusBadRequest)
+		return
+	}
+	if err := ca.VerifyBlob(s.CA.Cert, payload.Bytes(), sig); err != nil {
+		http.Error(w, "snapshot signature does not verify against this controller's CA", http.StatusBadRequest)
+		return
+	}
+
+	var snap state.Snapshot
+	dec := json.NewDecoder(&payload)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&snap); err != nil {
+		http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.State.Import(snap, s.TrustDomain); err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, state.ErrStateNotEmpty) {
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	log.Printf("admin: imported state snapshot created_at=%s tokens=%d connectors=%d tunnelers=%d", snap.CreatedAt.Format(time.RFC3339), len(snap.Tokens), len(snap.Connectors), len(snap.Tunnelers))
+	writeJSON(w, http.StatusOK, map[string]int{
+		"tokens":     len(snap.Tokens),
+		"connectors": len(snap.Connectors),
+		"tunnelers":  len(snap.Tunnelers),
+	})
+}
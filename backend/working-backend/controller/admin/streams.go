@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"controller/api"
+)
+
+func (s *Server) handleListStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var streams []api.StreamInfo
+	if s.Streams != nil {
+		streams = s.Streams.Streams()
+	}
+	writeJSON(w, http.StatusOK, paginate(streams, limit, offset))
+}
+
+// handleCloseStream closes the stream named by a connector SPIFFE ID
+// (URL-encoded) or a bare connector id.
+func (s *Server) handleCloseStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Streams == nil {
+		http.Error(w, "stream control unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	spiffeID := r.PathValue("id")
+	if !strings.HasPrefix(spiffeID, "spiffe://") {
+		if !validID(spiffeID) {
+			http.Error(w, "invalid stream id", http.StatusBadRequest)
+			return
+		}
+		spiffeID = "spiffe://" + s.TrustDomain + "/connector/" + spiffeID
+	}
+	if !s.Streams.CloseStream(spiffeID) {
+		http.Error(w, "stream not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("admin: closed control-plane stream spiffe_id=%s", spiffeID)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "closed", "spiffe_id": spiffeID})
+}
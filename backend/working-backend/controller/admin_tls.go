@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// adminTLSConfig returns the TLS configuration for the admin HTTP server
+// (ADMIN_TLS). With certFile and keyFile it serves that pair, for operators
+// who front the admin API with a certificate from their own CA; otherwise it
+// serves the controller certificates, so clients verify the admin port
+// against the internal CA bundle just like the gRPC port. Plaintext requests
+// to the TLS port are answered by net/http with 400 Bad Request.
+func adminTLSConfig(certFile, keyFile string, controller *certSelector) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS13}
+	if certFile == "" {
+		cfg.GetCertificate = controller.GetCertificate
+		return cfg, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load admin TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse admin TLS certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("admin TLS certificate %s expired at %s", certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+	cfg.Certificates = []tls.Certificate{cert}
+	return cfg, nil
+}
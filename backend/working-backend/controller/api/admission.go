@@ -0,0 +1,87 @@
+package api
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// AcceptLimiter bounds how many new control-plane streams are admitted per
+// second so a reconnect storm after a controller restart is spread out.
+type AcceptLimiter struct {
+	perSecond  float64
+	retryAfter time.Duration
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewAcceptLimiter returns a limiter admitting perSecond new streams per second
+// (with an equal burst). Rejected callers are told to retry after roughly
+// retryAfter, with jitter. A non-positive perSecond disables the limiter.
+func NewAcceptLimiter(perSecond int, retryAfter time.Duration) *AcceptLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if retryAfter <= 0 {
+		retryAfter = 5 * time.Second
+	}
+	return &AcceptLimiter{
+		perSecond:  float64(perSecond),
+		retryAfter: retryAfter,
+		tokens:     float64(perSecond),
+		last:       time.Now(),
+	}
+}
+
+// Allow consumes an admission slot if one is available.
+func (l *AcceptLimiter) Allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+	if l.tokens > l.perSecond {
+		l.tokens = l.perSecond
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// RetryAfter returns a jittered retry delay in [retryAfter, 2*retryAfter).
+func (l *AcceptLimiter) RetryAfter() time.Duration {
+	return jitterRetryAfter(l.retryAfter)
+}
+
+// jitterRetryAfter returns a delay in [base, 2*base) so rejected connectors
+// do not come back in lockstep; a non-positive base uses 5s.
+func jitterRetryAfter(base time.Duration) time.Duration {
+	if base <= 0 {
+		base = 5 * time.Second
+	}
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
+
+// retryAfterError builds a gRPC status carrying a RetryInfo detail so clients
+// can wait the suggested delay instead of their own backoff.
+func retryAfterError(code codes.Code, msg string, retryAfter time.Duration) error {
+	st := status.New(code, msg)
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
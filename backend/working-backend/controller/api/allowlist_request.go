@@ -0,0 +1,44 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"controller/metrics"
+)
+
+// DefaultAllowlistRequestInterval is the minimum spacing between
+// allowlist_request messages the controller answers for one stream.
+const DefaultAllowlistRequestInterval = 10 * time.Second
+
+var allowlistRequests = metrics.NewCounterVec(
+	"controller_allowlist_requests_total",
+	"allowlist_request messages from connectors, by result (sent, throttled).",
+	"result",
+)
+
+// allowlistRequestLog logs throttled requests at most once a minute per
+// connector.
+var allowlistRequestLog = &LogSampler{interval: time.Minute, seen: make(map[string]*sampleState)}
+
+// handleAllowlistRequest answers a connector's allowlist_request with the
+// full tunneler_allowlist, at most once per AllowlistRequestInterval per
+// stream; requests inside the interval are dropped. Only the Connect
+// goroutine of client calls it.
+func (s *ControlPlaneServer) handleAllowlistRequest(client *connectorClient) {
+	interval := s.AllowlistRequestInterval
+	if interval <= 0 {
+		interval = DefaultAllowlistRequestInterval
+	}
+	now := time.Now()
+	if !client.lastAllowlistRequest.IsZero() && now.Sub(client.lastAllowlistRequest) < interval {
+		allowlistRequests.Inc("throttled")
+		if allowlistRequestLog.Allow(client.spiffeID) {
+			log.Printf("control-plane: throttled allowlist_request from %s (at most one per %s)", client.spiffeID, interval)
+		}
+		return
+	}
+	client.lastAllowlistRequest = now
+	allowlistRequests.Inc("sent")
+	_ = s.sendAllowlist(client)
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Attestor verifies a connector's platform identity during EnrollConnector
+// when ENROLL_MODE=attest. Attest reports whether req may enroll; when it may
+// not, reason is returned to the client. An error means the attestation
+// could not be checked and is also refused.
+type Attestor interface {
+	Attest(ctx context.Context, req *controllerpb.EnrollRequest) (ok bool, reason string, err error)
+}
+
+// NoopAttestor accepts every request. It is the default attestor, leaving
+// the enrollment token as the only check.
+type NoopAttestor struct{}
+
+func (NoopAttestor) Attest(context.Context, *controllerpb.EnrollRequest) (bool, string, error) {
+	return true, "", nil
+}
+
+// AttestationAWSIID is the Attestation.Type of an EC2 instance identity
+// document.
+const AttestationAWSIID = "aws-iid"
+
+// AWSIdentityAttestor verifies EC2 instance identity documents: the document
+// from /latest/dynamic/instance-identity/document and its base64 RSA-SHA256
+// signature from /latest/dynamic/instance-identity/signature, checked
+// against the AWS public certificate for the region.
+//
+// A document is the same for the whole life of the instance, so anyone who
+// has read it can replay it; keep the enrollment token required unless the
+// metadata service is otherwise protected.
+type AWSIdentityAttestor struct {
+	// Certs are the AWS signing certificates; any one may verify.
+	Certs []*x509.Certificate
+	// Accounts, when non-empty, limits the AWS account ids allowed to enroll.
+	Accounts map[string]struct{}
+}
+
+// awsIdentityDocument holds the fields of an instance identity document the
+// attestor checks.
+type awsIdentityDocument struct {
+	AccountID  string `json:"accountId"`
+	InstanceID string `json:"instanceId"`
+	Region     string `json:"region"`
+}
+
+// NewAWSIdentityAttestor loads the AWS signing certificates from the PEM file
+// certPath. accounts is a comma-separated account id allowlist; empty allows
+// any account.
+func NewAWSIdentityAttestor(certPath, accounts string) (*AWSIdentityAttestor, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	a := &AWSIdentityAttestor{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", certPath, err)
+		}
+		if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("%s: certificate %q does not hold an RSA key", certPath, cert.Subject.String())
+		}
+		a.Certs = append(a.Certs, cert)
+	}
+	if len(a.Certs) == 0 {
+		return nil, errors.New(certPath + ": no PEM certificate found")
+	}
+	for _, id := range strings.Split(accounts, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if a.Accounts == nil {
+			a.Accounts = make(map[string]struct{})
+		}
+		a.Accounts[id] = struct{}{}
+	}
+	return a, nil
+}
+
+func (a *AWSIdentityAttestor) Attest(_ context.Context, req *controllerpb.EnrollRequest) (bool, string, error) {
+	att := req.GetAttestation()
+	if att == nil {
+		return false, "attestation required", nil
+	}
+	if att.GetType() != AttestationAWSIID {
+		return false, fmt.Sprintf("unsupported attestation type %q (expected %s)", att.GetType(), AttestationAWSIID), nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(att.GetSignature())))
+	if err != nil {
+		return false, "attestation signature is not base64", nil
+	}
+	digest := sha256.Sum256(att.GetDocument())
+	verified := false
+	for _, cert := range a.Certs {
+		if rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return false, "attestation signature does not verify", nil
+	}
+
+	var doc awsIdentityDocument
+	if err := json.Unmarshal(att.GetDocument(), &doc); err != nil {
+		return false, "attestation document is not valid JSON", nil
+	}
+	if doc.InstanceID == "" || doc.AccountID == "" {
+		return false, "attestation document lacks instanceId or accountId", nil
+	}
+	if a.Accounts != nil {
+		if _, ok := a.Accounts[doc.AccountID]; !ok {
+			return false, fmt.Sprintf("account %s is not allowed to enroll", doc.AccountID), nil
+		}
+	}
+	// Self-reported metadata must agree with the signed document.
+	if md := req.GetMetadata(); md != nil {
+		if v := md.GetInstanceId(); v != "" && v != doc.InstanceID {
+			return false, fmt.Sprintf("metadata instance_id %s does not match attested instance %s", v, doc.InstanceID), nil
+		}
+		if v := md.GetRegion(); v != "" && v != doc.Region {
+			return false, fmt.Sprintf("metadata region %s does not match attested region %s", v, doc.Region), nil
+		}
+	}
+	return true, "", nil
+}
+
+// authorizeConnectorAttestation runs the configured attestor and then, unless
+// AttestTokenOptional is set and no token was sent, consumes the enrollment
+// token. The attestor runs first so a refused attestation does not burn the
+// token.
+func (s *EnrollmentServer) authorizeConnectorAttestation(ctx context.Context, req *controllerpb.EnrollRequest) error {
+	attestor := s.Attestor
+	if attestor == nil {
+		attestor = NoopAttestor{}
+	}
+	ok, reason, err := attestor.Attest(ctx, req)
+	if err != nil {
+		reason = err.Error()
+	}
+	if err != nil || !ok {
+		log.Printf("enrollment attestation rejected: id=%s reason=%s", req.GetId(), reason)
+		return status.Errorf(codes.PermissionDenied, "attestation rejected: %s", reason)
+	}
+	if s.AttestTokenOptional && req.GetToken() == "" {
+		return nil
+	}
+	return s.authorizeToken(ctx, state.TokenKindConnector, req)
+}
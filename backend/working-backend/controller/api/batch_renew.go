@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxBatchRenew bounds the identities renewed by one BatchRenew call.
+const maxBatchRenew = 100
+
+// BatchRenew renews several identities for one caller. Each request is
+// authorized and renewed on its own with the same policies as Renew, so one
+// refusal does not fail the others; the results are returned in request order.
+func (s *EnrollmentServer) BatchRenew(
+	ctx context.Context,
+	req *controllerpb.BatchRenewRequest,
+) (*controllerpb.BatchRenewResponse, error) {
+
+	if len(req.GetRequests()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no renewal requests")
+	}
+	if len(req.GetRequests()) > maxBatchRenew {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d renewal requests per batch", maxBatchRenew)
+	}
+
+	role, callerID, err := s.identityFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trustDomain := s.peerTrustDomain(ctx)
+	caller := fmt.Sprintf("spiffe://%s/%s/%s", trustDomain, role, callerID)
+
+	resp := &controllerpb.BatchRenewResponse{}
+	renewed := 0
+	for _, r := range req.GetRequests() {
+		result := &controllerpb.BatchRenewResult{Id: r.GetId()}
+		out, err := s.batchRenewOne(role, trustDomain, callerID, caller, r)
+		if err != nil {
+			st := status.Convert(err)
+			result.Code, result.Error = uint32(st.Code()), st.Message()
+		} else {
+			result.Response = out
+			renewed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	log.Printf("batch renew: caller=%s trust_domain=%s requested=%d renewed=%d", caller, trustDomain, len(req.GetRequests()), renewed)
+	return resp, nil
+}
+
+func (s *EnrollmentServer) batchRenewOne(role, trustDomain, callerID, caller string, req *controllerpb.EnrollRequest) (*controllerpb.EnrollResponse, error) {
+	if err := s.validateEnrollRequest(req, "", true); err != nil {
+		return nil, err
+	}
+	pubKey, err := s.parseEnrollKey("batch-renew", req.GetPublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetId() != callerID && !s.renewAgentAllows(caller, req.GetId()) {
+		log.Printf("batch renew refused: caller=%s is not a renewal agent for %s/%s", caller, role, req.GetId())
+		return nil, status.Error(codes.PermissionDenied, "caller may not renew this id")
+	}
+	return s.renew(role, trustDomain, req, pubKey)
+}
+
+func (s *EnrollmentServer) renewAgentAllows(agent, id string) bool {
+	ids, ok := s.RenewAgents[agent]
+	return ok && (slices.Contains(ids, "*") || slices.Contains(ids, id))
+}
+
+// ParseRenewAgents parses BATCH_RENEW_AGENTS: comma-separated
+// role/agent-id=id-a|id-b entries granting an agent renewal of other ids of
+// its own role, or role/agent-id=* for all of them. Keys of the result are
+// agent SPIFFE ids.
+func ParseRenewAgents(trustDomain, spec string) (map[string][]string, error) {
+	agents := make(map[string][]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		agent, idList, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q must be role/agent-id=id|id", entry)
+		}
+		role, agentID, ok := strings.Cut(strings.TrimSpace(agent), "/")
+		if !ok || (role != "connector" && role != "tunneler") || !validID(agentID) {
+			return nil, fmt.Errorf("entry %q: agent must be connector/<id> or tunneler/<id>", entry)
+		}
+		var ids []string
+		for _, id := range strings.Split(idList, "|") {
+			id = strings.TrimSpace(id)
+			if id != "*" && !validID(id) {
+				return nil, fmt.Errorf("entry %q: invalid id %q", entry, id)
+			}
+			ids = append(ids, id)
+		}
+		key := fmt.Sprintf("spiffe://%s/%s/%s", trustDomain, role, agentID)
+		agents[key] = append(agents[key], ids...)
+	}
+	return agents, nil
+}
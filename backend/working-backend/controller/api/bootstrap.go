@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryBootstrapOnlyInterceptor rejects every unary RPC outside
+// BootstrapMethods. It guards the bootstrap listener, which does not request
+// client certificates.
+func UnaryBootstrapOnlyInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if _, ok := BootstrapMethods[info.FullMethod]; !ok {
+			return nil, status.Error(codes.PermissionDenied, "method requires the authenticated listener")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamBootstrapRejectInterceptor rejects all streaming RPCs on the
+// bootstrap listener; none are bootstrap methods.
+func StreamBootstrapRejectInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return status.Error(codes.PermissionDenied, "method requires the authenticated listener")
+	}
+}
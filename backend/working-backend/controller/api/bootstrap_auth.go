@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Connector enrollment credentials for EnrollmentServer.EnrollAuth.
+const (
+	EnrollAuthToken         = "token"
+	EnrollAuthBootstrapCert = "bootstrap_cert"
+	EnrollAuthBoth          = "both"
+)
+
+// ParseBootstrapCAs parses the PEM bundle of CAs that issue bootstrap client
+// certificates. Provisioning trust must stay separate from runtime trust, so
+// the bundle may not contain internalCA or another certificate for its key:
+// that would let any workload certificate enroll as any connector.
+func ParseBootstrapCAs(data []byte, internalCA *x509.Certificate) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	found := false
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if internalCA != nil && bytes.Equal(cert.RawSubjectPublicKeyInfo, internalCA.RawSubjectPublicKeyInfo) {
+			return nil, fmt.Errorf("certificate %q uses the internal CA key; bootstrap CAs must be separate from the internal CA", cert.Subject.String())
+		}
+		pool.AddCert(cert)
+		found = true
+	}
+	if !found {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return pool, nil
+}
+
+// bootstrapCertRequired reports whether connector enrollment needs a
+// bootstrap client certificate.
+func (s *EnrollmentServer) bootstrapCertRequired() bool {
+	return s.EnrollAuth == EnrollAuthBootstrapCert || s.EnrollAuth == EnrollAuthBoth
+}
+
+// authorizeBootstrapCert checks that the client certificate presented on
+// the connection chains to BootstrapCAs. It is verified here rather than
+// trusted from the handshake so that a connection on a listener with other
+// client CAs cannot pass.
+func (s *EnrollmentServer) authorizeBootstrapCert(ctx context.Context, connectorID string) error {
+	if s.BootstrapCAs == nil {
+		return status.Error(codes.FailedPrecondition, "bootstrap CA unavailable")
+	}
+	var chain []*x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chain = info.State.PeerCertificates
+		}
+	}
+	if len(chain) == 0 {
+		log.Printf("enrollment bootstrap certificate missing: id=%s", connectorID)
+		return status.Error(codes.PermissionDenied, "bootstrap client certificate required")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         s.BootstrapCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		log.Printf("enrollment bootstrap certificate rejected: id=%s subject=%q err=%v", connectorID, chain[0].Subject.String(), err)
+		return status.Error(codes.PermissionDenied, "bootstrap client certificate is not trusted")
+	}
+	log.Printf("enrollment bootstrap certificate accepted: id=%s subject=%q serial=%s", connectorID, chain[0].Subject.String(), chain[0].SerialNumber.Text(16))
+	return nil
+}
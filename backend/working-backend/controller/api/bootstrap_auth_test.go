@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/pem"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseBootstrapCAsRejectsInternalCA(t *testing.T) {
+	internal, bootstrap := newTestCA(t), newTestCA(t)
+	bootstrapPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: bootstrap.Cert.Raw})
+	internalPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: internal.Cert.Raw})
+
+	if _, err := ParseBootstrapCAs(bootstrapPEM, internal.Cert); err != nil {
+		t.Fatalf("separate bootstrap CA refused: %v", err)
+	}
+	if _, err := ParseBootstrapCAs(append(bootstrapPEM, internalPEM...), internal.Cert); err == nil {
+		t.Fatal("bundle containing the internal CA accepted")
+	}
+	if _, err := ParseBootstrapCAs([]byte("not pem"), internal.Cert); err == nil {
+		t.Fatal("bundle without certificates accepted")
+	}
+}
+
+func TestAuthorizeBootstrapCert(t *testing.T) {
+	internal, bootstrap := newTestCA(t), newTestCA(t)
+	pool, err := ParseBootstrapCAs(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: bootstrap.Cert.Raw}), internal.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &EnrollmentServer{EnrollAuth: EnrollAuthBootstrapCert, BootstrapCAs: pool}
+	if !s.bootstrapCertRequired() {
+		t.Fatal("bootstrap_cert mode does not require a certificate")
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"bootstrap certificate", peerContext(t, bootstrap, "connector", "c1"), codes.OK},
+		{"internal CA certificate", peerContext(t, internal, "connector", "c1"), codes.PermissionDenied},
+		{"no certificate", context.Background(), codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		if got := status.Code(s.authorizeBootstrapCert(tt.ctx, "c1")); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/stats"
+)
+
+// CompressionGzip is the only supported CONTROL_PLANE_COMPRESSION value.
+// Importing the gzip encoding registers it, so the controller always accepts
+// gzip from connectors; Compression only controls what it sends.
+const CompressionGzip = gzip.Name
+
+var (
+	controlPlanePayloadBytes = metrics.NewCounter(
+		"controller_control_plane_payload_bytes_total",
+		"Uncompressed size of control messages sent to connectors.",
+	)
+	controlPlaneCompressedBytes = metrics.NewCounter(
+		"controller_control_plane_compressed_bytes_total",
+		"Size of control messages sent to connectors after compression (equal to the payload size when uncompressed).",
+	)
+)
+
+// enableSendCompression turns on gzip for messages sent on this stream when
+// configured and the connector advertises gzip support; otherwise messages
+// stay uncompressed.
+func (s *ControlPlaneServer) enableSendCompression(ctx context.Context) {
+	if s.Compression != CompressionGzip {
+		return
+	}
+	supported, err := grpc.ClientSupportedCompressors(ctx)
+	if err != nil || !slices.Contains(supported, CompressionGzip) {
+		return
+	}
+	if err := grpc.SetSendCompressor(ctx, CompressionGzip); err != nil {
+		log.Printf("control-plane compression disabled for stream: %v", err)
+	}
+}
+
+type methodKey struct{}
+
+// ControlPlaneStats is a stats.Handler that counts payload and compressed
+// bytes sent on ControlPlane.Connect streams, exposing what compression
+// saves.
+type ControlPlaneStats struct{}
+
+func (ControlPlaneStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (ControlPlaneStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	out, ok := s.(*stats.OutPayload)
+	if !ok {
+		return
+	}
+	if method, _ := ctx.Value(methodKey{}).(string); method != controllerpb.ControlPlane_Connect_FullMethodName {
+		return
+	}
+	controlPlanePayloadBytes.Add(float64(out.Length))
+	controlPlaneCompressedBytes.Add(float64(out.CompressedLength))
+}
+
+func (ControlPlaneStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (ControlPlaneStats) HandleConn(context.Context, stats.ConnStats) {}
@@ -1,72 +1,258 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	controllerpb "controller/gen/controllerpb"
+	"controller/metrics"
+	"controller/spiffeid"
 	"controller/state"
+	"controller/webhook"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 // ControlPlaneServer implements the controller.v1.ControlPlane service.
 type ControlPlaneServer struct {
 	controllerpb.UnimplementedControlPlaneServer
+	trustDomain    string
 	registry       *state.Registry
 	tunnelers      *state.TunnelerRegistry
 	tunnelerStatus *state.TunnelerStatusRegistry
 	mu             sync.Mutex
 	clients        map[string]*connectorClient
+
+	// HeartbeatLogSampler limits heartbeat log lines; nil logs every heartbeat.
+	HeartbeatLogSampler *LogSampler
+	// AcceptLimiter throttles new streams during reconnect storms; nil admits all.
+	AcceptLimiter *AcceptLimiter
+	// MaxStreams caps the connected control-plane streams; zero is
+	// unlimited. Streams over the cap are rejected with ResourceExhausted
+	// and a retry delay based on StreamRetryAfter.
+	MaxStreams       int
+	StreamRetryAfter time.Duration
+	activeStreams    atomic.Int64
+	// ClockSkewThreshold is the client/controller clock difference above which
+	// a connector is flagged. Detection only; nothing is enforced.
+	ClockSkewThreshold time.Duration
+	// Events receives connector/tunneler lifecycle events; may be nil.
+	Events EventNotifier
+	// Compression, when CompressionGzip, gzips messages sent to connectors
+	// that support it. Empty sends uncompressed.
+	Compression string
+	// StrictProtocol closes streams that send an unknown message type
+	// instead of logging and dropping the message.
+	StrictProtocol bool
+	// AllowlistResyncInterval re-sends the full tunneler allowlist to each
+	// connector so allowlists that missed a tunneler_allow converge. Zero
+	// disables the resync.
+	AllowlistResyncInterval time.Duration
+	// AllowlistRequestInterval is the minimum spacing between answered
+	// allowlist_request messages per stream; zero uses
+	// DefaultAllowlistRequestInterval.
+	AllowlistRequestInterval time.Duration
+	// TargetVersion is the connector release the fleet should run.
+	// Connectors reporting an older version receive upgrade_available with
+	// UpgradeURL. Empty disables the signal.
+	TargetVersion string
+	UpgradeURL    string
+	// DeadLetters records control messages that could not be delivered;
+	// nil only counts them.
+	DeadLetters *DeadLetterLog
 }
 
+var controlPlaneOverloadRejects = metrics.NewCounter(
+	"controller_control_plane_overload_rejections_total",
+	"Control-plane streams rejected because the accept rate limit was exceeded.",
+)
+
+var controlPlaneStreams = metrics.NewGaugeVec(
+	"controller_control_plane_streams",
+	"Connected control-plane streams by the trust domain of the connector.",
+	"trust_domain",
+)
+
+var controlPlaneStreamLimitRejects = metrics.NewCounter(
+	"controller_control_plane_stream_limit_rejections_total",
+	"Control-plane streams rejected because MAX_CONTROL_PLANE_STREAMS streams were connected.",
+)
+
+var controlPlaneIdentityMismatches = metrics.NewCounterVec(
+	"controller_control_plane_identity_mismatches_total",
+	"Control messages dropped because their claimed identity did not match the stream's SPIFFE ID.",
+	"type",
+)
+
+var (
+	clockSkewDetections = metrics.NewCounter(
+		"controller_clock_skew_detections_total",
+		"Times a connector was newly flagged for clock skew beyond the threshold.",
+	)
+	clockSkewedConnectors = metrics.NewGauge(
+		"controller_clock_skewed_connectors",
+		"Connectors currently flagged for clock skew beyond the threshold.",
+	)
+)
+
 // NewControlPlaneServer creates a new control plane server.
 func NewControlPlaneServer(trustDomain string, registry *state.Registry, tunnelers *state.TunnelerRegistry, tunnelerStatus *state.TunnelerStatusRegistry) *ControlPlaneServer {
-	_ = trustDomain
 	return &ControlPlaneServer{
-		registry:       registry,
-		tunnelers:      tunnelers,
-		tunnelerStatus: tunnelerStatus,
-		clients:        make(map[string]*connectorClient),
+		trustDomain:        trustDomain,
+		registry:           registry,
+		tunnelers:          tunnelers,
+		tunnelerStatus:     tunnelerStatus,
+		clients:            make(map[string]*connectorClient),
+		ClockSkewThreshold: 30 * time.Second,
 	}
 }
 
 // Connect handles a persistent control-plane stream from connectors.
-func (s *ControlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectServer) error {
-	role, ok := RoleFromContext(stream.Context())
-	if !ok || role != "connector" {
-		return status.Error(codes.PermissionDenied, "connector role required")
+func (s *ControlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectServer) (err error) {
+	spiffeID, _ := SPIFFEIDFromContext(stream.Context())
+	connectorID := s.connectorIDFromSPIFFE(spiffeID)
+	if connectorID == "" {
+		return status.Error(codes.PermissionDenied, "invalid connector identity")
+	}
+	if !s.AcceptLimiter.Allow() {
+		retryAfter := s.AcceptLimiter.RetryAfter()
+		controlPlaneOverloadRejects.Inc()
+		log.Printf("control-plane stream rejected (overload): %s retry_after=%s", spiffeID, retryAfter)
+		s.recordEvent(connectorID, state.EventRejected, "reason=accept_limit")
+		_ = stream.Send(disconnectMessage(DisconnectOverload, "controller overloaded, retry later"))
+		return retryAfterError(codes.Unavailable, "controller overloaded, retry later", retryAfter)
+	}
+	if !s.acquireStream(spiffeID) {
+		retryAfter := jitterRetryAfter(s.StreamRetryAfter)
+		controlPlaneStreamLimitRejects.Inc()
+		log.Printf("control-plane stream rejected (stream limit %d): %s retry_after=%s", s.MaxStreams, spiffeID, retryAfter)
+		s.recordEvent(connectorID, state.EventRejected, "reason=stream_limit")
+		_ = stream.Send(disconnectMessage(DisconnectOverload, "controller stream limit reached, retry later"))
+		return retryAfterError(codes.ResourceExhausted, "controller stream limit reached, retry later", retryAfter)
+	}
+	defer s.activeStreams.Add(-1)
+	s.enableSendCompression(stream.Context())
+	client := newConnectorClient(spiffeID, stream)
+	log.Printf("control-plane stream connected: %s trust_domain=%s", spiffeID, client.trustDomain)
+	controlPlaneStreams.Inc(client.trustDomain)
+	defer controlPlaneStreams.Dec(client.trustDomain)
+	s.recordEvent(connectorID, state.EventConnected, "peer_addr="+client.peerAddr)
+	defer func() { s.recordStreamEnd(connectorID, client, err) }()
+	if prev := s.addClient(spiffeID, client); prev != nil {
+		log.Printf("control-plane stream replaced: %s connected again, closing the previous stream", spiffeID)
+		prev.disconnect(DisconnectDuplicateID, "replaced by a newer stream for the same connector identity")
+	}
+	s.notify(webhook.ConnectorOnline, map[string]string{"connector_id": connectorID, "spiffe_id": spiffeID})
+	defer func() {
+		// A replaced stream leaves the connector online.
+		if s.removeClient(spiffeID, client) {
+			s.notify(webhook.ConnectorOffline, map[string]string{"connector_id": connectorID, "spiffe_id": spiffeID})
+		}
+	}()
+	_ = s.sendAllowlist(client)
+	var resync <-chan time.Time
+	if s.AllowlistResyncInterval > 0 {
+		ticker := time.NewTicker(s.AllowlistResyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
 	}
 
-	spiffeID, _ := SPIFFEIDFromContext(stream.Context())
-	log.Printf("control-plane stream connected: %s", spiffeID)
-	client := &connectorClient{stream: stream}
-	s.addClient(spiffeID, client)
-	defer s.removeClient(spiffeID)
-	s.sendAllowlist(client)
+	// The receive goroutine stops when Connect returns: a message it holds
+	// is dropped via done, and a blocked Recv fails once gRPC cancels the
+	// stream after the handler returns.
+	recvCh := make(chan *controllerpb.ControlMessage)
+	recvErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case recvCh <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
 
+	first := true
 	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
+		var msg *controllerpb.ControlMessage
+		select {
+		case <-client.closed:
+			log.Printf("control-plane stream closed: %s trust_domain=%s reason=%s", spiffeID, client.trustDomain, client.reason)
+			return disconnectError(client.reason, client.message)
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
 			return err
+		case <-resync:
+			_ = s.sendAllowlist(client)
+			continue
+		case msg = <-recvCh:
+		}
+		client.touch(msg.GetType())
+
+		if first && msg.GetType() != "connector_hello" {
+			log.Printf("control-plane stream rejected: %s sent %q before connector_hello", spiffeID, msg.GetType())
+			client.disconnect(DisconnectProtocolMismatch, "expected connector_hello as the first message")
+			continue
 		}
+		first = false
 
+		if _, ok := connectorMessageTypes[msg.GetType()]; !ok {
+			s.handleUnknownMessage(client, spiffeID, msg.GetType())
+			continue
+		}
+		if msg.GetType() == "connector_hello" {
+			s.checkClockSkew(connectorID, msg.GetClientTime())
+			if s.registry != nil {
+				s.registry.RecordVersion(connectorID, msg.GetVersion())
+			}
+			s.checkUpgrade(client, connectorID, msg.GetVersion())
+			s.resumeDrain(client, connectorID)
+		}
+		if msg.GetType() == "allowlist_request" {
+			s.handleAllowlistRequest(client)
+		}
 		if msg.GetType() == "ping" {
-			if err := stream.Send(&controllerpb.ControlMessage{Type: "pong"}); err != nil {
+			if err := s.send(client, &controllerpb.ControlMessage{Type: "pong"}); err != nil {
 				return err
 			}
 		}
 		if msg.GetType() == "heartbeat" {
+			// The payload id must match the authenticated stream identity so a
+			// connector cannot heartbeat on behalf of another.
+			if claimed := msg.GetConnectorId(); claimed != "" && claimed != connectorID {
+				controlPlaneIdentityMismatches.Inc("heartbeat")
+				log.Printf("heartbeat dropped: claimed connector_id=%s does not match %s", claimed, spiffeID)
+				continue
+			}
 			if s.registry != nil {
-				s.registry.RecordHeartbeat(msg.GetConnectorId(), msg.GetPrivateIp())
+				s.registry.RecordHeartbeat(connectorID, msg.GetPrivateIp(), msg.GetListenAddr())
+				s.registry.RecordVersion(connectorID, msg.GetVersion())
+			}
+			s.checkClockSkew(connectorID, msg.GetClientTime())
+			s.checkUpgrade(client, connectorID, msg.GetVersion())
+			s.recordDrainProgress(connectorID, msg)
+			if s.HeartbeatLogSampler.Allow("connector/" + connectorID) {
+				log.Printf("heartbeat: connector_id=%s private_ip=%s status=%s", connectorID, msg.GetPrivateIp(), msg.GetStatus())
 			}
-			log.Printf("heartbeat: connector_id=%s private_ip=%s status=%s", msg.GetConnectorId(), msg.GetPrivateIp(), msg.GetStatus())
 		}
 		if msg.GetType() == "tunneler_heartbeat" && s.tunnelerStatus != nil {
 			var payload struct {
@@ -76,17 +262,184 @@ func (s *ControlPlaneServer) Connect(stream controllerpb.ControlPlane_ConnectSer
 				ConnectorID string `json:"connector_id"`
 			}
 			if err := json.Unmarshal(msg.GetPayload(), &payload); err == nil {
-				s.tunnelerStatus.Record(payload.TunnelerID, payload.SPIFFEID, payload.ConnectorID)
+				// The relaying connector is the stream's authenticated
+				// identity. A payload naming another connector is dropped;
+				// one naming none is attributed to this stream rather than
+				// dropped, so the tunneler's connector is never lost.
+				if payload.ConnectorID != "" && payload.ConnectorID != connectorID {
+					controlPlaneIdentityMismatches.Inc("tunneler_heartbeat")
+					log.Printf("tunneler_heartbeat dropped: claimed connector_id=%s does not match %s", payload.ConnectorID, spiffeID)
+					continue
+				}
+				if payload.SPIFFEID != "" && s.tunnelerIDFromSPIFFE(payload.SPIFFEID) != payload.TunnelerID {
+					controlPlaneIdentityMismatches.Inc("tunneler_heartbeat")
+					log.Printf("tunneler_heartbeat dropped: tunneler_id=%s does not match spiffe_id=%s from %s", payload.TunnelerID, payload.SPIFFEID, spiffeID)
+					continue
+				}
+				s.tunnelerStatus.Record(payload.TunnelerID, payload.SPIFFEID, connectorID)
+				if s.HeartbeatLogSampler.Allow("tunneler/" + payload.TunnelerID) {
+					log.Printf("tunneler_heartbeat: tunneler_id=%s connector_id=%s status=%s", payload.TunnelerID, connectorID, payload.Status)
+				}
 			}
 		}
 	}
 }
 
+// ResolveConnector returns the current tunneler-facing address of a connector
+// so tunnelers can follow connectors whose private IP changes.
+func (s *ControlPlaneServer) ResolveConnector(ctx context.Context, req *controllerpb.ResolveConnectorRequest) (*controllerpb.ResolveConnectorResponse, error) {
+	if !validID(req.GetConnectorId()) {
+		return nil, status.Error(codes.InvalidArgument, "missing connector id")
+	}
+	if s.registry == nil {
+		return nil, status.Error(codes.FailedPrecondition, "connector registry unavailable")
+	}
+	rec, ok := s.registry.Get(req.GetConnectorId())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown connector")
+	}
+	// A draining connector refuses new tunnelers, so stop pointing them at
+	// it.
+	if _, draining := s.registry.Drain(rec.ID); draining {
+		return nil, status.Error(codes.Unavailable, "connector is draining")
+	}
+	addr := connectorDialAddr(rec)
+	if addr == "" {
+		return nil, status.Error(codes.FailedPrecondition, "connector address unknown")
+	}
+	return &controllerpb.ResolveConnectorResponse{Address: addr}, nil
+}
+
+// connectorDialAddr combines the reported listen address with the connector's
+// private IP when the listen host is empty or a wildcard.
+func connectorDialAddr(rec state.ConnectorRecord) string {
+	const defaultPort = "9443"
+	if strings.HasPrefix(rec.ListenAddr, "unix:") {
+		// Only reachable from the connector's host, i.e. by sidecar tunnelers.
+		return rec.ListenAddr
+	}
+	if rec.ListenAddr == "" {
+		if rec.PrivateIP == "" {
+			return ""
+		}
+		return net.JoinHostPort(rec.PrivateIP, defaultPort)
+	}
+	host, port, err := net.SplitHostPort(rec.ListenAddr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if rec.PrivateIP == "" {
+			return ""
+		}
+		host = rec.PrivateIP
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// checkClockSkew compares a connector-reported timestamp with the local clock
+// and records/flags skew beyond ClockSkewThreshold. Large skew is a common root
+// cause of "certificate not yet valid" and "expired" handshake failures.
+func (s *ControlPlaneServer) checkClockSkew(connectorID string, clientTimeMillis int64) {
+	if connectorID == "" || clientTimeMillis == 0 || s.registry == nil {
+		return
+	}
+	skew := time.Since(time.UnixMilli(clientTimeMillis))
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	skewed := s.ClockSkewThreshold > 0 && abs > s.ClockSkewThreshold
+	if !s.registry.RecordClockSkew(connectorID, skew, skewed) {
+		return
+	}
+	if skewed {
+		clockSkewDetections.Inc()
+		clockSkewedConnectors.Inc()
+		log.Printf("clock skew detected: connector_id=%s skew=%s threshold=%s", connectorID, skew.Round(time.Millisecond), s.ClockSkewThreshold)
+	} else {
+		clockSkewedConnectors.Dec()
+		log.Printf("clock skew resolved: connector_id=%s skew=%s", connectorID, skew.Round(time.Millisecond))
+	}
+}
+
+// recordEvent adds to the connector's event history when a registry is set.
+func (s *ControlPlaneServer) recordEvent(connectorID, typ, detail string) {
+	if s.registry != nil {
+		s.registry.RecordEvent(connectorID, typ, detail)
+	}
+}
+
+// recordStreamEnd records why a connector's stream ended: the disconnect
+// reason when the controller closed it, otherwise the stream error. A
+// revoked stream is recorded as its own event type.
+func (s *ControlPlaneServer) recordStreamEnd(connectorID string, client *connectorClient, err error) {
+	select {
+	case <-client.closed:
+		typ := state.EventDisconnected
+		if client.reason == DisconnectRevoked {
+			typ = state.EventRevoked
+		}
+		s.recordEvent(connectorID, typ, "reason="+client.reason)
+		return
+	default:
+	}
+	if err == nil {
+		s.recordEvent(connectorID, state.EventDisconnected, "reason=peer_closed")
+		return
+	}
+	s.recordEvent(connectorID, state.EventDisconnected, "reason=stream_error error="+status.Convert(err).Message())
+}
+
+// acquireStream counts a new stream against MaxStreams and reports whether it
+// is admitted. A connector that already has a stream is admitted at the
+// limit, since its new stream replaces the old one.
+func (s *ControlPlaneServer) acquireStream(spiffeID string) bool {
+	n := s.activeStreams.Add(1)
+	if s.MaxStreams <= 0 || n <= int64(s.MaxStreams) {
+		return true
+	}
+	s.mu.Lock()
+	_, replacing := s.clients[spiffeID]
+	s.mu.Unlock()
+	if replacing {
+		return true
+	}
+	s.activeStreams.Add(-1)
+	return false
+}
+
+// ActiveStreams returns the number of admitted control-plane streams,
+// including streams still being replaced.
+func (s *ControlPlaneServer) ActiveStreams() int {
+	return int(s.activeStreams.Load())
+}
+
+// connectorIDFromSPIFFE returns the connector id from a connector SPIFFE ID in
+// this server's trust domain, or "" if it does not match.
+func (s *ControlPlaneServer) connectorIDFromSPIFFE(spiffeID string) string {
+	id, err := spiffeid.ParseIn(spiffeID, s.trustDomain, "connector")
+	if err != nil {
+		return ""
+	}
+	return id.Name
+}
+
+// tunnelerIDFromSPIFFE is connectorIDFromSPIFFE for tunneler identities.
+func (s *ControlPlaneServer) tunnelerIDFromSPIFFE(spiffeID string) string {
+	id, err := spiffeid.ParseIn(spiffeID, s.trustDomain, "tunneler")
+	if err != nil {
+		return ""
+	}
+	return id.Name
+}
+
 // NotifyTunnelerAllowed broadcasts a newly enrolled tunneler to all connectors.
 func (s *ControlPlaneServer) NotifyTunnelerAllowed(tunnelerID, spiffeID string) {
 	if s.tunnelers != nil {
 		s.tunnelers.Add(tunnelerID, spiffeID)
 	}
+	s.notify(webhook.TunnelerEnrolled, map[string]string{"tunneler_id": tunnelerID, "spiffe_id": spiffeID})
 	info := state.TunnelerInfo{ID: tunnelerID, SPIFFEID: spiffeID}
 	payload, err := json.Marshal(info)
 	if err != nil {
@@ -98,21 +451,143 @@ func (s *ControlPlaneServer) NotifyTunnelerAllowed(tunnelerID, spiffeID string)
 	})
 }
 
+func (s *ControlPlaneServer) notify(eventType string, data map[string]string) {
+	if s.Events != nil {
+		s.Events.Notify(eventType, data)
+	}
+}
+
 type connectorClient struct {
 	stream controllerpb.ControlPlane_ConnectServer
 	sendMu sync.Mutex
+
+	spiffeID    string
+	trustDomain string
+	peerAddr    string
+	connectedAt time.Time
+
+	lastMu          sync.Mutex
+	lastMessageAt   time.Time
+	lastMessageType string
+
+	// upgradeNotified is the TargetVersion already announced on this
+	// stream; only the Connect goroutine uses it.
+	upgradeNotified string
+	// lastAllowlistRequest is when an allowlist_request was last answered;
+	// only the Connect goroutine uses it.
+	lastAllowlistRequest time.Time
+
+	// closed is closed by disconnect; reason and message are set first.
+	closed    chan struct{}
+	closeOnce sync.Once
+	reason    string
+	message   string
 }
 
-func (s *ControlPlaneServer) addClient(id string, c *connectorClient) {
+func newConnectorClient(spiffeID string, stream controllerpb.ControlPlane_ConnectServer) *connectorClient {
+	c := &connectorClient{
+		stream:      stream,
+		spiffeID:    spiffeID,
+		connectedAt: time.Now(),
+		closed:      make(chan struct{}),
+	}
+	c.trustDomain, _ = TrustDomainFromContext(stream.Context())
+	if p, ok := peer.FromContext(stream.Context()); ok && p.Addr != nil {
+		c.peerAddr = p.Addr.String()
+	}
+	return c
+}
+
+// touch records a message received on the stream.
+func (c *connectorClient) touch(msgType string) {
+	c.lastMu.Lock()
+	c.lastMessageAt = time.Now()
+	c.lastMessageType = msgType
+	c.lastMu.Unlock()
+}
+
+// disconnectSendTimeout bounds the wait for the final disconnect message. A
+// peer that stops reading blocks Send once its flow-control window is full;
+// after this long the handler returns anyway, which cancels the stream and
+// fails the pending Send.
+var disconnectSendTimeout = 5 * time.Second
+
+// disconnect sends the final disconnect message and makes the stream's
+// Connect handler return. Only the first call has an effect.
+func (c *connectorClient) disconnect(reason, message string) {
+	c.closeOnce.Do(func() {
+		sent := make(chan struct{})
+		go func() {
+			c.sendMu.Lock()
+			_ = c.stream.Send(disconnectMessage(reason, message))
+			c.sendMu.Unlock()
+			close(sent)
+		}()
+		timer := time.NewTimer(disconnectSendTimeout)
+		select {
+		case <-sent:
+		case <-timer.C:
+			log.Printf("control-plane disconnect message to %s not sent within %s; closing the stream", c.spiffeID, disconnectSendTimeout)
+		}
+		timer.Stop()
+		c.reason, c.message = reason, message
+		close(c.closed)
+	})
+}
+
+// addClient registers c under id and returns the client it replaced, if any.
+func (s *ControlPlaneServer) addClient(id string, c *connectorClient) *connectorClient {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	prev := s.clients[id]
 	s.clients[id] = c
+	return prev
 }
 
-func (s *ControlPlaneServer) removeClient(id string) {
+// removeClient unregisters c and reports whether it was still the current
+// client for id.
+func (s *ControlPlaneServer) removeClient(id string, c *connectorClient) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.clients[id] != c {
+		return false
+	}
 	delete(s.clients, id)
+	return true
+}
+
+// Disconnect closes the stream of the connected connector connectorID with
+// reason and reports whether it was connected.
+func (s *ControlPlaneServer) Disconnect(connectorID, reason, message string) bool {
+	s.mu.Lock()
+	c, ok := s.clients["spiffe://"+s.trustDomain+"/connector/"+connectorID]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.disconnect(reason, message)
+	return true
+}
+
+// DisconnectAll closes every connected stream with reason, e.g. on shutdown.
+func (s *ControlPlaneServer) DisconnectAll(reason, message string) {
+	s.mu.Lock()
+	clients := make([]*connectorClient, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	// In parallel, so streams that do not take the message delay shutdown
+	// by one disconnectSendTimeout in total.
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.disconnect(reason, message)
+		}()
+	}
+	wg.Wait()
 }
 
 func (s *ControlPlaneServer) broadcast(msg *controllerpb.ControlMessage) {
@@ -124,25 +599,72 @@ func (s *ControlPlaneServer) broadcast(msg *controllerpb.ControlMessage) {
 	s.mu.Unlock()
 
 	for _, c := range clients {
-		c.sendMu.Lock()
-		_ = c.stream.Send(msg)
-		c.sendMu.Unlock()
+		_ = s.send(c, msg)
+	}
+}
+
+// PushConfig sends a config_update control message carrying payload to the
+// connected connector connectorID, or to every connected connector when
+// connectorID is empty. It returns the number of connectors sent to.
+func (s *ControlPlaneServer) PushConfig(connectorID string, payload []byte) int {
+	msg := &controllerpb.ControlMessage{Type: "config_update", Payload: payload}
+	if connectorID == "" {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		s.broadcast(msg)
+		return n
+	}
+
+	s.mu.Lock()
+	c, ok := s.clients["spiffe://"+s.trustDomain+"/connector/"+connectorID]
+	s.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	if err := s.send(c, msg); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// RebroadcastAllowlist re-sends the full tunneler allowlist to the connected
+// connector connectorID, or to every connected connector when connectorID is
+// empty, without waiting for the periodic resync. It returns the number of
+// connectors the list was delivered to.
+func (s *ControlPlaneServer) RebroadcastAllowlist(connectorID string) int {
+	s.mu.Lock()
+	var clients []*connectorClient
+	if connectorID == "" {
+		clients = make([]*connectorClient, 0, len(s.clients))
+		for _, c := range s.clients {
+			clients = append(clients, c)
+		}
+	} else if c, ok := s.clients["spiffe://"+s.trustDomain+"/connector/"+connectorID]; ok {
+		clients = []*connectorClient{c}
+	}
+	s.mu.Unlock()
+
+	sent := 0
+	for _, c := range clients {
+		if s.sendAllowlist(c) == nil {
+			sent++
+		}
 	}
+	return sent
 }
 
-func (s *ControlPlaneServer) sendAllowlist(c *connectorClient) {
+func (s *ControlPlaneServer) sendAllowlist(c *connectorClient) error {
 	if s.tunnelers == nil {
-		return
+		return errors.New("no tunneler registry")
 	}
 	list := s.tunnelers.List()
 	payload, err := json.Marshal(list)
 	if err != nil {
-		return
+		return err
 	}
-	c.sendMu.Lock()
-	_ = c.stream.Send(&controllerpb.ControlMessage{
+	return s.send(c, &controllerpb.ControlMessage{
 		Type:    "tunneler_allowlist",
 		Payload: payload,
 	})
-	c.sendMu.Unlock()
 }
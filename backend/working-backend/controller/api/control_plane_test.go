@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+)
+
+// fakeConnectorStream is a connector's control-plane stream as Connect sees
+// it. Send calls sendFn; Recv returns messages from recv and blocks until
+// the context ends otherwise.
+type fakeConnectorStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	recv   chan *controllerpb.ControlMessage
+	sendFn func(*controllerpb.ControlMessage) error
+}
+
+func (f *fakeConnectorStream) Context() context.Context { return f.ctx }
+
+func (f *fakeConnectorStream) Send(msg *controllerpb.ControlMessage) error { return f.sendFn(msg) }
+
+func (f *fakeConnectorStream) Recv() (*controllerpb.ControlMessage, error) {
+	select {
+	case msg := <-f.recv:
+		return msg, nil
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+func TestDisconnectUnreadStream(t *testing.T) {
+	saved := disconnectSendTimeout
+	disconnectSendTimeout = 50 * time.Millisecond
+	defer func() { disconnectSendTimeout = saved }()
+
+	// The peer never reads, so every Send blocks until the stream ends.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeConnectorStream{ctx: ctx, sendFn: func(*controllerpb.ControlMessage) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	s := NewControlPlaneServer(testTrustDomain, nil, nil, nil)
+	for _, id := range []string{"c1", "c2", "c3"} {
+		s.addClient("spiffe://"+testTrustDomain+"/connector/"+id, newConnectorClient(id, stream))
+	}
+
+	start := time.Now()
+	s.DisconnectAll(DisconnectShutdown, "stopping")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("DisconnectAll took %s with unread streams", elapsed)
+	}
+	for _, c := range s.clients {
+		select {
+		case <-c.closed:
+		default:
+			t.Fatalf("stream %s left open", c.spiffeID)
+		}
+	}
+}
+
+func TestConnectStopsReceiverOnReturn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	spiffeID := "spiffe://" + testTrustDomain + "/connector/c1"
+	stream := &fakeConnectorStream{
+		ctx:  verifiedPeer{spiffeID: spiffeID, role: "connector", trustDomain: testTrustDomain}.withContext(ctx),
+		recv: make(chan *controllerpb.ControlMessage),
+		sendFn: func(msg *controllerpb.ControlMessage) error {
+			if msg.GetType() == "pong" {
+				return errors.New("connection reset")
+			}
+			return nil
+		},
+	}
+	s := NewControlPlaneServer(testTrustDomain, nil, nil, nil)
+	before := runtime.NumGoroutine()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Connect(stream) }()
+	stream.recv <- &controllerpb.ControlMessage{Type: "connector_hello"}
+	stream.recv <- &controllerpb.ControlMessage{Type: "ping"}
+	// The receiver already holds this message when Connect fails on the pong.
+	stream.recv <- &controllerpb.ControlMessage{Type: "heartbeat"}
+	if err := <-done; err == nil {
+		t.Fatal("Connect returned nil after a failed send")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after Connect returned", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/metrics"
+)
+
+// DefaultDeadLetterMaxBytes bounds the dead-letter file when no size is
+// configured.
+const DefaultDeadLetterMaxBytes = 10 << 20
+
+var controlMessagesDropped = metrics.NewCounterVec(
+	"controller_control_messages_dropped_total",
+	"Control messages the controller failed to deliver to a connector, by message type.",
+	"type",
+)
+
+// deadLetter is one line of the dead-letter file.
+type deadLetter struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Target string    `json:"target"`
+	Reason string    `json:"reason"`
+}
+
+// DeadLetterLog appends control messages that could not be delivered to a
+// JSON-lines file, so message loss (e.g. a tunneler_allow a connector never
+// received) can be confirmed after the fact. When the file would grow past
+// maxBytes it is renamed to <path>.1, replacing the previous generation,
+// and a new file is started. A nil log records nothing.
+type DeadLetterLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	f        *os.File
+	size     int64
+}
+
+// NewDeadLetterLog opens path for appending. It returns nil when path is
+// empty; a non-positive maxBytes uses DefaultDeadLetterMaxBytes.
+func NewDeadLetterLog(path string, maxBytes int64) (*DeadLetterLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultDeadLetterMaxBytes
+	}
+	l := &DeadLetterLog{path: path, maxBytes: maxBytes}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *DeadLetterLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// Record appends a dropped message of msgType for target.
+func (l *DeadLetterLog) Record(msgType, target, reason string) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(deadLetter{Time: time.Now().UTC(), Type: msgType, Target: target, Reason: reason})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			log.Printf("dead-letter log: rotate %s: %v", l.path, err)
+		}
+	}
+	if l.f == nil {
+		return
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("dead-letter log: write %s: %v", l.path, err)
+	}
+}
+
+func (l *DeadLetterLog) rotate() error {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the file.
+func (l *DeadLetterLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// send delivers msg on c's stream. A failure is counted and recorded in the
+// dead-letter log rather than dropped silently.
+func (s *ControlPlaneServer) send(c *connectorClient, msg *controllerpb.ControlMessage) error {
+	c.sendMu.Lock()
+	err := c.stream.Send(msg)
+	c.sendMu.Unlock()
+	if err != nil {
+		s.dropped(msg.GetType(), c.spiffeID, fmt.Sprintf("send failed: %v", err))
+	}
+	return err
+}
+
+// dropped accounts for a control message that was not delivered to target.
+func (s *ControlPlaneServer) dropped(msgType, target, reason string) {
+	controlMessagesDropped.Inc(msgType)
+	s.DeadLetters.Record(msgType, target, reason)
+}
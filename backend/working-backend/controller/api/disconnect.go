@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reason codes carried by the final "disconnect" control message sent before
+// a control-plane stream is closed. revoked and protocol_mismatch are
+// terminal: the peer should stop reconnecting.
+const (
+	DisconnectShutdown         = "shutdown"
+	DisconnectDuplicateID      = "duplicate_id"
+	DisconnectRevoked          = "revoked"
+	DisconnectOverload         = "overload"
+	DisconnectProtocolMismatch = "protocol_mismatch"
+	DisconnectAdminClose       = "admin_close"
+)
+
+var disconnectCodes = map[string]codes.Code{
+	DisconnectShutdown:         codes.Unavailable,
+	DisconnectDuplicateID:      codes.Aborted,
+	DisconnectRevoked:          codes.PermissionDenied,
+	DisconnectOverload:         codes.Unavailable,
+	DisconnectProtocolMismatch: codes.FailedPrecondition,
+	DisconnectAdminClose:       codes.Unavailable,
+}
+
+// disconnectMessage builds the final control message for reason.
+func disconnectMessage(reason, message string) *controllerpb.ControlMessage {
+	payload, _ := json.Marshal(map[string]string{"reason": reason, "message": message})
+	return &controllerpb.ControlMessage{Type: "disconnect", Payload: payload}
+}
+
+// disconnectError is the stream status matching a disconnect message, for
+// peers that stop reading before they see the message.
+func disconnectError(reason, message string) error {
+	code, ok := disconnectCodes[reason]
+	if !ok {
+		code = codes.Unavailable
+	}
+	st, err := status.New(code, message).WithDetails(&errdetails.ErrorInfo{
+		Reason: strings.ToUpper(reason),
+		Domain: "controller",
+	})
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
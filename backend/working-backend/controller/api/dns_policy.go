@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseDNSSuffixes parses a comma-separated ALLOWED_DNS_SUFFIXES value.
+func ParseDNSSuffixes(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		part = strings.Trim(part, ".")
+		if part == "" {
+			continue
+		}
+		out = append(out, part)
+	}
+	return out
+}
+
+// validateDNSNames checks requested DNS SANs against the allowed-suffix policy.
+// With no suffixes configured, DNS SANs are rejected entirely.
+func validateDNSNames(names, allowedSuffixes []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if len(names) > maxDNSNames {
+		return nil, fmt.Errorf("too many dns names")
+	}
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+		if !validDNSName(name) {
+			return nil, fmt.Errorf("invalid dns name %q", name)
+		}
+		if !dnsNameAllowed(name, allowedSuffixes) {
+			return nil, fmt.Errorf("dns name %q not permitted by policy", name)
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+func dnsNameAllowed(name string, allowedSuffixes []string) bool {
+	for _, suffix := range allowedSuffixes {
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func validDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+				continue
+			}
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+	"controller/webhook"
+)
+
+// HeartbeatStatusDraining is the heartbeat status of a draining connector.
+// Its payload is a drainReport.
+const HeartbeatStatusDraining = "DRAINING"
+
+// drainNotice is the payload of a "drain" control message.
+type drainNotice struct {
+	Drain bool `json:"drain"`
+}
+
+// drainReport is the heartbeat payload of a draining connector.
+type drainReport struct {
+	Tunnelers int `json:"tunnelers"`
+}
+
+// SendDrain tells the connected connector connectorID to start (drain) or
+// stop refusing new tunnelers. It reports whether the connector was
+// connected; one that is not learns of a drain when it next connects.
+func (s *ControlPlaneServer) SendDrain(connectorID string, drain bool) bool {
+	s.mu.Lock()
+	c, ok := s.clients["spiffe://"+s.trustDomain+"/connector/"+connectorID]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	return s.sendDrain(c, drain) == nil
+}
+
+func (s *ControlPlaneServer) sendDrain(c *connectorClient, drain bool) error {
+	payload, err := json.Marshal(drainNotice{Drain: drain})
+	if err != nil {
+		return err
+	}
+	return s.send(c, &controllerpb.ControlMessage{Type: "drain", Payload: payload})
+}
+
+// resumeDrain re-sends a pending drain to a connector that reconnected, so
+// a restart does not end the drain.
+func (s *ControlPlaneServer) resumeDrain(c *connectorClient, connectorID string) {
+	if s.registry == nil {
+		return
+	}
+	if _, ok := s.registry.Drain(connectorID); !ok {
+		return
+	}
+	if err := s.sendDrain(c, true); err == nil {
+		log.Printf("drain resumed: connector_id=%s", connectorID)
+	}
+}
+
+// recordDrainProgress applies the tunneler count in a draining connector's
+// heartbeat.
+func (s *ControlPlaneServer) recordDrainProgress(connectorID string, msg *controllerpb.ControlMessage) {
+	if s.registry == nil || msg.GetStatus() != HeartbeatStatusDraining {
+		return
+	}
+	var report drainReport
+	if err := json.Unmarshal(msg.GetPayload(), &report); err != nil || report.Tunnelers < 0 {
+		log.Printf("drain report ignored: connector_id=%s invalid payload", connectorID)
+		return
+	}
+	if !s.registry.RecordDrainProgress(connectorID, report.Tunnelers) {
+		return
+	}
+	log.Printf("connector drained: connector_id=%s has no tunnelers left", connectorID)
+	s.registry.RecordEvent(connectorID, state.EventDrained, "")
+	s.notify(webhook.ConnectorDrained, map[string]string{"connector_id": connectorID})
+}
@@ -2,25 +2,26 @@ package api
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-	"strings"
 	"time"
 
 	controllerpb "controller/gen/controllerpb"
 
 	"controller/ca"
+	"controller/spiffeid"
 	"controller/state"
+	"controller/webhook"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -34,12 +35,80 @@ type EnrollmentServer struct {
 	Tokens      *state.TokenStore
 	Registry    *state.Registry
 	Notifier    TunnelerNotifier
+
+	// AllowedDNSSuffixes limits the DNS SANs a connector may request.
+	AllowedDNSSuffixes []string
+	// Events receives lifecycle events (e.g. token_consumed); may be nil.
+	Events EventNotifier
+	// EnrollMode selects connector enrollment authorization: "token"
+	// (default), "approval", where an operator approves each request, or
+	// "attest", where Attestor verifies the connector's platform identity.
+	EnrollMode string
+	// Attestor checks connector attestations in "attest" mode; nil uses
+	// NoopAttestor.
+	Attestor Attestor
+	// AttestTokenOptional lets an attested connector enroll without a token
+	// in "attest" mode. A token that is sent is still checked.
+	AttestTokenOptional bool
+	// EnrollAuth selects the credentials connector enrollment requires:
+	// "token" (default), "bootstrap_cert", a client certificate issued by
+	// BootstrapCAs, or "both". A bootstrap certificate is checked in every
+	// EnrollMode; the token only replaces the default token check.
+	EnrollAuth   string
+	BootstrapCAs *x509.CertPool
+	// Pending holds connector requests awaiting approval in "approval" mode.
+	Pending *state.PendingStore
+	// TunnelerPreRegistry lists tunneler ids an admin has approved;
+	// EnrollTunneler refuses any other id.
+	TunnelerPreRegistry *state.TunnelerPreRegistry
+	// EnforceKeyRotation rejects renewals that present the same public key
+	// as the certificate previously issued to that SPIFFE id.
+	EnforceKeyRotation bool
+	// RenewSoftLimit refuses renewals for identities whose issuance rate
+	// is already anomalous; see recordIssuance.
+	RenewSoftLimit bool
+	// RenewAgents maps an agent SPIFFE id to the ids of its own role it may
+	// renew through BatchRenew; "*" allows every id of that role.
+	RenewAgents map[string][]string
+	// EnrollmentQuota caps new enrollments across all tokens; renewals are
+	// not counted. Nil disables the cap.
+	EnrollmentQuota *state.EnrollmentQuota
+	// EnrollReplays returns the earlier certificate to an identical retry
+	// of a successful enrollment instead of rejecting its spent token. Nil
+	// disables deduplication.
+	EnrollReplays *state.EnrollReplayCache
+	// ExtraSANs allows additional URI and email SANs in enrollment and
+	// renewal requests; nil rejects them.
+	ExtraSANs *ExtraSANPolicy
+	// SignResponses adds a CA signature over the server time, echoed nonce
+	// and certificate to every EnrollResponse.
+	SignResponses bool
+	// AllowedKeyAlgorithms restricts the public keys enrollment and renewal
+	// accept; nil accepts every supported algorithm.
+	AllowedKeyAlgorithms KeyAlgorithms
+	// OptionalPrivateIP lets connectors enroll without a private IP. Their
+	// certificates then carry no IP SAN, so tunnelers must verify them by
+	// SPIFFE id alone.
+	OptionalPrivateIP bool
 }
 
+// Enrollment modes for EnrollmentServer.EnrollMode.
+const (
+	EnrollModeToken    = "token"
+	EnrollModeApproval = "approval"
+	EnrollModeAttest   = "attest"
+)
+
 type TunnelerNotifier interface {
 	NotifyTunnelerAllowed(tunnelerID, spiffeID string)
 }
 
+// EventNotifier receives best-effort lifecycle events such as webhooks.
+// Implementations must not block.
+type EventNotifier interface {
+	Notify(eventType string, data map[string]string)
+}
+
 // NewEnrollmentServer creates a new EnrollmentServer.
 func NewEnrollmentServer(caInst *ca.CA, caPEM []byte, trustDomain string, tokens *state.TokenStore, registry *state.Registry, notifier TunnelerNotifier) *EnrollmentServer {
 	return &EnrollmentServer{
@@ -58,25 +127,70 @@ func (s *EnrollmentServer) EnrollConnector(
 	req *controllerpb.EnrollRequest,
 ) (*controllerpb.EnrollResponse, error) {
 
-	if !validID(req.GetId()) {
-		return nil, status.Error(codes.InvalidArgument, "missing connector id")
+	if err := s.validateEnrollRequest(req, "connector", false); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := s.parseEnrollKey("enroll-connector", req.GetPublicKey())
+	if err != nil {
+		return nil, err
 	}
-	if req.GetPrivateIp() == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing private ip")
+
+	dnsNames, err := validateDNSNames(req.GetDnsNames(), s.AllowedDNSSuffixes)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
 	}
-	if req.GetVersion() == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing version")
+	extraURIs, emails, err := validateExtraSANs(req, s.ExtraSANs)
+	if err != nil {
+		return nil, err
 	}
 
-	pubKey, err := parsePublicKey(req.GetPublicKey())
+	replayKey, replay, replayDone, err := s.replayEnrollment(ctx, "connector", req)
+	defer replayDone()
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+		return nil, err
+	}
+	if replay != nil {
+		return s.stampResponse(req, replay)
 	}
-	logPublicKey("enroll-connector", pubKey, req.GetPublicKey())
 
-	if err := s.authorizeConnectorToken(req.GetToken(), req.GetId()); err != nil {
+	// Reserve before authorizing so a refused enrollment does not burn its
+	// token; the slot is returned if the enrollment fails.
+	if err := s.reserveEnrollment("connector", req.GetId()); err != nil {
 		return nil, err
 	}
+	issued := false
+	defer func() {
+		if !issued {
+			s.EnrollmentQuota.Release()
+		}
+	}()
+
+	// The certificate is checked first so a refused one does not burn the
+	// token.
+	if s.bootstrapCertRequired() {
+		if err := s.authorizeBootstrapCert(ctx, req.GetId()); err != nil {
+			return nil, err
+		}
+	}
+	switch s.EnrollMode {
+	case EnrollModeApproval:
+		if err := s.authorizeConnectorApproval(ctx, req); err != nil {
+			return nil, err
+		}
+	case EnrollModeAttest:
+		if err := s.authorizeConnectorAttestation(ctx, req); err != nil {
+			return nil, err
+		}
+	default:
+		// With bootstrap_cert alone a token is optional, but one that is
+		// sent is still checked.
+		if s.EnrollAuth != EnrollAuthBootstrapCert || req.GetToken() != "" {
+			if err := s.authorizeToken(ctx, state.TokenKindConnector, req); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	spiffeID := fmt.Sprintf(
 		"spiffe://%s/connector/%s",
@@ -88,29 +202,38 @@ func (s *EnrollmentServer) EnrollConnector(
 		ipAddrs = []net.IP{ip}
 	}
 
-	certPEM, err := ca.IssueWorkloadCert(
+	leaf, err := ca.Issue(
 		s.CA,
 		spiffeID,
 		pubKey,
 		5*time.Minute,
-		nil,
-		ipAddrs,
+		ca.SANs{DNSNames: dnsNames, IPAddresses: ipAddrs, URIs: extraURIs, EmailAddresses: emails},
 	)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "certificate issuance failed: %v", err)
 	}
-	logIssuedCert("enroll-connector", spiffeID, certPEM)
+	issued = true
+	s.EnrollReplays.Store(replayKey, leaf.PEM)
+	logIssuedCert("enroll-connector", spiffeID, leaf)
+	s.recordKeyFingerprint(spiffeID, req.GetPublicKey())
+	s.recordIssuance("enroll", "connector", s.TrustDomain, spiffeID, 5*time.Minute)
 
 	// Registration side-effect: log enrollment details.
-	logEnrollment("connector", req.GetId(), req.GetPrivateIp(), req.GetVersion())
+	logEnrollment("connector", req.GetId(), s.TrustDomain, req.GetPrivateIp(), req.GetVersion(), req.GetMetadata())
 	if s.Registry != nil {
 		s.Registry.Register(req.GetId(), req.GetPrivateIp(), req.GetVersion())
+		s.Registry.SetDNSNames(req.GetId(), dnsNames)
+		s.Registry.SetProvisioning(req.GetId(), provisioningOf(req.GetMetadata()))
+		s.Registry.RecordEvent(req.GetId(), state.EventEnrolled, fmt.Sprintf("private_ip=%s version=%s serial=%s", req.GetPrivateIp(), req.GetVersion(), leaf.Serial))
+	}
+	if s.EnrollMode == EnrollModeApproval && s.Pending != nil {
+		s.Pending.Complete(req.GetId())
 	}
 
-	return &controllerpb.EnrollResponse{
-		Certificate:   certPEM,
+	return s.stampResponse(req, &controllerpb.EnrollResponse{
+		Certificate:   leaf.PEM,
 		CaCertificate: s.CAPEM,
-	}, nil
+	})
 }
 
 // EnrollTunneler enrolls a tunneler and issues a short-lived certificate.
@@ -119,20 +242,43 @@ func (s *EnrollmentServer) EnrollTunneler(
 	req *controllerpb.EnrollRequest,
 ) (*controllerpb.EnrollResponse, error) {
 
-	if !validID(req.GetId()) {
-		return nil, status.Error(codes.InvalidArgument, "missing tunneler id")
-	}
-	if req.GetToken() == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing enrollment token")
+	if err := s.validateEnrollRequest(req, "tunneler", false); err != nil {
+		return nil, err
 	}
 
-	pubKey, err := parsePublicKey(req.GetPublicKey())
+	pubKey, err := s.parseEnrollKey("enroll-tunneler", req.GetPublicKey())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+		return nil, err
+	}
+	extraURIs, emails, err := validateExtraSANs(req, s.ExtraSANs)
+	if err != nil {
+		return nil, err
 	}
-	logPublicKey("enroll-tunneler", pubKey, req.GetPublicKey())
 
-	if err := s.authorizeConnectorToken(req.GetToken(), req.GetId()); err != nil {
+	// Tunnelers are enrolled only under ids an admin pre-registered, so a
+	// token holder cannot mint certificates for arbitrary tunneler ids.
+	if !s.tunnelerPreRegistered(req.GetId()) {
+		log.Printf("enroll-tunneler rejected: id=%s is not pre-registered", req.GetId())
+		return nil, status.Error(codes.PermissionDenied, "tunneler id is not pre-registered")
+	}
+	replayKey, replay, replayDone, err := s.replayEnrollment(ctx, "tunneler", req)
+	defer replayDone()
+	if err != nil {
+		return nil, err
+	}
+	if replay != nil {
+		return s.stampResponse(req, replay)
+	}
+	if err := s.reserveEnrollment("tunneler", req.GetId()); err != nil {
+		return nil, err
+	}
+	issued := false
+	defer func() {
+		if !issued {
+			s.EnrollmentQuota.Release()
+		}
+	}()
+	if err := s.authorizeToken(ctx, state.TokenKindTunneler, req); err != nil {
 		return nil, err
 	}
 
@@ -142,26 +288,29 @@ func (s *EnrollmentServer) EnrollTunneler(
 		req.GetId(),
 	)
 
-	certPEM, err := ca.IssueWorkloadCert(
+	leaf, err := ca.Issue(
 		s.CA,
 		spiffeID,
 		pubKey,
 		30*time.Minute,
-		nil,
-		nil,
+		ca.SANs{URIs: extraURIs, EmailAddresses: emails},
 	)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "certificate issuance failed: %v", err)
 	}
-	logIssuedCert("enroll-tunneler", spiffeID, certPEM)
+	issued = true
+	s.EnrollReplays.Store(replayKey, leaf.PEM)
+	logIssuedCert("enroll-tunneler", spiffeID, leaf)
+	s.recordKeyFingerprint(spiffeID, req.GetPublicKey())
+	s.recordIssuance("enroll", "tunneler", s.TrustDomain, spiffeID, 30*time.Minute)
 	if s.Notifier != nil {
 		s.Notifier.NotifyTunnelerAllowed(req.GetId(), spiffeID)
 	}
 
-	return &controllerpb.EnrollResponse{
-		Certificate:   certPEM,
+	return s.stampResponse(req, &controllerpb.EnrollResponse{
+		Certificate:   leaf.PEM,
 		CaCertificate: s.CAPEM,
-	}, nil
+	})
 }
 
 // Renew re-issues a certificate for an existing workload based on its SPIFFE identity.
@@ -170,15 +319,14 @@ func (s *EnrollmentServer) Renew(
 	req *controllerpb.EnrollRequest,
 ) (*controllerpb.EnrollResponse, error) {
 
-	if !validID(req.GetId()) {
-		return nil, status.Error(codes.InvalidArgument, "missing id")
+	if err := s.validateEnrollRequest(req, "", true); err != nil {
+		return nil, err
 	}
 
-	pubKey, err := parsePublicKey(req.GetPublicKey())
+	pubKey, err := s.parseEnrollKey("renew", req.GetPublicKey())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+		return nil, err
 	}
-	logPublicKey("renew", pubKey, req.GetPublicKey())
 
 	role, id, err := s.identityFromContext(ctx)
 	if err != nil {
@@ -187,32 +335,83 @@ func (s *EnrollmentServer) Renew(
 	if id != req.GetId() {
 		return nil, status.Error(codes.PermissionDenied, "id mismatch for renewal")
 	}
+	return s.renew(role, s.peerTrustDomain(ctx), req, pubKey)
+}
 
+// renew issues a fresh certificate for the already authorized identity
+// role/req.Id, applying the per-identity renewal policies. trustDomain is
+// the domain the caller was verified in and labels the issuance.
+func (s *EnrollmentServer) renew(role, trustDomain string, req *controllerpb.EnrollRequest, pubKey interface{}) (*controllerpb.EnrollResponse, error) {
+	extraURIs, emails, err := validateExtraSANs(req, s.ExtraSANs)
+	if err != nil {
+		return nil, err
+	}
 	spiffeID := fmt.Sprintf("spiffe://%s/%s/%s", s.TrustDomain, role, req.GetId())
 
+	if s.EnforceKeyRotation && s.Registry != nil {
+		if prev, ok := s.Registry.KeyFingerprint(spiffeID); ok && prev == publicKeyFingerprint(req.GetPublicKey()) {
+			log.Printf("renew rejected: spiffe_id=%s reused previous public key", spiffeID)
+			return nil, status.Error(codes.InvalidArgument, "renewal must use a new public key")
+		}
+	}
+
+	if err := s.checkRenewalRate(spiffeID); err != nil {
+		return nil, err
+	}
+
 	ttl := 30 * time.Minute
 	if role == "connector" {
 		ttl = 5 * time.Minute
 	}
 	var ipAddrs []net.IP
+	var dnsNames []string
 	if role == "connector" && s.Registry != nil {
 		if rec, ok := s.Registry.Get(req.GetId()); ok {
 			if ip := net.ParseIP(rec.PrivateIP); ip != nil {
 				ipAddrs = []net.IP{ip}
 			}
+			dnsNames = rec.DNSNames
 		}
 	}
 
-	certPEM, err := ca.IssueWorkloadCert(s.CA, spiffeID, pubKey, ttl, nil, ipAddrs)
+	leaf, err := ca.Issue(s.CA, spiffeID, pubKey, ttl, ca.SANs{
+		DNSNames:       dnsNames,
+		IPAddresses:    ipAddrs,
+		URIs:           extraURIs,
+		EmailAddresses: emails,
+	})
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "certificate renewal failed: %v", err)
 	}
-	logIssuedCert("renew", spiffeID, certPEM)
+	logIssuedCert("renew", spiffeID, leaf)
+	s.recordKeyFingerprint(spiffeID, req.GetPublicKey())
+	s.recordIssuance("renew", role, trustDomain, spiffeID, ttl)
+	if role == "connector" && s.Registry != nil {
+		s.Registry.RecordEvent(req.GetId(), state.EventRenewed, fmt.Sprintf("serial=%s not_after=%s", leaf.Serial, leaf.NotAfter.Format(time.RFC3339)))
+	}
 
-	return &controllerpb.EnrollResponse{
-		Certificate:   certPEM,
+	return s.stampResponse(req, &controllerpb.EnrollResponse{
+		Certificate:   leaf.PEM,
 		CaCertificate: s.CAPEM,
-	}, nil
+	})
+}
+
+// parseEnrollKey parses and logs a requested public key and applies the key
+// policies. Enrollment and renewal share it.
+func (s *EnrollmentServer) parseEnrollKey(scope string, pemBytes []byte) (interface{}, error) {
+	pubKey, err := parsePublicKey(pemBytes)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
+	}
+	logPublicKey(scope, pubKey, pemBytes)
+	algo, bits := publicKeyParams(pubKey)
+	if perr := checkPublicKey(algo, bits); perr != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %s", perr.reason)
+	}
+	if perr := s.AllowedKeyAlgorithms.check(algo, bits); perr != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %s", perr.reason)
+	}
+	return pubKey, nil
 }
 
 // parsePublicKey parses a PEM-encoded public key.
@@ -234,6 +433,26 @@ func parsePublicKey(pemBytes []byte) (interface{}, error) {
 	return pub, nil
 }
 
+// recordKeyFingerprint remembers the key issued to spiffeID so
+// EnforceKeyRotation can detect reuse on the next renewal.
+func (s *EnrollmentServer) recordKeyFingerprint(spiffeID string, pemBytes []byte) {
+	if s.Registry == nil {
+		return
+	}
+	s.Registry.SetKeyFingerprint(spiffeID, publicKeyFingerprint(pemBytes))
+}
+
+// publicKeyFingerprint returns the hex SHA-256 of the DER-encoded public key,
+// so re-encodings of the same PEM compare equal.
+func publicKeyFingerprint(pemBytes []byte) string {
+	der := pemBytes
+	if block, _ := pem.Decode(pemBytes); block != nil {
+		der = block.Bytes
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
 func (s *EnrollmentServer) authorize(ctx context.Context, expectedRole, expectedID string) error {
 	role, id, err := s.identityFromContext(ctx)
 	if err != nil {
@@ -248,16 +467,78 @@ func (s *EnrollmentServer) authorize(ctx context.Context, expectedRole, expected
 	return nil
 }
 
-func (s *EnrollmentServer) authorizeConnectorToken(token, connectorID string) error {
+// authorizeToken consumes the request's enrollment token, which must have
+// been created for kind. A token bound to a network is checked against the
+// claimed private IP rather than the peer address, which NAT may rewrite;
+// the claimed IP is what the certificate's IP SAN carries.
+func (s *EnrollmentServer) authorizeToken(ctx context.Context, kind string, req *controllerpb.EnrollRequest) error {
 	if s.Tokens == nil {
 		return status.Error(codes.FailedPrecondition, "token service unavailable")
 	}
-	if err := s.Tokens.ConsumeToken(token, connectorID); err != nil {
+	id := req.GetId()
+	if err := s.Tokens.ConsumeToken(req.GetToken(), kind, id, req.GetPrivateIp()); err != nil {
+		peerAddr := ""
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			peerAddr = p.Addr.String()
+		}
+		var binding *state.TokenBindingError
+		if errors.As(err, &binding) {
+			log.Printf("enrollment token binding rejected: id=%s private_ip=%q peer=%s bound=%s", id, req.GetPrivateIp(), peerAddr, binding.BoundCIDR)
+			return status.Error(codes.PermissionDenied, "enrollment token is not valid for this private IP")
+		}
+		var wrongKind *state.TokenKindError
+		if errors.As(err, &wrongKind) {
+			log.Printf("enrollment token kind rejected: id=%s peer=%s token_kind=%s role=%s", id, peerAddr, wrongKind.Kind, kind)
+		}
 		return status.Error(codes.PermissionDenied, "invalid enrollment token")
 	}
+	if s.Events != nil {
+		s.Events.Notify(webhook.TokenConsumed, map[string]string{"id": id, "kind": kind})
+	}
+	return nil
+}
+
+// authorizeConnectorApproval records the request in the pending queue and
+// only lets it through once an operator has approved this id and public key.
+func (s *EnrollmentServer) authorizeConnectorApproval(ctx context.Context, req *controllerpb.EnrollRequest) error {
+	if s.Pending == nil {
+		return status.Error(codes.FailedPrecondition, "approval queue unavailable")
+	}
+	peerAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		peerAddr = p.Addr.String()
+	}
+	approved, err := s.Pending.Check(state.PendingEnrollment{
+		ID:           req.GetId(),
+		PublicKeySHA: publicKeyFingerprint(req.GetPublicKey()),
+		PrivateIP:    req.GetPrivateIp(),
+		Version:      req.GetVersion(),
+		PeerAddr:     peerAddr,
+	})
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "enrollment rejected: %v", err)
+	}
+	if !approved {
+		log.Printf("enrollment pending approval: id=%s peer=%s", req.GetId(), peerAddr)
+		return pendingApprovalError()
+	}
+	log.Printf("enrollment approved: id=%s peer=%s", req.GetId(), peerAddr)
 	return nil
 }
 
+// pendingApprovalError is returned while a connector awaits operator approval.
+// Clients detect it via the PENDING_APPROVAL ErrorInfo reason and retry.
+func pendingApprovalError() error {
+	st := status.New(codes.Unavailable, "pending approval")
+	if withDetails, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: "PENDING_APPROVAL",
+		Domain: "controller",
+	}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
 func (s *EnrollmentServer) identityFromContext(ctx context.Context) (string, string, error) {
 	spiffeID, ok := SPIFFEIDFromContext(ctx)
 	if !ok {
@@ -269,62 +550,50 @@ func (s *EnrollmentServer) identityFromContext(ctx context.Context) (string, str
 		return "", "", status.Error(codes.Unauthenticated, "missing SPIFFE role")
 	}
 
-	id := strings.TrimPrefix(spiffeID, fmt.Sprintf("spiffe://%s/%s/", s.TrustDomain, role))
-	if id == "" || strings.Contains(id, "/") {
+	id, err := spiffeid.ParseIn(spiffeID, s.peerTrustDomain(ctx), role)
+	if err != nil {
 		return "", "", status.Error(codes.Unauthenticated, "invalid SPIFFE id")
 	}
 
-	return role, id, nil
+	return role, id.Name, nil
 }
 
-func logEnrollment(role, id, privateIP, version string) {
+// peerTrustDomain returns the trust domain the caller's SPIFFE ID was
+// verified against, or the controller's own domain for unauthenticated
+// calls.
+func (s *EnrollmentServer) peerTrustDomain(ctx context.Context) string {
+	if td, ok := TrustDomainFromContext(ctx); ok {
+		return td
+	}
+	return s.TrustDomain
+}
+
+func logEnrollment(role, id, trustDomain, privateIP, version string, md *controllerpb.EnrollMetadata) {
 	// Keep as a structured line to aid operator log parsing.
-	fmt.Printf("enrollment: role=%s id=%s private_ip=%s version=%s\n", role, id, privateIP, version)
+	fmt.Printf("enrollment: role=%s id=%s trust_domain=%s private_ip=%s version=%s%s\n", role, id, trustDomain, privateIP, version, metadataLogFields(md))
 }
 
 func logPublicKey(scope string, pubKey interface{}, rawPEM []byte) {
-	algo := "unknown"
-	bits := 0
-	switch k := pubKey.(type) {
-	case *rsa.PublicKey:
-		algo = "rsa"
-		bits = k.N.BitLen()
-	case *ecdsa.PublicKey:
-		algo = "ecdsa"
-		if k.Curve == elliptic.P256() {
-			bits = 256
-		} else if k.Curve == elliptic.P384() {
-			bits = 384
-		} else if k.Curve == elliptic.P521() {
-			bits = 521
-		}
-	}
+	algo, bits := publicKeyParams(pubKey)
 	fp := sha256.Sum256(rawPEM)
 	log.Printf("%s public_key: alg=%s bits=%d sha256=%s", scope, algo, bits, hex.EncodeToString(fp[:8]))
 }
 
-func logIssuedCert(scope, spiffeID string, certPEM []byte) {
-	block, _ := pem.Decode(certPEM)
-	if block == nil || block.Type != "CERTIFICATE" {
-		log.Printf("%s issued_cert: spiffe=%s parse_error=invalid_pem", scope, spiffeID)
-		return
-	}
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		log.Printf("%s issued_cert: spiffe=%s parse_error=%v", scope, spiffeID, err)
-		return
-	}
+func logIssuedCert(scope, spiffeID string, leaf ca.Issued) {
 	log.Printf(
 		"%s issued_cert: spiffe=%s serial=%s not_after=%s",
 		scope,
 		spiffeID,
-		cert.SerialNumber.String(),
-		cert.NotAfter.Format(time.RFC3339),
+		leaf.Serial.String(),
+		leaf.NotAfter.Format(time.RFC3339),
 	)
 }
 
+// maxIDLength bounds connector and tunneler ids.
+const maxIDLength = 128
+
 func validID(id string) bool {
-	if id == "" || len(id) > 128 {
+	if id == "" || len(id) > maxIDLength {
 		return false
 	}
 	for _, r := range id {
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"time"
+
+	"controller/ca"
+	"controller/enrollresponse"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxEnrollNonce bounds the client nonce echoed back in EnrollResponse.
+const maxEnrollNonce = 64
+
+// stampResponse adds the server time and echoed nonce to resp, and the CA
+// signature over them when SignResponses is set, so clients can reject
+// stale or replayed responses.
+func (s *EnrollmentServer) stampResponse(req *controllerpb.EnrollRequest, resp *controllerpb.EnrollResponse) (*controllerpb.EnrollResponse, error) {
+	resp.ServerTime = time.Now().UnixMilli()
+	resp.Nonce = req.GetNonce()
+	if !s.SignResponses {
+		return resp, nil
+	}
+	sig, err := ca.SignBlob(s.CA, enrollresponse.Payload(resp.Nonce, resp.ServerTime, resp.Certificate))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "sign enrollment response: %v", err)
+	}
+	resp.Signature = sig
+	return resp, nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxMetadataFieldLength bounds each EnrollMetadata field.
+const maxMetadataFieldLength = 128
+
+// validateEnrollMetadata checks the self-reported provisioning metadata of an
+// enrollment request. The values are informational, so only their size and
+// character set are enforced: printable ASCII without spaces, which keeps
+// them safe to log on a single key=value line.
+func validateEnrollMetadata(md *controllerpb.EnrollMetadata) error {
+	fields := []struct{ name, value string }{
+		{"provider", md.GetProvider()},
+		{"instance_id", md.GetInstanceId()},
+		{"region", md.GetRegion()},
+	}
+	for _, f := range fields {
+		if len(f.value) > maxMetadataFieldLength {
+			return status.Errorf(codes.InvalidArgument, "invalid metadata: %s exceeds %d bytes", f.name, maxMetadataFieldLength)
+		}
+		if !printableToken(f.value) {
+			return status.Errorf(codes.InvalidArgument, "invalid metadata: %s must be printable ASCII without spaces", f.name)
+		}
+	}
+	return nil
+}
+
+// provisioningOf converts validated request metadata for the registry.
+func provisioningOf(md *controllerpb.EnrollMetadata) state.Provisioning {
+	return state.Provisioning{
+		Provider:   md.GetProvider(),
+		InstanceID: md.GetInstanceId(),
+		Region:     md.GetRegion(),
+	}
+}
+
+// metadataLogFields renders the metadata that is set as " key=value" pairs
+// for the enrollment log line.
+func metadataLogFields(md *controllerpb.EnrollMetadata) string {
+	var out string
+	if v := md.GetProvider(); v != "" {
+		out += fmt.Sprintf(" provider=%s", v)
+	}
+	if v := md.GetInstanceId(); v != "" {
+		out += fmt.Sprintf(" instance_id=%s", v)
+	}
+	if v := md.GetRegion(); v != "" {
+		out += fmt.Sprintf(" region=%s", v)
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// enrollReplayKey identifies an enrollment request for retry deduplication.
+// It covers every field but the nonce, token and public key included, so only
+// a retry of the same request matches, and the certificate it returns is
+// useless to anyone without the private key. The nonce is left out because a
+// client picks a fresh one for each attempt.
+func enrollReplayKey(role string, req *controllerpb.EnrollRequest) string {
+	req = proto.Clone(req).(*controllerpb.EnrollRequest)
+	req.Nonce = nil
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(append([]byte(role+"\x00"), b...))
+	return hex.EncodeToString(sum[:])
+}
+
+// replayEnrollment returns the response to an identical enrollment that
+// already succeeded within EnrollReplays' window, without consuming the
+// token or quota again. Otherwise it returns the key to store the new
+// certificate under and done, which the caller must call when it returns;
+// identical requests arriving meanwhile wait for it.
+func (s *EnrollmentServer) replayEnrollment(ctx context.Context, role string, req *controllerpb.EnrollRequest) (string, *controllerpb.EnrollResponse, func(), error) {
+	if s.EnrollReplays == nil {
+		return "", nil, func() {}, nil
+	}
+	key := enrollReplayKey(role, req)
+	if key == "" {
+		return "", nil, func() {}, nil
+	}
+	certPEM, done, err := s.EnrollReplays.Claim(ctx, key)
+	if err != nil {
+		return "", nil, done, status.FromContextError(err).Err()
+	}
+	if certPEM == nil {
+		return key, nil, done, nil
+	}
+	log.Printf("enroll-%s replayed: id=%s retried an enrollment that already succeeded, returning the issued certificate", role, req.GetId())
+	return key, &controllerpb.EnrollResponse{
+		Certificate:   certPEM,
+		CaCertificate: s.CAPEM,
+	}, done, nil
+}
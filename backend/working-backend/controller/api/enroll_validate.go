@@ -0,0 +1,107 @@
+package api
+
+import (
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Size bounds on EnrollRequest fields. They are far above what a real
+// client sends and only keep oversized input from reaching the parsers.
+const (
+	maxPublicKeyBytes        = 16 << 10
+	maxTokenLength           = 256
+	maxPrivateIPLength       = 64
+	maxVersionLength         = 64
+	maxDNSNames              = 8
+	maxDNSNameLength         = 253
+	maxExtraURILength        = 2048
+	maxEmailLength           = 254
+	maxAttestationTypeLength = 32
+	maxAttestationDocument   = 16 << 10
+	maxAttestationSignature  = 4 << 10
+)
+
+// validateEnrollRequest checks every field of an EnrollConnector,
+// EnrollTunneler or Renew request (renewal set) before any key is parsed or
+// token looked up, and returns an InvalidArgument error naming the first
+// field at fault. role is the enrolling role and is ignored for renewals.
+// Checks that depend on configured policy, such as ALLOWED_DNS_SUFFIXES and
+// EXTRA_SAN_POLICY, stay with the handlers.
+func (s *EnrollmentServer) validateEnrollRequest(req *controllerpb.EnrollRequest, role string, renewal bool) error {
+	if err := checkEnrollBounds(req); err != nil {
+		return err
+	}
+	if renewal {
+		if !validID(req.GetId()) {
+			return status.Error(codes.InvalidArgument, "missing id")
+		}
+	} else if perr := s.checkEnrollFields(role, req.GetId(), req.GetPrivateIp(), req.GetVersion()); perr != nil {
+		return status.Error(codes.InvalidArgument, perr.reason)
+	}
+	if role == "tunneler" && req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "missing enrollment token")
+	}
+	if !printableToken(req.GetVersion()) {
+		return status.Error(codes.InvalidArgument, "invalid version: must be printable ASCII without spaces")
+	}
+	return validateEnrollMetadata(req.GetMetadata())
+}
+
+// checkEnrollBounds applies the size bounds to every field of req.
+func checkEnrollBounds(req *controllerpb.EnrollRequest) error {
+	fields := []struct {
+		name string
+		size int
+		max  int
+	}{
+		{"id", len(req.GetId()), maxIDLength},
+		{"public key", len(req.GetPublicKey()), maxPublicKeyBytes},
+		{"token", len(req.GetToken()), maxTokenLength},
+		{"private ip", len(req.GetPrivateIp()), maxPrivateIPLength},
+		{"version", len(req.GetVersion()), maxVersionLength},
+		{"nonce", len(req.GetNonce()), maxEnrollNonce},
+		{"attestation type", len(req.GetAttestation().GetType()), maxAttestationTypeLength},
+		{"attestation document", len(req.GetAttestation().GetDocument()), maxAttestationDocument},
+		{"attestation signature", len(req.GetAttestation().GetSignature()), maxAttestationSignature},
+	}
+	for _, f := range fields {
+		if f.size > f.max {
+			return status.Errorf(codes.InvalidArgument, "invalid %s: longer than %d bytes", f.name, f.max)
+		}
+	}
+	if len(req.GetDnsNames()) > maxDNSNames {
+		return status.Errorf(codes.InvalidArgument, "invalid dns names: at most %d", maxDNSNames)
+	}
+	for _, name := range req.GetDnsNames() {
+		if len(name) > maxDNSNameLength {
+			return status.Errorf(codes.InvalidArgument, "invalid dns name: longer than %d bytes", maxDNSNameLength)
+		}
+	}
+	if len(req.GetExtraUris())+len(req.GetEmailAddresses()) > maxExtraSANs {
+		return status.Errorf(codes.InvalidArgument, "too many extra sans (at most %d)", maxExtraSANs)
+	}
+	for _, uri := range req.GetExtraUris() {
+		if len(uri) > maxExtraURILength {
+			return status.Errorf(codes.InvalidArgument, "invalid extra san uri: longer than %d bytes", maxExtraURILength)
+		}
+	}
+	for _, email := range req.GetEmailAddresses() {
+		if len(email) > maxEmailLength {
+			return status.Errorf(codes.InvalidArgument, "invalid extra san email: longer than %d bytes", maxEmailLength)
+		}
+	}
+	return nil
+}
+
+// printableToken reports whether v is printable ASCII without spaces, so it
+// can be logged on a single key=value line. Empty is allowed.
+func printableToken(v string) bool {
+	for _, r := range v {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
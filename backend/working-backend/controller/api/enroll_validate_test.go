@@ -0,0 +1,113 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func validEnrollRequest() *controllerpb.EnrollRequest {
+	return &controllerpb.EnrollRequest{
+		Id:        "conn-1",
+		PublicKey: []byte("key"),
+		Token:     "token",
+		PrivateIp: "10.0.0.1",
+		Version:   "1.0.0",
+	}
+}
+
+func TestCheckEnrollBounds(t *testing.T) {
+	attestation := func(req *controllerpb.EnrollRequest) *controllerpb.Attestation {
+		if req.Attestation == nil {
+			req.Attestation = &controllerpb.Attestation{}
+		}
+		return req.Attestation
+	}
+	tests := []struct {
+		field string
+		max   int
+		set   func(req *controllerpb.EnrollRequest, n int)
+	}{
+		{"id", maxIDLength, func(req *controllerpb.EnrollRequest, n int) { req.Id = strings.Repeat("a", n) }},
+		{"public key", maxPublicKeyBytes, func(req *controllerpb.EnrollRequest, n int) { req.PublicKey = make([]byte, n) }},
+		{"token", maxTokenLength, func(req *controllerpb.EnrollRequest, n int) { req.Token = strings.Repeat("t", n) }},
+		{"private ip", maxPrivateIPLength, func(req *controllerpb.EnrollRequest, n int) { req.PrivateIp = strings.Repeat("1", n) }},
+		{"version", maxVersionLength, func(req *controllerpb.EnrollRequest, n int) { req.Version = strings.Repeat("v", n) }},
+		{"nonce", maxEnrollNonce, func(req *controllerpb.EnrollRequest, n int) { req.Nonce = make([]byte, n) }},
+		{"attestation type", maxAttestationTypeLength, func(req *controllerpb.EnrollRequest, n int) { attestation(req).Type = strings.Repeat("x", n) }},
+		{"attestation document", maxAttestationDocument, func(req *controllerpb.EnrollRequest, n int) { attestation(req).Document = make([]byte, n) }},
+		{"attestation signature", maxAttestationSignature, func(req *controllerpb.EnrollRequest, n int) { attestation(req).Signature = make([]byte, n) }},
+		{"dns names", maxDNSNames, func(req *controllerpb.EnrollRequest, n int) { req.DnsNames = make([]string, n) }},
+		{"dns name", maxDNSNameLength, func(req *controllerpb.EnrollRequest, n int) { req.DnsNames = []string{strings.Repeat("d", n)} }},
+		{"extra sans", maxExtraSANs, func(req *controllerpb.EnrollRequest, n int) {
+			req.ExtraUris = make([]string, n/2)
+			req.EmailAddresses = make([]string, n-n/2)
+		}},
+		{"extra san uri", maxExtraURILength, func(req *controllerpb.EnrollRequest, n int) { req.ExtraUris = []string{strings.Repeat("u", n)} }},
+		{"extra san email", maxEmailLength, func(req *controllerpb.EnrollRequest, n int) { req.EmailAddresses = []string{strings.Repeat("e", n)} }},
+	}
+	s := &EnrollmentServer{}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			req := validEnrollRequest()
+			tt.set(req, tt.max)
+			if err := checkEnrollBounds(req); err != nil {
+				t.Fatalf("at the bound of %d: %v", tt.max, err)
+			}
+
+			req = validEnrollRequest()
+			tt.set(req, tt.max+1)
+			err := s.validateEnrollRequest(req, "connector", false)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("one past the bound of %d: err = %v, want InvalidArgument", tt.max, err)
+			}
+			if !strings.Contains(status.Convert(err).Message(), tt.field) {
+				t.Errorf("error %q does not name %s", status.Convert(err).Message(), tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateEnrollRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		renewal bool
+		mutate  func(req *controllerpb.EnrollRequest)
+		wantErr string
+	}{
+		{name: "valid connector", role: "connector"},
+		{name: "valid tunneler", role: "tunneler"},
+		{name: "valid renewal", renewal: true},
+		{name: "tunneler without token", role: "tunneler", mutate: func(req *controllerpb.EnrollRequest) { req.Token = "" }, wantErr: "missing enrollment token"},
+		{name: "non-printable version", role: "connector", mutate: func(req *controllerpb.EnrollRequest) { req.Version = "1.0\x07" }, wantErr: "invalid version"},
+		{name: "version with space", role: "tunneler", mutate: func(req *controllerpb.EnrollRequest) { req.Version = "1.0 beta" }, wantErr: "invalid version"},
+		{name: "renewal without id", renewal: true, mutate: func(req *controllerpb.EnrollRequest) { req.Id = "" }, wantErr: "missing id"},
+		{name: "renewal with invalid id", renewal: true, mutate: func(req *controllerpb.EnrollRequest) { req.Id = "a/b" }, wantErr: "missing id"},
+		{name: "connector without private ip", role: "connector", mutate: func(req *controllerpb.EnrollRequest) { req.PrivateIp = "" }, wantErr: "missing private ip"},
+		{name: "connector with invalid private ip", role: "connector", mutate: func(req *controllerpb.EnrollRequest) { req.PrivateIp = "10.0.0" }, wantErr: "invalid private ip"},
+	}
+	s := &EnrollmentServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validEnrollRequest()
+			if tt.mutate != nil {
+				tt.mutate(req)
+			}
+			err := s.validateEnrollRequest(req, tt.role, tt.renewal)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(status.Convert(err).Message(), tt.wantErr) {
+				t.Fatalf("err = %v, want InvalidArgument containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"log"
+	"strconv"
+
+	"controller/metrics"
+	"controller/webhook"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var enrollmentQuotaRejected = metrics.NewCounterVec(
+	"controller_enrollment_quota_rejected_total",
+	"Enrollments refused because MAX_DAILY_ISSUANCE was reached.",
+	"role",
+)
+
+// reserveEnrollment claims a slot in the global enrollment quota. The first
+// refusal after the quota runs out is logged as an alarm and sent as an
+// enrollment_quota_exceeded event.
+func (s *EnrollmentServer) reserveEnrollment(role, id string) error {
+	ok, first := s.EnrollmentQuota.Reserve()
+	if ok {
+		return nil
+	}
+	enrollmentQuotaRejected.Inc(role)
+	limit := strconv.Itoa(s.EnrollmentQuota.Limit())
+	if first {
+		log.Printf("ALARM: daily enrollment quota of %s reached; refusing new enrollments (first refused: %s %s)", limit, role, id)
+		if s.Events != nil {
+			s.Events.Notify(webhook.EnrollmentQuotaExceeded, map[string]string{"limit": limit, "role": role, "id": id})
+		}
+	} else {
+		log.Printf("enroll-%s rejected: id=%s daily enrollment quota of %s reached", role, id, limit)
+	}
+	return status.Error(codes.ResourceExhausted, "daily enrollment quota exhausted")
+}
@@ -2,11 +2,9 @@ package api
 
 import (
 	"context"
-	"crypto/x509"
 	"errors"
-	"log"
-	"strings"
-	"time"
+
+	"controller/spiffeid"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -17,85 +15,25 @@ import (
 type contextKey string
 
 const (
-	spiffeIDContextKey contextKey = "spiffe-id"
-	roleContextKey     contextKey = "spiffe-role"
+	spiffeIDContextKey    contextKey = "spiffe-id"
+	roleContextKey        contextKey = "spiffe-role"
+	trustDomainContextKey contextKey = "spiffe-trust-domain"
 )
 
-// UnarySPIFFEInterceptor enforces SPIFFE identity on unary RPCs.
-func UnarySPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.UnaryServerInterceptor {
-	roles := makeRoleSet(allowedRoles)
-	return func(
-		ctx context.Context,
-		req interface{},
-		info *grpc.UnaryServerInfo,
-		handler grpc.UnaryHandler,
-	) (interface{}, error) {
-
-		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles)
-		if err != nil {
-			return nil, err
-		}
-
-		ctx = context.WithValue(ctx, spiffeIDContextKey, spiffeID)
-		ctx = context.WithValue(ctx, roleContextKey, role)
-
-		return handler(ctx, req)
-	}
+// verifiedPeer is the identity extractAndVerifySPIFFE accepted, including
+// the trust domain the peer's SPIFFE ID matched.
+type verifiedPeer struct {
+	spiffeID    string
+	role        string
+	trustDomain string
 }
 
-// UnaryAuthInterceptor enforces SPIFFE identity on unary RPCs, with optional
-// method-level bypass for bootstrap enrollment.
-func UnaryAuthInterceptor(trustDomain string, unauthenticatedMethods map[string]struct{}, allowedRoles ...string) grpc.UnaryServerInterceptor {
-	roles := makeRoleSet(allowedRoles)
-	return func(
-		ctx context.Context,
-		req interface{},
-		info *grpc.UnaryServerInfo,
-		handler grpc.UnaryHandler,
-	) (interface{}, error) {
-
-		if _, ok := unauthenticatedMethods[info.FullMethod]; ok {
-			return handler(ctx, req)
-		}
-
-		spiffeID, role, err := extractAndVerifySPIFFE(ctx, trustDomain, roles)
-		if err != nil {
-			return nil, err
-		}
-
-		ctx = context.WithValue(ctx, spiffeIDContextKey, spiffeID)
-		ctx = context.WithValue(ctx, roleContextKey, role)
-
-		return handler(ctx, req)
-	}
-}
-
-// StreamSPIFFEInterceptor enforces SPIFFE identity on streaming RPCs.
-func StreamSPIFFEInterceptor(trustDomain string, allowedRoles ...string) grpc.StreamServerInterceptor {
-	roles := makeRoleSet(allowedRoles)
-	return func(
-		srv interface{},
-		ss grpc.ServerStream,
-		info *grpc.StreamServerInfo,
-		handler grpc.StreamHandler,
-	) error {
-
-		spiffeID, role, err := extractAndVerifySPIFFE(ss.Context(), trustDomain, roles)
-		if err != nil {
-			return err
-		}
-
-		wrapped := &wrappedStream{
-			ServerStream: ss,
-			ctx: context.WithValue(
-				context.WithValue(ss.Context(), spiffeIDContextKey, spiffeID),
-				roleContextKey,
-				role,
-			),
-		}
-
-		return handler(srv, wrapped)
-	}
+// withContext returns ctx carrying the peer's SPIFFE ID, role and trust
+// domain for the handlers.
+func (p verifiedPeer) withContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, spiffeIDContextKey, p.spiffeID)
+	ctx = context.WithValue(ctx, roleContextKey, p.role)
+	return context.WithValue(ctx, trustDomainContextKey, p.trustDomain)
 }
 
 // wrappedStream allows us to override Context().
@@ -128,58 +66,67 @@ func RoleFromContext(ctx context.Context) (string, bool) {
 	return role, ok
 }
 
+// TrustDomainFromContext returns the trust domain the peer's SPIFFE ID was
+// verified against.
+func TrustDomainFromContext(ctx context.Context) (string, bool) {
+	v := ctx.Value(trustDomainContextKey)
+	if v == nil {
+		return "", false
+	}
+	td, ok := v.(string)
+	return td, ok
+}
+
 // extractAndVerifySPIFFE pulls the peer certificate from context and validates
-// the SPIFFE ID and role.
+// the SPIFFE ID and role. It returns the verified identity together with the
+// trust domain it matched.
 func extractAndVerifySPIFFE(
 	ctx context.Context,
 	trustDomain string,
 	allowedRoles map[string]struct{},
-) (string, string, error) {
+) (verifiedPeer, error) {
 	p, ok := peer.FromContext(ctx)
 	if !ok {
-		return "", "", errors.New("missing peer information")
+		return verifiedPeer{}, errors.New("missing peer information")
 	}
 
 	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return "", "", errors.New("connection is not using TLS")
+		return verifiedPeer{}, errors.New("connection is not using TLS")
 	}
 
 	if len(tlsInfo.State.PeerCertificates) == 0 {
-		return "", "", errors.New("no peer certificates presented")
+		return verifiedPeer{}, errors.New("no peer certificates presented")
 	}
 
 	cert := tlsInfo.State.PeerCertificates[0]
 	logPeerTLS(cert)
 
-	if len(cert.URIs) != 1 {
-		return "", "", errors.New("exactly one SPIFFE ID is required")
-	}
-
-	uri := cert.URIs[0]
-
-	if uri.Scheme != "spiffe" {
-		return "", "", errors.New("SPIFFE ID must use spiffe:// scheme")
-	}
+	v := verifiedPeer{trustDomain: trustDomain}
+	v.spiffeID, v.role, ok = PeerIdentities.lookup(cert, trustDomain)
+	if !ok {
+		id, err := spiffeid.FromLeaf(cert)
+		if err != nil {
+			return verifiedPeer{}, err
+		}
 
-	if uri.Host != trustDomain {
-		return "", "", errors.New("SPIFFE trust domain mismatch")
-	}
+		if id.TrustDomain != trustDomain {
+			return verifiedPeer{}, errors.New("SPIFFE trust domain mismatch")
+		}
 
-	path := strings.TrimPrefix(uri.Path, "/")
-	parts := strings.Split(path, "/")
-	if len(parts) != 2 {
-		return "", "", errors.New("invalid SPIFFE path format")
+		v = verifiedPeer{spiffeID: id.String(), role: id.Role, trustDomain: id.TrustDomain}
+		PeerIdentities.store(cert, v.trustDomain, v.spiffeID, v.role)
 	}
 
-	role := parts[0]
+	// Roles are checked on every call: interceptors share the cache but
+	// not their allowed roles.
 	if len(allowedRoles) > 0 {
-		if _, ok := allowedRoles[role]; !ok {
-			return "", "", errors.New("invalid SPIFFE role")
+		if _, ok := allowedRoles[v.role]; !ok {
+			return verifiedPeer{}, errors.New("invalid SPIFFE role")
 		}
 	}
 
-	return uri.String(), role, nil
+	return v, nil
 }
 
 func makeRoleSet(roles []string) map[string]struct{} {
@@ -195,20 +142,3 @@ func makeRoleSet(roles []string) map[string]struct{} {
 	}
 	return set
 }
-
-func logPeerTLS(cert *x509.Certificate) {
-	if cert == nil {
-		return
-	}
-	var spiffeURI string
-	if len(cert.URIs) == 1 {
-		spiffeURI = cert.URIs[0].String()
-	}
-	log.Printf(
-		"mtls peer: subject=%q serial=%s not_after=%s spiffe=%q",
-		cert.Subject.String(),
-		cert.SerialNumber.String(),
-		cert.NotAfter.Format(time.RFC3339),
-		spiffeURI,
-	)
-}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	issuanceInFlight = metrics.NewGauge(
+		"controller_issuance_in_flight",
+		"Certificate-issuing RPCs currently executing.",
+	)
+	issuanceQueueDepth = metrics.NewGauge(
+		"controller_issuance_queue_depth",
+		"Certificate-issuing RPCs waiting for an issuance slot.",
+	)
+	issuanceRejected = metrics.NewCounter(
+		"controller_issuance_rejected_total",
+		"Certificate-issuing RPCs rejected after waiting for a slot until their deadline.",
+	)
+)
+
+// issuanceMethods are the RPCs that sign certificates.
+var issuanceMethods = map[string]struct{}{
+	controllerpb.EnrollmentService_EnrollConnector_FullMethodName: {},
+	controllerpb.EnrollmentService_EnrollTunneler_FullMethodName:  {},
+	controllerpb.EnrollmentService_Renew_FullMethodName:           {},
+	controllerpb.EnrollmentService_BatchRenew_FullMethodName:      {},
+}
+
+// UnaryIssuanceLimitInterceptor bounds the number of concurrently executing
+// certificate-issuing RPCs. Callers over the limit wait for a slot until their
+// context is done and then fail with ResourceExhausted. A non-positive limit
+// disables the interceptor.
+func UnaryIssuanceLimitInterceptor(limit int) grpc.UnaryServerInterceptor {
+	if limit <= 0 {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(ctx, req)
+		}
+	}
+	slots := make(chan struct{}, limit)
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+
+		if _, ok := issuanceMethods[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+
+		issuanceQueueDepth.Inc()
+		select {
+		case slots <- struct{}{}:
+			issuanceQueueDepth.Dec()
+		case <-ctx.Done():
+			issuanceQueueDepth.Dec()
+			issuanceRejected.Inc()
+			return nil, status.Error(codes.ResourceExhausted, "certificate issuance capacity exhausted, retry later")
+		}
+		issuanceInFlight.Inc()
+		defer func() {
+			issuanceInFlight.Dec()
+			<-slots
+		}()
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyAlgorithms is an explicit allowlist of enrollment key algorithms, read
+// from ALLOWED_KEY_ALGORITHMS. It is applied on top of checkPublicKey, which
+// only rejects keys the CA cannot certify. A nil allowlist permits every
+// supported algorithm.
+type KeyAlgorithms map[string]struct{}
+
+// keyAlgorithmNames are the accepted ALLOWED_KEY_ALGORITHMS entries. "ecdsa"
+// allows every supported curve.
+var keyAlgorithmNames = map[string]struct{}{
+	"rsa":        {},
+	"ecdsa":      {},
+	"ecdsa-p256": {},
+	"ecdsa-p384": {},
+	"ecdsa-p521": {},
+	"ed25519":    {},
+}
+
+// ParseKeyAlgorithms parses a comma-separated ALLOWED_KEY_ALGORITHMS value
+// such as "ecdsa-p256,ed25519". It returns nil for an empty value.
+func ParseKeyAlgorithms(v string) (KeyAlgorithms, error) {
+	var allowed KeyAlgorithms
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := keyAlgorithmNames[name]; !ok {
+			return nil, fmt.Errorf("unknown key algorithm %q (expected rsa, ecdsa, ecdsa-p256, ecdsa-p384, ecdsa-p521 or ed25519)", name)
+		}
+		if allowed == nil {
+			allowed = make(KeyAlgorithms)
+		}
+		allowed[name] = struct{}{}
+	}
+	return allowed, nil
+}
+
+// keyAlgorithmName names a key as in ALLOWED_KEY_ALGORITHMS, with the curve
+// for ECDSA.
+func keyAlgorithmName(algo string, bits int) string {
+	if algo == "ecdsa" {
+		return "ecdsa-p" + strconv.Itoa(bits)
+	}
+	return algo
+}
+
+// check rejects a key whose algorithm is not on the allowlist.
+func (a KeyAlgorithms) check(algo string, bits int) *policyError {
+	if a == nil {
+		return nil
+	}
+	name := keyAlgorithmName(algo, bits)
+	if _, ok := a[name]; ok {
+		return nil
+	}
+	if _, ok := a[algo]; ok {
+		return nil
+	}
+	return &policyError{PolicyKeyAlgorithm, fmt.Sprintf("key algorithm %s is not allowed (allowed: %s)", name, a)}
+}
+
+func (a KeyAlgorithms) String() string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,49 @@
+package api
+
+import "testing"
+
+func TestParseKeyAlgorithms(t *testing.T) {
+	allowed, err := ParseKeyAlgorithms(" ECDSA-P256, ed25519,, ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := allowed.String(); got != "ecdsa-p256, ed25519" {
+		t.Fatalf("parsed %q", got)
+	}
+	if allowed, err := ParseKeyAlgorithms(""); err != nil || allowed != nil {
+		t.Fatalf("empty value parsed to %v, %v; want nil", allowed, err)
+	}
+	if _, err := ParseKeyAlgorithms("ecdsa-p256,dsa"); err == nil {
+		t.Fatal("unknown algorithm accepted")
+	}
+}
+
+func TestKeyAlgorithmsCheck(t *testing.T) {
+	tests := []struct {
+		allow string
+		algo  string
+		bits  int
+		ok    bool
+	}{
+		{"", "rsa", 2048, true},
+		{"ecdsa-p256", "ecdsa", 256, true},
+		{"ecdsa-p256", "ecdsa", 384, false},
+		{"ecdsa", "ecdsa", 521, true},
+		{"ecdsa", "rsa", 3072, false},
+		{"rsa,ed25519", "ed25519", 256, true},
+		{"rsa,ed25519", "ecdsa", 256, false},
+	}
+	for _, tt := range tests {
+		allowed, err := ParseKeyAlgorithms(tt.allow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		perr := allowed.check(tt.algo, tt.bits)
+		if (perr == nil) != tt.ok {
+			t.Errorf("allow %q: check(%s, %d) = %v, want ok=%v", tt.allow, tt.algo, tt.bits, perr, tt.ok)
+		}
+		if perr != nil && perr.policy != PolicyKeyAlgorithm {
+			t.Errorf("allow %q: check(%s, %d) reported policy %s", tt.allow, tt.algo, tt.bits, perr.policy)
+		}
+	}
+}
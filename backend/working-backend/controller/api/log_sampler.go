@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sampleIdle is how long a key may go unused before its state is dropped.
+// Keys sampled by interval are also kept for at least the interval, so
+// dropping them never lets an extra line through.
+const sampleIdle = 10 * time.Minute
+
+// LogSampler rate-limits high-frequency log lines per key (e.g. connector id).
+// It either emits 1 in N lines, or at most one line per interval.
+// A nil *LogSampler allows every line.
+//
+// Keys unused for sampleIdle are swept, so per-peer keys do not accumulate
+// on a long-running controller.
+type LogSampler struct {
+	every    uint64
+	interval time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]*sampleState
+	swept time.Time
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+}
+
+type sampleState struct {
+	count uint64
+	// last is when a line for the key was last emitted, used was when one
+	// was last offered.
+	last time.Time
+	used time.Time
+}
+
+// NewLogSampler parses a sampling spec. An integer N logs 1 in N lines per key;
+// a duration (e.g. "1m") logs at most once per key per interval. An empty spec,
+// "0" or "1" disables sampling and returns nil.
+func NewLogSampler(spec string) (*LogSampler, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if n, err := strconv.ParseUint(spec, 10, 64); err == nil {
+		if n <= 1 {
+			return nil, nil
+		}
+		return &LogSampler{every: n, seen: make(map[string]*sampleState)}, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return nil, fmt.Errorf("expected a count or a duration, got %q", spec)
+	}
+	if d <= 0 {
+		return nil, nil
+	}
+	return &LogSampler{interval: d, seen: make(map[string]*sampleState)}, nil
+}
+
+// Allow reports whether a log line for key should be emitted now.
+func (s *LogSampler) Allow(key string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	s.sweep(now)
+	st, ok := s.seen[key]
+	if !ok {
+		st = &sampleState{}
+		s.seen[key] = st
+	}
+	st.used = now
+	st.count++
+	if s.every > 0 {
+		return st.count%s.every == 1
+	}
+	if st.last.IsZero() || now.Sub(st.last) >= s.interval {
+		st.last = now
+		return true
+	}
+	return false
+}
+
+// sweep drops keys idle for longer than sampleIdle (or the interval, if
+// longer). It scans the map at most once per that period.
+func (s *LogSampler) sweep(now time.Time) {
+	idle := max(sampleIdle, s.interval)
+	if s.swept.IsZero() {
+		s.swept = now
+	}
+	if now.Sub(s.swept) < idle {
+		return
+	}
+	s.swept = now
+	for key, st := range s.seen {
+		if now.Sub(st.used) >= idle {
+			delete(s.seen, key)
+		}
+	}
+}
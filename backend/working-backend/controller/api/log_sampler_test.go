@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLogSamplerSweepsIdleKeys(t *testing.T) {
+	s, err := NewLogSampler("1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		if !s.Allow(fmt.Sprintf("peer-%d", i)) {
+			t.Fatalf("first line for peer-%d suppressed", i)
+		}
+	}
+	if s.Allow("peer-0") {
+		t.Fatal("second line within the interval emitted")
+	}
+
+	// Only the key still in use survives a sweep.
+	now = now.Add(sampleIdle / 2)
+	s.Allow("active")
+	now = now.Add(sampleIdle / 2)
+	s.Allow("active")
+	if len(s.seen) != 1 {
+		t.Fatalf("sampler holds %d keys after the sweep, want 1", len(s.seen))
+	}
+	if !s.Allow("peer-0") {
+		t.Fatal("line for a swept key suppressed")
+	}
+}
+
+func TestLogSamplerEvery(t *testing.T) {
+	s, err := NewLogSampler("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []bool
+	for i := 0; i < 6; i++ {
+		got = append(got, s.Allow("k"))
+	}
+	if fmt.Sprint(got) != "[true false false true false false]" {
+		t.Fatalf("1 in 3 sampling emitted %v", got)
+	}
+}
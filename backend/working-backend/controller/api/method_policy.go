@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// MethodPolicy is the authorization an RPC needs before its handler runs.
+// Handlers still check which ids a caller may act on.
+type MethodPolicy struct {
+	// Bootstrap methods may be called without a workload certificate on
+	// the enrollment listener, or on the main listener in single-port mode.
+	Bootstrap bool
+	// Roles are the SPIFFE roles allowed to call the method with a
+	// workload certificate. A bootstrap method called with one is not
+	// checked against them.
+	Roles []string
+}
+
+// MethodPolicies maps every RPC the controller serves to its policy. The
+// policy interceptors refuse methods missing from the table, and
+// CheckMethodPolicies fails startup for them, so a new RPC cannot be served
+// unprotected by accident.
+var MethodPolicies = map[string]MethodPolicy{
+	controllerpb.EnrollmentService_EnrollConnector_FullMethodName: {Bootstrap: true, Roles: []string{"connector", "tunneler"}},
+	controllerpb.EnrollmentService_EnrollTunneler_FullMethodName:  {Bootstrap: true, Roles: []string{"connector", "tunneler"}},
+	controllerpb.EnrollmentService_Renew_FullMethodName:           {Roles: []string{"connector", "tunneler"}},
+	controllerpb.EnrollmentService_BatchRenew_FullMethodName:      {Roles: []string{"connector", "tunneler"}},
+	controllerpb.ControlPlane_Connect_FullMethodName:              {Roles: []string{"connector"}},
+	controllerpb.ControlPlane_ResolveConnector_FullMethodName:     {Roles: []string{"tunneler"}},
+	// Reflection (GRPC_REFLECTION) is for debugging with a workload
+	// certificate.
+	reflectionv1.ServerReflection_ServerReflectionInfo_FullMethodName:      {Roles: []string{"connector", "tunneler"}},
+	reflectionv1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: {Roles: []string{"connector", "tunneler"}},
+}
+
+// BootstrapMethods are the RPCs a client may call before it holds a workload
+// certificate, taken from MethodPolicies. It drives both the policy
+// interceptor bypass on a combined listener and the method filter on a
+// dedicated bootstrap listener, so the two cannot drift apart.
+var BootstrapMethods = bootstrapMethods()
+
+func bootstrapMethods() map[string]struct{} {
+	methods := make(map[string]struct{})
+	for method, p := range MethodPolicies {
+		if p.Bootstrap {
+			methods[method] = struct{}{}
+		}
+	}
+	return methods
+}
+
+// methodRoles holds the role sets of MethodPolicies, built once.
+var methodRoles = func() map[string]map[string]struct{} {
+	roles := make(map[string]map[string]struct{}, len(MethodPolicies))
+	for method, p := range MethodPolicies {
+		roles[method] = makeRoleSet(p.Roles)
+	}
+	return roles
+}()
+
+// authorizeMethod applies the policy of method to the caller in ctx and
+// returns the context the handler should see. allowBootstrap lets bootstrap
+// methods through without a workload certificate.
+func authorizeMethod(ctx context.Context, trustDomain, method string, allowBootstrap bool) (context.Context, error) {
+	policy, ok := MethodPolicies[method]
+	if !ok {
+		log.Printf("rpc refused: %s has no authorization policy", method)
+		return nil, status.Error(codes.PermissionDenied, "method has no authorization policy")
+	}
+	if policy.Bootstrap && allowBootstrap {
+		return ctx, nil
+	}
+	p, err := extractAndVerifySPIFFE(ctx, trustDomain, methodRoles[method])
+	if err != nil {
+		return nil, err
+	}
+	return p.withContext(ctx), nil
+}
+
+// UnaryPolicyInterceptor enforces MethodPolicies on unary RPCs. With
+// allowBootstrap, bootstrap methods are served to clients without a
+// workload certificate (single-port mode).
+func UnaryPolicyInterceptor(trustDomain string, allowBootstrap bool) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := authorizeMethod(ctx, trustDomain, info.FullMethod, allowBootstrap)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamPolicyInterceptor enforces MethodPolicies on streaming RPCs. No
+// streaming RPC is a bootstrap method.
+func StreamPolicyInterceptor(trustDomain string) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := authorizeMethod(ss.Context(), trustDomain, info.FullMethod, false)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// CheckMethodPolicies returns an error naming every method registered on
+// server that has no entry in MethodPolicies.
+func CheckMethodPolicies(server *grpc.Server) error {
+	var missing []string
+	for service, info := range server.GetServiceInfo() {
+		for _, m := range info.Methods {
+			method := "/" + service + "/" + m.Name
+			if _, ok := MethodPolicies[method]; !ok {
+				missing = append(missing, method)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("no authorization policy for %v", missing)
+	}
+	return nil
+}
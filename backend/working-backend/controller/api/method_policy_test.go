@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const testTrustDomain = "example.org"
+
+// peerContext returns a context carrying a TLS peer that presented a
+// workload certificate for spiffe://example.org/<role>/<name>.
+func peerContext(t testing.TB, caInst *ca.CA, role, name string) context.Context {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := ca.IssueWorkloadCert(caInst, "spiffe://"+testTrustDomain+"/"+role+"/"+name, &key.PublicKey, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+}
+
+func newTestCA(t testing.TB) *ca.CA {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caInst, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caInst
+}
+
+func TestMethodPoliciesCoverServices(t *testing.T) {
+	for _, desc := range []grpc.ServiceDesc{controllerpb.EnrollmentService_ServiceDesc, controllerpb.ControlPlane_ServiceDesc} {
+		for _, m := range desc.Methods {
+			if _, ok := MethodPolicies["/"+desc.ServiceName+"/"+m.MethodName]; !ok {
+				t.Errorf("%s/%s has no MethodPolicies entry", desc.ServiceName, m.MethodName)
+			}
+		}
+		for _, s := range desc.Streams {
+			if _, ok := MethodPolicies["/"+desc.ServiceName+"/"+s.StreamName]; !ok {
+				t.Errorf("%s/%s has no MethodPolicies entry", desc.ServiceName, s.StreamName)
+			}
+		}
+	}
+
+	server := grpc.NewServer()
+	controllerpb.RegisterEnrollmentServiceServer(server, controllerpb.UnimplementedEnrollmentServiceServer{})
+	controllerpb.RegisterControlPlaneServer(server, controllerpb.UnimplementedControlPlaneServer{})
+	if err := CheckMethodPolicies(server); err != nil {
+		t.Fatalf("CheckMethodPolicies: %v", err)
+	}
+
+	// A service the table does not know about fails the check.
+	controllerpb.RegisterTunnelServiceServer(server, controllerpb.UnimplementedTunnelServiceServer{})
+	err := CheckMethodPolicies(server)
+	if err == nil || !strings.Contains(err.Error(), controllerpb.TunnelService_ServiceDesc.ServiceName) {
+		t.Fatalf("CheckMethodPolicies with an unlisted service = %v, want an error naming it", err)
+	}
+}
+
+func TestAuthorizeMethodRoles(t *testing.T) {
+	caInst := newTestCA(t)
+	for method, policy := range MethodPolicies {
+		allowed := makeRoleSet(policy.Roles)
+		var denied string
+		for _, role := range []string{"connector", "tunneler", "controller"} {
+			if _, ok := allowed[role]; !ok {
+				denied = role
+				break
+			}
+		}
+		if denied == "" {
+			t.Fatalf("%s allows every role", method)
+		}
+		if _, err := authorizeMethod(peerContext(t, caInst, policy.Roles[0], "ok"), testTrustDomain, method, false); err != nil {
+			t.Errorf("%s refused role %s: %v", method, policy.Roles[0], err)
+		}
+		if _, err := authorizeMethod(peerContext(t, caInst, denied, "bad"), testTrustDomain, method, false); err == nil {
+			t.Errorf("%s accepted role %s", method, denied)
+		}
+
+		// Without a certificate only bootstrap methods pass, and only
+		// where bootstrap is allowed.
+		_, err := authorizeMethod(context.Background(), testTrustDomain, method, true)
+		if policy.Bootstrap != (err == nil) {
+			t.Errorf("%s without a certificate: err = %v, bootstrap = %v", method, err, policy.Bootstrap)
+		}
+		if _, err := authorizeMethod(context.Background(), testTrustDomain, method, false); err == nil {
+			t.Errorf("%s accepted a caller without a certificate on the main listener", method)
+		}
+	}
+	if _, err := authorizeMethod(context.Background(), testTrustDomain, "/controller.v1.Unknown/Call", true); err == nil {
+		t.Error("method without a policy was authorized")
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"container/list"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"controller/metrics"
+)
+
+var peerIdentityCacheLookups = metrics.NewCounterVec(
+	"controller_peer_identity_cache_lookups_total",
+	"Peer identity cache lookups by the SPIFFE interceptors, by result (hit or miss).",
+	"result",
+)
+
+// PeerIdentities caches the SPIFFE id and role extracted from recently seen
+// peer certificates so repeated RPCs on the same connection skip parsing and
+// validating the SAN. It is set once at startup; nil disables caching.
+var PeerIdentities *PeerIdentityCache
+
+// PeerIdentityCache is a fixed-size LRU keyed by a leaf certificate's raw
+// DER. A renewed certificate has a different DER and so a different entry;
+// entries are also dropped once the certificate expires. Each entry records
+// the trust domain it was verified against and is only used for that domain.
+type PeerIdentityCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type peerIdentity struct {
+	der         string
+	trustDomain string
+	id          string
+	role        string
+	notAfter    time.Time
+}
+
+// NewPeerIdentityCache returns a cache holding up to size identities, or nil
+// when size is not positive.
+func NewPeerIdentityCache(size int) *PeerIdentityCache {
+	if size <= 0 {
+		return nil
+	}
+	return &PeerIdentityCache{size: size, order: list.New(), entries: make(map[string]*list.Element, size)}
+}
+
+// lookup returns the identity cached for cert under trustDomain.
+func (c *PeerIdentityCache) lookup(cert *x509.Certificate, trustDomain string) (string, string, bool) {
+	if c == nil {
+		return "", "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[string(cert.Raw)]
+	if !ok {
+		peerIdentityCacheLookups.Inc("miss")
+		return "", "", false
+	}
+	e := el.Value.(*peerIdentity)
+	if !time.Now().Before(e.notAfter) {
+		c.order.Remove(el)
+		delete(c.entries, e.der)
+		peerIdentityCacheLookups.Inc("miss")
+		return "", "", false
+	}
+	if e.trustDomain != trustDomain {
+		peerIdentityCacheLookups.Inc("miss")
+		return "", "", false
+	}
+	c.order.MoveToFront(el)
+	peerIdentityCacheLookups.Inc("hit")
+	return e.id, e.role, true
+}
+
+// store records the identity verified for cert under trustDomain, evicting
+// the least recently used entry when full.
+func (c *PeerIdentityCache) store(cert *x509.Certificate, trustDomain, id, role string) {
+	if c == nil || !time.Now().Before(cert.NotAfter) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &peerIdentity{
+		der:         string(cert.Raw),
+		trustDomain: trustDomain,
+		id:          id,
+		role:        role,
+		notAfter:    cert.NotAfter,
+	}
+	if el, ok := c.entries[e.der]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.der] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*peerIdentity).der)
+	}
+}
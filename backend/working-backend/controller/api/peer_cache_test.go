@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// peerLeaf returns the leaf certificate carried by a peerContext.
+func peerLeaf(ctx context.Context) *x509.Certificate {
+	p, _ := peer.FromContext(ctx)
+	return p.AuthInfo.(credentials.TLSInfo).State.PeerCertificates[0]
+}
+
+func TestPeerIdentityCacheMisses(t *testing.T) {
+	caInst := newTestCA(t)
+	leaf := peerLeaf(peerContext(t, caInst, "connector", "c1"))
+	renewed := peerLeaf(peerContext(t, caInst, "connector", "c1"))
+
+	c := NewPeerIdentityCache(1)
+	c.store(leaf, testTrustDomain, "spiffe://example.org/connector/c1", "connector")
+	if id, role, ok := c.lookup(leaf, testTrustDomain); !ok || id != "spiffe://example.org/connector/c1" || role != "connector" {
+		t.Fatalf("lookup of the stored leaf = %q, %q, %v", id, role, ok)
+	}
+	if _, _, ok := c.lookup(leaf, "other.org"); ok {
+		t.Fatal("identity verified for example.org was served for other.org")
+	}
+	if _, _, ok := c.lookup(renewed, testTrustDomain); ok {
+		t.Fatal("renewed leaf with the same SPIFFE ID hit the entry of the old one")
+	}
+
+	// A full cache evicts the least recently used leaf.
+	c.store(renewed, testTrustDomain, "spiffe://example.org/connector/c1", "connector")
+	if _, _, ok := c.lookup(leaf, testTrustDomain); ok {
+		t.Fatal("evicted leaf still cached")
+	}
+}
+
+func TestExtractAndVerifySPIFFECachedTrustDomain(t *testing.T) {
+	saved := PeerIdentities
+	PeerIdentities = NewPeerIdentityCache(16)
+	defer func() { PeerIdentities = saved }()
+
+	ctx := peerContext(t, newTestCA(t), "connector", "c1")
+	if _, err := extractAndVerifySPIFFE(ctx, testTrustDomain, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The cached entry must not let the same leaf pass for another domain.
+	if _, err := extractAndVerifySPIFFE(ctx, "other.org", nil); err == nil {
+		t.Fatal("cached peer accepted for another trust domain")
+	}
+	if _, err := extractAndVerifySPIFFE(ctx, testTrustDomain, makeRoleSet([]string{"tunneler"})); err == nil {
+		t.Fatal("cached peer accepted for a role it does not have")
+	}
+}
+
+func BenchmarkExtractAndVerifySPIFFE(b *testing.B) {
+	ctx := peerContext(b, newTestCA(b), "connector", "c1")
+	roles := makeRoleSet([]string{"connector"})
+	saved := PeerIdentities
+	defer func() { PeerIdentities = saved }()
+
+	for _, bc := range []struct {
+		name  string
+		cache *PeerIdentityCache
+	}{
+		{"uncached", nil},
+		{"cached", NewPeerIdentityCache(1024)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			PeerIdentities = bc.cache
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := extractAndVerifySPIFFE(ctx, testTrustDomain, roles); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
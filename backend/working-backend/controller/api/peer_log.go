@@ -0,0 +1,80 @@
+package api
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"controller/spiffeid"
+)
+
+// Levels for PeerLogConfig.Level and for LogLevel.
+const (
+	LogLevelOff   = "off"
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+)
+
+// LogLevel is the controller log threshold, "info" or "debug". Lines logged
+// at debug are dropped unless it is "debug". It is set once at startup.
+var LogLevel = LogLevelInfo
+
+// PeerLogConfig controls the per-RPC mTLS peer certificate log line.
+type PeerLogConfig struct {
+	// Level is the level the line is logged at: "off", "debug" or "info".
+	Level string
+	// RedactSubject replaces the certificate subject DN. The SPIFFE id is
+	// always logged.
+	RedactSubject bool
+}
+
+// PeerLog is applied by the SPIFFE interceptors. It is set once at startup.
+var PeerLog = PeerLogConfig{Level: LogLevelDebug}
+
+// ParseLogLevel validates a LOG_LEVEL or PEER_LOG_LEVEL value; allowOff
+// permits "off". Empty returns def.
+func ParseLogLevel(v, def string, allowOff bool) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch {
+	case v == "":
+		return def, nil
+	case v == LogLevelDebug || v == LogLevelInfo || allowOff && v == LogLevelOff:
+		return v, nil
+	}
+	if allowOff {
+		return "", fmt.Errorf("must be off, debug or info, got %q", v)
+	}
+	return "", fmt.Errorf("must be debug or info, got %q", v)
+}
+
+func logPeerTLS(cert *x509.Certificate) {
+	if cert == nil {
+		return
+	}
+	switch PeerLog.Level {
+	case LogLevelInfo:
+	case LogLevelDebug:
+		if LogLevel != LogLevelDebug {
+			return
+		}
+	default:
+		return
+	}
+	var spiffeURI string
+	if u, err := spiffeid.URIOf(cert); err == nil {
+		spiffeURI = u.String()
+	}
+	subject := cert.Subject.String()
+	if PeerLog.RedactSubject {
+		subject = "[redacted]"
+	}
+	log.Printf(
+		"mtls peer: subject=%q serial=%s not_after=%s spiffe=%q",
+		subject,
+		cert.SerialNumber.String(),
+		cert.NotAfter.Format(time.RFC3339),
+		spiffeURI,
+	)
+}
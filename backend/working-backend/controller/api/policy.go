@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"net"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/status"
+)
+
+// Enrollment policies named in PolicyDecision.Policy.
+const (
+	PolicyRole            = "role"
+	PolicyID              = "id"
+	PolicyPrivateIP       = "private_ip"
+	PolicyVersion         = "version"
+	PolicyPublicKey       = "public_key"
+	PolicyKeyAlgorithm    = "key_algorithm"
+	PolicyDNSNames        = "dns_names"
+	PolicyExtraSANs       = "extra_sans"
+	PolicyPreRegistration = "tunneler_preregistration"
+	PolicyEnrollmentQuota = "enrollment_quota"
+)
+
+// PolicyRequest describes a hypothetical enrollment for EvaluatePolicy.
+type PolicyRequest struct {
+	// Role is "connector" (default) or "tunneler".
+	Role     string   `json:"role"`
+	ID       string   `json:"id"`
+	Version  string   `json:"version"`
+	IP       string   `json:"ip"`
+	DNSNames []string `json:"dns_names"`
+	// KeyAlgorithm is "rsa", "ecdsa" or "ed25519"; empty skips the key
+	// check.
+	KeyAlgorithm string `json:"key_algorithm"`
+	KeyBits      int    `json:"key_bits"`
+	// ExtraURIs and EmailAddresses are checked against EXTRA_SAN_POLICY.
+	ExtraURIs      []string `json:"extra_uris"`
+	EmailAddresses []string `json:"email_addresses"`
+}
+
+// PolicyDecision is the outcome of EvaluatePolicy. Policy and Reason name
+// the first policy that rejects the request. Authorization is the step a
+// real enrollment would still need, "token", "approval", "attest",
+// "bootstrap_cert" or "both", which is not simulated.
+type PolicyDecision struct {
+	Allowed       bool   `json:"allowed"`
+	Policy        string `json:"policy,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Authorization string `json:"authorization"`
+}
+
+// policyError is a rejection by a named enrollment policy.
+type policyError struct {
+	policy string
+	reason string
+}
+
+func (e *policyError) Error() string { return e.reason }
+
+// checkEnrollFields applies the request field policies of EnrollConnector
+// and EnrollTunneler. The private IP may be omitted with OptionalPrivateIP.
+func (s *EnrollmentServer) checkEnrollFields(role, id, privateIP, version string) *policyError {
+	if !validID(id) {
+		return &policyError{PolicyID, "missing " + role + " id"}
+	}
+	if role != "connector" {
+		return nil
+	}
+	if privateIP == "" && !s.OptionalPrivateIP {
+		return &policyError{PolicyPrivateIP, "missing private ip"}
+	}
+	if privateIP != "" && net.ParseIP(privateIP) == nil {
+		return &policyError{PolicyPrivateIP, "invalid private ip"}
+	}
+	if version == "" {
+		return &policyError{PolicyVersion, "missing version"}
+	}
+	return nil
+}
+
+// checkPublicKey accepts the key types the CA can certify.
+func checkPublicKey(algo string, bits int) *policyError {
+	switch algo {
+	case "rsa":
+		if bits > 0 {
+			return nil
+		}
+	case "ecdsa":
+		if bits == 256 || bits == 384 || bits == 521 {
+			return nil
+		}
+	case "ed25519":
+		return nil
+	}
+	return &policyError{PolicyPublicKey, fmt.Sprintf("unsupported key type %s/%d", algo, bits)}
+}
+
+// publicKeyParams reports a parsed public key's algorithm and size.
+func publicKeyParams(pubKey interface{}) (string, int) {
+	switch k := pubKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		if k.Curve == nil {
+			return "ecdsa", 0
+		}
+		switch bits := k.Curve.Params().BitSize; bits {
+		case 256, 384, 521:
+			return "ecdsa", bits
+		}
+		return "ecdsa", 0
+	case ed25519.PublicKey:
+		return "ed25519", 256
+	}
+	return "unknown", 0
+}
+
+func (s *EnrollmentServer) tunnelerPreRegistered(id string) bool {
+	return s.TunnelerPreRegistry != nil && s.TunnelerPreRegistry.IsRegistered(id)
+}
+
+// EvaluatePolicy runs the enrollment policies against req without issuing a
+// certificate, consuming a token or claiming quota.
+func (s *EnrollmentServer) EvaluatePolicy(req PolicyRequest) PolicyDecision {
+	role := req.Role
+	if role == "" {
+		role = "connector"
+	}
+	d := PolicyDecision{Authorization: EnrollModeToken}
+	if role == "connector" && (s.EnrollMode == EnrollModeApproval || s.EnrollMode == EnrollModeAttest) {
+		d.Authorization = s.EnrollMode
+	} else if role == "connector" && s.bootstrapCertRequired() {
+		d.Authorization = s.EnrollAuth
+	}
+	reject := func(perr *policyError) PolicyDecision {
+		d.Policy, d.Reason = perr.policy, perr.reason
+		return d
+	}
+
+	if role != "connector" && role != "tunneler" {
+		return reject(&policyError{PolicyRole, fmt.Sprintf("unknown role %q", role)})
+	}
+	if perr := s.checkEnrollFields(role, req.ID, req.IP, req.Version); perr != nil {
+		return reject(perr)
+	}
+	if req.KeyAlgorithm != "" {
+		if perr := checkPublicKey(req.KeyAlgorithm, req.KeyBits); perr != nil {
+			return reject(perr)
+		}
+		if perr := s.AllowedKeyAlgorithms.check(req.KeyAlgorithm, req.KeyBits); perr != nil {
+			return reject(perr)
+		}
+	}
+	if role == "connector" {
+		if _, err := validateDNSNames(req.DNSNames, s.AllowedDNSSuffixes); err != nil {
+			return reject(&policyError{PolicyDNSNames, err.Error()})
+		}
+	}
+	if _, _, err := validateExtraSANs(&controllerpb.EnrollRequest{
+		ExtraUris:      req.ExtraURIs,
+		EmailAddresses: req.EmailAddresses,
+	}, s.ExtraSANs); err != nil {
+		return reject(&policyError{PolicyExtraSANs, status.Convert(err).Message()})
+	}
+	if role == "tunneler" && !s.tunnelerPreRegistered(req.ID) {
+		return reject(&policyError{PolicyPreRegistration, "tunneler id is not pre-registered"})
+	}
+	if s.EnrollmentQuota.Exhausted() {
+		return reject(&policyError{PolicyEnrollmentQuota, "daily enrollment quota exhausted"})
+	}
+	d.Allowed = true
+	return d
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"controller/metrics"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var certificatesIssued = metrics.NewCounterVec(
+	"controller_certificates_issued_total",
+	"Workload certificates issued, by kind (enroll or renew), role and trust domain.",
+	"kind", "role", "trust_domain",
+)
+
+var anomalousIssuances = metrics.NewCounterVec(
+	"controller_anomalous_issuances_total",
+	"Certificates issued to identities whose issuance rate exceeds the bound derived from their TTL.",
+	"role",
+)
+
+// recordIssuance counts a certificate of the given kind issued to spiffeID
+// for a peer of trustDomain and flags the identity when it is issued far
+// more often than its TTL warrants, which points at a crash loop or a
+// replayed identity.
+func (s *EnrollmentServer) recordIssuance(kind, role, trustDomain, spiffeID string, ttl time.Duration) {
+	certificatesIssued.Inc(kind, role, trustDomain)
+	if s.Registry == nil {
+		return
+	}
+	stats := s.Registry.RecordIssuance(spiffeID, ttl)
+	if stats.Anomalous {
+		anomalousIssuances.Inc(role)
+		log.Printf("issuance anomaly: spiffe_id=%s trust_domain=%s issued=%d in the last hour (expected at most %d)", spiffeID, trustDomain, stats.RatePerHour, stats.ExpectedPerHour)
+	}
+}
+
+// checkRenewalRate applies the optional soft per-identity limit: an identity
+// that is already over its expected issuance rate is refused until the rate
+// falls back under the bound.
+func (s *EnrollmentServer) checkRenewalRate(spiffeID string) error {
+	if !s.RenewSoftLimit || s.Registry == nil {
+		return nil
+	}
+	if stats, ok := s.Registry.IssuanceStats(spiffeID); ok && stats.Anomalous {
+		log.Printf("renew rejected: spiffe_id=%s issued=%d in the last hour (expected at most %d)", spiffeID, stats.RatePerHour, stats.ExpectedPerHour)
+		return status.Error(codes.ResourceExhausted, "renewal rate exceeded for this identity")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"controller/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var requestDuration = metrics.NewHistogramVec(
+	"controller_grpc_request_duration_seconds",
+	"Time spent handling unary gRPC requests, by method and status code.",
+	[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	"method", "code",
+)
+
+// UnaryDurationInterceptor records how long each unary RPC takes and logs
+// requests slower than slowThreshold. When the client sent no deadline and
+// maxDuration is positive, the handler runs under a context that expires after
+// maxDuration so a stalled request cannot hold resources indefinitely; client
+// deadlines are left alone. A non-positive slowThreshold disables slow-request
+// logging.
+//
+// It should be the first interceptor in the chain so the recorded duration
+// and the server-side deadline cover authentication and issuance queueing.
+func UnaryDurationInterceptor(maxDuration, slowThreshold time.Duration) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+
+		if _, ok := ctx.Deadline(); !ok && maxDuration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, maxDuration)
+			defer cancel()
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		elapsed := time.Since(start)
+
+		code := status.Code(err)
+		requestDuration.Observe(elapsed.Seconds(), info.FullMethod, code.String())
+		if slowThreshold > 0 && elapsed >= slowThreshold {
+			log.Printf("slow request: method=%s code=%s duration=%s", info.FullMethod, code, elapsed.Round(time.Millisecond))
+		}
+		return resp, err
+	}
+}
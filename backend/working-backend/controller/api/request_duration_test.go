@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryDurationInterceptor(t *testing.T) {
+	intercept := UnaryDurationInterceptor(50*time.Millisecond, 0)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Slow"}
+	stall := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	// Without a client deadline the handler is bounded by maxDuration.
+	before := requestDuration.Count(info.FullMethod, codes.DeadlineExceeded.String())
+	start := time.Now()
+	_, err := intercept(context.Background(), nil, info, stall)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("stalled request returned %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stalled request ran for %s", elapsed)
+	}
+	if got := requestDuration.Count(info.FullMethod, codes.DeadlineExceeded.String()); got != before+1 {
+		t.Fatalf("recorded %d observations, want %d", got, before+1)
+	}
+
+	// A client deadline is left alone, even when longer than maxDuration.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	_, err = intercept(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		if got, ok := ctx.Deadline(); !ok || !got.Equal(want) {
+			t.Errorf("handler deadline = %v, %v; want the client's %v", got, ok, want)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := requestDuration.Count(info.FullMethod, codes.OK.String()); got != 1 {
+		t.Fatalf("recorded %d OK observations, want 1", got)
+	}
+}
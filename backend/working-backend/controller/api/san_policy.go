@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	controllerpb "controller/gen/controllerpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxExtraSANs bounds the additional URI and email SANs per certificate.
+const maxExtraSANs = 4
+
+// ExtraSANPolicy lists the additional URI and email SANs clients may request
+// beside their SPIFFE ID. A nil policy rejects every such request.
+type ExtraSANPolicy struct {
+	URIs   []*regexp.Regexp
+	Emails []*regexp.Regexp
+}
+
+// ParseExtraSANPolicy parses a comma-separated EXTRA_SAN_POLICY value of
+// uri:<pattern> and email:<pattern> entries, where '*' in a pattern matches
+// any run of characters. It returns nil for an empty value.
+func ParseExtraSANPolicy(v string) (*ExtraSANPolicy, error) {
+	var p ExtraSANPolicy
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		typ, pattern, ok := strings.Cut(entry, ":")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("expected uri:<pattern> or email:<pattern>, got %q", entry)
+		}
+		re := sanPattern(pattern)
+		switch typ {
+		case "uri":
+			if strings.HasPrefix(strings.ToLower(pattern), "spiffe:") {
+				return nil, fmt.Errorf("%q: additional URIs must not be SPIFFE IDs", entry)
+			}
+			p.URIs = append(p.URIs, re)
+		case "email":
+			p.Emails = append(p.Emails, regexp.MustCompile("(?i)"+re.String()))
+		default:
+			return nil, fmt.Errorf("unknown SAN type %q in %q (expected uri or email)", typ, entry)
+		}
+	}
+	if len(p.URIs) == 0 && len(p.Emails) == 0 {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func sanPattern(glob string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*") + "$")
+}
+
+func matchAny(patterns []*regexp.Regexp, v string) bool {
+	for _, re := range patterns {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateExtraSANs checks the additional URI and email SANs of req against
+// policy. The SPIFFE ID stays the certificate's only spiffe:// URI.
+func validateExtraSANs(req *controllerpb.EnrollRequest, policy *ExtraSANPolicy) ([]*url.URL, []string, error) {
+	if len(req.GetExtraUris()) == 0 && len(req.GetEmailAddresses()) == 0 {
+		return nil, nil, nil
+	}
+	if len(req.GetExtraUris())+len(req.GetEmailAddresses()) > maxExtraSANs {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "too many extra sans (at most %d)", maxExtraSANs)
+	}
+	if policy == nil {
+		return nil, nil, status.Error(codes.InvalidArgument, "extra sans not permitted by policy")
+	}
+	var uris []*url.URL
+	for _, raw := range req.GetExtraUris() {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" || len(raw) > 2048 {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid extra san uri %q", raw)
+		}
+		if strings.EqualFold(u.Scheme, "spiffe") {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "extra san uri %q must not be a SPIFFE ID", raw)
+		}
+		if !matchAny(policy.URIs, raw) {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "extra san uri %q not permitted by policy", raw)
+		}
+		uris = append(uris, u)
+	}
+	var emails []string
+	for _, raw := range req.GetEmailAddresses() {
+		addr, err := mail.ParseAddress(raw)
+		if err != nil || addr.Name != "" || addr.Address != raw {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid extra san email %q", raw)
+		}
+		if !matchAny(policy.Emails, raw) {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "extra san email %q not permitted by policy", raw)
+		}
+		emails = append(emails, raw)
+	}
+	return uris, emails, nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"sort"
+	"time"
+)
+
+// StreamInfo describes a live control-plane stream.
+type StreamInfo struct {
+	SPIFFEID        string    `json:"spiffe_id"`
+	TrustDomain     string    `json:"trust_domain"`
+	PeerAddr        string    `json:"peer_addr"`
+	ConnectedAt     time.Time `json:"connected_at"`
+	LastMessageAt   time.Time `json:"last_message_at,omitzero"`
+	LastMessageType string    `json:"last_message_type,omitempty"`
+}
+
+// Streams returns the connected control-plane streams sorted by SPIFFE ID.
+func (s *ControlPlaneServer) Streams() []StreamInfo {
+	s.mu.Lock()
+	clients := make([]*connectorClient, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	out := make([]StreamInfo, 0, len(clients))
+	for _, c := range clients {
+		c.lastMu.Lock()
+		out = append(out, StreamInfo{
+			SPIFFEID:        c.spiffeID,
+			TrustDomain:     c.trustDomain,
+			PeerAddr:        c.peerAddr,
+			ConnectedAt:     c.connectedAt,
+			LastMessageAt:   c.lastMessageAt,
+			LastMessageType: c.lastMessageType,
+		})
+		c.lastMu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SPIFFEID < out[j].SPIFFEID })
+	return out
+}
+
+// CloseStream ends the stream of spiffeID with DisconnectAdminClose and
+// reports whether it was connected. The connector reconnects with its usual
+// backoff.
+func (s *ControlPlaneServer) CloseStream(spiffeID string) bool {
+	s.mu.Lock()
+	c, ok := s.clients[spiffeID]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.disconnect(DisconnectAdminClose, "stream closed by an administrator")
+	return true
+}
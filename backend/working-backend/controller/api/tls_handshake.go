@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"controller/metrics"
+	"controller/spiffeid"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Handshake failure reasons, used as the reason label of
+// controller_tls_handshake_failures_total.
+const (
+	HandshakeExpired       = "expired"        // client certificate expired or not yet valid
+	HandshakeUnknownCA     = "unknown_ca"     // client certificate not signed by a trusted CA
+	HandshakeBadCert       = "bad_cert"       // client certificate rejected for another reason
+	HandshakeWrongSPIFFE   = "wrong_spiffe"   // client certificate without a SPIFFE ID in the trust domain
+	HandshakeNoCert        = "no_cert"        // client certificate required but not presented
+	HandshakePeerRejected  = "peer_rejected"  // the client aborted with an alert, e.g. it does not trust our certificate
+	HandshakeTimeout       = "timeout"        // the handshake did not complete in time
+	HandshakeConnClosed    = "conn_closed"    // the client went away mid-handshake (port scans, probes)
+	HandshakeProtocolError = "protocol_error" // not TLS, or no common version or cipher suite
+)
+
+var tlsHandshakeFailures = metrics.NewCounterVec(
+	"controller_tls_handshake_failures_total",
+	"Failed TLS handshakes on the controller's gRPC listeners, by listener and reason.",
+	"listener", "reason",
+)
+
+// handshakeLog logs at most one failure a minute per listener and reason, so
+// a scanner cannot flood the log; the metric counts every failure.
+var handshakeLog = &LogSampler{interval: time.Minute, seen: make(map[string]*sampleState)}
+
+// spiffeHandshakeError is returned by VerifyPeerSPIFFE so the failure can be
+// told apart from the certificate chain errors of crypto/tls.
+type spiffeHandshakeError struct{ err error }
+
+func (e *spiffeHandshakeError) Error() string {
+	return "client certificate SPIFFE ID: " + e.err.Error()
+}
+
+func (e *spiffeHandshakeError) Unwrap() error { return e.err }
+
+// VerifyPeerSPIFFE returns a tls.Config.VerifyConnection callback that fails
+// the handshake when the client presents a verified certificate whose SPIFFE
+// ID is missing, malformed, or outside trustDomain. Connections without a
+// client certificate are left to ClientAuth and the gRPC interceptors.
+func VerifyPeerSPIFFE(trustDomain string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return nil
+		}
+		id, err := spiffeid.FromLeaf(cs.PeerCertificates[0])
+		if err != nil {
+			return &spiffeHandshakeError{err: err}
+		}
+		if id.TrustDomain != trustDomain {
+			return &spiffeHandshakeError{err: fmt.Errorf("trust domain %q, want %q", id.TrustDomain, trustDomain)}
+		}
+		return nil
+	}
+}
+
+// ObserveHandshakes wraps gRPC server transport credentials so every failed
+// TLS handshake is counted in controller_tls_handshake_failures_total and
+// logged with its reason and the peer address. listener names the listener
+// in the metric and the log line.
+func ObserveHandshakes(creds credentials.TransportCredentials, listener string) credentials.TransportCredentials {
+	return &observedCreds{TransportCredentials: creds, listener: listener}
+}
+
+type observedCreds struct {
+	credentials.TransportCredentials
+	listener string
+}
+
+func (c *observedCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ServerHandshake(rawConn)
+	if err != nil {
+		reason := HandshakeReason(err)
+		tlsHandshakeFailures.Inc(c.listener, reason)
+		if handshakeLog.Allow(c.listener + "/" + reason) {
+			log.Printf("tls: %s handshake from %s failed: reason=%s: %v", c.listener, rawConn.RemoteAddr(), reason, err)
+		}
+	}
+	return conn, info, err
+}
+
+func (c *observedCreds) Clone() credentials.TransportCredentials {
+	return &observedCreds{TransportCredentials: c.TransportCredentials.Clone(), listener: c.listener}
+}
+
+// HandshakeReason classifies a server-side TLS handshake error into one of
+// the Handshake* reasons.
+func HandshakeReason(err error) string {
+	var spiffeErr *spiffeHandshakeError
+	var invalid x509.CertificateInvalidError
+	var unknownCA x509.UnknownAuthorityError
+	var verifyErr *tls.CertificateVerificationError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &spiffeErr):
+		return HandshakeWrongSPIFFE
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return HandshakeExpired
+		}
+		return HandshakeBadCert
+	case errors.As(err, &unknownCA):
+		return HandshakeUnknownCA
+	case errors.As(err, &verifyErr):
+		return HandshakeBadCert
+	case strings.Contains(err.Error(), "didn't provide a certificate"):
+		return HandshakeNoCert
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return HandshakePeerRejected
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded):
+		return HandshakeTimeout
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed):
+		return HandshakeConnClosed
+	}
+	return HandshakeProtocolError
+}
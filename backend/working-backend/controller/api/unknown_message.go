@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"controller/metrics"
+)
+
+// connectorMessageTypes are the control messages a connector may send.
+var connectorMessageTypes = map[string]struct{}{
+	"connector_hello":    {},
+	"ping":               {},
+	"heartbeat":          {},
+	"tunneler_heartbeat": {},
+	"allowlist_request":  {},
+}
+
+var controlPlaneUnknownMessages = metrics.NewCounter(
+	"controller_control_plane_unknown_messages_total",
+	"Control messages received from connectors with an unknown type.",
+)
+
+// unknownMessageLog logs unknown message types at most once a minute per
+// connector.
+var unknownMessageLog = &LogSampler{interval: time.Minute, seen: make(map[string]*sampleState)}
+
+// handleUnknownMessage records a message of unknown type. In StrictProtocol
+// mode the stream is closed with protocol_mismatch; otherwise the message is
+// dropped.
+func (s *ControlPlaneServer) handleUnknownMessage(client *connectorClient, spiffeID, msgType string) {
+	controlPlaneUnknownMessages.Inc()
+	if len(msgType) > 64 {
+		msgType = msgType[:64]
+	}
+	if s.StrictProtocol {
+		log.Printf("control-plane stream rejected: %s sent unknown message type %q", spiffeID, msgType)
+		client.disconnect(DisconnectProtocolMismatch, fmt.Sprintf("unknown control message type %q", msgType))
+		return
+	}
+	if unknownMessageLog.Allow(spiffeID) {
+		log.Printf("control-plane: ignoring unknown message type %q from %s", msgType, spiffeID)
+	}
+}
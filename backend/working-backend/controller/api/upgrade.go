@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// upgradeNotice is the upgrade_available payload.
+type upgradeNotice struct {
+	TargetVersion  string `json:"target_version"`
+	CurrentVersion string `json:"current_version"`
+	DownloadURL    string `json:"download_url,omitempty"`
+}
+
+// VersionBehind reports whether current is an older release than target.
+// Versions are compared as dot-separated numbers with an optional "v"
+// prefix; anything after '-' or '+' is ignored. Versions that do not parse,
+// such as "dev", are never considered behind.
+func VersionBehind(current, target string) bool {
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	t, ok := parseVersion(target)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(c) || i < len(t); i++ {
+		var a, b int
+		if i < len(c) {
+			a = c[i]
+		}
+		if i < len(t) {
+			b = t[i]
+		}
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	out := make([]int, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		out = append(out, n)
+	}
+	return out, true
+}
+
+// checkUpgrade tells a connector reporting an older version than
+// TargetVersion that an upgrade is available, once per stream and target.
+func (s *ControlPlaneServer) checkUpgrade(c *connectorClient, connectorID, version string) {
+	target := s.TargetVersion
+	if target == "" || version == "" || c.upgradeNotified == target || !VersionBehind(version, target) {
+		return
+	}
+	payload, err := json.Marshal(upgradeNotice{
+		TargetVersion:  target,
+		CurrentVersion: version,
+		DownloadURL:    s.UpgradeURL,
+	})
+	if err != nil {
+		return
+	}
+	if err := s.send(c, &controllerpb.ControlMessage{Type: "upgrade_available", Payload: payload}); err != nil {
+		return
+	}
+	c.upgradeNotified = target
+	log.Printf("upgrade_available sent: connector_id=%s version=%s target=%s", connectorID, version, target)
+}
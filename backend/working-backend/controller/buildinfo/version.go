@@ -0,0 +1,17 @@
+package buildinfo
+
+import "fmt"
+
+// Build metadata, set at build time with -ldflags, e.g.
+//
+//	-X controller/buildinfo.Version=v1.2.3 -X controller/buildinfo.Commit=$(git rev-parse --short HEAD) -X controller/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// String formats the build metadata for the version command and logs.
+func String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, Date)
+}
@@ -3,13 +3,13 @@ package ca
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
-	"math/big"
 	"time"
 )
 
@@ -18,21 +18,53 @@ import (
 type CA struct {
 	Cert *x509.Certificate
 	Key  crypto.Signer
+
+	// LeafSubject is copied into the subject of workload certificates for
+	// legacy consumers that key off subject DN fields. Only Organization and
+	// OrganizationalUnit are used; the URI SAN remains the identity.
+	LeafSubject pkix.Name
+	// EKUs selects the extended key usages of workload certificates by
+	// role; nil means DefaultEKUPolicy.
+	EKUs EKUPolicy
+
+	// serials holds the serials of unexpired certificates issued by this
+	// CA; see IssueWorkloadCert.
+	serials serialLedger
 }
 
+// Supported CA key algorithms for GenerateSelfSignedCAWithAlgorithm.
+const (
+	KeyAlgorithmECDSA   = "ecdsa"
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
 // GenerateSelfSignedCA creates a standards-compliant CA certificate and key.
 // The CA certificate includes critical BasicConstraints and KeyUsage for cert signing.
 func GenerateSelfSignedCA(commonName string, ttl time.Duration) (certPEM, keyPEM []byte, err error) {
+	return GenerateSelfSignedCAWithAlgorithm(commonName, ttl, KeyAlgorithmECDSA)
+}
+
+// GenerateSelfSignedCAWithAlgorithm is GenerateSelfSignedCA with a choice of
+// CA key algorithm: "ecdsa" (P-256) or "ed25519".
+func GenerateSelfSignedCAWithAlgorithm(commonName string, ttl time.Duration, algorithm string) (certPEM, keyPEM []byte, err error) {
 	if ttl <= 0 {
 		return nil, nil, errors.New("invalid CA TTL")
 	}
 
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var privKey crypto.Signer
+	switch algorithm {
+	case "", KeyAlgorithmECDSA:
+		privKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, privKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, errors.New("unsupported CA key algorithm: " + algorithm)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
 
-	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	serial, err := randomSerial()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -49,7 +81,7 @@ func GenerateSelfSignedCA(commonName string, ttl time.Duration) (certPEM, keyPEM
 		MaxPathLenZero:        true,
 	}
 
-	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &privKey.PublicKey, privKey)
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, privKey.Public(), privKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -66,7 +98,7 @@ func GenerateSelfSignedCA(commonName string, ttl time.Duration) (certPEM, keyPEM
 
 // LoadCA loads and parses the internal CA certificate and private key.
 // certPEM and keyPEM must be PEM-encoded data.
-// The private key must implement crypto.Signer (RSA, ECDSA, TPM-backed, etc.).
+// The private key must implement crypto.Signer (RSA, ECDSA, Ed25519, TPM-backed, etc.).
 func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
 	if len(certPEM) == 0 {
 		return nil, errors.New("CA certificate PEM is empty")
@@ -102,6 +134,13 @@ func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
 		return nil, errors.New("CA private key does not implement crypto.Signer")
 	}
 
+	// A key from a different CA would sign certificates that fail to verify
+	// against the distributed CA certificate; refuse it at load time.
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, errors.New("CA private key does not match the CA certificate's public key")
+	}
+
 	return &CA{
 		Cert: cert,
 		Key:  signer,
@@ -0,0 +1,75 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestIssueWithKeyAlgorithms(t *testing.T) {
+	workloadKeys := map[string]func() (crypto.Signer, error){
+		"ecdsa": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		"ed25519": func() (crypto.Signer, error) {
+			_, k, err := ed25519.GenerateKey(rand.Reader)
+			return k, err
+		},
+	}
+	for _, caAlg := range []string{KeyAlgorithmECDSA, KeyAlgorithmEd25519} {
+		c := newTestCA(t, caAlg)
+		roots := x509.NewCertPool()
+		roots.AddCert(c.Cert)
+		for keyAlg, generate := range workloadKeys {
+			key, err := generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			certPEM, err := IssueWorkloadCert(c, "spiffe://example.org/connector/c1", key.Public(), time.Hour, nil, nil)
+			if err != nil {
+				t.Fatalf("%s CA, %s key: issue: %v", caAlg, keyAlg, err)
+			}
+			block, _ := pem.Decode(certPEM)
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+				t.Errorf("%s CA, %s key: certificate does not verify: %v", caAlg, keyAlg, err)
+			}
+			if pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(key.Public()) {
+				t.Errorf("%s CA, %s key: certificate carries another public key", caAlg, keyAlg)
+			}
+		}
+	}
+}
+
+func TestGenerateSelfSignedCAAlgorithms(t *testing.T) {
+	if _, _, err := GenerateSelfSignedCAWithAlgorithm("ca", time.Hour, "rsa"); err == nil {
+		t.Error("unsupported CA key algorithm accepted")
+	}
+	if c := newTestCA(t, KeyAlgorithmEd25519); c.Cert.PublicKeyAlgorithm != x509.Ed25519 || !c.Cert.IsCA {
+		t.Errorf("ed25519 CA certificate: algorithm %s, IsCA %v", c.Cert.PublicKeyAlgorithm, c.Cert.IsCA)
+	}
+}
+
+func TestLoadCAKeyMismatch(t *testing.T) {
+	certA, keyA, err := GenerateSelfSignedCA("ca a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyB, err := GenerateSelfSignedCA("ca b", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCA(certA, keyA); err != nil {
+		t.Fatalf("matching key refused: %v", err)
+	}
+	if _, err := LoadCA(certA, keyB); err == nil {
+		t.Fatal("CA certificate loaded with another CA's private key")
+	}
+}
@@ -0,0 +1,68 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// EKUPolicy maps a workload role to the extended key usages of the
+// certificates issued to it.
+type EKUPolicy map[string][]x509.ExtKeyUsage
+
+// DefaultEKUPolicy grants each role only the TLS direction it uses:
+// connectors serve tunnelers and dial the controller, tunnelers only dial,
+// and the controller only serves.
+var DefaultEKUPolicy = EKUPolicy{
+	"connector":  {x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	"tunneler":   {x509.ExtKeyUsageClientAuth},
+	"controller": {x509.ExtKeyUsageServerAuth},
+}
+
+var ekuNames = map[string]x509.ExtKeyUsage{
+	"client": x509.ExtKeyUsageClientAuth,
+	"server": x509.ExtKeyUsageServerAuth,
+}
+
+// ParseEKUPolicy returns DefaultEKUPolicy with the roles listed in a
+// CERT_EKU_POLICY value overridden, e.g. "controller=server+client,tunneler=client".
+func ParseEKUPolicy(v string) (EKUPolicy, error) {
+	p := make(EKUPolicy, len(DefaultEKUPolicy))
+	for role, ekus := range DefaultEKUPolicy {
+		p[role] = ekus
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return p, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		role, usages, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		role = strings.TrimSpace(role)
+		if _, known := DefaultEKUPolicy[role]; !ok || !known {
+			return nil, fmt.Errorf("entry %q must be <role>=<usage>[+<usage>] for role connector, tunneler or controller", entry)
+		}
+		var ekus []x509.ExtKeyUsage
+		for _, name := range strings.Split(usages, "+") {
+			eku, ok := ekuNames[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("%s: usage %q must be client or server", role, name)
+			}
+			ekus = append(ekus, eku)
+		}
+		p[role] = ekus
+	}
+	return p, nil
+}
+
+// ekusFor returns the extended key usages for role under the CA's policy.
+func (ca *CA) ekusFor(role string) ([]x509.ExtKeyUsage, error) {
+	policy := ca.EKUs
+	if policy == nil {
+		policy = DefaultEKUPolicy
+	}
+	ekus, ok := policy[role]
+	if !ok || len(ekus) == 0 {
+		return nil, fmt.Errorf("no extended key usage policy for role %q", role)
+	}
+	return ekus, nil
+}
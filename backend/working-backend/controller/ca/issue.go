@@ -4,16 +4,31 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"math/big"
 	"net"
 	"net/url"
+	"strings"
 	"time"
+
+	"controller/spiffeid"
 )
 
+// SANs are the subject alternative names of a workload certificate besides
+// its SPIFFE ID.
+type SANs struct {
+	DNSNames    []string
+	IPAddresses []net.IP
+	// URIs are additional non-SPIFFE URIs; the SPIFFE ID stays the only
+	// spiffe:// URI in the certificate.
+	URIs           []*url.URL
+	EmailAddresses []string
+}
+
 // IssueWorkloadCert issues a short-lived X.509 certificate for a workload.
-// - spiffeID must be a valid SPIFFE URI (spiffe://...)
+// - spiffeID must be a valid SPIFFE ID under spiffeid.Default
 // - pubKey is the workload public key
 // - ttl controls certificate lifetime
 //
@@ -27,51 +42,106 @@ func IssueWorkloadCert(
 	dnsNames []string,
 	ipAddrs []net.IP,
 ) ([]byte, error) {
+	return IssueWorkloadCertSANs(ca, spiffeID, pubKey, ttl, SANs{DNSNames: dnsNames, IPAddresses: ipAddrs})
+}
+
+// IssueWorkloadCertSANs is IssueWorkloadCert with the full set of extra SANs.
+// Like IssueWorkloadCert it does not check the SANs against any policy.
+func IssueWorkloadCertSANs(
+	ca *CA,
+	spiffeID string,
+	pubKey crypto.PublicKey,
+	ttl time.Duration,
+	sans SANs,
+) ([]byte, error) {
+	issued, err := Issue(ca, spiffeID, pubKey, ttl, sans)
+	if err != nil {
+		return nil, err
+	}
+	return issued.PEM, nil
+}
+
+// Issued is a workload certificate returned by Issue, with the fields
+// callers log so that they need not parse the certificate again.
+type Issued struct {
+	PEM      []byte
+	Serial   *big.Int
+	NotAfter time.Time
+}
+
+// Issue is IssueWorkloadCertSANs returning the certificate's serial and
+// expiry along with its PEM. Nearly all of its cost is in
+// x509.CreateCertificate: signing, and verifying the signature it made
+// against the CA public key, which guards against a faulty signer.
+func Issue(
+	ca *CA,
+	spiffeID string,
+	pubKey crypto.PublicKey,
+	ttl time.Duration,
+	sans SANs,
+) (Issued, error) {
 
 	if ca == nil || ca.Cert == nil || ca.Key == nil {
-		return nil, errors.New("CA is not initialized")
+		return Issued{}, errors.New("CA is not initialized")
 	}
 
 	if ttl <= 0 {
-		return nil, errors.New("invalid certificate TTL")
+		return Issued{}, errors.New("invalid certificate TTL")
 	}
 
-	uri, err := url.Parse(spiffeID)
+	id, err := spiffeid.Parse(spiffeID)
 	if err != nil {
-		return nil, err
+		return Issued{}, err
 	}
-
-	if uri.Scheme != "spiffe" {
-		return nil, errors.New("SPIFFE ID must use spiffe:// scheme")
+	ekus, err := ca.ekusFor(id.Role)
+	if err != nil {
+		return Issued{}, err
 	}
-
-	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	uri, err := url.Parse(spiffeID)
 	if err != nil {
-		return nil, err
+		return Issued{}, err
+	}
+	uris := []*url.URL{uri}
+	for _, u := range sans.URIs {
+		if u == nil || strings.EqualFold(u.Scheme, "spiffe") {
+			return Issued{}, errors.New("additional URI SANs must not be SPIFFE IDs")
+		}
+		uris = append(uris, u)
 	}
 
 	now := time.Now()
+	notAfter := now.Add(ttl)
+
+	// Serials are random 159-bit values, checked against the serials of
+	// this CA's unexpired certificates.
+	serial, err := ca.serials.newSerial(notAfter)
+	if err != nil {
+		return Issued{}, err
+	}
 
 	tmpl := x509.Certificate{
 		SerialNumber: serial,
 
 		NotBefore: now.Add(-1 * time.Minute),
-		NotAfter:  now.Add(ttl),
+		NotAfter:  notAfter,
 
 		KeyUsage: x509.KeyUsageDigitalSignature,
 
-		ExtKeyUsage: []x509.ExtKeyUsage{
-			x509.ExtKeyUsageClientAuth,
-			x509.ExtKeyUsageServerAuth,
-		},
+		ExtKeyUsage: ekus,
 
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 
-		// Enforce exactly one URI SAN and no CN.
-		URIs:        []*url.URL{uri},
-		DNSNames:    dnsNames,
-		IPAddresses: ipAddrs,
+		// Enforce exactly one SPIFFE URI SAN and no CN. O/OU are
+		// informational.
+		Subject: pkix.Name{
+			Organization:       ca.LeafSubject.Organization,
+			OrganizationalUnit: ca.LeafSubject.OrganizationalUnit,
+		},
+		URIs:           uris,
+		DNSNames:       sans.DNSNames,
+		IPAddresses:    sans.IPAddresses,
+		EmailAddresses: sans.EmailAddresses,
 	}
 
 	der, err := x509.CreateCertificate(
@@ -82,7 +152,7 @@ func IssueWorkloadCert(
 		ca.Key,
 	)
 	if err != nil {
-		return nil, err
+		return Issued{}, err
 	}
 
 	certPEM := pem.EncodeToMemory(&pem.Block{
@@ -90,5 +160,6 @@ func IssueWorkloadCert(
 		Bytes: der,
 	})
 
-	return certPEM, nil
+	// The certificate encodes NotAfter in UTC to the second.
+	return Issued{PEM: certPEM, Serial: serial, NotAfter: notAfter.UTC().Truncate(time.Second)}, nil
 }
@@ -0,0 +1,41 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func newTestCA(tb testing.TB, algorithm string) *CA {
+	tb.Helper()
+	certPEM, keyPEM, err := GenerateSelfSignedCAWithAlgorithm("test ca", time.Hour, algorithm)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c, err := LoadCA(certPEM, keyPEM)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+func BenchmarkIssueWorkloadCert(b *testing.B) {
+	for _, alg := range []string{KeyAlgorithmECDSA, KeyAlgorithmEd25519} {
+		b.Run(alg, func(b *testing.B) {
+			c := newTestCA(b, alg)
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := IssueWorkloadCert(c, "spiffe://example.org/connector/bench", &key.PublicKey, time.Hour, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
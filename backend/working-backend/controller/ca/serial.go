@@ -0,0 +1,84 @@
+package ca
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// serialBits is the serial number entropy. RFC 5280 caps serials at 20
+	// octets and requires them to be positive, so the top bit of the 20
+	// bytes is left clear; the CA/Browser Forum asks for at least 64 bits.
+	serialBits = 159
+	// maxSerialAttempts bounds regeneration after a collision; a repeat
+	// at 159 bits means the random source is broken.
+	maxSerialAttempts = 4
+	// serialPruneEvery is how many issuances pass between sweeps of
+	// expired serials.
+	serialPruneEvery = 1024
+)
+
+var serialLimit = new(big.Int).Lsh(big.NewInt(1), serialBits)
+
+// serialLedger remembers the serials of certificates issued by a CA that
+// have not yet expired, so a duplicate is regenerated instead of issued.
+type serialLedger struct {
+	mu      sync.Mutex
+	live    map[string]time.Time
+	inserts int
+}
+
+// randomSerial returns a positive serial with serialBits of entropy.
+func randomSerial() (*big.Int, error) {
+	for {
+		serial, err := rand.Int(rand.Reader, serialLimit)
+		if err != nil {
+			return nil, err
+		}
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
+
+// newSerial returns a random serial not held by any unexpired certificate
+// from this ledger and records it until notAfter.
+func (l *serialLedger) newSerial(notAfter time.Time) (*big.Int, error) {
+	for attempt := 0; attempt < maxSerialAttempts; attempt++ {
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, err
+		}
+		if l.reserve(serial, notAfter) {
+			return serial, nil
+		}
+		log.Printf("ca: serial collision on %s; regenerating", serial.Text(16))
+	}
+	return nil, errors.New("could not generate a unique certificate serial")
+}
+
+func (l *serialLedger) reserve(serial *big.Int, notAfter time.Time) bool {
+	key := string(serial.Bytes())
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.live == nil {
+		l.live = make(map[string]time.Time)
+	}
+	if exp, ok := l.live[key]; ok && now.Before(exp) {
+		return false
+	}
+	l.live[key] = notAfter
+	if l.inserts++; l.inserts%serialPruneEvery == 0 {
+		for k, exp := range l.live {
+			if !now.Before(exp) {
+				delete(l.live, k)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package ca
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSerialLedgerReserve(t *testing.T) {
+	var l serialLedger
+	serial := big.NewInt(42)
+	now := time.Now()
+
+	if !l.reserve(serial, now.Add(time.Hour)) {
+		t.Fatal("first reservation refused")
+	}
+	if l.reserve(big.NewInt(42), now.Add(time.Hour)) {
+		t.Fatal("serial held by an unexpired certificate was reserved again")
+	}
+
+	// Once the holder expires the serial may be reused.
+	expired := big.NewInt(7)
+	if !l.reserve(expired, now.Add(-time.Second)) {
+		t.Fatal("first reservation refused")
+	}
+	if !l.reserve(big.NewInt(7), now.Add(time.Hour)) {
+		t.Fatal("serial of an expired certificate was not reusable")
+	}
+}
+
+func TestSerialLedgerPrune(t *testing.T) {
+	var l serialLedger
+	past := time.Now().Add(-time.Minute)
+	for i := 1; i < serialPruneEvery; i++ {
+		l.reserve(big.NewInt(int64(i)), past)
+	}
+	if len(l.live) != serialPruneEvery-1 {
+		t.Fatalf("ledger holds %d serials before the sweep", len(l.live))
+	}
+	l.reserve(big.NewInt(serialPruneEvery), time.Now().Add(time.Hour))
+	if len(l.live) != 1 {
+		t.Fatalf("ledger holds %d serials after the sweep, want only the live one", len(l.live))
+	}
+}
+
+func TestNewSerial(t *testing.T) {
+	var l serialLedger
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		serial, err := l.newSerial(time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial.Sign() <= 0 || serial.BitLen() > serialBits {
+			t.Fatalf("serial %s out of range", serial.Text(16))
+		}
+		if seen[serial.Text(16)] {
+			t.Fatalf("duplicate serial %s", serial.Text(16))
+		}
+		seen[serial.Text(16)] = true
+	}
+}
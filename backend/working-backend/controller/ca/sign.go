@@ -0,0 +1,51 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// SignBlob signs data with the CA key. Ed25519 keys sign data directly;
+// ECDSA and RSA keys sign its SHA-256 digest.
+func SignBlob(ca *CA, data []byte) ([]byte, error) {
+	if ca == nil || ca.Key == nil {
+		return nil, errors.New("CA key unavailable")
+	}
+	if _, ok := ca.Key.Public().(ed25519.PublicKey); ok {
+		return ca.Key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return ca.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// VerifyBlob checks a SignBlob signature against the CA certificate.
+func VerifyBlob(cert *x509.Certificate, data, sig []byte) error {
+	if cert == nil {
+		return errors.New("CA certificate unavailable")
+	}
+	digest := sha256.Sum256(data)
+	switch pub := cert.PublicKey.(type) {
+	case ed25519.PublicKey:
+		if ed25519.Verify(pub, data, sig) {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported CA key type %T", cert.PublicKey)
+	}
+	return errors.New("signature verification failed")
+}
@@ -0,0 +1,49 @@
+package ca
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+)
+
+// maxSubjectFieldLength is the X.520 upper bound for O and OU.
+const maxSubjectFieldLength = 64
+
+// ParseLeafSubject builds CA.LeafSubject from the CERT_SUBJECT_O and
+// CERT_SUBJECT_OU values. Empty values leave the field empty.
+func ParseLeafSubject(org, orgUnit string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, f := range []struct {
+		env string
+		v   string
+		dst *[]string
+	}{
+		{"CERT_SUBJECT_O", org, &name.Organization},
+		{"CERT_SUBJECT_OU", orgUnit, &name.OrganizationalUnit},
+	} {
+		v := strings.TrimSpace(f.v)
+		if v == "" {
+			continue
+		}
+		if err := validateSubjectField(v); err != nil {
+			return pkix.Name{}, fmt.Errorf("%s: %w", f.env, err)
+		}
+		*f.dst = []string{v}
+	}
+	return name, nil
+}
+
+// validateSubjectField limits v to the PrintableString character set so it
+// encodes the same way for every consumer.
+func validateSubjectField(v string) error {
+	if len(v) > maxSubjectFieldLength {
+		return fmt.Errorf("must be at most %d characters", maxSubjectFieldLength)
+	}
+	for _, r := range v {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(" '()+,-./:=?", r) {
+			continue
+		}
+		return fmt.Errorf("invalid character %q (letters, digits, spaces and '()+,-./:=? are allowed)", r)
+	}
+	return nil
+}
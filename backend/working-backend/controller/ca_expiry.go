@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/x509"
+	"log"
+	"time"
+
+	"controller/metrics"
+)
+
+// caExpiryCheckInterval is how often the CA's remaining validity is checked.
+const caExpiryCheckInterval = time.Hour
+
+// caExpiryLevels are the warning thresholds, most severe first. Entering a
+// level is logged at once; the warning then repeats every repeat interval.
+var caExpiryLevels = []struct {
+	within time.Duration
+	label  string
+	repeat time.Duration
+}{
+	{0, "CRITICAL: internal CA certificate has expired", time.Hour},
+	{7 * 24 * time.Hour, "CRITICAL: internal CA certificate expires soon", time.Hour},
+	{30 * 24 * time.Hour, "WARNING: internal CA certificate expires soon", 24 * time.Hour},
+	{90 * 24 * time.Hour, "NOTICE: internal CA certificate expires soon", 7 * 24 * time.Hour},
+}
+
+// monitorCAExpiry exports controller_ca_seconds_until_expiry and logs
+// escalating warnings as the CA approaches NotAfter. Every certificate the
+// controller issues chains to the CA, so its expiry breaks all mTLS at once.
+func monitorCAExpiry(cert *x509.Certificate) {
+	metrics.NewGaugeFunc(
+		"controller_ca_seconds_until_expiry",
+		"Seconds until the internal CA certificate expires; negative once it has.",
+		func() float64 { return time.Until(cert.NotAfter).Seconds() },
+	)
+
+	level := -1
+	var lastLogged time.Time
+	check := func() {
+		remaining := time.Until(cert.NotAfter)
+		current := -1
+		for i, l := range caExpiryLevels {
+			if remaining <= l.within {
+				current = i
+				break
+			}
+		}
+		if current < 0 {
+			level = -1
+			return
+		}
+		if current == level && time.Since(lastLogged) < caExpiryLevels[current].repeat {
+			return
+		}
+		level, lastLogged = current, time.Now()
+		log.Printf("%s: not_after=%s remaining=%s sha256=%s; rotate the CA",
+			caExpiryLevels[current].label, cert.NotAfter.UTC().Format(time.RFC3339), remaining.Round(time.Minute), caFingerprint(cert))
+	}
+
+	check()
+	go func() {
+		ticker := time.NewTicker(caExpiryCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			check()
+		}
+	}()
+}
@@ -0,0 +1,297 @@
+// Package config reads the controller configuration from the environment
+// and, optionally, a KEY=VALUE file named by CONTROLLER_CONFIG_FILE. The
+// environment takes precedence over the file. Variable names are the ones
+// documented in docs/controller.md.
+package config
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"controller/api"
+	"controller/ca"
+	"controller/envconfig"
+	"controller/spiffeid"
+	"controller/state"
+)
+
+// FileEnv names the optional config file.
+const FileEnv = "CONTROLLER_CONFIG_FILE"
+
+// Config is the controller configuration. Load fills in defaults and
+// validates every value; files it names (CA, tokens, certificates) are read
+// by the components that use them.
+type Config struct {
+	// CA material: PEM from INTERNAL_CA_CERT/INTERNAL_CA_KEY, else the files
+	// CA_CERT_FILE/CA_KEY_FILE (empty means the ca/ defaults).
+	CACertPEM  []byte
+	CAKeyPEM   []byte
+	CACertFile string
+	CAKeyFile  string
+
+	TrustDomain  string
+	SPIFFEPolicy spiffeid.Policy
+	// LeafSubject and EKUs shape issued certificates (CERT_SUBJECT_O,
+	// CERT_SUBJECT_OU, CERT_EKU_POLICY).
+	LeafSubject pkix.Name
+	EKUs        ca.EKUPolicy
+
+	// Controller serving certificate: CONTROLLER_CERT/CONTROLLER_KEY, else
+	// one issued at startup for CONTROLLER_ID. SNICertFiles is the raw
+	// CONTROLLER_SNI_CERT_FILES list of cert:key pairs.
+	ControllerCertPEM []byte
+	ControllerKeyPEM  []byte
+	ControllerID      string
+	SNICertFiles      string
+	BootstrapAddr     string
+	GRPCReflection    bool
+
+	LogLevel              string
+	PeerLogLevel          string
+	PeerLogRedactSubject  bool
+	PeerIdentityCacheSize int
+
+	AdminAddr              string
+	AdminTLS               bool
+	AdminTLSCertFile       string
+	AdminTLSKeyFile        string
+	AdminAuthToken         string
+	AdminAuthTokenFile     string
+	AdminReadOnlyToken     string
+	AdminReadOnlyTokenFile string
+	InternalAPIToken       string
+	InternalAPITokenFile   string
+	AuthTokenGrace         time.Duration
+	BreakGlassTokenFile    string
+	BreakGlassFactorFile   string
+	AdminShutdownTimeout   time.Duration
+	TokenStorePath         string
+	MaxTokenTTL            time.Duration
+	GRPCMaxHandlerDuration time.Duration
+	GRPCSlowRequest        time.Duration
+	MaxConcurrentIssuance  int
+
+	HeartbeatLogSample     string
+	ControlPlaneStrict     bool
+	AllowlistResync        time.Duration
+	AllowlistRequestMin    time.Duration
+	TargetConnectorVersion string
+	ConnectorUpgradeURL    string
+	AcceptLimit            int
+	AcceptRetryAfter       time.Duration
+	MaxControlPlaneStreams int
+	ClockSkewThreshold     time.Duration
+	Compression            string
+	DeadLetterLogPath      string
+	DeadLetterLogMaxBytes  int64
+
+	AllowedDNSSuffixes   []string
+	ExtraSANs            *api.ExtraSANPolicy
+	AllowedKeyAlgorithms api.KeyAlgorithms
+	EnforceKeyRotation   bool
+	RequirePrivateIP     bool
+	RenewSoftLimit       bool
+	RenewAgents          map[string][]string
+	MaxDailyIssuance     int
+	IdempotencyWindow    time.Duration
+	IdempotencyMax       int
+	EnrollSignResponses  bool
+
+	// EnrollMode is api.EnrollModeToken, EnrollModeApproval or
+	// EnrollModeAttest. The attestation settings apply to the last only.
+	EnrollMode          string
+	EnrollAttestor      string
+	AttestAWSCert       string
+	AttestAWSAccounts   string
+	AttestTokenOptional bool
+	// EnrollAuth is api.EnrollAuthToken, EnrollAuthBootstrapCert or
+	// EnrollAuthBoth; the last two need a bootstrap CA and BootstrapAddr.
+	EnrollAuth string
+	// Bootstrap CA bundle: PEM from BOOTSTRAP_CA, else the file
+	// BOOTSTRAP_CA_FILE.
+	BootstrapCAPEM  []byte
+	BootstrapCAFile string
+
+	WebhookURL    string
+	WebhookSecret string
+
+	rendered string
+}
+
+// Load reads and validates the configuration. The error lists every invalid
+// variable.
+func Load() (*Config, error) {
+	l, err := envconfig.New(FileEnv)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+
+	c.CACertPEM = []byte(l.Raw("INTERNAL_CA_CERT", false))
+	c.CAKeyPEM = []byte(l.Raw("INTERNAL_CA_KEY", true))
+	c.CACertFile = l.Str("CA_CERT_FILE", "")
+	c.CAKeyFile = l.Str("CA_KEY_FILE", "")
+
+	c.TrustDomain = strings.TrimSuffix(l.Str("TRUST_DOMAIN", "mycorp.internal"), ".")
+	if err := spiffeid.ValidateTrustDomain(c.TrustDomain); err != nil {
+		l.Fail("TRUST_DOMAIN", err)
+	}
+	c.SPIFFEPolicy = spiffeid.Policy{
+		MaxLength: l.Int("SPIFFE_ID_MAX_LENGTH", spiffeid.DefaultMaxLength),
+		Strict:    l.Bool("SPIFFE_ID_STRICT", false),
+	}
+	if n := c.SPIFFEPolicy.MaxLength; n < 1 || n > spiffeid.DefaultMaxLength {
+		l.Fail("SPIFFE_ID_MAX_LENGTH", fmt.Errorf("must be between 1 and %d, got %d", spiffeid.DefaultMaxLength, n))
+	}
+	if c.LeafSubject, err = ca.ParseLeafSubject(l.Str("CERT_SUBJECT_O", ""), l.Str("CERT_SUBJECT_OU", "")); err != nil {
+		l.Fail("certificate subject", err)
+	}
+	if c.EKUs, err = ca.ParseEKUPolicy(l.Str("CERT_EKU_POLICY", "")); err != nil {
+		l.Fail("CERT_EKU_POLICY", err)
+	}
+
+	c.ControllerCertPEM = []byte(l.Raw("CONTROLLER_CERT", false))
+	c.ControllerKeyPEM = []byte(l.Raw("CONTROLLER_KEY", true))
+	c.ControllerID = l.Str("CONTROLLER_ID", "default")
+	c.SNICertFiles = l.Str("CONTROLLER_SNI_CERT_FILES", "")
+	c.BootstrapAddr = l.Str("BOOTSTRAP_LISTEN_ADDR", "")
+	c.GRPCReflection = l.Bool("GRPC_REFLECTION", false)
+
+	if c.LogLevel, err = api.ParseLogLevel(l.Str("LOG_LEVEL", ""), api.LogLevelInfo, false); err != nil {
+		l.Fail("LOG_LEVEL", err)
+	}
+	if c.PeerLogLevel, err = api.ParseLogLevel(l.Str("PEER_LOG_LEVEL", ""), api.LogLevelDebug, true); err != nil {
+		l.Fail("PEER_LOG_LEVEL", err)
+	}
+	c.PeerLogRedactSubject = l.Bool("PEER_LOG_REDACT_SUBJECT", false)
+	c.PeerIdentityCacheSize = l.Int("PEER_IDENTITY_CACHE_SIZE", 1024)
+
+	c.AdminAddr = l.Str("ADMIN_HTTP_ADDR", ":8081")
+	c.AdminTLS = l.Bool("ADMIN_TLS", false)
+	c.AdminTLSCertFile = l.Str("ADMIN_TLS_CERT_FILE", "")
+	c.AdminTLSKeyFile = l.Str("ADMIN_TLS_KEY_FILE", "")
+	if (c.AdminTLSCertFile == "") != (c.AdminTLSKeyFile == "") {
+		l.Fail("ADMIN_TLS_CERT_FILE", fmt.Errorf("must be set together with ADMIN_TLS_KEY_FILE"))
+	}
+	if c.AdminTLSCertFile != "" && !c.AdminTLS {
+		l.Fail("ADMIN_TLS_CERT_FILE", fmt.Errorf("requires ADMIN_TLS=true"))
+	}
+	c.AdminAuthToken = l.Raw("ADMIN_AUTH_TOKEN", true)
+	c.AdminAuthTokenFile = l.Str("ADMIN_AUTH_TOKEN_FILE", "")
+	c.AdminReadOnlyToken = l.Raw("ADMIN_READONLY_TOKEN", true)
+	c.AdminReadOnlyTokenFile = l.Str("ADMIN_READONLY_TOKEN_FILE", "")
+	if c.AdminReadOnlyToken != "" && c.AdminReadOnlyToken == c.AdminAuthToken {
+		l.Fail("ADMIN_READONLY_TOKEN", fmt.Errorf("must differ from ADMIN_AUTH_TOKEN"))
+	}
+	c.InternalAPIToken = l.Raw("INTERNAL_API_TOKEN", true)
+	c.InternalAPITokenFile = l.Str("INTERNAL_API_TOKEN_FILE", "")
+	c.AuthTokenGrace = l.Duration("AUTH_TOKEN_GRACE", 5*time.Minute)
+	c.BreakGlassTokenFile = l.Str("BREAK_GLASS_TOKEN_FILE", "")
+	c.BreakGlassFactorFile = l.Str("BREAK_GLASS_FACTOR_FILE", "")
+	if c.BreakGlassFactorFile != "" && c.BreakGlassTokenFile == "" {
+		l.Fail("BREAK_GLASS_FACTOR_FILE", fmt.Errorf("requires BREAK_GLASS_TOKEN_FILE"))
+	}
+	c.AdminShutdownTimeout = l.Duration("ADMIN_SHUTDOWN_TIMEOUT", 30*time.Second)
+	c.TokenStorePath = l.Str("TOKEN_STORE_PATH", "/var/lib/grpccontroller/tokens.json")
+	c.MaxTokenTTL = l.Duration("MAX_TOKEN_TTL", state.DefaultMaxTokenTTL)
+	if c.MaxTokenTTL <= 0 {
+		l.Fail("MAX_TOKEN_TTL", fmt.Errorf("must be positive"))
+	}
+	c.GRPCMaxHandlerDuration = l.Duration("GRPC_MAX_HANDLER_DURATION", 30*time.Second)
+	c.GRPCSlowRequest = l.Duration("GRPC_SLOW_REQUEST_THRESHOLD", time.Second)
+	c.MaxConcurrentIssuance = l.Int("MAX_CONCURRENT_ISSUANCE", 0)
+
+	c.HeartbeatLogSample = l.Str("HEARTBEAT_LOG_SAMPLE", "")
+	if _, err := api.NewLogSampler(c.HeartbeatLogSample); err != nil {
+		l.Fail("HEARTBEAT_LOG_SAMPLE", err)
+	}
+	c.ControlPlaneStrict = l.Bool("CONTROL_PLANE_STRICT", false)
+	c.AllowlistResync = l.Duration("ALLOWLIST_RESYNC_INTERVAL", 5*time.Minute)
+	c.AllowlistRequestMin = l.Duration("ALLOWLIST_REQUEST_MIN_INTERVAL", api.DefaultAllowlistRequestInterval)
+	c.TargetConnectorVersion = l.Str("TARGET_CONNECTOR_VERSION", "")
+	c.ConnectorUpgradeURL = l.Str("CONNECTOR_UPGRADE_URL", "")
+	if u := c.ConnectorUpgradeURL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			l.Fail("CONNECTOR_UPGRADE_URL", fmt.Errorf("%q must be an absolute URL", u))
+		}
+	}
+	c.AcceptLimit = l.Int("CONTROL_PLANE_ACCEPT_LIMIT", 0)
+	c.AcceptRetryAfter = l.Duration("CONTROL_PLANE_RETRY_AFTER", 5*time.Second)
+	c.MaxControlPlaneStreams = l.Int("MAX_CONTROL_PLANE_STREAMS", 0)
+	if c.MaxControlPlaneStreams < 0 {
+		l.Fail("MAX_CONTROL_PLANE_STREAMS", fmt.Errorf("must not be negative"))
+	}
+	c.ClockSkewThreshold = l.Duration("CLOCK_SKEW_THRESHOLD", 30*time.Second)
+	if c.Compression = l.OneOf("CONTROL_PLANE_COMPRESSION", "none", "none", api.CompressionGzip); c.Compression == "none" {
+		c.Compression = ""
+	}
+	c.DeadLetterLogPath = l.Str("DEAD_LETTER_LOG_PATH", "")
+	c.DeadLetterLogMaxBytes = int64(l.Int("DEAD_LETTER_LOG_MAX_BYTES", api.DefaultDeadLetterMaxBytes))
+
+	c.AllowedDNSSuffixes = api.ParseDNSSuffixes(l.Str("ALLOWED_DNS_SUFFIXES", ""))
+	if c.ExtraSANs, err = api.ParseExtraSANPolicy(l.Str("EXTRA_SAN_POLICY", "")); err != nil {
+		l.Fail("EXTRA_SAN_POLICY", err)
+	}
+	if c.AllowedKeyAlgorithms, err = api.ParseKeyAlgorithms(l.Str("ALLOWED_KEY_ALGORITHMS", "")); err != nil {
+		l.Fail("ALLOWED_KEY_ALGORITHMS", err)
+	}
+	c.EnforceKeyRotation = l.Bool("ENFORCE_KEY_ROTATION", false)
+	c.RequirePrivateIP = l.Bool("REQUIRE_PRIVATE_IP", true)
+	c.RenewSoftLimit = l.Bool("RENEW_SOFT_LIMIT", false)
+	if c.RenewAgents, err = api.ParseRenewAgents(c.TrustDomain, l.Str("BATCH_RENEW_AGENTS", "")); err != nil {
+		l.Fail("BATCH_RENEW_AGENTS", err)
+	}
+	c.MaxDailyIssuance = l.Int("MAX_DAILY_ISSUANCE", 0)
+	// ENROLL_RETRY_WINDOW is the older name of IDEMPOTENCY_WINDOW.
+	c.IdempotencyWindow = l.Duration("IDEMPOTENCY_WINDOW", l.Duration("ENROLL_RETRY_WINDOW", 5*time.Minute))
+	if c.IdempotencyWindow < 0 {
+		l.Fail("IDEMPOTENCY_WINDOW", fmt.Errorf("must not be negative"))
+	}
+	if c.IdempotencyMax = l.Int("IDEMPOTENCY_MAX_ENTRIES", 10000); c.IdempotencyMax <= 0 {
+		l.Fail("IDEMPOTENCY_MAX_ENTRIES", fmt.Errorf("must be positive"))
+	}
+	c.EnrollSignResponses = l.Bool("ENROLL_SIGN_RESPONSES", true)
+
+	c.EnrollMode = l.OneOf("ENROLL_MODE", api.EnrollModeToken, api.EnrollModeToken, api.EnrollModeApproval, api.EnrollModeAttest)
+	if c.EnrollMode == api.EnrollModeAttest {
+		if c.EnrollAttestor = l.OneOf("ENROLL_ATTESTOR", "none", "none", api.AttestationAWSIID); c.EnrollAttestor == "none" {
+			c.EnrollAttestor = ""
+		}
+		c.AttestAWSCert = l.Str("ATTEST_AWS_CERT", "")
+		c.AttestAWSAccounts = l.Str("ATTEST_AWS_ACCOUNTS", "")
+		c.AttestTokenOptional = l.Bool("ATTEST_TOKEN_OPTIONAL", false)
+		if c.AttestTokenOptional && c.EnrollAttestor == "" {
+			l.Fail("ATTEST_TOKEN_OPTIONAL", fmt.Errorf("requires an ENROLL_ATTESTOR"))
+		}
+	}
+	c.EnrollAuth = l.OneOf("ENROLL_AUTH", api.EnrollAuthToken, api.EnrollAuthToken, api.EnrollAuthBootstrapCert, api.EnrollAuthBoth)
+	if c.EnrollAuth != api.EnrollAuthToken {
+		c.BootstrapCAPEM = []byte(l.Raw("BOOTSTRAP_CA", false))
+		if c.BootstrapCAFile = l.Str("BOOTSTRAP_CA_FILE", ""); c.BootstrapCAFile == "" && len(c.BootstrapCAPEM) == 0 {
+			l.Fail("ENROLL_AUTH", fmt.Errorf("%s requires BOOTSTRAP_CA or BOOTSTRAP_CA_FILE", c.EnrollAuth))
+		}
+		// Bootstrap certificates are only trusted on the enrollment-only
+		// listener, which serves no workload RPCs.
+		if c.BootstrapAddr == "" {
+			l.Fail("ENROLL_AUTH", fmt.Errorf("%s requires BOOTSTRAP_LISTEN_ADDR", c.EnrollAuth))
+		}
+	}
+
+	c.WebhookURL = l.Str("WEBHOOK_URL", "")
+	c.WebhookSecret = l.Raw("WEBHOOK_SECRET", true)
+
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	c.rendered = l.Render()
+	return c, nil
+}
+
+// String lists the variables that were set as NAME=value pairs, with
+// secrets such as tokens and private keys replaced by envconfig.Redacted, for logging.
+func (c *Config) String() string {
+	return c.rendered
+}
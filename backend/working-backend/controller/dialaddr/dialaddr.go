@@ -0,0 +1,79 @@
+// Package dialaddr validates and normalizes host:port dial addresses such as
+// CONTROLLER_ADDR, with errors that point at the usual mistakes. The
+// connector and tunneler share it for their address settings.
+package dialaddr
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Parse validates value as a host:port address and returns it in canonical
+// form: surrounding space removed, IP literals normalized and IPv6 hosts
+// bracketed. name is the setting reported in errors.
+func Parse(name, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	if scheme, _, ok := strings.Cut(value, "://"); ok {
+		return "", fmt.Errorf("%s=%q must be host:port without a scheme (did you include %s://?)", name, value, scheme)
+	}
+	if strings.Contains(value, "/") {
+		return "", fmt.Errorf("%s=%q must be host:port without a path", name, value)
+	}
+	if !strings.HasPrefix(value, "[") && strings.Count(value, ":") > 1 {
+		return "", fmt.Errorf("%s=%q: IPv6 addresses must be bracketed as [address]:port, e.g. [fd00::1]:8443", name, value)
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		if strings.Contains(err.Error(), "missing port") {
+			return "", fmt.Errorf("%s=%q is missing a port, e.g. %s", name, value, net.JoinHostPort(strings.Trim(value, "[]"), "8443"))
+		}
+		return "", fmt.Errorf("%s=%q must be host:port: %v", name, value, err)
+	}
+	if host == "" {
+		return "", fmt.Errorf("%s=%q is missing a host", name, value)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("%s=%q: port must be a number between 1 and 65535", name, value)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Host returns the host part of an address returned by Parse.
+func Host(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// UnixPrefix marks a Unix domain socket address, e.g. unix:/run/connector.sock.
+const UnixPrefix = "unix:"
+
+// ParseUnix validates value as unix:/absolute/path and returns it with the
+// path cleaned. name is the setting reported in errors.
+func ParseUnix(name, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	path, ok := strings.CutPrefix(value, UnixPrefix)
+	if !ok {
+		return "", fmt.Errorf("%s=%q is not a unix: address", name, value)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s=%q: socket path must be absolute, e.g. unix:/run/connector.sock", name, value)
+	}
+	return UnixPrefix + filepath.Clean(path), nil
+}
+
+// UnixPath returns the socket path of a unix: address and whether addr is one.
+func UnixPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, UnixPrefix)
+}
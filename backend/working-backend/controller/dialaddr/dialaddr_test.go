@@ -0,0 +1,80 @@
+package dialaddr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr string
+	}{
+		{value: "controller.internal:8443", want: "controller.internal:8443"},
+		{value: "  10.0.0.1:8443\n", want: "10.0.0.1:8443"},
+		{value: "[fd00::1]:8443", want: "[fd00::1]:8443"},
+		{value: "[FD00:0:0::1]:443", want: "[fd00::1]:443"},
+		{value: "[::ffff:10.0.0.1]:8443", want: "10.0.0.1:8443"},
+		{value: "localhost:1", want: "localhost:1"},
+		{value: "localhost:65535", want: "localhost:65535"},
+
+		{value: "", wantErr: "is not set"},
+		{value: "controller.internal", wantErr: "missing a port, e.g. controller.internal:8443"},
+		{value: "10.0.0.1", wantErr: "missing a port, e.g. 10.0.0.1:8443"},
+		{value: "[fd00::1]", wantErr: "missing a port, e.g. [fd00::1]:8443"},
+		{value: "fd00::1", wantErr: "must be bracketed"},
+		{value: "fd00::1:8443", wantErr: "must be bracketed"},
+		{value: "https://controller.internal:8443", wantErr: "did you include https://?"},
+		{value: "controller.internal:8443/api", wantErr: "without a path"},
+		{value: ":8443", wantErr: "missing a host"},
+		{value: "controller.internal:0", wantErr: "between 1 and 65535"},
+		{value: "controller.internal:65536", wantErr: "between 1 and 65535"},
+		{value: "controller.internal:https", wantErr: "between 1 and 65535"},
+	}
+	for _, tt := range tests {
+		got, err := Parse("CONTROLLER_ADDR", tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want one containing %q", tt.value, err, tt.wantErr)
+			}
+			if err != nil && !strings.HasPrefix(err.Error(), "CONTROLLER_ADDR") {
+				t.Errorf("Parse(%q) error %q does not name the setting", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %q, %v; want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	for addr, want := range map[string]string{
+		"controller.internal:8443": "controller.internal",
+		"[fd00::1]:8443":           "fd00::1",
+		"no-port":                  "",
+	} {
+		if got := Host(addr); got != want {
+			t.Errorf("Host(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestParseUnix(t *testing.T) {
+	got, err := ParseUnix("LISTEN_ADDR", " unix:/run//connector/../connector.sock ")
+	if err != nil || got != "unix:/run/connector.sock" {
+		t.Fatalf("ParseUnix = %q, %v; want unix:/run/connector.sock", got, err)
+	}
+	if path, ok := UnixPath(got); !ok || path != "/run/connector.sock" {
+		t.Fatalf("UnixPath(%q) = %q, %v", got, path, ok)
+	}
+	for _, value := range []string{"unix:run/connector.sock", "/run/connector.sock", "localhost:8443"} {
+		if _, err := ParseUnix("LISTEN_ADDR", value); err == nil {
+			t.Errorf("ParseUnix(%q) succeeded", value)
+		}
+	}
+	if _, ok := UnixPath("localhost:8443"); ok {
+		t.Error("UnixPath accepted a host:port address")
+	}
+}
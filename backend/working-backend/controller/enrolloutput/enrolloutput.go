@@ -0,0 +1,203 @@
+// Package enrolloutput writes the identity issued by an enroll subcommand
+// to a directory or standard output, as PEM files or a PKCS#12 bundle. The
+// connector and tunneler enroll commands share it.
+package enrolloutput
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Output formats for the enroll --output-format flag.
+const (
+	OutputPEM    = "pem"
+	OutputPKCS12 = "pkcs12"
+)
+
+// outputStdout as --output-dir writes the bundle to standard output.
+const outputStdout = "-"
+
+// File names written to --output-dir.
+const (
+	outputCertFile = "cert.pem"
+	outputKeyFile  = "key.pem"
+	outputCAFile   = "ca.pem"
+	pkcs12File     = "identity.p12"
+)
+
+// Output describes where enroll writes the issued identity. An empty Dir
+// discards it, as before.
+type Output struct {
+	Dir    string
+	Format string
+	// Password protects the PKCS#12 bundle.
+	Password string
+}
+
+// ParseFlags parses the enroll subcommand flags. The PKCS#12 password is
+// the ENROLL_OUTPUT_PASSWORD credential, looked up with readCredential,
+// else password.
+func ParseFlags(args []string, password string, readCredential func(name string) (string, error)) (Output, error) {
+	fs := flag.NewFlagSet("enroll", flag.ContinueOnError)
+	var out Output
+	fs.StringVar(&out.Dir, "output-dir", "", `directory to write the issued certificate, key and CA to, or "-" for stdout`)
+	fs.StringVar(&out.Format, "output-format", OutputPEM, "output format: pem or pkcs12")
+	if err := fs.Parse(args); err != nil {
+		return Output{}, err
+	}
+	if fs.NArg() > 0 {
+		return Output{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	switch out.Format {
+	case OutputPEM:
+	case OutputPKCS12:
+		cred, err := readCredential("ENROLL_OUTPUT_PASSWORD")
+		if err != nil {
+			return Output{}, err
+		}
+		if cred != "" {
+			password = cred
+		}
+		if password == "" {
+			return Output{}, errors.New("ENROLL_OUTPUT_PASSWORD is required for --output-format=pkcs12")
+		}
+		out.Password = password
+	default:
+		return Output{}, fmt.Errorf("--output-format must be pem or pkcs12, got %q", out.Format)
+	}
+	if out.Dir != "" && out.Dir != outputStdout {
+		if err := checkOutputDir(out.Dir); err != nil {
+			return Output{}, err
+		}
+		out.Dir = filepath.Clean(out.Dir)
+	}
+	return out, nil
+}
+
+// ToStdout reports whether the bundle goes to standard output, in which case
+// status messages must go elsewhere.
+func (o Output) ToStdout() bool {
+	return o.Dir == outputStdout
+}
+
+// checkOutputDir refuses an output directory that is not a directory or that
+// other users can write to, since they could replace the key.
+func checkOutputDir(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("--output-dir %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("--output-dir %s is writable by group or others (mode %04o)", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// Write stores cert, its key and the CA according to o. Files are created
+// with mode 0600 and replaced atomically.
+func (o Output) Write(cert tls.Certificate, certPEM, caPEM []byte) error {
+	if o.Dir == "" {
+		return nil
+	}
+	files, err := o.encode(cert, certPEM, caPEM)
+	if err != nil {
+		return err
+	}
+	if o.ToStdout() {
+		for _, f := range files {
+			if _, err := os.Stdout.Write(f.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := os.MkdirAll(o.Dir, 0o700); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(o.Dir, f.name), f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type outputFile struct {
+	name string
+	data []byte
+}
+
+func (o Output) encode(cert tls.Certificate, certPEM, caPEM []byte) ([]outputFile, error) {
+	if o.Format == OutputPKCS12 {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse issued certificate: %w", err)
+		}
+		var caCerts []*x509.Certificate
+		for rest := caPEM; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			ca, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse CA certificate: %w", err)
+			}
+			caCerts = append(caCerts, ca)
+		}
+		data, err := pkcs12.Modern.Encode(cert.PrivateKey, leaf, caCerts, o.Password)
+		if err != nil {
+			return nil, fmt.Errorf("encode PKCS#12: %w", err)
+		}
+		return []outputFile{{pkcs12File, data}}, nil
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return []outputFile{
+		{outputCertFile, certPEM},
+		{outputKeyFile, keyPEM},
+		{outputCAFile, caPEM},
+	}, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
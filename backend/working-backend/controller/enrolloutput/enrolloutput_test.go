@@ -0,0 +1,87 @@
+package enrolloutput
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/ca"
+)
+
+func noCredential(string) (string, error) { return "", nil }
+
+func TestParseFlags(t *testing.T) {
+	if _, err := ParseFlags([]string{"--output-format=pkcs12"}, "", noCredential); err == nil {
+		t.Error("pkcs12 output accepted without a password")
+	}
+	out, err := ParseFlags([]string{"--output-format=pkcs12"}, "env", func(string) (string, error) { return "cred", nil })
+	if err != nil || out.Password != "cred" {
+		t.Errorf("pkcs12 password = %q, %v; want the credential", out.Password, err)
+	}
+	if _, err := ParseFlags([]string{"--output-format=der"}, "", noCredential); err == nil {
+		t.Error("unknown output format accepted")
+	}
+
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFlags([]string{"--output-dir", shared}, "", noCredential); err == nil {
+		t.Error("world-writable output directory accepted")
+	}
+}
+
+func TestWritePEM(t *testing.T) {
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("enrolloutput test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafPEM, err := ca.IssueWorkloadCert(c, "spiffe://example.org/connector/c1", &key.PublicKey, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(leafPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "out")
+	out, err := ParseFlags([]string{"--output-dir", dir}, "", noCredential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(cert, leafPEM, certPEM); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{outputCertFile, outputKeyFile, outputCAFile} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("%s mode = %v, want 0600", name, info.Mode().Perm())
+		}
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, outputCertFile), filepath.Join(dir, outputKeyFile)); err != nil {
+		t.Errorf("written cert and key do not load: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Package enrollresponse defines the freshness fields of an EnrollResponse:
+// the controller stamps and signs them, and connectors and tunnelers check
+// them so a stale or replayed response is refused.
+package enrollresponse
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+)
+
+const (
+	// NonceSize is the size of the nonce clients send in an EnrollRequest.
+	NonceSize = 16
+	// sigPrefix separates response signatures from other ca.SignBlob
+	// signatures, such as trust bundles.
+	sigPrefix = "grpccontroller enroll-response\x00"
+	// maxSkewEnv is the client setting that bounds server_time skew.
+	maxSkewEnv = "ENROLL_RESPONSE_MAX_SKEW"
+)
+
+// NewNonce returns a random request nonce.
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate enrollment nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// Payload is what EnrollResponse.signature covers: a fixed prefix, the
+// echoed nonce, server_time as a big-endian int64 and the SHA-256 of the
+// certificate PEM.
+func Payload(nonce []byte, serverTime int64, certPEM []byte) []byte {
+	certSum := sha256.Sum256(certPEM)
+	b := make([]byte, 0, len(sigPrefix)+4+len(nonce)+8+len(certSum))
+	b = append(b, sigPrefix...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(nonce)))
+	b = append(b, nonce...)
+	b = binary.BigEndian.AppendUint64(b, uint64(serverTime))
+	return append(b, certSum[:]...)
+}
+
+// Check rejects an EnrollResponse that was not produced for this request: a
+// server_time outside maxSkew, a nonce other than the one sent, or a
+// signature that does not verify against trustedCAPEM. Responses from
+// controllers that predate these fields carry no server_time and are
+// accepted as before.
+func Check(resp *controllerpb.EnrollResponse, nonce, trustedCAPEM []byte, maxSkew time.Duration) error {
+	if resp.GetServerTime() == 0 {
+		return nil
+	}
+	skew := time.Since(time.UnixMilli(resp.GetServerTime()))
+	if skew < -maxSkew || skew > maxSkew {
+		return fmt.Errorf("enrollment response is stale or from the future: controller time differs by %s (max %s, %s)", skew.Round(time.Second), maxSkew, maxSkewEnv)
+	}
+	if !bytes.Equal(resp.GetNonce(), nonce) {
+		return errors.New("enrollment response does not echo this request's nonce; it may be replayed")
+	}
+	if len(resp.GetSignature()) == 0 {
+		return nil
+	}
+	payload := Payload(resp.GetNonce(), resp.GetServerTime(), resp.GetCertificate())
+	if err := verify(trustedCAPEM, payload, resp.GetSignature()); err != nil {
+		return fmt.Errorf("enrollment response signature: %w", err)
+	}
+	return nil
+}
+
+// verify checks a ca.SignBlob signature against each CA certificate in
+// caPEM.
+func verify(caPEM, data, sig []byte) error {
+	for rest := caPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return errors.New("not signed by a trusted controller CA")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if ca.VerifyBlob(cert, data, sig) == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package enrollresponse
+
+import (
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+)
+
+func TestCheck(t *testing.T) {
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("enrollresponse test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM, _, err := ca.GenerateSelfSignedCA("other ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Cert.Raw})
+
+	nonce, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	respond := func(serverTime time.Time, nonce []byte) *controllerpb.EnrollResponse {
+		resp := &controllerpb.EnrollResponse{Certificate: []byte("cert"), ServerTime: serverTime.UnixMilli(), Nonce: nonce}
+		sig, err := ca.SignBlob(signer, Payload(resp.Nonce, resp.ServerTime, resp.Certificate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Signature = sig
+		return resp
+	}
+	tampered := respond(time.Now(), nonce)
+	tampered.Certificate = []byte("other cert")
+
+	tests := []struct {
+		name    string
+		resp    *controllerpb.EnrollResponse
+		trusted []byte
+		ok      bool
+	}{
+		{"fresh", respond(time.Now(), nonce), trusted, true},
+		{"legacy controller", &controllerpb.EnrollResponse{Certificate: []byte("cert")}, trusted, true},
+		{"stale", respond(time.Now().Add(-time.Hour), nonce), trusted, false},
+		{"from the future", respond(time.Now().Add(time.Hour), nonce), trusted, false},
+		{"other nonce", respond(time.Now(), []byte("replayed nonce!!")), trusted, false},
+		{"tampered certificate", tampered, trusted, false},
+		{"untrusted signer", respond(time.Now(), nonce), otherPEM, false},
+	}
+	for _, tt := range tests {
+		err := Check(tt.resp, nonce, tt.trusted, 5*time.Minute)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Check = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
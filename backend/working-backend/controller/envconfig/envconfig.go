@@ -0,0 +1,285 @@
+// Package envconfig reads settings from the environment, falling back to an
+// optional KEY=VALUE file, and collects every invalid value so a Load
+// function can report them together. The controller, connector and tunneler
+// config packages share it.
+package envconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"controller/dialaddr"
+)
+
+// Redacted replaces secret values in Render.
+const Redacted = "<redacted>"
+
+// Loader reads settings from the environment, falling back to the config
+// file. Each getter records invalid values instead of failing; Err returns
+// them all.
+type Loader struct {
+	file map[string]string
+	errs []error
+	// set holds the variables that were set, rendered for String.
+	set map[string]string
+}
+
+// New returns a Loader backed by the config file named by the environment
+// variable fileEnv, if set.
+func New(fileEnv string) (*Loader, error) {
+	file, err := loadFile(fileEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &Loader{file: file, set: make(map[string]string)}, nil
+}
+
+// lookup returns the value of name: the environment wins over the config
+// file, and an empty value counts as unset.
+func (l *Loader) lookup(name string) (string, bool) {
+	if v := os.Getenv(name); v != "" {
+		return v, true
+	}
+	if v := l.file[name]; v != "" {
+		return v, true
+	}
+	return "", false
+}
+
+// Raw returns the untrimmed value of name, e.g. PEM material or a token.
+// Only its length is rendered unless it is secret.
+func (l *Loader) Raw(name string, secret bool) string {
+	v, ok := l.lookup(name)
+	if ok {
+		l.record(name, fmt.Sprintf("<%d bytes>", len(v)), secret)
+	}
+	return v
+}
+
+// Str returns the trimmed value of name, or def when unset.
+func (l *Loader) Str(name, def string) string {
+	v, ok := l.lookup(name)
+	if !ok {
+		return def
+	}
+	v = strings.TrimSpace(v)
+	l.record(name, v, false)
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// Int returns the integer value of name, or def when unset.
+func (l *Loader) Int(name string, def int) int {
+	v := l.Str(name, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.Fail(name, err)
+		return def
+	}
+	return n
+}
+
+// Bool returns the boolean value of name, or def when unset.
+func (l *Loader) Bool(name string, def bool) bool {
+	v := l.Str(name, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.Fail(name, err)
+		return def
+	}
+	return b
+}
+
+// Duration returns the duration value of name, or def when unset.
+func (l *Loader) Duration(name string, def time.Duration) time.Duration {
+	v := l.Str(name, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.Fail(name, err)
+		return def
+	}
+	return d
+}
+
+// Positive is Duration for settings that must be greater than zero.
+func (l *Loader) Positive(name string, def time.Duration) time.Duration {
+	d := l.Duration(name, def)
+	if d <= 0 {
+		l.Fail(name, fmt.Errorf("must be positive, got %s", d))
+		return def
+	}
+	return d
+}
+
+// List returns the non-empty entries of a comma-separated value.
+func (l *Loader) List(name string) []string {
+	var out []string
+	for _, v := range strings.Split(l.Str(name, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Addrs returns the comma-separated dial addresses in name, each validated
+// by dialaddr.Parse.
+func (l *Loader) Addrs(name string) []string {
+	var out []string
+	for _, v := range l.List(name) {
+		addr, err := dialaddr.Parse(name, v)
+		if err != nil {
+			l.errs = append(l.errs, err)
+			continue
+		}
+		out = append(out, addr)
+	}
+	return out
+}
+
+// Addr returns the dial address in name, validated by dialaddr.Parse, or ""
+// when unset.
+func (l *Loader) Addr(name string) string {
+	v := l.Str(name, "")
+	if v == "" {
+		return ""
+	}
+	addr, err := dialaddr.Parse(name, v)
+	if err != nil {
+		l.errs = append(l.errs, err)
+		return ""
+	}
+	return addr
+}
+
+// OneOf returns the value of name, which must be one of allowed (compared
+// case-insensitively); def is returned when unset.
+func (l *Loader) OneOf(name, def string, allowed ...string) string {
+	v := l.Str(name, def)
+	for _, a := range allowed {
+		if strings.EqualFold(v, a) {
+			return a
+		}
+	}
+	l.Fail(name, fmt.Errorf("%q is not one of %s", v, strings.Join(allowed, ", ")))
+	return def
+}
+
+// Require reports name as not set unless a non-empty value was read for it.
+func (l *Loader) Require(name string) {
+	if l.set[name] == "" {
+		l.errs = append(l.errs, fmt.Errorf("%s is not set", name))
+	}
+}
+
+// Fail records that the value of name is invalid.
+func (l *Loader) Fail(name string, err error) {
+	l.errs = append(l.errs, fmt.Errorf("invalid %s: %w", name, err))
+}
+
+// Add records err, already naming its variable, as a load error.
+func (l *Loader) Add(err error) {
+	l.errs = append(l.errs, err)
+}
+
+// Values returns the rendered value of each variable that was set.
+func (l *Loader) Values() map[string]string {
+	return l.set
+}
+
+func (l *Loader) record(name, v string, secret bool) {
+	if secret {
+		v = Redacted
+	}
+	l.set[name] = v
+}
+
+// Render formats the recorded variables as sorted NAME=value pairs.
+func (l *Loader) Render() string {
+	names := make([]string, 0, len(l.set))
+	for name := range l.set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		v := l.set[name]
+		if strings.ContainsAny(v, " \n\"") {
+			v = strconv.Quote(v)
+		}
+		pairs = append(pairs, name+"="+v)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// readEnvFile parses a KEY=VALUE file in the format systemd's
+// EnvironmentFile= accepts: blank lines and lines starting with # or ; are
+// skipped, an "export " prefix is ignored, and a value may be wrapped in
+// single or double quotes.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// loadFile reads the config file named by the environment variable env, if
+// set.
+func loadFile(env string) (map[string]string, error) {
+	path := strings.TrimSpace(os.Getenv(env))
+	if path == "" {
+		return nil, nil
+	}
+	values, err := readEnvFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env, err)
+	}
+	return values, nil
+}
+
+// Err joins every error recorded while loading, or returns nil.
+func (l *Loader) Err() error {
+	return errors.Join(l.errs...)
+}
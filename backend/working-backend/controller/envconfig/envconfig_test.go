@@ -0,0 +1,79 @@
+package envconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.env")
+	file := `# comment
+; also a comment
+export TEST_NAME="from file"
+TEST_TOKEN='s3cret'
+TEST_TIMEOUT=5s
+TEST_ADDRS=a.internal:1, b.internal:2
+TEST_COUNT=not-a-number
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CONFIG_FILE", path)
+	t.Setenv("TEST_TIMEOUT", "2s")
+
+	l, err := New("TEST_CONFIG_FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Str("TEST_NAME", ""); got != "from file" {
+		t.Errorf("TEST_NAME = %q, want the quoted file value", got)
+	}
+	if got := l.Raw("TEST_TOKEN", true); got != "s3cret" {
+		t.Errorf("TEST_TOKEN = %q, want s3cret", got)
+	}
+	if got := l.Positive("TEST_TIMEOUT", time.Minute); got != 2*time.Second {
+		t.Errorf("TEST_TIMEOUT = %s, want the environment's 2s", got)
+	}
+	if got := l.Addrs("TEST_ADDRS"); len(got) != 2 || got[1] != "b.internal:2" {
+		t.Errorf("TEST_ADDRS = %q", got)
+	}
+	if got := l.Int("TEST_COUNT", 7); got != 7 {
+		t.Errorf("invalid TEST_COUNT = %d, want the default 7", got)
+	}
+	if got := l.OneOf("TEST_MODE", "open", "open", "closed"); got != "open" {
+		t.Errorf("unset TEST_MODE = %q, want the default", got)
+	}
+	l.Require("TEST_MISSING")
+
+	err = l.Err()
+	if err == nil {
+		t.Fatal("Err() = nil, want the invalid and missing variables")
+	}
+	for _, want := range []string{"invalid TEST_COUNT", "TEST_MISSING is not set"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Err() = %v, want it to mention %q", err, want)
+		}
+	}
+
+	rendered := l.Render()
+	if strings.Contains(rendered, "s3cret") || !strings.Contains(rendered, "TEST_TOKEN="+Redacted) {
+		t.Errorf("Render() = %s, want TEST_TOKEN redacted", rendered)
+	}
+	if !strings.Contains(rendered, `TEST_NAME="from file"`) {
+		t.Errorf("Render() = %s, want TEST_NAME quoted", rendered)
+	}
+}
+
+func TestNewBadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.env")
+	if err := os.WriteFile(path, []byte("NOT A PAIR\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CONFIG_FILE", path)
+	if _, err := New("TEST_CONFIG_FILE"); err == nil || !strings.Contains(err.Error(), "expected KEY=VALUE") {
+		t.Errorf("New() error = %v, want a KEY=VALUE error", err)
+	}
+}
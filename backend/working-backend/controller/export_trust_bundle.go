@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"controller/ca"
+	"controller/config"
+	"controller/trustbundle"
+)
+
+// runExportTrustBundle implements "controller export-trust-bundle": it
+// writes the internal CA certificate, plus any --add-ca certificates, and the
+// trust domain as a bundle signed by the CA key. Clients verify it against
+// the pinned CA fingerprint before installing the CA. It returns the process
+// exit code.
+func runExportTrustBundle(args []string, stdout, stderr io.Writer) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid configuration: %v\n", err)
+		return 2
+	}
+	fs := flag.NewFlagSet("export-trust-bundle", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("out", "", "file to write the bundle to (default stdout)")
+	addCA := fs.String("add-ca", "", "PEM file of further CA certificates to include, e.g. the next CA during a rotation")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: controller export-trust-bundle [--out <file>] [--add-ca <file>]")
+		return 2
+	}
+
+	caCertPEM, caKeyPEM := cfg.CACertPEM, cfg.CAKeyPEM
+	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
+		caCertPEM, caKeyPEM = loadCAFromFiles(cfg, caCertPEM, caKeyPEM)
+	}
+	caInst, err := ca.LoadCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		fmt.Fprintf(stderr, "load internal CA: %v\n", err)
+		return 1
+	}
+	bundlePEM := append([]byte(nil), caCertPEM...)
+	if *addCA != "" {
+		extra, err := os.ReadFile(*addCA)
+		if err != nil {
+			fmt.Fprintf(stderr, "read --add-ca: %v\n", err)
+			return 1
+		}
+		bundlePEM = append(bundlePEM, extra...)
+	}
+
+	bundle, err := trustbundle.Sign(caInst, cfg.TrustDomain, bundlePEM, time.Now())
+	if err != nil {
+		fmt.Fprintf(stderr, "sign trust bundle: %v\n", err)
+		return 1
+	}
+	bundle = append(bundle, '\n')
+	if *out == "" {
+		stdout.Write(bundle)
+	} else if err := os.WriteFile(*out, bundle, 0o644); err != nil {
+		fmt.Fprintf(stderr, "write bundle: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "trust bundle for %s signed by CA sha256:%s; pin this fingerprint on clients\n", cfg.TrustDomain, trustbundle.Fingerprint(caInst.Cert))
+	return 0
+}
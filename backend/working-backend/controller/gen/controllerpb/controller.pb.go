@@ -22,12 +22,27 @@ const (
 )
 
 type EnrollRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	PublicKey     []byte                 `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
-	Token         string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
-	PrivateIp     string                 `protobuf:"bytes,4,opt,name=private_ip,json=privateIp,proto3" json:"private_ip,omitempty"`
-	Version       string                 `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PublicKey []byte                 `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	Token     string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
+	PrivateIp string                 `protobuf:"bytes,4,opt,name=private_ip,json=privateIp,proto3" json:"private_ip,omitempty"`
+	Version   string                 `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
+	DnsNames  []string               `protobuf:"bytes,6,rep,name=dns_names,json=dnsNames,proto3" json:"dns_names,omitempty"`
+	// Random value chosen by the client and echoed in EnrollResponse.nonce,
+	// so the client can tell a response to this request from a replayed one.
+	Nonce []byte `protobuf:"bytes,7,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// Additional non-SPIFFE URI SANs, e.g. a legacy identity during a
+	// migration. Issued only where the controller's EXTRA_SAN_POLICY allows.
+	ExtraUris []string `protobuf:"bytes,8,rep,name=extra_uris,json=extraUris,proto3" json:"extra_uris,omitempty"`
+	// Email SANs, under the same policy.
+	EmailAddresses []string `protobuf:"bytes,9,rep,name=email_addresses,json=emailAddresses,proto3" json:"email_addresses,omitempty"`
+	// Where the client runs, as read from its cloud instance metadata
+	// service. Self-reported and informational only.
+	Metadata *EnrollMetadata `protobuf:"bytes,10,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	// Signed proof of the client's platform identity, checked by the
+	// controller's attestor when ENROLL_MODE=attest.
+	Attestation   *Attestation `protobuf:"bytes,11,opt,name=attestation,proto3" json:"attestation,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -97,17 +112,192 @@ func (x *EnrollRequest) GetVersion() string {
 	return ""
 }
 
+func (x *EnrollRequest) GetDnsNames() []string {
+	if x != nil {
+		return x.DnsNames
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetExtraUris() []string {
+	if x != nil {
+		return x.ExtraUris
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetEmailAddresses() []string {
+	if x != nil {
+		return x.EmailAddresses
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetMetadata() *EnrollMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetAttestation() *Attestation {
+	if x != nil {
+		return x.Attestation
+	}
+	return nil
+}
+
+// Attestation carries a platform-signed identity document.
+type Attestation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Document format, e.g. "aws-iid" for an EC2 instance identity document.
+	Type          string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Document      []byte `protobuf:"bytes,2,opt,name=document,proto3" json:"document,omitempty"`
+	Signature     []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Attestation) Reset() {
+	*x = Attestation{}
+	mi := &file_controller_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Attestation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Attestation) ProtoMessage() {}
+
+func (x *Attestation) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Attestation.ProtoReflect.Descriptor instead.
+func (*Attestation) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Attestation) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Attestation) GetDocument() []byte {
+	if x != nil {
+		return x.Document
+	}
+	return nil
+}
+
+func (x *Attestation) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// EnrollMetadata describes how an enrolling client was provisioned. Each
+// field is at most 128 bytes.
+type EnrollMetadata struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Cloud provider: "aws", "gcp" or "azure".
+	Provider      string `protobuf:"bytes,1,opt,name=provider,proto3" json:"provider,omitempty"`
+	InstanceId    string `protobuf:"bytes,2,opt,name=instance_id,json=instanceId,proto3" json:"instance_id,omitempty"`
+	Region        string `protobuf:"bytes,3,opt,name=region,proto3" json:"region,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollMetadata) Reset() {
+	*x = EnrollMetadata{}
+	mi := &file_controller_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollMetadata) ProtoMessage() {}
+
+func (x *EnrollMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollMetadata.ProtoReflect.Descriptor instead.
+func (*EnrollMetadata) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *EnrollMetadata) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+func (x *EnrollMetadata) GetInstanceId() string {
+	if x != nil {
+		return x.InstanceId
+	}
+	return ""
+}
+
+func (x *EnrollMetadata) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
 type EnrollResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Certificate   []byte                 `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
 	CaCertificate []byte                 `protobuf:"bytes,2,opt,name=ca_certificate,json=caCertificate,proto3" json:"ca_certificate,omitempty"`
+	// Controller clock, Unix milliseconds, when the response was produced.
+	// Zero from controllers that predate it.
+	ServerTime int64 `protobuf:"varint,3,opt,name=server_time,json=serverTime,proto3" json:"server_time,omitempty"`
+	// The request's nonce, echoed.
+	Nonce []byte `protobuf:"bytes,4,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// Optional CA signature over nonce, server_time and certificate; see
+	// enrollresponse.Payload.
+	Signature     []byte `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *EnrollResponse) Reset() {
 	*x = EnrollResponse{}
-	mi := &file_controller_proto_msgTypes[1]
+	mi := &file_controller_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -119,7 +309,7 @@ func (x *EnrollResponse) String() string {
 func (*EnrollResponse) ProtoMessage() {}
 
 func (x *EnrollResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_controller_proto_msgTypes[1]
+	mi := &file_controller_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -132,7 +322,7 @@ func (x *EnrollResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use EnrollResponse.ProtoReflect.Descriptor instead.
 func (*EnrollResponse) Descriptor() ([]byte, []int) {
-	return file_controller_proto_rawDescGZIP(), []int{1}
+	return file_controller_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *EnrollResponse) GetCertificate() []byte {
@@ -149,20 +339,209 @@ func (x *EnrollResponse) GetCaCertificate() []byte {
 	return nil
 }
 
-type ControlMessage struct {
+func (x *EnrollResponse) GetServerTime() int64 {
+	if x != nil {
+		return x.ServerTime
+	}
+	return 0
+}
+
+func (x *EnrollResponse) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *EnrollResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// BatchRenewRequest carries one Renew request per identity. Each is
+// authorized and checked on its own: the caller must hold that id or be a
+// configured renewal agent for it.
+type BatchRenewRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
-	Payload       []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
-	ConnectorId   string                 `protobuf:"bytes,3,opt,name=connector_id,json=connectorId,proto3" json:"connector_id,omitempty"`
-	PrivateIp     string                 `protobuf:"bytes,4,opt,name=private_ip,json=privateIp,proto3" json:"private_ip,omitempty"`
-	Status        string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	Requests      []*EnrollRequest       `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchRenewRequest) Reset() {
+	*x = BatchRenewRequest{}
+	mi := &file_controller_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchRenewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRenewRequest) ProtoMessage() {}
+
+func (x *BatchRenewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRenewRequest.ProtoReflect.Descriptor instead.
+func (*BatchRenewRequest) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchRenewRequest) GetRequests() []*EnrollRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type BatchRenewResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Set when renewal succeeded.
+	Response *EnrollResponse `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
+	// gRPC status code and message when renewal failed; code is 0 on success.
+	Code          uint32 `protobuf:"varint,3,opt,name=code,proto3" json:"code,omitempty"`
+	Error         string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchRenewResult) Reset() {
+	*x = BatchRenewResult{}
+	mi := &file_controller_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchRenewResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRenewResult) ProtoMessage() {}
+
+func (x *BatchRenewResult) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRenewResult.ProtoReflect.Descriptor instead.
+func (*BatchRenewResult) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchRenewResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *BatchRenewResult) GetResponse() *EnrollResponse {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *BatchRenewResult) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *BatchRenewResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type BatchRenewResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Results in request order.
+	Results       []*BatchRenewResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchRenewResponse) Reset() {
+	*x = BatchRenewResponse{}
+	mi := &file_controller_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchRenewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRenewResponse) ProtoMessage() {}
+
+func (x *BatchRenewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRenewResponse.ProtoReflect.Descriptor instead.
+func (*BatchRenewResponse) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BatchRenewResponse) GetResults() []*BatchRenewResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type ControlMessage struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Type        string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Payload     []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	ConnectorId string                 `protobuf:"bytes,3,opt,name=connector_id,json=connectorId,proto3" json:"connector_id,omitempty"`
+	PrivateIp   string                 `protobuf:"bytes,4,opt,name=private_ip,json=privateIp,proto3" json:"private_ip,omitempty"`
+	Status      string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	// Sender wall clock in Unix milliseconds, used for clock-skew detection.
+	ClientTime int64 `protobuf:"varint,6,opt,name=client_time,json=clientTime,proto3" json:"client_time,omitempty"`
+	// Connector tunneler-facing listen address, reported on heartbeats.
+	ListenAddr string `protobuf:"bytes,7,opt,name=listen_addr,json=listenAddr,proto3" json:"listen_addr,omitempty"`
+	// Connector software version, reported on connector_hello and heartbeats.
+	Version       string `protobuf:"bytes,8,opt,name=version,proto3" json:"version,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ControlMessage) Reset() {
 	*x = ControlMessage{}
-	mi := &file_controller_proto_msgTypes[2]
+	mi := &file_controller_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -174,7 +553,7 @@ func (x *ControlMessage) String() string {
 func (*ControlMessage) ProtoMessage() {}
 
 func (x *ControlMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_controller_proto_msgTypes[2]
+	mi := &file_controller_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -187,7 +566,7 @@ func (x *ControlMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ControlMessage.ProtoReflect.Descriptor instead.
 func (*ControlMessage) Descriptor() ([]byte, []int) {
-	return file_controller_proto_rawDescGZIP(), []int{2}
+	return file_controller_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *ControlMessage) GetType() string {
@@ -225,11 +604,173 @@ func (x *ControlMessage) GetStatus() string {
 	return ""
 }
 
+func (x *ControlMessage) GetClientTime() int64 {
+	if x != nil {
+		return x.ClientTime
+	}
+	return 0
+}
+
+func (x *ControlMessage) GetListenAddr() string {
+	if x != nil {
+		return x.ListenAddr
+	}
+	return ""
+}
+
+func (x *ControlMessage) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+type ResolveConnectorRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectorId   string                 `protobuf:"bytes,1,opt,name=connector_id,json=connectorId,proto3" json:"connector_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveConnectorRequest) Reset() {
+	*x = ResolveConnectorRequest{}
+	mi := &file_controller_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveConnectorRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveConnectorRequest) ProtoMessage() {}
+
+func (x *ResolveConnectorRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveConnectorRequest.ProtoReflect.Descriptor instead.
+func (*ResolveConnectorRequest) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ResolveConnectorRequest) GetConnectorId() string {
+	if x != nil {
+		return x.ConnectorId
+	}
+	return ""
+}
+
+type ResolveConnectorResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveConnectorResponse) Reset() {
+	*x = ResolveConnectorResponse{}
+	mi := &file_controller_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveConnectorResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveConnectorResponse) ProtoMessage() {}
+
+func (x *ResolveConnectorResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveConnectorResponse.ProtoReflect.Descriptor instead.
+func (*ResolveConnectorResponse) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ResolveConnectorResponse) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type TunnelFrame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Backend name from CONNECTOR_BACKENDS; only read on the first frame.
+	Target        string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	Data          []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TunnelFrame) Reset() {
+	*x = TunnelFrame{}
+	mi := &file_controller_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TunnelFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TunnelFrame) ProtoMessage() {}
+
+func (x *TunnelFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_controller_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TunnelFrame.ProtoReflect.Descriptor instead.
+func (*TunnelFrame) Descriptor() ([]byte, []int) {
+	return file_controller_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *TunnelFrame) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *TunnelFrame) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 var File_controller_proto protoreflect.FileDescriptor
 
 const file_controller_proto_rawDesc = "" +
 	"\n" +
-	"\x10controller.proto\x12\rcontroller.v1\"\x8d\x01\n" +
+	"\x10controller.proto\x12\rcontroller.v1\"\x81\x03\n" +
 	"\rEnrollRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1d\n" +
 	"\n" +
@@ -237,23 +778,70 @@ const file_controller_proto_rawDesc = "" +
 	"\x05token\x18\x03 \x01(\tR\x05token\x12\x1d\n" +
 	"\n" +
 	"private_ip\x18\x04 \x01(\tR\tprivateIp\x12\x18\n" +
-	"\aversion\x18\x05 \x01(\tR\aversion\"Y\n" +
+	"\aversion\x18\x05 \x01(\tR\aversion\x12\x1b\n" +
+	"\tdns_names\x18\x06 \x03(\tR\bdnsNames\x12\x14\n" +
+	"\x05nonce\x18\a \x01(\fR\x05nonce\x12\x1d\n" +
+	"\n" +
+	"extra_uris\x18\b \x03(\tR\textraUris\x12'\n" +
+	"\x0femail_addresses\x18\t \x03(\tR\x0eemailAddresses\x129\n" +
+	"\bmetadata\x18\n" +
+	" \x01(\v2\x1d.controller.v1.EnrollMetadataR\bmetadata\x12<\n" +
+	"\vattestation\x18\v \x01(\v2\x1a.controller.v1.AttestationR\vattestation\"[\n" +
+	"\vAttestation\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x1a\n" +
+	"\bdocument\x18\x02 \x01(\fR\bdocument\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature\"e\n" +
+	"\x0eEnrollMetadata\x12\x1a\n" +
+	"\bprovider\x18\x01 \x01(\tR\bprovider\x12\x1f\n" +
+	"\vinstance_id\x18\x02 \x01(\tR\n" +
+	"instanceId\x12\x16\n" +
+	"\x06region\x18\x03 \x01(\tR\x06region\"\xae\x01\n" +
 	"\x0eEnrollResponse\x12 \n" +
 	"\vcertificate\x18\x01 \x01(\fR\vcertificate\x12%\n" +
-	"\x0eca_certificate\x18\x02 \x01(\fR\rcaCertificate\"\x98\x01\n" +
+	"\x0eca_certificate\x18\x02 \x01(\fR\rcaCertificate\x12\x1f\n" +
+	"\vserver_time\x18\x03 \x01(\x03R\n" +
+	"serverTime\x12\x14\n" +
+	"\x05nonce\x18\x04 \x01(\fR\x05nonce\x12\x1c\n" +
+	"\tsignature\x18\x05 \x01(\fR\tsignature\"M\n" +
+	"\x11BatchRenewRequest\x128\n" +
+	"\brequests\x18\x01 \x03(\v2\x1c.controller.v1.EnrollRequestR\brequests\"\x87\x01\n" +
+	"\x10BatchRenewResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x129\n" +
+	"\bresponse\x18\x02 \x01(\v2\x1d.controller.v1.EnrollResponseR\bresponse\x12\x12\n" +
+	"\x04code\x18\x03 \x01(\rR\x04code\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"O\n" +
+	"\x12BatchRenewResponse\x129\n" +
+	"\aresults\x18\x01 \x03(\v2\x1f.controller.v1.BatchRenewResultR\aresults\"\xf4\x01\n" +
 	"\x0eControlMessage\x12\x12\n" +
 	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
 	"\apayload\x18\x02 \x01(\fR\apayload\x12!\n" +
 	"\fconnector_id\x18\x03 \x01(\tR\vconnectorId\x12\x1d\n" +
 	"\n" +
 	"private_ip\x18\x04 \x01(\tR\tprivateIp\x12\x16\n" +
-	"\x06status\x18\x05 \x01(\tR\x06status2\xf8\x01\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x1f\n" +
+	"\vclient_time\x18\x06 \x01(\x03R\n" +
+	"clientTime\x12\x1f\n" +
+	"\vlisten_addr\x18\a \x01(\tR\n" +
+	"listenAddr\x12\x18\n" +
+	"\aversion\x18\b \x01(\tR\aversion\"<\n" +
+	"\x17ResolveConnectorRequest\x12!\n" +
+	"\fconnector_id\x18\x01 \x01(\tR\vconnectorId\"4\n" +
+	"\x18ResolveConnectorResponse\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\"9\n" +
+	"\vTunnelFrame\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data2\xcb\x02\n" +
 	"\x11EnrollmentService\x12N\n" +
 	"\x0fEnrollConnector\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse\x12M\n" +
 	"\x0eEnrollTunneler\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse\x12D\n" +
-	"\x05Renew\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse2[\n" +
+	"\x05Renew\x12\x1c.controller.v1.EnrollRequest\x1a\x1d.controller.v1.EnrollResponse\x12Q\n" +
+	"\n" +
+	"BatchRenew\x12 .controller.v1.BatchRenewRequest\x1a!.controller.v1.BatchRenewResponse2\xc0\x01\n" +
 	"\fControlPlane\x12K\n" +
-	"\aConnect\x12\x1d.controller.v1.ControlMessage\x1a\x1d.controller.v1.ControlMessage(\x010\x01B*Z(controller/gen/controllerpb;controllerpbb\x06proto3"
+	"\aConnect\x12\x1d.controller.v1.ControlMessage\x1a\x1d.controller.v1.ControlMessage(\x010\x01\x12c\n" +
+	"\x10ResolveConnector\x12&.controller.v1.ResolveConnectorRequest\x1a'.controller.v1.ResolveConnectorResponse2S\n" +
+	"\rTunnelService\x12B\n" +
+	"\x04Open\x12\x1a.controller.v1.TunnelFrame\x1a\x1a.controller.v1.TunnelFrame(\x010\x01B*Z(controller/gen/controllerpb;controllerpbb\x06proto3"
 
 var (
 	file_controller_proto_rawDescOnce sync.Once
@@ -267,26 +855,45 @@ func file_controller_proto_rawDescGZIP() []byte {
 	return file_controller_proto_rawDescData
 }
 
-var file_controller_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_controller_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_controller_proto_goTypes = []any{
-	(*EnrollRequest)(nil),  // 0: controller.v1.EnrollRequest
-	(*EnrollResponse)(nil), // 1: controller.v1.EnrollResponse
-	(*ControlMessage)(nil), // 2: controller.v1.ControlMessage
+	(*EnrollRequest)(nil),            // 0: controller.v1.EnrollRequest
+	(*Attestation)(nil),              // 1: controller.v1.Attestation
+	(*EnrollMetadata)(nil),           // 2: controller.v1.EnrollMetadata
+	(*EnrollResponse)(nil),           // 3: controller.v1.EnrollResponse
+	(*BatchRenewRequest)(nil),        // 4: controller.v1.BatchRenewRequest
+	(*BatchRenewResult)(nil),         // 5: controller.v1.BatchRenewResult
+	(*BatchRenewResponse)(nil),       // 6: controller.v1.BatchRenewResponse
+	(*ControlMessage)(nil),           // 7: controller.v1.ControlMessage
+	(*ResolveConnectorRequest)(nil),  // 8: controller.v1.ResolveConnectorRequest
+	(*ResolveConnectorResponse)(nil), // 9: controller.v1.ResolveConnectorResponse
+	(*TunnelFrame)(nil),              // 10: controller.v1.TunnelFrame
 }
 var file_controller_proto_depIdxs = []int32{
-	0, // 0: controller.v1.EnrollmentService.EnrollConnector:input_type -> controller.v1.EnrollRequest
-	0, // 1: controller.v1.EnrollmentService.EnrollTunneler:input_type -> controller.v1.EnrollRequest
-	0, // 2: controller.v1.EnrollmentService.Renew:input_type -> controller.v1.EnrollRequest
-	2, // 3: controller.v1.ControlPlane.Connect:input_type -> controller.v1.ControlMessage
-	1, // 4: controller.v1.EnrollmentService.EnrollConnector:output_type -> controller.v1.EnrollResponse
-	1, // 5: controller.v1.EnrollmentService.EnrollTunneler:output_type -> controller.v1.EnrollResponse
-	1, // 6: controller.v1.EnrollmentService.Renew:output_type -> controller.v1.EnrollResponse
-	2, // 7: controller.v1.ControlPlane.Connect:output_type -> controller.v1.ControlMessage
-	4, // [4:8] is the sub-list for method output_type
-	0, // [0:4] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	2,  // 0: controller.v1.EnrollRequest.metadata:type_name -> controller.v1.EnrollMetadata
+	1,  // 1: controller.v1.EnrollRequest.attestation:type_name -> controller.v1.Attestation
+	0,  // 2: controller.v1.BatchRenewRequest.requests:type_name -> controller.v1.EnrollRequest
+	3,  // 3: controller.v1.BatchRenewResult.response:type_name -> controller.v1.EnrollResponse
+	5,  // 4: controller.v1.BatchRenewResponse.results:type_name -> controller.v1.BatchRenewResult
+	0,  // 5: controller.v1.EnrollmentService.EnrollConnector:input_type -> controller.v1.EnrollRequest
+	0,  // 6: controller.v1.EnrollmentService.EnrollTunneler:input_type -> controller.v1.EnrollRequest
+	0,  // 7: controller.v1.EnrollmentService.Renew:input_type -> controller.v1.EnrollRequest
+	4,  // 8: controller.v1.EnrollmentService.BatchRenew:input_type -> controller.v1.BatchRenewRequest
+	7,  // 9: controller.v1.ControlPlane.Connect:input_type -> controller.v1.ControlMessage
+	8,  // 10: controller.v1.ControlPlane.ResolveConnector:input_type -> controller.v1.ResolveConnectorRequest
+	10, // 11: controller.v1.TunnelService.Open:input_type -> controller.v1.TunnelFrame
+	3,  // 12: controller.v1.EnrollmentService.EnrollConnector:output_type -> controller.v1.EnrollResponse
+	3,  // 13: controller.v1.EnrollmentService.EnrollTunneler:output_type -> controller.v1.EnrollResponse
+	3,  // 14: controller.v1.EnrollmentService.Renew:output_type -> controller.v1.EnrollResponse
+	6,  // 15: controller.v1.EnrollmentService.BatchRenew:output_type -> controller.v1.BatchRenewResponse
+	7,  // 16: controller.v1.ControlPlane.Connect:output_type -> controller.v1.ControlMessage
+	9,  // 17: controller.v1.ControlPlane.ResolveConnector:output_type -> controller.v1.ResolveConnectorResponse
+	10, // 18: controller.v1.TunnelService.Open:output_type -> controller.v1.TunnelFrame
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_controller_proto_init() }
@@ -300,9 +907,9 @@ func file_controller_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_controller_proto_rawDesc), len(file_controller_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   11,
 			NumExtensions: 0,
-			NumServices:   2,
+			NumServices:   3,
 		},
 		GoTypes:           file_controller_proto_goTypes,
 		DependencyIndexes: file_controller_proto_depIdxs,
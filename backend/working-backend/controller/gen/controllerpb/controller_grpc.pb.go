@@ -22,6 +22,7 @@ const (
 	EnrollmentService_EnrollConnector_FullMethodName = "/controller.v1.EnrollmentService/EnrollConnector"
 	EnrollmentService_EnrollTunneler_FullMethodName  = "/controller.v1.EnrollmentService/EnrollTunneler"
 	EnrollmentService_Renew_FullMethodName           = "/controller.v1.EnrollmentService/Renew"
+	EnrollmentService_BatchRenew_FullMethodName      = "/controller.v1.EnrollmentService/BatchRenew"
 )
 
 // EnrollmentServiceClient is the client API for EnrollmentService service.
@@ -31,6 +32,8 @@ type EnrollmentServiceClient interface {
 	EnrollConnector(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
 	EnrollTunneler(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
 	Renew(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
+	// BatchRenew renews several identities in one call; see BatchRenewRequest.
+	BatchRenew(ctx context.Context, in *BatchRenewRequest, opts ...grpc.CallOption) (*BatchRenewResponse, error)
 }
 
 type enrollmentServiceClient struct {
@@ -71,6 +74,16 @@ func (c *enrollmentServiceClient) Renew(ctx context.Context, in *EnrollRequest,
 	return out, nil
 }
 
+func (c *enrollmentServiceClient) BatchRenew(ctx context.Context, in *BatchRenewRequest, opts ...grpc.CallOption) (*BatchRenewResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchRenewResponse)
+	err := c.cc.Invoke(ctx, EnrollmentService_BatchRenew_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // EnrollmentServiceServer is the server API for EnrollmentService service.
 // All implementations must embed UnimplementedEnrollmentServiceServer
 // for forward compatibility.
@@ -78,6 +91,8 @@ type EnrollmentServiceServer interface {
 	EnrollConnector(context.Context, *EnrollRequest) (*EnrollResponse, error)
 	EnrollTunneler(context.Context, *EnrollRequest) (*EnrollResponse, error)
 	Renew(context.Context, *EnrollRequest) (*EnrollResponse, error)
+	// BatchRenew renews several identities in one call; see BatchRenewRequest.
+	BatchRenew(context.Context, *BatchRenewRequest) (*BatchRenewResponse, error)
 	mustEmbedUnimplementedEnrollmentServiceServer()
 }
 
@@ -97,6 +112,9 @@ func (UnimplementedEnrollmentServiceServer) EnrollTunneler(context.Context, *Enr
 func (UnimplementedEnrollmentServiceServer) Renew(context.Context, *EnrollRequest) (*EnrollResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method Renew not implemented")
 }
+func (UnimplementedEnrollmentServiceServer) BatchRenew(context.Context, *BatchRenewRequest) (*BatchRenewResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchRenew not implemented")
+}
 func (UnimplementedEnrollmentServiceServer) mustEmbedUnimplementedEnrollmentServiceServer() {}
 func (UnimplementedEnrollmentServiceServer) testEmbeddedByValue()                           {}
 
@@ -172,6 +190,24 @@ func _EnrollmentService_Renew_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _EnrollmentService_BatchRenew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnrollmentServiceServer).BatchRenew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EnrollmentService_BatchRenew_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnrollmentServiceServer).BatchRenew(ctx, req.(*BatchRenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // EnrollmentService_ServiceDesc is the grpc.ServiceDesc for EnrollmentService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -191,13 +227,18 @@ var EnrollmentService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Renew",
 			Handler:    _EnrollmentService_Renew_Handler,
 		},
+		{
+			MethodName: "BatchRenew",
+			Handler:    _EnrollmentService_BatchRenew_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "controller.proto",
 }
 
 const (
-	ControlPlane_Connect_FullMethodName = "/controller.v1.ControlPlane/Connect"
+	ControlPlane_Connect_FullMethodName          = "/controller.v1.ControlPlane/Connect"
+	ControlPlane_ResolveConnector_FullMethodName = "/controller.v1.ControlPlane/ResolveConnector"
 )
 
 // ControlPlaneClient is the client API for ControlPlane service.
@@ -205,6 +246,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ControlPlaneClient interface {
 	Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ControlMessage, ControlMessage], error)
+	ResolveConnector(ctx context.Context, in *ResolveConnectorRequest, opts ...grpc.CallOption) (*ResolveConnectorResponse, error)
 }
 
 type controlPlaneClient struct {
@@ -228,11 +270,22 @@ func (c *controlPlaneClient) Connect(ctx context.Context, opts ...grpc.CallOptio
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ControlPlane_ConnectClient = grpc.BidiStreamingClient[ControlMessage, ControlMessage]
 
+func (c *controlPlaneClient) ResolveConnector(ctx context.Context, in *ResolveConnectorRequest, opts ...grpc.CallOption) (*ResolveConnectorResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResolveConnectorResponse)
+	err := c.cc.Invoke(ctx, ControlPlane_ResolveConnector_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ControlPlaneServer is the server API for ControlPlane service.
 // All implementations must embed UnimplementedControlPlaneServer
 // for forward compatibility.
 type ControlPlaneServer interface {
 	Connect(grpc.BidiStreamingServer[ControlMessage, ControlMessage]) error
+	ResolveConnector(context.Context, *ResolveConnectorRequest) (*ResolveConnectorResponse, error)
 	mustEmbedUnimplementedControlPlaneServer()
 }
 
@@ -246,6 +299,9 @@ type UnimplementedControlPlaneServer struct{}
 func (UnimplementedControlPlaneServer) Connect(grpc.BidiStreamingServer[ControlMessage, ControlMessage]) error {
 	return status.Error(codes.Unimplemented, "method Connect not implemented")
 }
+func (UnimplementedControlPlaneServer) ResolveConnector(context.Context, *ResolveConnectorRequest) (*ResolveConnectorResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ResolveConnector not implemented")
+}
 func (UnimplementedControlPlaneServer) mustEmbedUnimplementedControlPlaneServer() {}
 func (UnimplementedControlPlaneServer) testEmbeddedByValue()                      {}
 
@@ -274,13 +330,36 @@ func _ControlPlane_Connect_Handler(srv interface{}, stream grpc.ServerStream) er
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ControlPlane_ConnectServer = grpc.BidiStreamingServer[ControlMessage, ControlMessage]
 
+func _ControlPlane_ResolveConnector_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveConnectorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlPlaneServer).ResolveConnector(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlPlane_ResolveConnector_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlPlaneServer).ResolveConnector(ctx, req.(*ResolveConnectorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ControlPlane_ServiceDesc is the grpc.ServiceDesc for ControlPlane service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var ControlPlane_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "controller.v1.ControlPlane",
 	HandlerType: (*ControlPlaneServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ResolveConnector",
+			Handler:    _ControlPlane_ResolveConnector_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Connect",
@@ -291,3 +370,105 @@ var ControlPlane_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "controller.proto",
 }
+
+const (
+	TunnelService_Open_FullMethodName = "/controller.v1.TunnelService/Open"
+)
+
+// TunnelServiceClient is the client API for TunnelService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TunnelService is served by connectors to tunnelers. Each Open stream
+// carries one TCP connection to a backend named in the first frame.
+type TunnelServiceClient interface {
+	Open(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TunnelFrame, TunnelFrame], error)
+}
+
+type tunnelServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTunnelServiceClient(cc grpc.ClientConnInterface) TunnelServiceClient {
+	return &tunnelServiceClient{cc}
+}
+
+func (c *tunnelServiceClient) Open(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TunnelFrame, TunnelFrame], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TunnelService_ServiceDesc.Streams[0], TunnelService_Open_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TunnelFrame, TunnelFrame]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TunnelService_OpenClient = grpc.BidiStreamingClient[TunnelFrame, TunnelFrame]
+
+// TunnelServiceServer is the server API for TunnelService service.
+// All implementations must embed UnimplementedTunnelServiceServer
+// for forward compatibility.
+//
+// TunnelService is served by connectors to tunnelers. Each Open stream
+// carries one TCP connection to a backend named in the first frame.
+type TunnelServiceServer interface {
+	Open(grpc.BidiStreamingServer[TunnelFrame, TunnelFrame]) error
+	mustEmbedUnimplementedTunnelServiceServer()
+}
+
+// UnimplementedTunnelServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTunnelServiceServer struct{}
+
+func (UnimplementedTunnelServiceServer) Open(grpc.BidiStreamingServer[TunnelFrame, TunnelFrame]) error {
+	return status.Error(codes.Unimplemented, "method Open not implemented")
+}
+func (UnimplementedTunnelServiceServer) mustEmbedUnimplementedTunnelServiceServer() {}
+func (UnimplementedTunnelServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeTunnelServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TunnelServiceServer will
+// result in compilation errors.
+type UnsafeTunnelServiceServer interface {
+	mustEmbedUnimplementedTunnelServiceServer()
+}
+
+func RegisterTunnelServiceServer(s grpc.ServiceRegistrar, srv TunnelServiceServer) {
+	// If the following call panics, it indicates UnimplementedTunnelServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TunnelService_ServiceDesc, srv)
+}
+
+func _TunnelService_Open_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TunnelServiceServer).Open(&grpc.GenericServerStream[TunnelFrame, TunnelFrame]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TunnelService_OpenServer = grpc.BidiStreamingServer[TunnelFrame, TunnelFrame]
+
+// TunnelService_ServiceDesc is the grpc.ServiceDesc for TunnelService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TunnelService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "controller.v1.TunnelService",
+	HandlerType: (*TunnelServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Open",
+			Handler:       _TunnelService_Open_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "controller.proto",
+}
@@ -1,61 +1,111 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"controller/admin"
 	"controller/api"
+	"controller/buildinfo"
 	"controller/ca"
+	"controller/config"
 	controllerpb "controller/gen/controllerpb"
+	"controller/metrics"
+	"controller/spiffeid"
 	"controller/state"
+	"controller/webhook"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	// ---- required environment variables ----
-	caCertPEM := []byte(os.Getenv("INTERNAL_CA_CERT"))
-	caKeyPEM := []byte(os.Getenv("INTERNAL_CA_KEY"))
-	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
-		caCertPEM, caKeyPEM = loadCAFromFiles(caCertPEM, caKeyPEM)
+	if len(os.Args) > 1 && (os.Args[1] == "version" || os.Args[1] == "--version") {
+		fmt.Printf("controller %s\n", buildinfo.String())
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-cert" {
+		os.Exit(runVerifyCert(os.Args[2:], os.Stdout, os.Stderr))
 	}
-	trustDomain := os.Getenv("TRUST_DOMAIN")
-	if trustDomain == "" {
-		trustDomain = "mycorp.internal"
+	if len(os.Args) > 1 && os.Args[1] == "export-trust-bundle" {
+		os.Exit(runExportTrustBundle(os.Args[2:], os.Stdout, os.Stderr))
 	}
-	trustDomain = normalizeTrustDomain(trustDomain)
-	adminAddr := os.Getenv("ADMIN_HTTP_ADDR")
-	if adminAddr == "" {
-		adminAddr = ":8081"
+	log.Printf("controller %s starting", buildinfo.String())
+
+	// ---- configuration ----
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	adminAuthToken := os.Getenv("ADMIN_AUTH_TOKEN")
-	internalAuthToken := os.Getenv("INTERNAL_API_TOKEN")
-	tokenStorePath := os.Getenv("TOKEN_STORE_PATH")
-	if tokenStorePath == "" {
-		tokenStorePath = "/var/lib/grpccontroller/tokens.json"
+	log.Printf("configuration: %s", cfg)
+
+	caCertPEM, caKeyPEM := cfg.CACertPEM, cfg.CAKeyPEM
+	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
+		caCertPEM, caKeyPEM = loadCAFromFiles(cfg, caCertPEM, caKeyPEM)
 	}
+	trustDomain := cfg.TrustDomain
+	spiffeid.Default = cfg.SPIFFEPolicy
+	api.LogLevel = cfg.LogLevel
+	api.PeerLog.Level = cfg.PeerLogLevel
+	api.PeerLog.RedactSubject = cfg.PeerLogRedactSubject
+	api.PeerIdentities = api.NewPeerIdentityCache(cfg.PeerIdentityCacheSize)
 
 	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
-		log.Fatal("INTERNAL_CA_CERT or INTERNAL_CA_KEY is not set and ca/ca.crt+ca/ca.key not found")
+		log.Fatal("INTERNAL_CA_CERT or INTERNAL_CA_KEY is not set and no CA files found (CA_CERT_FILE/CA_KEY_FILE, default ca/ca.crt and ca/ca.pkcs8.key)")
+	}
+	// Tokens read from *_FILE can be rotated with SIGHUP or
+	// POST /api/admin/reload-auth; the previous value stays valid for
+	// AUTH_TOKEN_GRACE.
+	authGrace := cfg.AuthTokenGrace
+	adminAuth, err := admin.NewAuthToken("ADMIN_AUTH_TOKEN", cfg.AdminAuthToken, cfg.AdminAuthTokenFile, authGrace)
+	if err != nil {
+		log.Fatalf("failed to load admin auth token: %v", err)
+	}
+	adminReadOnlyAuth, err := admin.NewAuthToken("ADMIN_READONLY_TOKEN", cfg.AdminReadOnlyToken, cfg.AdminReadOnlyTokenFile, authGrace)
+	if err != nil {
+		log.Fatalf("failed to load read-only admin token: %v", err)
+	}
+	internalAuth, err := admin.NewAuthToken("INTERNAL_API_TOKEN", cfg.InternalAPIToken, cfg.InternalAPITokenFile, authGrace)
+	if err != nil {
+		log.Fatalf("failed to load internal API token: %v", err)
+	}
+	if !adminAuth.Configured() {
+		log.Fatal("ADMIN_AUTH_TOKEN is not set (set ADMIN_AUTH_TOKEN or ADMIN_AUTH_TOKEN_FILE)")
 	}
-	if adminAuthToken == "" {
-		log.Fatal("ADMIN_AUTH_TOKEN is not set")
+	if !internalAuth.Configured() {
+		log.Fatal("INTERNAL_API_TOKEN is not set (set INTERNAL_API_TOKEN or INTERNAL_API_TOKEN_FILE)")
 	}
-	if internalAuthToken == "" {
-		log.Fatal("INTERNAL_API_TOKEN is not set")
+	// The break-glass credential is file-only so it never sits in the
+	// environment next to the primary token.
+	var breakGlass, breakGlassFactor *admin.AuthToken
+	if path := cfg.BreakGlassTokenFile; path != "" {
+		if breakGlass, err = admin.NewAuthToken("BREAK_GLASS_TOKEN", "", path, authGrace); err != nil {
+			log.Fatalf("failed to load break-glass token: %v", err)
+		}
+		if path := cfg.BreakGlassFactorFile; path != "" {
+			if breakGlassFactor, err = admin.NewAuthToken("BREAK_GLASS_FACTOR", "", path, authGrace); err != nil {
+				log.Fatalf("failed to load break-glass factor: %v", err)
+			}
+		} else {
+			log.Println("WARNING: BREAK_GLASS_TOKEN_FILE is set without BREAK_GLASS_FACTOR_FILE; the break-glass token alone grants admin access")
+		}
 	}
 
 	// ---- load internal CA ----
@@ -63,9 +113,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to load internal CA: %v", err)
 	}
+	caInst.LeafSubject = cfg.LeafSubject
+	caInst.EKUs = cfg.EKUs
+	monitorCAExpiry(caInst.Cert)
 
 	// ---- load or issue controller TLS certificate ----
-	controllerTLSCert, err := loadOrIssueControllerCert(caInst, trustDomain)
+	controllerTLSCert, err := loadOrIssueControllerCert(cfg, caInst)
 	if err != nil {
 		log.Fatalf("failed to prepare controller TLS cert: %v", err)
 	}
@@ -76,32 +129,87 @@ func main() {
 		log.Fatal("failed to append internal CA cert to pool")
 	}
 
+	sniCerts, err := loadSNICerts(cfg.SNICertFiles, cfg.TrustDomain, caPool)
+	if err != nil {
+		log.Fatal(err)
+	}
+	controllerCerts, err := newCertSelector(controllerTLSCert, sniCerts...)
+	if err != nil {
+		log.Fatalf("failed to prepare controller TLS certs: %v", err)
+	}
+
 	// ---- TLS config (mTLS enforced) ----
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{controllerTLSCert},
-		ClientCAs:    caPool,
-		ClientAuth:   tls.VerifyClientCertIfGiven,
-		MinVersion:   tls.VersionTLS13,
+		GetCertificate: controllerCerts.GetCertificate,
+		ClientCAs:      caPool,
+		ClientAuth:     tls.VerifyClientCertIfGiven,
+		MinVersion:     tls.VersionTLS13,
+		// Reject certificates outside the trust domain during the
+		// handshake, so they are counted as TLS failures.
+		VerifyConnection: api.VerifyPeerSPIFFE(trustDomain),
+	}
+
+	// With BOOTSTRAP_LISTEN_ADDR set, enrollment moves to its own listener
+	// that does not ask for client certificates, and the main listener
+	// requires one at the TLS layer for every method.
+	bootstrapAddr := cfg.BootstrapAddr
+	if bootstrapAddr != "" {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
 
-	creds := credentials.NewTLS(tlsConfig)
+	creds := api.ObserveHandshakes(credentials.NewTLS(tlsConfig), "main")
 
 	registry := state.NewRegistry()
 	tunnelerRegistry := state.NewTunnelerRegistry()
 	tunnelerStatus := state.NewTunnelerStatusRegistry()
-	tokenStore := state.NewTokenStore(0, tokenStorePath)
+	tunnelerPreRegistry := state.NewTunnelerPreRegistry()
+	tokenStore := state.NewTokenStore(0, cfg.MaxTokenTTL, cfg.TokenStorePath)
 
 	// ---- gRPC server ----
+	requestTiming := api.UnaryDurationInterceptor(cfg.GRPCMaxHandlerDuration, cfg.GRPCSlowRequest)
+	issuanceLimit := api.UnaryIssuanceLimitInterceptor(cfg.MaxConcurrentIssuance)
 	grpcServer := grpc.NewServer(
 		grpc.Creds(creds),
-		grpc.UnaryInterceptor(api.UnaryAuthInterceptor(trustDomain, map[string]struct{}{
-			controllerpb.EnrollmentService_EnrollConnector_FullMethodName: {},
-			controllerpb.EnrollmentService_EnrollTunneler_FullMethodName:  {},
-		}, "connector", "tunneler")),
-		grpc.StreamInterceptor(api.StreamSPIFFEInterceptor(trustDomain, "connector", "tunneler")),
+		grpc.ChainUnaryInterceptor(
+			requestTiming,
+			api.UnaryPolicyInterceptor(trustDomain, bootstrapAddr == ""),
+			issuanceLimit,
+		),
+		grpc.StreamInterceptor(api.StreamPolicyInterceptor(trustDomain)),
+		grpc.StatsHandler(api.ControlPlaneStats{}),
 	)
 
 	controlPlaneServer := api.NewControlPlaneServer(trustDomain, registry, tunnelerRegistry, tunnelerStatus)
+	heartbeatSampler, err := api.NewLogSampler(cfg.HeartbeatLogSample)
+	if err != nil {
+		log.Fatalf("invalid HEARTBEAT_LOG_SAMPLE: %v", err)
+	}
+	controlPlaneServer.HeartbeatLogSampler = heartbeatSampler
+	controlPlaneServer.StrictProtocol = cfg.ControlPlaneStrict
+	controlPlaneServer.AllowlistResyncInterval = cfg.AllowlistResync
+	controlPlaneServer.AllowlistRequestInterval = cfg.AllowlistRequestMin
+	controlPlaneServer.TargetVersion = cfg.TargetConnectorVersion
+	controlPlaneServer.UpgradeURL = cfg.ConnectorUpgradeURL
+	controlPlaneServer.AcceptLimiter = api.NewAcceptLimiter(cfg.AcceptLimit, cfg.AcceptRetryAfter)
+	controlPlaneServer.MaxStreams = cfg.MaxControlPlaneStreams
+	controlPlaneServer.StreamRetryAfter = cfg.AcceptRetryAfter
+	metrics.NewGaugeFunc(
+		"controller_control_plane_streams_active",
+		"Control-plane streams currently admitted.",
+		func() float64 { return float64(controlPlaneServer.ActiveStreams()) },
+	)
+	metrics.NewGaugeFunc(
+		"controller_control_plane_streams_limit",
+		"MAX_CONTROL_PLANE_STREAMS; 0 means unlimited.",
+		func() float64 { return float64(cfg.MaxControlPlaneStreams) },
+	)
+	controlPlaneServer.ClockSkewThreshold = cfg.ClockSkewThreshold
+	controlPlaneServer.Compression = cfg.Compression
+	deadLetters, err := api.NewDeadLetterLog(cfg.DeadLetterLogPath, cfg.DeadLetterLogMaxBytes)
+	if err != nil {
+		log.Fatalf("invalid DEAD_LETTER_LOG_PATH: %v", err)
+	}
+	controlPlaneServer.DeadLetters = deadLetters
 
 	// ---- enrollment service ----
 	enrollServer := api.NewEnrollmentServer(
@@ -112,27 +220,166 @@ func main() {
 		registry,
 		controlPlaneServer,
 	)
+	enrollServer.AllowedDNSSuffixes = cfg.AllowedDNSSuffixes
+	enrollServer.ExtraSANs = cfg.ExtraSANs
+	enrollServer.AllowedKeyAlgorithms = cfg.AllowedKeyAlgorithms
+	enrollServer.EnforceKeyRotation = cfg.EnforceKeyRotation
+	enrollServer.OptionalPrivateIP = !cfg.RequirePrivateIP
+	enrollServer.RenewSoftLimit = cfg.RenewSoftLimit
+	enrollServer.TunnelerPreRegistry = tunnelerPreRegistry
+	enrollServer.RenewAgents = cfg.RenewAgents
+	if quota := state.NewEnrollmentQuota(cfg.MaxDailyIssuance); quota != nil {
+		enrollServer.EnrollmentQuota = quota
+		log.Printf("new enrollments capped at %d per 24h", quota.Limit())
+	}
+	if replays := state.NewEnrollReplayCache(cfg.IdempotencyWindow, cfg.IdempotencyMax); replays != nil {
+		enrollServer.EnrollReplays = replays
+		go replays.RunCleaner(context.Background())
+		metrics.NewGaugeFunc(
+			"controller_enroll_idempotency_entries",
+			"Enrollment certificates remembered for identical retries.",
+			func() float64 { return float64(replays.Len()) },
+		)
+	}
+	enrollServer.SignResponses = cfg.EnrollSignResponses
+
+	var pendingStore *state.PendingStore
+	switch cfg.EnrollMode {
+	case api.EnrollModeApproval:
+		pendingStore = state.NewPendingStore()
+		enrollServer.EnrollMode = api.EnrollModeApproval
+		enrollServer.Pending = pendingStore
+		log.Println("connector enrollment requires operator approval")
+	case api.EnrollModeAttest:
+		enrollServer.EnrollMode = api.EnrollModeAttest
+		if cfg.EnrollAttestor == api.AttestationAWSIID {
+			aws, err := api.NewAWSIdentityAttestor(cfg.AttestAWSCert, cfg.AttestAWSAccounts)
+			if err != nil {
+				log.Fatalf("invalid ATTEST_AWS_CERT: %v", err)
+			}
+			enrollServer.Attestor = aws
+		}
+		enrollServer.AttestTokenOptional = cfg.AttestTokenOptional
+		log.Printf("connector enrollment requires attestation (token optional: %t)", enrollServer.AttestTokenOptional)
+	}
+	var bootstrapCAs *x509.CertPool
+	if cfg.EnrollAuth != api.EnrollAuthToken {
+		source, bundle := "BOOTSTRAP_CA", cfg.BootstrapCAPEM
+		if len(bundle) == 0 {
+			source = "BOOTSTRAP_CA_FILE"
+			if bundle, err = os.ReadFile(cfg.BootstrapCAFile); err != nil {
+				log.Fatalf("invalid %s: %v", source, err)
+			}
+		}
+		if bootstrapCAs, err = api.ParseBootstrapCAs(bundle, caInst.Cert); err != nil {
+			log.Fatalf("invalid %s: %v", source, err)
+		}
+		enrollServer.EnrollAuth = cfg.EnrollAuth
+		enrollServer.BootstrapCAs = bootstrapCAs
+		log.Printf("connector enrollment requires a bootstrap client certificate (ENROLL_AUTH=%s)", cfg.EnrollAuth)
+	}
+
+	// ---- lifecycle webhooks (optional) ----
+	notifier := webhook.New(cfg.WebhookURL, cfg.WebhookSecret)
+	if notifier != nil {
+		notifier.Start(context.Background())
+		enrollServer.Events = notifier
+		controlPlaneServer.Events = notifier
+		log.Println("lifecycle webhook notifications enabled")
+	}
 
 	controllerpb.RegisterEnrollmentServiceServer(grpcServer, enrollServer)
 	controllerpb.RegisterControlPlaneServer(grpcServer, controlPlaneServer)
 
+	// Reflection has a MethodPolicies entry, so a valid workload
+	// certificate is still required before any schema is served.
+	if cfg.GRPCReflection {
+		reflection.Register(grpcServer)
+		log.Println("gRPC server reflection enabled")
+	}
+	if err := api.CheckMethodPolicies(grpcServer); err != nil {
+		log.Fatalf("grpc: %v", err)
+	}
+
 	// ---- admin HTTP server ----
 	adminMux := http.NewServeMux()
 	adminServer := &admin.Server{
-		Tokens:            tokenStore,
-		Reg:               registry,
-		Tunnelers:         tunnelerStatus,
-		AdminAuthToken:    adminAuthToken,
-		InternalAuthToken: internalAuthToken,
+		Tokens:                 tokenStore,
+		Reg:                    registry,
+		Tunnelers:              tunnelerStatus,
+		TunnelerPreRegistry:    tunnelerPreRegistry,
+		Pending:                pendingStore,
+		Config:                 controlPlaneServer,
+		Allowlist:              controlPlaneServer,
+		Drain:                  controlPlaneServer,
+		Streams:                controlPlaneServer,
+		Policy:                 enrollServer,
+		TargetConnectorVersion: controlPlaneServer.TargetVersion,
+		TrustDomain:            trustDomain,
+		CAFingerprint:          caFingerprint(caInst.Cert),
+		CANotAfter:             caInst.Cert.NotAfter,
+		CA:                     caInst,
+		State: state.Stores{
+			Tokens:              tokenStore,
+			Registry:            registry,
+			Tunnelers:           tunnelerRegistry,
+			TunnelerStatus:      tunnelerStatus,
+			TunnelerPreRegistry: tunnelerPreRegistry,
+		},
+		AdminAuth:         adminAuth,
+		AdminReadOnlyAuth: adminReadOnlyAuth,
+		InternalAuth:      internalAuth,
+		BreakGlass:        breakGlass,
+		BreakGlassFactor:  breakGlassFactor,
+	}
+	if notifier != nil {
+		adminServer.Events = notifier
 	}
 	adminServer.RegisterRoutes(adminMux)
 	go func() {
-		log.Printf("admin HTTP server listening on %s", adminAddr)
-		if err := http.ListenAndServe(adminAddr, adminMux); err != nil {
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		for range hupCh {
+			if err := adminServer.ReloadAuth(); err != nil {
+				log.Printf("auth token reload failed: %v", err)
+			}
+		}
+	}()
+	// Header and write timeouts bound slow clients on the admin port; the
+	// write timeout leaves room for state exports.
+	adminHTTP := &http.Server{
+		Addr:              cfg.AdminAddr,
+		Handler:           adminMux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	if cfg.AdminTLS {
+		adminHTTP.TLSConfig, err = adminTLSConfig(cfg.AdminTLSCertFile, cfg.AdminTLSKeyFile, controllerCerts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	adminDrain := cfg.AdminShutdownTimeout
+	go func() {
+		var err error
+		if adminHTTP.TLSConfig != nil {
+			log.Printf("admin HTTPS server listening on %s", cfg.AdminAddr)
+			err = adminHTTP.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("admin HTTP server listening on %s", cfg.AdminAddr)
+			err = adminHTTP.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("admin HTTP server failed: %v", err)
 		}
 	}()
 
+	if bootstrapAddr != "" {
+		go serveBootstrap(bootstrapAddr, controllerCerts, bootstrapCAs, enrollServer, requestTiming, issuanceLimit)
+	}
+
 	// ---- listen ----
 	lis, err := net.Listen("tcp", ":8443")
 	if err != nil {
@@ -141,46 +388,154 @@ func main() {
 
 	log.Println("controller gRPC server listening on :8443")
 
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigCh
+		log.Printf("received %s, disconnecting connectors", sig)
+		controlPlaneServer.DisconnectAll(api.DisconnectShutdown, "controller shutting down")
+
+		// Drain in-flight admin requests alongside the gRPC shutdown.
+		adminStopped := make(chan struct{})
+		go func() {
+			defer close(adminStopped)
+			ctx, cancel := context.WithTimeout(context.Background(), adminDrain)
+			defer cancel()
+			if err := adminHTTP.Shutdown(ctx); err != nil {
+				log.Printf("admin HTTP server did not drain within %s: %v", adminDrain, err)
+				adminHTTP.Close()
+			}
+		}()
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			grpcServer.Stop()
+		}
+		<-adminStopped
+		controlPlaneServer.DeadLetters.Close()
+	}()
+
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("gRPC server failed: %v", err)
 	}
+	<-shutdownDone
+}
+
+// serveBootstrap runs the enrollment-only listener and only admits
+// api.BootstrapMethods. It requests client certificates only when
+// bootstrapCAs is set (ENROLL_AUTH), and then optionally, so tunnelers can
+// still enroll without one.
+func serveBootstrap(addr string, certs *certSelector, bootstrapCAs *x509.CertPool, enrollServer controllerpb.EnrollmentServiceServer, requestTiming, issuanceLimit grpc.UnaryServerInterceptor) {
+	tlsConfig := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		ClientAuth:     tls.NoClientCert,
+		MinVersion:     tls.VersionTLS13,
+	}
+	if bootstrapCAs != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = bootstrapCAs
+	}
+	server := grpc.NewServer(
+		grpc.Creds(api.ObserveHandshakes(credentials.NewTLS(tlsConfig), "bootstrap")),
+		grpc.ChainUnaryInterceptor(requestTiming, api.UnaryBootstrapOnlyInterceptor(), issuanceLimit),
+		grpc.StreamInterceptor(api.StreamBootstrapRejectInterceptor()),
+	)
+	controllerpb.RegisterEnrollmentServiceServer(server, enrollServer)
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("failed to listen on bootstrap address %s: %v", addr, err)
+	}
+	log.Printf("controller bootstrap gRPC server listening on %s", addr)
+	if err := server.Serve(lis); err != nil {
+		log.Fatalf("bootstrap gRPC server failed: %v", err)
+	}
 }
 
-func loadCAFromFiles(certPEM, keyPEM []byte) ([]byte, []byte) {
-	certPath := "ca/ca.crt"
-	keyPath := "ca/ca.pkcs8.key"
+// caFingerprint returns the hex SHA-256 of the CA certificate DER.
+func caFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
 
+// loadCAFromFiles fills in CA material not supplied via env from files.
+// CA_CERT_FILE and CA_KEY_FILE override the default ca/ca.crt and
+// ca/ca.pkcs8.key; an explicitly configured file that cannot be read is fatal.
+func loadCAFromFiles(cfg *config.Config, certPEM, keyPEM []byte) ([]byte, []byte) {
 	if len(certPEM) == 0 {
-		if b, err := os.ReadFile(certPath); err == nil {
-			certPEM = b
-		}
+		certPEM = readCAFile("CA_CERT_FILE", cfg.CACertFile, "ca/ca.crt")
 	}
 	if len(keyPEM) == 0 {
-		if b, err := os.ReadFile(keyPath); err == nil {
-			keyPEM = b
-		}
+		keyPEM = readCAFile("CA_KEY_FILE", cfg.CAKeyFile, "ca/ca.pkcs8.key")
 	}
 	return certPEM, keyPEM
 }
 
-func normalizeTrustDomain(v string) string {
-	v = strings.TrimSpace(v)
-	v = strings.TrimSuffix(v, ".")
-	return v
+func readCAFile(envName, path, defaultPath string) []byte {
+	if path == "" {
+		b, err := os.ReadFile(defaultPath)
+		if err != nil {
+			return nil
+		}
+		return b
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s=%s is not readable: %v", envName, path, err)
+	}
+	if len(b) == 0 {
+		log.Fatalf("%s=%s is empty", envName, path)
+	}
+	return b
 }
 
-func loadOrIssueControllerCert(caInst *ca.CA, trustDomain string) (tls.Certificate, error) {
-	controllerCertPEM := []byte(os.Getenv("CONTROLLER_CERT"))
-	controllerKeyPEM := []byte(os.Getenv("CONTROLLER_KEY"))
-	if len(controllerCertPEM) > 0 && len(controllerKeyPEM) > 0 {
-		return tls.X509KeyPair(controllerCertPEM, controllerKeyPEM)
+// checkControllerSPIFFE fails unless cert carries exactly one SPIFFE ID, a
+// controller ID in trustDomain. Connectors and tunnelers require that ID, so
+// a certificate without it would otherwise only show up as handshake
+// failures on every client.
+func checkControllerSPIFFE(cert tls.Certificate, trustDomain string) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	u, err := spiffeid.URIOf(leaf)
+	if err != nil {
+		return fmt.Errorf("certificate needs a spiffe://%s/controller/<id> URI SAN: %w", trustDomain, err)
+	}
+	id, err := spiffeid.ParseURI(u)
+	if err != nil {
+		return fmt.Errorf("certificate SPIFFE ID %q: %w", u, err)
 	}
+	if id.TrustDomain != trustDomain || id.Role != "controller" {
+		return fmt.Errorf("certificate SPIFFE ID %q is not a controller ID in trust domain %s; clients expect spiffe://%s/controller/<id>", id, trustDomain, trustDomain)
+	}
+	return nil
+}
 
-	controllerID := os.Getenv("CONTROLLER_ID")
-	if controllerID == "" {
-		controllerID = "default"
+func loadOrIssueControllerCert(cfg *config.Config, caInst *ca.CA) (tls.Certificate, error) {
+	if len(cfg.ControllerCertPEM) > 0 && len(cfg.ControllerKeyPEM) > 0 {
+		cert, err := tls.X509KeyPair(cfg.ControllerCertPEM, cfg.ControllerKeyPEM)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := checkControllerSPIFFE(cert, cfg.TrustDomain); err != nil {
+			return tls.Certificate{}, fmt.Errorf("CONTROLLER_CERT: %w", err)
+		}
+		return cert, nil
 	}
-	spiffeID := "spiffe://" + trustDomain + "/controller/" + controllerID
+
+	spiffeID := "spiffe://" + cfg.TrustDomain + "/controller/" + cfg.ControllerID
 
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -0,0 +1,449 @@
+// Package metrics is a minimal in-process metrics registry that renders the
+// Prometheus text exposition format. It intentionally covers only what the
+// controller needs: counters, gauges, labelled counters and gauges, and
+// labelled histograms.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]collector)
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[c.name()]; exists {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	registry[c.name()] = c
+}
+
+// Handler serves all registered metrics in Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteTo(w)
+	})
+}
+
+// WriteTo renders all registered metrics, sorted by name.
+func WriteTo(w io.Writer) {
+	registryMu.Lock()
+	collectors := make([]collector, 0, len(registry))
+	for _, c := range registry {
+		collectors = append(collectors, c)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// value is a float64 updated atomically.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if v.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	v.bits.Store(math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	metricName string
+	help       string
+	v          value
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	register(c)
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add increments the counter by delta; negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+// Value returns the current counter value.
+func (c *Counter) Value() float64 { return c.v.get() }
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.metricName, formatValue(c.v.get()))
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	metricName string
+	help       string
+	v          value
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{metricName: name, help: help}
+	register(g)
+	return g
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(f float64) { g.v.set(f) }
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Value returns the current gauge value.
+func (g *Gauge) Value() float64 { return g.v.get() }
+
+func (g *Gauge) name() string { return g.metricName }
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.v.get()))
+}
+
+// GaugeFunc is a gauge whose value is computed at scrape time.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.fn()))
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*labelledValue
+}
+
+type labelledValue struct {
+	labelValues []string
+	v           value
+}
+
+// NewCounterVec creates and registers a labelled counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     make(map[string]*labelledValue),
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the series identified by labelValues.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the series identified by labelValues by delta.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta <= 0 {
+		return
+	}
+	if len(labelValues) != len(c.labels) {
+		panic("metrics: label cardinality mismatch for " + c.metricName)
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	lv, ok := c.series[key]
+	if !ok {
+		lv = &labelledValue{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = lv
+	}
+	c.mu.Unlock()
+	lv.v.add(delta)
+}
+
+// Value returns the current value of a series.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lv, ok := c.series[strings.Join(labelValues, "\xff")]; ok {
+		return lv.v.get()
+	}
+	return 0
+}
+
+func (c *CounterVec) name() string { return c.metricName }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	series := sortedSeries(c.series)
+	c.mu.Unlock()
+
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, lv := range series {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.metricName, formatLabels(c.labels, lv.labelValues), formatValue(lv.v.get()))
+	}
+}
+
+// GaugeVec is a set of gauges partitioned by label values.
+type GaugeVec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*labelledValue
+}
+
+// NewGaugeVec creates and registers a labelled gauge.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     make(map[string]*labelledValue),
+	}
+	register(g)
+	return g
+}
+
+// Inc increments the series identified by labelValues by one.
+func (g *GaugeVec) Inc(labelValues ...string) { g.get(labelValues).v.add(1) }
+
+// Dec decrements the series identified by labelValues by one.
+func (g *GaugeVec) Dec(labelValues ...string) { g.get(labelValues).v.add(-1) }
+
+// Set sets the series identified by labelValues.
+func (g *GaugeVec) Set(f float64, labelValues ...string) { g.get(labelValues).v.set(f) }
+
+// Value returns the current value of a series.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lv, ok := g.series[strings.Join(labelValues, "\xff")]; ok {
+		return lv.v.get()
+	}
+	return 0
+}
+
+func (g *GaugeVec) get(labelValues []string) *labelledValue {
+	if len(labelValues) != len(g.labels) {
+		panic("metrics: label cardinality mismatch for " + g.metricName)
+	}
+	key := strings.Join(labelValues, "\xff")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lv, ok := g.series[key]
+	if !ok {
+		lv = &labelledValue{labelValues: append([]string(nil), labelValues...)}
+		g.series[key] = lv
+	}
+	return lv
+}
+
+func (g *GaugeVec) name() string { return g.metricName }
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	series := sortedSeries(g.series)
+	g.mu.Unlock()
+
+	writeHeader(w, g.metricName, g.help, "gauge")
+	for _, lv := range series {
+		fmt.Fprintf(w, "%s{%s} %s\n", g.metricName, formatLabels(g.labels, lv.labelValues), formatValue(lv.v.get()))
+	}
+}
+
+// sortedSeries returns the values of series ordered by key; the caller
+// holds the lock guarding series.
+func sortedSeries(series map[string]*labelledValue) []*labelledValue {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*labelledValue, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, series[k])
+	}
+	return out
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative; last entry is +Inf
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec creates and registers a labelled histogram with the given
+// upper bucket bounds, which must be sorted in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: unsorted buckets for " + name)
+	}
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    append([]float64(nil), buckets...),
+		series:     make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+// Observe records v in the series identified by labelValues.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic("metrics: label cardinality mismatch for " + h.metricName)
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hs, ok := h.series[key]
+	if !ok {
+		hs = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+		}
+		h.series[key] = hs
+	}
+	hs.counts[i]++
+	hs.count++
+	hs.sum += v
+}
+
+// Count returns the number of observations recorded in a series.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hs, ok := h.series[strings.Join(labelValues, "\xff")]; ok {
+		return hs.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) name() string { return h.metricName }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]histogramSeries, 0, len(keys))
+	for _, k := range keys {
+		hs := *h.series[k]
+		hs.counts = append([]uint64(nil), hs.counts...)
+		series = append(series, hs)
+	}
+	h.mu.Unlock()
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+	for _, hs := range series {
+		labels := formatLabels(h.labels, hs.labelValues)
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hs.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", h.metricName, labels, sep, formatValue(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", h.metricName, labels, sep, hs.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.metricName, labels, formatValue(hs.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.metricName, labels, hs.count)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		parts[i] = n + `="` + v + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatValue(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
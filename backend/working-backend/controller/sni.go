@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// certSelector chooses the controller certificate for each TLS handshake
+// from the ClientHello server name, so one controller can answer on several
+// hostnames without a single certificate carrying every SAN. The first
+// certificate is the default, used when no other one matches or the client
+// sent no server name (Go clients never send one for IP targets).
+type certSelector struct {
+	certs []tls.Certificate
+}
+
+func newCertSelector(def tls.Certificate, extra ...tls.Certificate) (*certSelector, error) {
+	s := &certSelector{certs: append([]tls.Certificate{def}, extra...)}
+	for i := range s.certs {
+		if s.certs[i].Leaf != nil {
+			continue
+		}
+		if len(s.certs[i].Certificate) == 0 {
+			return nil, fmt.Errorf("controller certificate %d is empty", i)
+		}
+		leaf, err := x509.ParseCertificate(s.certs[i].Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse controller certificate %d: %w", i, err)
+		}
+		s.certs[i].Leaf = leaf
+	}
+	return s, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (s *certSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName != "" {
+		for i := range s.certs {
+			c := &s.certs[i]
+			if c.Leaf.VerifyHostname(hello.ServerName) == nil && hello.SupportsCertificate(c) == nil {
+				return c, nil
+			}
+		}
+	}
+	return &s.certs[0], nil
+}
+
+// loadSNICerts loads CONTROLLER_SNI_CERT_FILES, a comma-separated list of
+// cert:key PEM file pairs, and checks that each certificate was issued by the
+// internal CA for server use with a controller SPIFFE ID in trustDomain.
+func loadSNICerts(raw, trustDomain string, caPool *x509.CertPool) ([]tls.Certificate, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var certs []tls.Certificate
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		certPath, keyPath, ok := strings.Cut(pair, ":")
+		if !ok || certPath == "" || keyPath == "" {
+			return nil, fmt.Errorf("CONTROLLER_SNI_CERT_FILES: %q is not a cert:key pair", pair)
+		}
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("CONTROLLER_SNI_CERT_FILES: load %s: %w", certPath, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("CONTROLLER_SNI_CERT_FILES: parse %s: %w", certPath, err)
+			}
+		}
+		intermediates := x509.NewCertPool()
+		for _, der := range cert.Certificate[1:] {
+			if c, err := x509.ParseCertificate(der); err == nil {
+				intermediates.AddCert(c)
+			}
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{
+			Roots:         caPool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}); err != nil {
+			return nil, fmt.Errorf("CONTROLLER_SNI_CERT_FILES: %s is not a server certificate from the internal CA: %w", certPath, err)
+		}
+		if err := checkControllerSPIFFE(cert, trustDomain); err != nil {
+			return nil, fmt.Errorf("CONTROLLER_SNI_CERT_FILES: %s: %w", certPath, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"controller/ca"
+)
+
+// newTestCA returns an internal CA and a pool trusting it.
+func newTestCA(t *testing.T) (*ca.CA, *x509.CertPool) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("controller test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caInst, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caInst.Cert)
+	return caInst, pool
+}
+
+// issueServerCert issues a certificate for spiffeID with dnsNames as SANs.
+func issueServerCert(t *testing.T, caInst *ca.CA, spiffeID string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := ca.IssueWorkloadCert(caInst, spiffeID, &key.PublicKey, time.Hour, dnsNames, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// served returns the leaf the server presents to a client asking for
+// serverName.
+func served(t *testing.T, cfg *tls.Config, roots *x509.CertPool, serverName string) *x509.Certificate {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go tls.Server(serverConn, cfg).Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{
+		RootCAs:    roots,
+		ServerName: serverName,
+		// Without a server name the client sends no SNI, as for an IP
+		// target, and has no hostname to verify.
+		InsecureSkipVerify: serverName == "",
+	})
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("handshake for %q: %v", serverName, err)
+	}
+	return client.ConnectionState().PeerCertificates[0]
+}
+
+func TestAdminTLSCertSelection(t *testing.T) {
+	caInst, roots := newTestCA(t)
+	def := issueServerCert(t, caInst, "spiffe://example.org/controller/a", "ctl-a.example.org")
+	extra := issueServerCert(t, caInst, "spiffe://example.org/controller/b", "ctl-b.example.org")
+	selector, err := newCertSelector(def, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := adminTLSConfig("", "", selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       tls.Certificate
+	}{
+		{"ctl-b.example.org", extra},
+		{"ctl-a.example.org", def},
+		{"", def},
+	}
+	for _, tt := range tests {
+		if got := served(t, cfg, roots, tt.serverName); string(got.Raw) != string(tt.want.Certificate[0]) {
+			t.Errorf("server name %q: served %v, want %v", tt.serverName, got.DNSNames, tt.want.Leaf.DNSNames)
+		}
+	}
+}
+
+func TestCheckControllerSPIFFE(t *testing.T) {
+	caInst, _ := newTestCA(t)
+	tests := []struct {
+		spiffeID string
+		ok       bool
+	}{
+		{"spiffe://example.org/controller/a", true},
+		{"spiffe://example.org/connector/a", false},
+		{"spiffe://other.org/controller/a", false},
+	}
+	for _, tt := range tests {
+		err := checkControllerSPIFFE(issueServerCert(t, caInst, tt.spiffeID), "example.org")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: checkControllerSPIFFE = %v, want ok=%v", tt.spiffeID, err, tt.ok)
+		}
+	}
+}
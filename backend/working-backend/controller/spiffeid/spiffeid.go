@@ -0,0 +1,233 @@
+// Package spiffeid parses and validates workload SPIFFE IDs of the form
+// spiffe://<trust domain>/<role>/<id>. Certificate issuance and every peer
+// verifier use it so that malformed or oversized identities are rejected
+// consistently. The connector and tunneler import it as well, so both ends of
+// every connection apply the same rules.
+package spiffeid
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultMaxLength caps a whole SPIFFE ID; it is the SPIFFE
+	// specification's limit for the URI.
+	DefaultMaxLength = 2048
+	// maxTrustDomainLength is the SPIFFE limit for the trust domain.
+	maxTrustDomainLength = 255
+	// maxNameLength matches the enrollment id limit.
+	maxNameLength = 128
+)
+
+// ID is a parsed workload SPIFFE ID.
+type ID struct {
+	TrustDomain string
+	Role        string
+	Name        string
+}
+
+// String returns the ID as a spiffe:// URI.
+func (id ID) String() string {
+	return "spiffe://" + id.TrustDomain + "/" + id.Role + "/" + id.Name
+}
+
+// Policy controls how strictly IDs are validated.
+type Policy struct {
+	// MaxLength caps the whole ID; 0 means DefaultMaxLength.
+	MaxLength int
+	// Strict additionally limits path segments to letters, digits, '.',
+	// '-' and '_', and the name to 128 characters.
+	Strict bool
+}
+
+// Default is the policy used by Parse and ParseURI. It is set once at
+// startup, before any certificate is issued or verified.
+var Default = Policy{MaxLength: DefaultMaxLength}
+
+// Parse validates s under the Default policy.
+func Parse(s string) (ID, error) {
+	return Default.Parse(s)
+}
+
+// ParseURI validates a certificate URI SAN under the Default policy.
+func ParseURI(u *url.URL) (ID, error) {
+	if u == nil {
+		return ID{}, errors.New("missing SPIFFE ID")
+	}
+	return Default.Parse(u.String())
+}
+
+// ParseIn validates s under the Default policy and requires it to be a
+// role identity in trustDomain. Callers that map a verified SPIFFE ID back to
+// a workload name use it instead of trimming a prefix.
+func ParseIn(s, trustDomain, role string) (ID, error) {
+	id, err := Parse(s)
+	if err != nil {
+		return ID{}, err
+	}
+	if id.TrustDomain != trustDomain {
+		return ID{}, fmt.Errorf("SPIFFE trust domain %q, want %q", id.TrustDomain, trustDomain)
+	}
+	if id.Role != role {
+		return ID{}, fmt.Errorf("SPIFFE role %q, want %q", id.Role, role)
+	}
+	return id, nil
+}
+
+// FromLeaf returns the SPIFFE ID of a peer's leaf certificate under the
+// Default policy. The leaf must carry exactly one spiffe:// URI SAN (other
+// URI SANs are ignored), must not be a CA and must allow digital signatures,
+// so a CA certificate cannot stand in for a workload identity.
+func FromLeaf(cert *x509.Certificate) (ID, error) {
+	if cert == nil {
+		return ID{}, errors.New("no peer certificate")
+	}
+	if cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+		return ID{}, errors.New("peer presented a CA certificate as its leaf")
+	}
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return ID{}, errors.New("peer certificate lacks the digitalSignature key usage")
+	}
+	u, err := URIOf(cert)
+	if err != nil {
+		return ID{}, err
+	}
+	return ParseURI(u)
+}
+
+// URIOf returns the single spiffe:// URI SAN of cert. Certificates may carry
+// other URI SANs, such as a legacy identity during a migration, but exactly
+// one SPIFFE ID.
+func URIOf(cert *x509.Certificate) (*url.URL, error) {
+	var found *url.URL
+	for _, u := range cert.URIs {
+		if !strings.EqualFold(u.Scheme, "spiffe") {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("exactly one SPIFFE ID is required")
+		}
+		found = u
+	}
+	if found == nil {
+		return nil, errors.New("exactly one SPIFFE ID is required")
+	}
+	return found, nil
+}
+
+// ValidateTrustDomain checks that td is a bare DNS-like name: lowercase
+// letters, digits, '.', '-' and '_' in non-empty labels, with no scheme, path
+// or port, and at most 255 bytes.
+func ValidateTrustDomain(td string) error {
+	switch {
+	case td == "":
+		return errors.New("trust domain is empty")
+	case strings.Contains(td, "://"):
+		return fmt.Errorf("trust domain %q must not include a scheme", td)
+	case strings.Contains(td, "/"):
+		return fmt.Errorf("trust domain %q must not include a path", td)
+	case strings.Contains(td, ":"):
+		return fmt.Errorf("trust domain %q must not include a port", td)
+	case len(td) > maxTrustDomainLength:
+		return fmt.Errorf("trust domain exceeds %d bytes", maxTrustDomainLength)
+	}
+	for _, label := range strings.Split(td, ".") {
+		if label == "" {
+			return fmt.Errorf("trust domain %q has an empty label", td)
+		}
+	}
+	for _, r := range td {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return fmt.Errorf("trust domain %q must contain only lowercase letters, digits, '.', '-' and '_'", td)
+		}
+	}
+	return nil
+}
+
+// Parse validates s as spiffe://<trust domain>/<role>/<name>. The scheme
+// must be lowercase and the trust domain a lowercase DNS-like name. Both
+// path segments must be non-empty, other than "." and "..", and made of
+// characters a URI path carries unescaped, so an ID has exactly one
+// spelling and reads the same in a certificate URI SAN.
+func (p Policy) Parse(s string) (ID, error) {
+	maxLen := p.MaxLength
+	if maxLen <= 0 {
+		maxLen = DefaultMaxLength
+	}
+	if len(s) > maxLen {
+		return ID{}, fmt.Errorf("SPIFFE ID exceeds %d bytes", maxLen)
+	}
+	rest, ok := strings.CutPrefix(s, "spiffe://")
+	if !ok {
+		return ID{}, errors.New("SPIFFE ID must use the lowercase spiffe:// scheme")
+	}
+	if strings.ContainsAny(rest, "?#%@") {
+		return ID{}, errors.New("SPIFFE ID must not contain a query, fragment, escapes or user info")
+	}
+	td, path, _ := strings.Cut(rest, "/")
+	if td == "" {
+		return ID{}, errors.New("SPIFFE ID is missing a trust domain")
+	}
+	if len(td) > maxTrustDomainLength {
+		return ID{}, fmt.Errorf("SPIFFE trust domain exceeds %d bytes", maxTrustDomainLength)
+	}
+	if strings.Contains(td, ":") {
+		return ID{}, errors.New("SPIFFE trust domain must not include a port")
+	}
+	for _, label := range strings.Split(td, ".") {
+		if label == "" {
+			return ID{}, errors.New("SPIFFE trust domain has an empty label")
+		}
+	}
+	for _, r := range td {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return ID{}, errors.New("SPIFFE trust domain has invalid characters")
+		}
+	}
+	role, name, ok := strings.Cut(path, "/")
+	if !ok || role == "" || name == "" || strings.Contains(name, "/") {
+		return ID{}, errors.New("invalid SPIFFE path format")
+	}
+	for _, seg := range []string{role, name} {
+		if seg == "." || seg == ".." {
+			return ID{}, errors.New("SPIFFE path segment must not be . or ..")
+		}
+		for _, r := range seg {
+			if !isPathChar(r) {
+				return ID{}, errors.New("SPIFFE path has invalid characters")
+			}
+		}
+	}
+	id := ID{TrustDomain: td, Role: role, Name: name}
+	if p.Strict {
+		if err := id.validateStrict(); err != nil {
+			return ID{}, err
+		}
+	}
+	return id, nil
+}
+
+// isPathChar reports whether r may appear unescaped in a URI path segment
+// (RFC 3986 pchar, without '%' and '@').
+func isPathChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune("-._~!$&'()*+,;=:", r)
+}
+
+func (id ID) validateStrict() error {
+	for _, seg := range []string{id.Role, id.Name} {
+		for _, r := range seg {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+				return errors.New("SPIFFE path has invalid characters")
+			}
+		}
+	}
+	if len(id.Name) > maxNameLength {
+		return fmt.Errorf("SPIFFE workload id exceeds %d characters", maxNameLength)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package spiffeid
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLeaf returns a self-signed certificate carrying uris, shaped by
+// modify.
+func testLeaf(t *testing.T, uris []string, modify func(*x509.Certificate)) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	if modify != nil {
+		modify(tmpl)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestFromLeaf(t *testing.T) {
+	const id = "spiffe://example.org/connector/c1"
+	tests := []struct {
+		name    string
+		uris    []string
+		modify  func(*x509.Certificate)
+		wantErr string
+	}{
+		{name: "workload leaf", uris: []string{id}},
+		{name: "extra non-SPIFFE URI", uris: []string{"https://legacy.example/c1", id}},
+		{name: "CA certificate", uris: []string{id}, modify: func(c *x509.Certificate) {
+			c.IsCA = true
+			c.KeyUsage |= x509.KeyUsageCertSign
+		}, wantErr: "CA certificate"},
+		{name: "cert signing key usage", uris: []string{id}, modify: func(c *x509.Certificate) {
+			c.KeyUsage |= x509.KeyUsageCertSign
+		}, wantErr: "CA certificate"},
+		{name: "no digitalSignature", uris: []string{id}, modify: func(c *x509.Certificate) {
+			c.KeyUsage = x509.KeyUsageKeyEncipherment
+		}, wantErr: "digitalSignature"},
+		{name: "two SPIFFE IDs", uris: []string{id, "spiffe://example.org/connector/c2"}, wantErr: "exactly one SPIFFE ID"},
+		{name: "no SPIFFE ID", uris: []string{"https://legacy.example/c1"}, wantErr: "exactly one SPIFFE ID"},
+		{name: "malformed SPIFFE ID", uris: []string{"spiffe://example.org/connector"}, wantErr: "invalid SPIFFE path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromLeaf(testLeaf(t, tt.uris, tt.modify))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FromLeaf err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.String() != id {
+				t.Fatalf("FromLeaf = %v, %v; want %s", got, err, id)
+			}
+		})
+	}
+	if _, err := FromLeaf(nil); err == nil {
+		t.Error("FromLeaf accepted a nil certificate")
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{
+		"spiffe://example.org/connector/conn-1",
+		"spiffe://example.org/tunneler/t_1.a",
+		"spiffe://a.b-c_d/controller/x:y",
+		"spiffe://example.org//conn",
+		"spiffe://example.org/connector/conn/",
+		"spiffe://example.org:443/connector/conn",
+		"spiffe://Example.org/connector/conn",
+		"SPIFFE://example.org/connector/conn",
+		"spiffe://example.org/connector/%41",
+		"spiffe://example.org/connector/..",
+		"spiffe://user@example.org/connector/conn",
+		"spiffe://example..org/connector/conn",
+		"",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		strict := Policy{Strict: true}
+		id, err := Policy{}.Parse(s)
+		if err != nil {
+			if _, serr := strict.Parse(s); serr == nil {
+				t.Fatalf("strict policy accepted %q rejected by the default policy: %v", s, err)
+			}
+			return
+		}
+		// An accepted ID has exactly one spelling.
+		if got := id.String(); got != s {
+			t.Fatalf("Parse(%q).String() = %q", s, got)
+		}
+		again, err := Policy{}.Parse(id.String())
+		if err != nil || again != id {
+			t.Fatalf("reparse of %q = %+v, %v; want %+v", s, again, err, id)
+		}
+		// It reads the same as a certificate URI SAN.
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("accepted %q is not a URI: %v", s, err)
+		}
+		if u.String() != s {
+			t.Fatalf("accepted %q re-encodes as URI %q", s, u.String())
+		}
+		if err := ValidateTrustDomain(id.TrustDomain); err != nil {
+			t.Fatalf("accepted %q has invalid trust domain: %v", s, err)
+		}
+	})
+}
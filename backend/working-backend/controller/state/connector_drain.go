@@ -0,0 +1,75 @@
+package state
+
+import "time"
+
+// DrainStatus is the drain progress of one connector.
+type DrainStatus struct {
+	// Since is when the drain was requested.
+	Since time.Time
+	// Tunnelers is the number of tunnelers the connector last reported as
+	// connected; it is only meaningful once Reported is set.
+	Tunnelers  int
+	Reported   bool
+	ReportedAt time.Time
+}
+
+// Drained reports whether the connector has confirmed it has no tunnelers
+// left.
+func (d DrainStatus) Drained() bool {
+	return d.Reported && d.Tunnelers == 0
+}
+
+// StartDrain marks connector id as draining and reports whether it was not
+// already. Drain state is kept in memory only and is not part of state
+// snapshots.
+func (r *Registry) StartDrain(id string) (DrainStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.drains[id]; ok {
+		return *d, false
+	}
+	d := &DrainStatus{Since: time.Now().UTC()}
+	r.drains[id] = d
+	return *d, true
+}
+
+// StopDrain clears the drain of connector id and reports whether it was
+// draining.
+func (r *Registry) StopDrain(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.drains[id]; !ok {
+		return false
+	}
+	delete(r.drains, id)
+	return true
+}
+
+// Drain returns the drain status of connector id and whether it is draining.
+func (r *Registry) Drain(id string) (DrainStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.drains[id]
+	if !ok {
+		return DrainStatus{}, false
+	}
+	return *d, true
+}
+
+// RecordDrainProgress stores the tunneler count reported by draining
+// connector id. It reports whether this report completed the drain, which
+// happens once per drain. Reports from connectors that are not draining are
+// ignored.
+func (r *Registry) RecordDrainProgress(id string, tunnelers int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.drains[id]
+	if !ok {
+		return false
+	}
+	wasDrained := d.Drained()
+	d.Tunnelers = tunnelers
+	d.Reported = true
+	d.ReportedAt = time.Now().UTC()
+	return !wasDrained && d.Drained()
+}
@@ -0,0 +1,103 @@
+package state
+
+import "time"
+
+// Connector event types recorded in the per-connector history.
+const (
+	EventEnrolled     = "enrolled"
+	EventRenewed      = "renewed"
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+	EventRevoked      = "revoked"
+	EventRejected     = "rejected"
+	EventDraining     = "draining"
+	EventDrained      = "drained"
+	EventUndrained    = "undrained"
+)
+
+const (
+	// maxConnectorEvents is the ring size per connector; older events are
+	// overwritten.
+	maxConnectorEvents = 64
+	// maxEventHistories caps how many connectors keep a history. Past it,
+	// the history updated least recently is dropped, so ids that never
+	// return cannot grow memory without bound.
+	maxEventHistories = 4096
+)
+
+// ConnectorEvent is one entry in a connector's history.
+type ConnectorEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// eventRing holds the most recent maxConnectorEvents events of a connector.
+type eventRing struct {
+	events []ConnectorEvent
+	next   int
+	last   time.Time
+}
+
+func (e *eventRing) add(ev ConnectorEvent) {
+	if len(e.events) < maxConnectorEvents {
+		e.events = append(e.events, ev)
+	} else {
+		e.events[e.next] = ev
+	}
+	e.next = (e.next + 1) % maxConnectorEvents
+	e.last = ev.Time
+}
+
+// list returns the events oldest first.
+func (e *eventRing) list() []ConnectorEvent {
+	out := make([]ConnectorEvent, 0, len(e.events))
+	if len(e.events) == maxConnectorEvents {
+		out = append(out, e.events[e.next:]...)
+		out = append(out, e.events[:e.next]...)
+		return out
+	}
+	return append(out, e.events...)
+}
+
+// RecordEvent appends an event of type typ to the history of connector id.
+// Histories are kept in memory only and are not part of state snapshots.
+func (r *Registry) RecordEvent(id, typ, detail string) {
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ring, ok := r.events[id]
+	if !ok {
+		if len(r.events) >= maxEventHistories {
+			r.dropOldestHistory()
+		}
+		ring = &eventRing{}
+		r.events[id] = ring
+	}
+	ring.add(ConnectorEvent{Time: now, Type: typ, Detail: detail})
+}
+
+// Events returns the recorded history of connector id, oldest first, and
+// whether any history exists.
+func (r *Registry) Events(id string) ([]ConnectorEvent, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ring, ok := r.events[id]
+	if !ok {
+		return nil, false
+	}
+	return ring.list(), true
+}
+
+// dropOldestHistory removes the history updated least recently; r.mu must
+// be held.
+func (r *Registry) dropOldestHistory() {
+	var oldestID string
+	var oldest time.Time
+	for id, ring := range r.events {
+		if oldestID == "" || ring.last.Before(oldest) {
+			oldestID, oldest = id, ring.last
+		}
+	}
+	delete(r.events, oldestID)
+}
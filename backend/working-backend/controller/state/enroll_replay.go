@@ -0,0 +1,174 @@
+package state
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// EnrollReplayCache remembers recently issued enrollment certificates by a
+// digest of the request, so a client that retries after losing the response
+// gets the same certificate back instead of failing on its spent token.
+// Entries live for the window and at most maxEntries are kept; past that the
+// least recently used entry is evicted, which only costs that client a fresh
+// enrollment.
+type EnrollReplayCache struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+	// inflight holds a channel per key whose enrollment is being issued;
+	// it is closed when that attempt finishes.
+	inflight map[string]chan struct{}
+	// now is the clock entries expire by; tests replace it.
+	now func() time.Time
+}
+
+type enrollReplay struct {
+	key     string
+	certPEM []byte
+	expires time.Time
+}
+
+// NewEnrollReplayCache returns a cache holding up to maxEntries issuances for
+// window, or nil when either is not positive. A nil cache remembers nothing.
+func NewEnrollReplayCache(window time.Duration, maxEntries int) *EnrollReplayCache {
+	if window <= 0 || maxEntries <= 0 {
+		return nil
+	}
+	return &EnrollReplayCache{
+		window:     window,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		inflight:   make(map[string]chan struct{}),
+		now:        time.Now,
+	}
+}
+
+// Claim returns the certificate issued for key within the window. Otherwise
+// it makes the caller the only one issuing for key. Either way it returns
+// done, which the caller must call once it has stored a certificate or given
+// up. An identical request arriving meanwhile waits for done and then gets
+// the stored certificate, so concurrent retries see one issuance; if the
+// first attempt fails, the next waiter issues instead. Claim fails only when
+// ctx ends while waiting.
+func (c *EnrollReplayCache) Claim(ctx context.Context, key string) ([]byte, func(), error) {
+	if c == nil {
+		return nil, func() {}, nil
+	}
+	for {
+		c.mu.Lock()
+		if certPEM, ok := c.lookupLocked(key); ok {
+			c.mu.Unlock()
+			return certPEM, func() {}, nil
+		}
+		wait, busy := c.inflight[key]
+		if !busy {
+			ch := make(chan struct{})
+			c.inflight[key] = ch
+			c.mu.Unlock()
+			var once sync.Once
+			return nil, func() {
+				once.Do(func() {
+					c.mu.Lock()
+					delete(c.inflight, key)
+					c.mu.Unlock()
+					close(ch)
+				})
+			}, nil
+		}
+		c.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, func() {}, ctx.Err()
+		}
+	}
+}
+
+func (c *EnrollReplayCache) lookupLocked(key string) ([]byte, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*enrollReplay)
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.certPEM, true
+}
+
+// Store records the certificate issued for key, evicting the least recently
+// used entry when full.
+func (c *EnrollReplayCache) Store(key string, certPEM []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	for c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*enrollReplay).key)
+	}
+	c.entries[key] = c.order.PushFront(&enrollReplay{key: key, certPEM: certPEM, expires: c.now().Add(c.window)})
+}
+
+// Len returns the number of entries held, expired ones included until the
+// next sweep.
+func (c *EnrollReplayCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Sweep drops expired entries and returns how many it removed.
+func (c *EnrollReplayCache) Sweep() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	removed := 0
+	for key, el := range c.entries {
+		if !now.Before(el.Value.(*enrollReplay).expires) {
+			c.order.Remove(el)
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RunCleaner sweeps expired entries every half window, at most once a
+// second, until ctx ends, so certificates of clients that never retry are not
+// held past their window.
+func (c *EnrollReplayCache) RunCleaner(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(max(c.window/2, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Sweep()
+		}
+	}
+}
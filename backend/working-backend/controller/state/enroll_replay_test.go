@@ -0,0 +1,158 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestReplayCache returns a cache whose clock only moves when advance is
+// called.
+func newTestReplayCache(window time.Duration, maxEntries int) (*EnrollReplayCache, func(time.Duration)) {
+	c := NewEnrollReplayCache(window, maxEntries)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+// lookup returns the stored certificate for key, or nil after releasing the
+// claim a miss hands out.
+func lookup(t *testing.T, c *EnrollReplayCache, key string) []byte {
+	t.Helper()
+	certPEM, done, err := c.Claim(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Claim(%s): %v", key, err)
+	}
+	done()
+	return certPEM
+}
+
+func TestEnrollReplayCacheWindowBoundary(t *testing.T) {
+	c, advance := newTestReplayCache(time.Minute, 10)
+	c.Store("k", []byte("cert"))
+
+	advance(time.Minute - time.Nanosecond)
+	if got := lookup(t, c, "k"); string(got) != "cert" {
+		t.Fatalf("just inside the window: got %q, want the stored certificate", got)
+	}
+	advance(time.Nanosecond)
+	if got := lookup(t, c, "k"); got != nil {
+		t.Fatalf("at the end of the window: got %q, want a miss", got)
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("Len after an expired lookup = %d, want 0", n)
+	}
+
+	c.Store("a", []byte("a"))
+	advance(30 * time.Second)
+	c.Store("b", []byte("b"))
+	advance(30 * time.Second)
+	if removed := c.Sweep(); removed != 1 {
+		t.Fatalf("Sweep removed %d entries, want 1", removed)
+	}
+	if got := lookup(t, c, "b"); string(got) != "b" {
+		t.Fatalf("entry inside its window after Sweep: got %q", got)
+	}
+}
+
+func TestEnrollReplayCacheLRUEviction(t *testing.T) {
+	c, _ := newTestReplayCache(time.Hour, 3)
+	c.Store("a", []byte("a"))
+	c.Store("b", []byte("b"))
+	c.Store("c", []byte("c"))
+	// Reading a makes b the least recently used.
+	if got := lookup(t, c, "a"); string(got) != "a" {
+		t.Fatalf("lookup a = %q", got)
+	}
+	c.Store("d", []byte("d"))
+
+	if n := c.Len(); n != 3 {
+		t.Fatalf("Len at capacity = %d, want 3", n)
+	}
+	if got := lookup(t, c, "b"); got != nil {
+		t.Fatalf("least recently used entry survived: %q", got)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if got := lookup(t, c, key); string(got) != key {
+			t.Errorf("lookup %s = %q, want %q", key, got, key)
+		}
+	}
+
+	// Storing an existing key replaces it without evicting another.
+	c.Store("a", []byte("a2"))
+	if n := c.Len(); n != 3 {
+		t.Fatalf("Len after replacing a key = %d, want 3", n)
+	}
+	if got := lookup(t, c, "a"); string(got) != "a2" {
+		t.Fatalf("lookup a after replacement = %q", got)
+	}
+}
+
+func TestEnrollReplayCacheClaimCoalesces(t *testing.T) {
+	c := NewEnrollReplayCache(time.Minute, 10)
+	ctx := context.Background()
+
+	certPEM, issue, err := c.Claim(ctx, "k")
+	if err != nil || certPEM != nil {
+		t.Fatalf("first Claim = %q, %v; want a miss", certPEM, err)
+	}
+
+	const waiters = 8
+	results := make(chan []byte, waiters)
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, done, err := c.Claim(ctx, "k")
+			if err != nil {
+				t.Error(err)
+			}
+			done()
+			results <- got
+		}()
+	}
+
+	// Nobody may get past Claim while the first attempt is in flight.
+	time.Sleep(20 * time.Millisecond)
+	if n := len(results); n != 0 {
+		t.Fatalf("%d waiters returned before the first attempt finished", n)
+	}
+	c.Store("k", []byte("cert"))
+	issue()
+	issue() // done is idempotent
+	wg.Wait()
+	close(results)
+	for got := range results {
+		if string(got) != "cert" {
+			t.Errorf("waiter got %q, want the coalesced certificate", got)
+		}
+	}
+
+	// A failed attempt hands the claim to the next caller.
+	_, giveUp, _ := c.Claim(ctx, "other")
+	next := make(chan []byte, 1)
+	go func() {
+		got, done, err := c.Claim(ctx, "other")
+		if err != nil {
+			t.Error(err)
+		}
+		next <- got
+		done()
+	}()
+	giveUp()
+	if got := <-next; got != nil {
+		t.Fatalf("waiter after a failed attempt got %q, want a miss to issue itself", got)
+	}
+
+	// A waiter gives up with its context.
+	_, hold, _ := c.Claim(ctx, "held")
+	defer hold()
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, done, err := c.Claim(cctx, "held"); err == nil {
+		done()
+		t.Fatal("Claim returned without the in-flight attempt finishing")
+	}
+}
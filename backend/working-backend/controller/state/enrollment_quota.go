@@ -0,0 +1,98 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+// enrollmentQuotaWindow is the rolling window of EnrollmentQuota.
+const enrollmentQuotaWindow = 24 * time.Hour
+
+// EnrollmentQuota caps new enrollments across all tokens over a rolling
+// 24-hour window. Renewals of existing identities are not counted.
+type EnrollmentQuota struct {
+	mu       sync.Mutex
+	limit    int
+	issued   []time.Time
+	exceeded bool
+}
+
+// NewEnrollmentQuota returns a quota admitting limit enrollments per 24
+// hours, or nil when limit is not positive. A nil quota admits everything.
+func NewEnrollmentQuota(limit int) *EnrollmentQuota {
+	if limit <= 0 {
+		return nil
+	}
+	return &EnrollmentQuota{limit: limit}
+}
+
+// Reserve claims one enrollment. ok is false once the quota is used up;
+// first is true for the first refusal after the quota was last available,
+// so callers can alarm once per exhaustion. A reservation whose enrollment
+// then fails should be returned with Release.
+func (q *EnrollmentQuota) Reserve() (ok, first bool) {
+	if q == nil {
+		return true, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	q.prune(now)
+	if len(q.issued) >= q.limit {
+		first = !q.exceeded
+		q.exceeded = true
+		return false, first
+	}
+	q.exceeded = false
+	q.issued = append(q.issued, now)
+	return true, false
+}
+
+// Release returns the most recent reservation.
+func (q *EnrollmentQuota) Release() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n := len(q.issued); n > 0 {
+		q.issued = q.issued[:n-1]
+	}
+}
+
+// Used returns the enrollments counted in the current window.
+func (q *EnrollmentQuota) Used() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(time.Now())
+	return len(q.issued)
+}
+
+// Exhausted reports whether Reserve would currently refuse.
+func (q *EnrollmentQuota) Exhausted() bool {
+	if q == nil {
+		return false
+	}
+	return q.Used() >= q.limit
+}
+
+// Limit returns the configured cap.
+func (q *EnrollmentQuota) Limit() int {
+	if q == nil {
+		return 0
+	}
+	return q.limit
+}
+
+func (q *EnrollmentQuota) prune(now time.Time) {
+	i := 0
+	for i < len(q.issued) && now.Sub(q.issued[i]) >= enrollmentQuotaWindow {
+		i++
+	}
+	if i > 0 {
+		q.issued = append(q.issued[:0], q.issued[i:]...)
+	}
+}
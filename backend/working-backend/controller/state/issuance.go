@@ -0,0 +1,99 @@
+package state
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// issuanceWindow is the sliding window over which issuance rates are
+	// measured.
+	issuanceWindow = time.Hour
+	// maxIssuanceHistory caps the timestamps kept per identity so a
+	// runaway client cannot grow memory without bound.
+	maxIssuanceHistory = 1024
+)
+
+// IssuanceStats summarizes the certificates issued to one SPIFFE id.
+type IssuanceStats struct {
+	// Total counts every certificate issued since the controller started.
+	Total int
+	// RatePerHour is the number issued during the last hour.
+	RatePerHour int
+	// ExpectedPerHour is the bound derived from the certificate TTL above
+	// which the identity is considered anomalous.
+	ExpectedPerHour int
+	Anomalous       bool
+}
+
+type issuanceRecord struct {
+	total  int
+	ttl    time.Duration
+	issued []time.Time
+}
+
+// issuanceBound returns the most certificates per window a well-behaved
+// client with this TTL should need. Clients renew at 70% of the TTL; the
+// bound allows three times that plus a little slack for restarts.
+func issuanceBound(ttl time.Duration) int {
+	if ttl <= 0 {
+		return math.MaxInt
+	}
+	perWindow := int(math.Ceil(float64(issuanceWindow) / float64(ttl*7/10)))
+	return 3*perWindow + 2
+}
+
+// RecordIssuance notes a certificate issued to spiffeID with the given TTL
+// and returns the updated stats.
+func (r *Registry) RecordIssuance(spiffeID string, ttl time.Duration) IssuanceStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.issuance[spiffeID]
+	if !ok {
+		rec = &issuanceRecord{}
+		r.issuance[spiffeID] = rec
+	}
+	now := time.Now()
+	rec.total++
+	rec.ttl = ttl
+	rec.issued = append(pruneIssued(rec.issued, now), now)
+	if len(rec.issued) > maxIssuanceHistory {
+		rec.issued = rec.issued[len(rec.issued)-maxIssuanceHistory:]
+	}
+	return rec.stats(now)
+}
+
+// IssuanceStats returns the issuance stats for spiffeID.
+func (r *Registry) IssuanceStats(spiffeID string) (IssuanceStats, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rec, ok := r.issuance[spiffeID]
+	if !ok {
+		return IssuanceStats{}, false
+	}
+	return rec.stats(time.Now()), true
+}
+
+func (rec *issuanceRecord) stats(now time.Time) IssuanceStats {
+	rate := 0
+	for _, t := range rec.issued {
+		if now.Sub(t) < issuanceWindow {
+			rate++
+		}
+	}
+	bound := issuanceBound(rec.ttl)
+	return IssuanceStats{
+		Total:           rec.total,
+		RatePerHour:     rate,
+		ExpectedPerHour: bound,
+		Anomalous:       rate > bound,
+	}
+}
+
+func pruneIssued(issued []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(issued) && now.Sub(issued[i]) >= issuanceWindow {
+		i++
+	}
+	return issued[i:]
+}
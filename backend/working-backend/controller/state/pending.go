@@ -0,0 +1,103 @@
+package state
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	pendingTTL        = 24 * time.Hour
+	maxPendingRecords = 1024
+)
+
+// PendingEnrollment is a connector enrollment request awaiting operator approval.
+type PendingEnrollment struct {
+	ID           string
+	PublicKeySHA string
+	PrivateIP    string
+	Version      string
+	PeerAddr     string
+	RequestedAt  time.Time
+	Approved     bool
+	ApprovedAt   time.Time
+}
+
+// PendingStore holds enrollment requests for ENROLL_MODE=approval.
+type PendingStore struct {
+	mu      sync.Mutex
+	pending map[string]*PendingEnrollment
+}
+
+func NewPendingStore() *PendingStore {
+	return &PendingStore{pending: make(map[string]*PendingEnrollment)}
+}
+
+// Check records or refreshes a pending request and reports whether it has
+// been approved for this exact public key. An unapproved request from a new
+// key replaces the previous one; an approved id presented with a different
+// key is rejected.
+func (s *PendingStore) Check(req PendingEnrollment) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+
+	rec, ok := s.pending[req.ID]
+	if ok && rec.Approved {
+		if rec.PublicKeySHA != req.PublicKeySHA {
+			return false, errors.New("approved enrollment is bound to a different public key")
+		}
+		return true, nil
+	}
+	if !ok && len(s.pending) >= maxPendingRecords {
+		return false, errors.New("too many pending enrollments")
+	}
+	req.Approved = false
+	req.RequestedAt = time.Now().UTC()
+	s.pending[req.ID] = &req
+	return false, nil
+}
+
+// Approve marks a pending request as approved.
+func (s *PendingStore) Approve(id string) (PendingEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.pending[id]
+	if !ok {
+		return PendingEnrollment{}, errors.New("no pending enrollment")
+	}
+	rec.Approved = true
+	rec.ApprovedAt = time.Now().UTC()
+	return *rec, nil
+}
+
+// Complete removes a request once its certificate has been issued.
+func (s *PendingStore) Complete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+}
+
+func (s *PendingStore) List() []PendingEnrollment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	out := make([]PendingEnrollment, 0, len(s.pending))
+	for _, rec := range s.pending {
+		out = append(out, *rec)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].RequestedAt.Before(out[j].RequestedAt)
+	})
+	return out
+}
+
+func (s *PendingStore) pruneLocked() {
+	cutoff := time.Now().Add(-pendingTTL)
+	for id, rec := range s.pending {
+		if rec.RequestedAt.Before(cutoff) {
+			delete(s.pending, id)
+		}
+	}
+}
@@ -10,20 +10,67 @@ type ConnectorRecord struct {
 	ID        string
 	PrivateIP string
 	Version   string
-	LastSeen  time.Time
+	DNSNames  []string
+	// ListenAddr is the connector's tunneler-facing address as reported in
+	// heartbeats; the host part may be empty or unspecified.
+	ListenAddr string
+	LastSeen   time.Time
+
+	ClockSkew   time.Duration
+	ClockSkewed bool
+
+	// Provisioning is the cloud metadata the connector reported at
+	// enrollment. It is self-reported and informational only.
+	Provisioning Provisioning
+}
+
+// Provisioning describes where a connector was provisioned, as reported by
+// the connector from its cloud instance metadata service.
+type Provisioning struct {
+	Provider   string
+	InstanceID string
+	Region     string
 }
 
 type Registry struct {
 	mu         sync.RWMutex
 	connectors map[string]*ConnectorRecord
+	// keyFingerprints maps a SPIFFE id to the SHA-256 fingerprint of the
+	// public key in its most recently issued certificate.
+	keyFingerprints map[string]string
+	// issuance tracks certificates issued per SPIFFE id; see issuance.go.
+	issuance map[string]*issuanceRecord
+	// events holds per-connector histories; see connector_events.go.
+	events map[string]*eventRing
+	// drains holds connectors being drained; see connector_drain.go.
+	drains map[string]*DrainStatus
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
-		connectors: make(map[string]*ConnectorRecord),
+		connectors:      make(map[string]*ConnectorRecord),
+		keyFingerprints: make(map[string]string),
+		issuance:        make(map[string]*issuanceRecord),
+		events:          make(map[string]*eventRing),
+		drains:          make(map[string]*DrainStatus),
 	}
 }
 
+// KeyFingerprint returns the public key fingerprint last issued to spiffeID.
+func (r *Registry) KeyFingerprint(spiffeID string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fp, ok := r.keyFingerprints[spiffeID]
+	return fp, ok
+}
+
+// SetKeyFingerprint records the public key fingerprint issued to spiffeID.
+func (r *Registry) SetKeyFingerprint(spiffeID, fingerprint string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keyFingerprints[spiffeID] = fingerprint
+}
+
 func (r *Registry) Register(id, privateIP, version string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -37,7 +84,35 @@ func (r *Registry) Register(id, privateIP, version string) {
 	rec.LastSeen = time.Now().UTC()
 }
 
-func (r *Registry) RecordHeartbeat(id, privateIP string) {
+// SetDNSNames records the DNS SANs approved for a connector at enrollment so
+// renewals can re-issue them.
+func (r *Registry) SetDNSNames(id string, names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.connectors[id]
+	if !ok {
+		return
+	}
+	rec.DNSNames = append([]string(nil), names...)
+}
+
+// RecordClockSkew stores the last measured clock skew for a connector and
+// reports whether its skewed flag changed.
+func (r *Registry) RecordClockSkew(id string, skew time.Duration, skewed bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.connectors[id]
+	if !ok {
+		rec = &ConnectorRecord{ID: id}
+		r.connectors[id] = rec
+	}
+	changed := rec.ClockSkewed != skewed
+	rec.ClockSkew = skew
+	rec.ClockSkewed = skewed
+	return changed
+}
+
+func (r *Registry) RecordHeartbeat(id, privateIP, listenAddr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	rec, ok := r.connectors[id]
@@ -48,9 +123,35 @@ func (r *Registry) RecordHeartbeat(id, privateIP string) {
 	if privateIP != "" {
 		rec.PrivateIP = privateIP
 	}
+	if listenAddr != "" {
+		rec.ListenAddr = listenAddr
+	}
 	rec.LastSeen = time.Now().UTC()
 }
 
+// RecordVersion updates the version a connected connector reports, which
+// may differ from the one it enrolled with after an upgrade. Unknown ids and
+// empty versions are ignored.
+func (r *Registry) RecordVersion(id, version string) {
+	if version == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.connectors[id]; ok {
+		rec.Version = version
+	}
+}
+
+// SetProvisioning records the provisioning metadata reported by connector id.
+func (r *Registry) SetProvisioning(id string, p Provisioning) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.connectors[id]; ok {
+		rec.Provisioning = p
+	}
+}
+
 func (r *Registry) List() []ConnectorRecord {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
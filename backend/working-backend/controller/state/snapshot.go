@@ -0,0 +1,201 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SnapshotVersion is the schema version written by Export and the only one
+// Import accepts.
+const SnapshotVersion = 1
+
+// Snapshot is a point-in-time copy of all controller state, used to move a
+// controller between hosts. Tokens are included as hashes only.
+type Snapshot struct {
+	Version                  int                       `json:"version"`
+	CreatedAt                time.Time                 `json:"created_at"`
+	TrustDomain              string                    `json:"trust_domain"`
+	Tokens                   []TokenRecord             `json:"tokens"`
+	Connectors               []ConnectorRecord         `json:"connectors"`
+	KeyFingerprints          map[string]string         `json:"key_fingerprints"`
+	Tunnelers                []TunnelerInfo            `json:"tunnelers"`
+	TunnelerStatus           []TunnelerRecord          `json:"tunneler_status"`
+	TunnelerPreRegistrations []TunnelerPreRegistration `json:"tunneler_preregistrations"`
+}
+
+// Stores groups the state stores covered by a Snapshot.
+type Stores struct {
+	Tokens              *TokenStore
+	Registry            *Registry
+	Tunnelers           *TunnelerRegistry
+	TunnelerStatus      *TunnelerStatusRegistry
+	TunnelerPreRegistry *TunnelerPreRegistry
+}
+
+func (s Stores) check() error {
+	if s.Tokens == nil || s.Registry == nil || s.Tunnelers == nil || s.TunnelerStatus == nil || s.TunnelerPreRegistry == nil {
+		return errors.New("snapshot requires all state stores")
+	}
+	return nil
+}
+
+// lock acquires every store lock in a fixed order so Export and Import see
+// and produce a consistent cut across stores.
+func (s Stores) lock() func() {
+	s.Tokens.mu.Lock()
+	s.Registry.mu.Lock()
+	s.Tunnelers.mu.Lock()
+	s.TunnelerStatus.mu.Lock()
+	s.TunnelerPreRegistry.mu.Lock()
+	return func() {
+		s.TunnelerPreRegistry.mu.Unlock()
+		s.TunnelerStatus.mu.Unlock()
+		s.Tunnelers.mu.Unlock()
+		s.Registry.mu.Unlock()
+		s.Tokens.mu.Unlock()
+	}
+}
+
+// Export returns a consistent snapshot of all stores.
+func (s Stores) Export(trustDomain string) (Snapshot, error) {
+	if err := s.check(); err != nil {
+		return Snapshot{}, err
+	}
+	unlock := s.lock()
+	defer unlock()
+
+	snap := Snapshot{
+		Version:                  SnapshotVersion,
+		CreatedAt:                time.Now().UTC(),
+		TrustDomain:              trustDomain,
+		Tokens:                   make([]TokenRecord, 0, len(s.Tokens.tokens)),
+		Connectors:               make([]ConnectorRecord, 0, len(s.Registry.connectors)),
+		KeyFingerprints:          make(map[string]string, len(s.Registry.keyFingerprints)),
+		Tunnelers:                make([]TunnelerInfo, 0, len(s.Tunnelers.order)),
+		TunnelerStatus:           make([]TunnelerRecord, 0, len(s.TunnelerStatus.tunnelers)),
+		TunnelerPreRegistrations: make([]TunnelerPreRegistration, 0, len(s.TunnelerPreRegistry.ids)),
+	}
+	for _, rec := range s.Tokens.tokens {
+		snap.Tokens = append(snap.Tokens, *rec)
+	}
+	for _, rec := range s.Registry.connectors {
+		snap.Connectors = append(snap.Connectors, *rec)
+	}
+	for id, fp := range s.Registry.keyFingerprints {
+		snap.KeyFingerprints[id] = fp
+	}
+	for _, id := range s.Tunnelers.order {
+		if info, ok := s.Tunnelers.byID[id]; ok {
+			snap.Tunnelers = append(snap.Tunnelers, info)
+		}
+	}
+	for _, rec := range s.TunnelerStatus.tunnelers {
+		snap.TunnelerStatus = append(snap.TunnelerStatus, *rec)
+	}
+	for id, at := range s.TunnelerPreRegistry.ids {
+		snap.TunnelerPreRegistrations = append(snap.TunnelerPreRegistrations, TunnelerPreRegistration{ID: id, RegisteredAt: at})
+	}
+	return snap, nil
+}
+
+// Import loads snap into empty stores. The snapshot is validated in full
+// before anything is applied, so an import either loads every section or
+// nothing.
+func (s Stores) Import(snap Snapshot, trustDomain string) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	if err := snap.validate(trustDomain); err != nil {
+		return err
+	}
+
+	unlock := s.lock()
+	defer unlock()
+	if len(s.Tokens.tokens) > 0 || len(s.Registry.connectors) > 0 || len(s.Registry.keyFingerprints) > 0 ||
+		len(s.Tunnelers.byID) > 0 || len(s.TunnelerStatus.tunnelers) > 0 || len(s.TunnelerPreRegistry.ids) > 0 {
+		return ErrStateNotEmpty
+	}
+
+	for i := range snap.Tokens {
+		rec := snap.Tokens[i]
+		s.Tokens.tokens[rec.Hash] = &rec
+	}
+	for i := range snap.Connectors {
+		rec := snap.Connectors[i]
+		s.Registry.connectors[rec.ID] = &rec
+	}
+	for id, fp := range snap.KeyFingerprints {
+		s.Registry.keyFingerprints[id] = fp
+	}
+	for _, info := range snap.Tunnelers {
+		if _, exists := s.Tunnelers.byID[info.ID]; !exists {
+			s.Tunnelers.order = append(s.Tunnelers.order, info.ID)
+		}
+		s.Tunnelers.byID[info.ID] = info
+	}
+	for i := range snap.TunnelerStatus {
+		rec := snap.TunnelerStatus[i]
+		s.TunnelerStatus.tunnelers[rec.ID] = &rec
+	}
+	for _, reg := range snap.TunnelerPreRegistrations {
+		s.TunnelerPreRegistry.ids[reg.ID] = reg.RegisteredAt
+	}
+	return s.Tokens.saveLocked()
+}
+
+// ErrStateNotEmpty is returned by Import when the target controller already
+// holds state.
+var ErrStateNotEmpty = errors.New("controller state is not empty; import requires a fresh controller")
+
+func (snap Snapshot) validate(trustDomain string) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (want %d)", snap.Version, SnapshotVersion)
+	}
+	if snap.TrustDomain != trustDomain {
+		return fmt.Errorf("snapshot trust domain %q does not match %q", snap.TrustDomain, trustDomain)
+	}
+	switch {
+	case snap.Tokens == nil:
+		return errors.New("snapshot is missing tokens")
+	case snap.Connectors == nil:
+		return errors.New("snapshot is missing connectors")
+	case snap.KeyFingerprints == nil:
+		return errors.New("snapshot is missing key_fingerprints")
+	case snap.Tunnelers == nil:
+		return errors.New("snapshot is missing tunnelers")
+	case snap.TunnelerStatus == nil:
+		return errors.New("snapshot is missing tunneler_status")
+	case snap.TunnelerPreRegistrations == nil:
+		return errors.New("snapshot is missing tunneler_preregistrations")
+	}
+	for _, rec := range snap.Tokens {
+		if len(rec.Hash) != 64 {
+			return fmt.Errorf("invalid token hash %q", rec.Hash)
+		}
+		if _, err := ParseTokenKind(rec.Kind); err != nil {
+			return fmt.Errorf("token %s: %w", rec.Hash[:8], err)
+		}
+	}
+	for _, rec := range snap.Connectors {
+		if rec.ID == "" {
+			return errors.New("connector record without id")
+		}
+	}
+	for _, info := range snap.Tunnelers {
+		if info.ID == "" || info.SPIFFEID == "" {
+			return errors.New("tunneler record without id or spiffe_id")
+		}
+	}
+	for _, rec := range snap.TunnelerStatus {
+		if rec.ID == "" {
+			return errors.New("tunneler status record without id")
+		}
+	}
+	for _, reg := range snap.TunnelerPreRegistrations {
+		if reg.ID == "" {
+			return errors.New("tunneler pre-registration without id")
+		}
+	}
+	return nil
+}
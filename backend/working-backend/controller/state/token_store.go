@@ -6,8 +6,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,38 +20,111 @@ type TokenRecord struct {
 	ExpiresAt   time.Time
 	Used        bool
 	ConnectorID string
+	// BoundCIDR, when set, is the network the private IP presented with
+	// the token must fall in.
+	BoundCIDR string `json:",omitempty"`
+	// Kind is the role the token enrolls, TokenKindConnector or
+	// TokenKindTunneler. Records written before kinds existed have none and
+	// count as connector tokens.
+	Kind string `json:",omitempty"`
 }
 
+// Token kinds. A token only enrolls the role it was created for.
+const (
+	TokenKindConnector = "connector"
+	TokenKindTunneler  = "tunneler"
+)
+
+// ParseTokenKind validates a token kind; empty selects TokenKindConnector.
+func ParseTokenKind(v string) (string, error) {
+	switch v {
+	case "", TokenKindConnector:
+		return TokenKindConnector, nil
+	case TokenKindTunneler:
+		return TokenKindTunneler, nil
+	}
+	return "", fmt.Errorf("unknown token kind %q", v)
+}
+
+func (r *TokenRecord) kind() string {
+	if r.Kind == "" {
+		return TokenKindConnector
+	}
+	return r.Kind
+}
+
+// DefaultMaxTokenTTL caps token lifetimes when no maximum is configured.
+const DefaultMaxTokenTTL = 24 * time.Hour
+
 type TokenStore struct {
 	mu     sync.Mutex
 	tokens map[string]*TokenRecord
 	ttl    time.Duration
+	maxTTL time.Duration
 	path   string
 }
 
-func NewTokenStore(ttl time.Duration, path string) *TokenStore {
+// NewTokenStore returns a store whose tokens live for ttl, capped at maxTTL.
+// A non-positive ttl defaults to the cap; a non-positive maxTTL uses
+// DefaultMaxTokenTTL.
+func NewTokenStore(ttl, maxTTL time.Duration, path string) *TokenStore {
+	if maxTTL <= 0 {
+		maxTTL = DefaultMaxTokenTTL
+	}
 	store := &TokenStore{
 		tokens: make(map[string]*TokenRecord),
 		ttl:    ttl,
+		maxTTL: maxTTL,
 		path:   path,
 	}
 	_ = store.load()
 	return store
 }
 
+// MaxTTL returns the cap applied to every token lifetime.
+func (s *TokenStore) MaxTTL() time.Duration {
+	return s.maxTTL
+}
+
+// EffectiveTTL returns the lifetime a token requested with ttl gets: the
+// store default when ttl is not positive, capped at MaxTTL. clamped reports
+// that the cap shortened it.
+func (s *TokenStore) EffectiveTTL(ttl time.Duration) (effective time.Duration, clamped bool) {
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	if ttl <= 0 {
+		return s.maxTTL, false
+	}
+	if ttl > s.maxTTL {
+		return s.maxTTL, true
+	}
+	return ttl, false
+}
+
+// CreateToken creates a connector token with the store's default lifetime.
 func (s *TokenStore) CreateToken() (string, time.Time, error) {
+	return s.CreateTokenWithTTL(0)
+}
+
+// CreateTokenWithTTL creates a connector token that expires after
+// EffectiveTTL(ttl).
+func (s *TokenStore) CreateTokenWithTTL(ttl time.Duration) (string, time.Time, error) {
+	return s.CreateBoundToken(TokenKindConnector, ttl, "")
+}
+
+// CreateBoundToken creates a token of kind that expires after
+// EffectiveTTL(ttl) and is only accepted with a private IP in boundCIDR, as
+// returned by ParseIPBinding; empty leaves the token unbound.
+func (s *TokenStore) CreateBoundToken(kind string, ttl time.Duration, boundCIDR string) (string, time.Time, error) {
 	raw := make([]byte, 16)
 	if _, err := rand.Read(raw); err != nil {
 		return "", time.Time{}, err
 	}
 	token := hex.EncodeToString(raw)
 	hash := hashToken(token)
-	expires := time.Time{}
-	if s.ttl > 0 {
-		expires = time.Now().Add(s.ttl)
-	} else {
-		expires = time.Now().Add(10 * 365 * 24 * time.Hour)
-	}
+	effective, _ := s.EffectiveTTL(ttl)
+	expires := time.Now().Add(effective)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -56,6 +132,8 @@ func (s *TokenStore) CreateToken() (string, time.Time, error) {
 		Hash:      hash,
 		ExpiresAt: expires,
 		Used:      false,
+		BoundCIDR: boundCIDR,
+		Kind:      kind,
 	}
 	if err := s.saveLocked(); err != nil {
 		return "", time.Time{}, err
@@ -63,7 +141,10 @@ func (s *TokenStore) CreateToken() (string, time.Time, error) {
 	return token, expires, nil
 }
 
-func (s *TokenStore) ConsumeToken(token, connectorID string) error {
+// ConsumeToken accepts token for the workload id enrolling as kind.
+// privateIP is the address the enrolling host claims; it must fall in the
+// token's BoundCIDR, if any.
+func (s *TokenStore) ConsumeToken(token, kind, connectorID, privateIP string) error {
 	if token == "" {
 		return errors.New("missing token")
 	}
@@ -81,10 +162,67 @@ func (s *TokenStore) ConsumeToken(token, connectorID string) error {
 	if !rec.ExpiresAt.IsZero() && time.Now().After(rec.ExpiresAt) {
 		return errors.New("token expired")
 	}
+	if rec.kind() != kind {
+		return &TokenKindError{Kind: rec.kind(), Want: kind}
+	}
+	if rec.BoundCIDR != "" && !ipInCIDR(privateIP, rec.BoundCIDR) {
+		return &TokenBindingError{BoundCIDR: rec.BoundCIDR, PrivateIP: privateIP}
+	}
 	rec.ConnectorID = connectorID
 	return s.saveLocked()
 }
 
+// TokenKindError reports a token presented for a role it was not created
+// for.
+type TokenKindError struct {
+	Kind string
+	Want string
+}
+
+func (e *TokenKindError) Error() string {
+	return fmt.Sprintf("%s token cannot enroll a %s", e.Kind, e.Want)
+}
+
+// TokenBindingError reports a private IP outside the token's binding.
+type TokenBindingError struct {
+	BoundCIDR string
+	PrivateIP string
+}
+
+func (e *TokenBindingError) Error() string {
+	if e.PrivateIP == "" {
+		return fmt.Sprintf("token is bound to %s but no private IP was presented", e.BoundCIDR)
+	}
+	return fmt.Sprintf("token is bound to %s, not %s", e.BoundCIDR, e.PrivateIP)
+}
+
+// ParseIPBinding validates an IP address or CIDR for CreateBoundToken and
+// returns it as a canonical prefix; a single address becomes a /32 or /128.
+func ParseIPBinding(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if prefix, err := netip.ParsePrefix(v); err == nil {
+		return prefix.Masked().String(), nil
+	}
+	addr, err := netip.ParseAddr(v)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an IP address or CIDR", v)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+}
+
+func ipInCIDR(ip, cidr string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(addr.Unmap())
+}
+
 func hashToken(token string) string {
 	sum := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(sum[:])
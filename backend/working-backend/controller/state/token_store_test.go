@@ -0,0 +1,46 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenStoreEffectiveTTL(t *testing.T) {
+	s := NewTokenStore(time.Hour, 6*time.Hour, filepath.Join(t.TempDir(), "tokens.json"))
+	tests := []struct {
+		requested   time.Duration
+		want        time.Duration
+		wantClamped bool
+	}{
+		{0, time.Hour, false},
+		{-time.Minute, time.Hour, false},
+		{30 * time.Minute, 30 * time.Minute, false},
+		{6 * time.Hour, 6 * time.Hour, false},
+		{7 * time.Hour, 6 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		got, clamped := s.EffectiveTTL(tt.requested)
+		if got != tt.want || clamped != tt.wantClamped {
+			t.Errorf("EffectiveTTL(%s) = %s, %v; want %s, %v", tt.requested, got, clamped, tt.want, tt.wantClamped)
+		}
+	}
+
+	// Without a store default tokens get the cap, which defaults too.
+	s = NewTokenStore(0, 0, filepath.Join(t.TempDir(), "tokens.json"))
+	if got, clamped := s.EffectiveTTL(0); got != DefaultMaxTokenTTL || clamped {
+		t.Fatalf("EffectiveTTL(0) = %s, %v; want %s", got, clamped, DefaultMaxTokenTTL)
+	}
+}
+
+func TestTokenStoreCreateTokenWithTTLCapped(t *testing.T) {
+	s := NewTokenStore(0, time.Hour, filepath.Join(t.TempDir(), "tokens.json"))
+	start := time.Now()
+	_, expires, err := s.CreateTokenWithTTL(30 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires.After(time.Now().Add(time.Hour)) || expires.Before(start.Add(time.Hour)) {
+		t.Fatalf("token expires at %s, want an hour after %s", expires, start)
+	}
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TunnelerPreRegistration is a tunneler id an admin has approved for
+// enrollment.
+type TunnelerPreRegistration struct {
+	ID           string
+	RegisteredAt time.Time
+}
+
+// TunnelerPreRegistry holds tunneler ids that EnrollTunneler may issue
+// certificates for. Enrollment of any other id is refused.
+type TunnelerPreRegistry struct {
+	mu  sync.RWMutex
+	ids map[string]time.Time
+}
+
+func NewTunnelerPreRegistry() *TunnelerPreRegistry {
+	return &TunnelerPreRegistry{ids: make(map[string]time.Time)}
+}
+
+// Register approves id for enrollment and reports whether it was new.
+func (r *TunnelerPreRegistry) Register(id string) (TunnelerPreRegistration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if at, ok := r.ids[id]; ok {
+		return TunnelerPreRegistration{ID: id, RegisteredAt: at}, false
+	}
+	at := time.Now().UTC()
+	r.ids[id] = at
+	return TunnelerPreRegistration{ID: id, RegisteredAt: at}, true
+}
+
+func (r *TunnelerPreRegistry) IsRegistered(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.ids[id]
+	return ok
+}
+
+func (r *TunnelerPreRegistry) List() []TunnelerPreRegistration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]TunnelerPreRegistration, 0, len(r.ids))
+	for id, at := range r.ids {
+		out = append(out, TunnelerPreRegistration{ID: id, RegisteredAt: at})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
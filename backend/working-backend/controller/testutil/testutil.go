@@ -0,0 +1,291 @@
+// Package testutil boots an in-process controller for integration tests. The
+// controller is wired like main: an ephemeral CA, mTLS gRPC with the SPIFFE
+// interceptors on a random loopback port, and the admin HTTP API. Helpers
+// create enrollment tokens and drive a fake connector through enrollment,
+// renewal and the control plane.
+package testutil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/admin"
+	"controller/api"
+	"controller/ca"
+	controllerpb "controller/gen/controllerpb"
+	"controller/state"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TrustDomain is the trust domain of controllers started by Start.
+const TrustDomain = "test.internal"
+
+// Controller is a running in-process controller. Its state stores are
+// exposed so tests can assert on them directly.
+type Controller struct {
+	// Addr is the gRPC listener as host:port.
+	Addr string
+	// AdminURL is the base URL of the admin HTTP API.
+	AdminURL string
+	// CAPEM is the internal CA certificate.
+	CAPEM []byte
+	// AdminToken authorizes admin API requests.
+	AdminToken string
+
+	CA             *ca.CA
+	Tokens         *state.TokenStore
+	Registry       *state.Registry
+	Tunnelers      *state.TunnelerRegistry
+	TunnelerStatus *state.TunnelerStatusRegistry
+	ControlPlane   *api.ControlPlaneServer
+	Enrollment     *api.EnrollmentServer
+}
+
+// Start boots a controller and stops it when the test finishes.
+func Start(t testing.TB) *Controller {
+	t.Helper()
+
+	caCertPEM, caKeyPEM, err := ca.GenerateSelfSignedCA("testutil internal CA", time.Hour)
+	if err != nil {
+		t.Fatalf("generate CA: %v", err)
+	}
+	caInst, err := ca.LoadCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		t.Fatalf("load CA: %v", err)
+	}
+	serverCert, err := issueCert(caInst, "spiffe://"+TrustDomain+"/controller/test", time.Hour)
+	if err != nil {
+		t.Fatalf("issue controller certificate: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AppendCertsFromPEM(caCertPEM)
+
+	c := &Controller{
+		CAPEM:          caCertPEM,
+		AdminToken:     "test-admin-token",
+		CA:             caInst,
+		Tokens:         state.NewTokenStore(0, 0, filepath.Join(t.TempDir(), "tokens.json")),
+		Registry:       state.NewRegistry(),
+		Tunnelers:      state.NewTunnelerRegistry(),
+		TunnelerStatus: state.NewTunnelerStatusRegistry(),
+	}
+	c.ControlPlane = api.NewControlPlaneServer(TrustDomain, c.Registry, c.Tunnelers, c.TunnelerStatus)
+	c.Enrollment = api.NewEnrollmentServer(caInst, caCertPEM, TrustDomain, c.Tokens, c.Registry, c.ControlPlane)
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(api.ObserveHandshakes(credentials.NewTLS(&tls.Config{
+			Certificates:     []tls.Certificate{serverCert},
+			ClientCAs:        caPool,
+			ClientAuth:       tls.VerifyClientCertIfGiven,
+			MinVersion:       tls.VersionTLS13,
+			VerifyConnection: api.VerifyPeerSPIFFE(TrustDomain),
+		}), "main")),
+		grpc.ChainUnaryInterceptor(api.UnaryPolicyInterceptor(TrustDomain, true)),
+		grpc.StreamInterceptor(api.StreamPolicyInterceptor(TrustDomain)),
+		grpc.StatsHandler(api.ControlPlaneStats{}),
+	)
+	controllerpb.RegisterEnrollmentServiceServer(grpcServer, c.Enrollment)
+	controllerpb.RegisterControlPlaneServer(grpcServer, c.ControlPlane)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	c.Addr = lis.Addr().String()
+	go grpcServer.Serve(lis)
+
+	adminAuth, _ := admin.NewAuthToken("ADMIN_AUTH_TOKEN", c.AdminToken, "", 0)
+	internalAuth, _ := admin.NewAuthToken("INTERNAL_API_TOKEN", "test-internal-token", "", 0)
+	adminMux := http.NewServeMux()
+	(&admin.Server{
+		Tokens:              c.Tokens,
+		Reg:                 c.Registry,
+		Tunnelers:           c.TunnelerStatus,
+		TunnelerPreRegistry: state.NewTunnelerPreRegistry(),
+		Config:              c.ControlPlane,
+		Allowlist:           c.ControlPlane,
+		Drain:               c.ControlPlane,
+		Streams:             c.ControlPlane,
+		Policy:              c.Enrollment,
+		TrustDomain:         TrustDomain,
+		CA:                  caInst,
+		AdminAuth:           adminAuth,
+		InternalAuth:        internalAuth,
+	}).RegisterRoutes(adminMux)
+	adminHTTP := httptest.NewServer(adminMux)
+	c.AdminURL = adminHTTP.URL
+
+	t.Cleanup(func() {
+		c.ControlPlane.DisconnectAll(api.DisconnectShutdown, "test controller stopping")
+		grpcServer.Stop()
+		adminHTTP.Close()
+	})
+	return c
+}
+
+// CreateToken returns a fresh single-use enrollment token.
+func (c *Controller) CreateToken(t testing.TB) string {
+	t.Helper()
+	token, _, err := c.Tokens.CreateToken()
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	return token
+}
+
+// Connector is a fake connector holding a workload certificate issued by the
+// controller.
+type Connector struct {
+	ID       string
+	SPIFFEID string
+	Cert     tls.Certificate
+
+	controller *Controller
+}
+
+// EnrollConnector enrolls a fake connector with a fresh token, the way the
+// connector binary does before it has a certificate.
+func (c *Controller) EnrollConnector(t testing.TB, id string) *Connector {
+	t.Helper()
+	conn := c.dial(t, nil)
+	defer conn.Close()
+
+	cert, err := c.requestCert(t, id, func(ctx context.Context, req *controllerpb.EnrollRequest, opts ...grpc.CallOption) (*controllerpb.EnrollResponse, error) {
+		req.Token = c.CreateToken(t)
+		req.PrivateIp = "127.0.0.1"
+		req.Version = "test"
+		return controllerpb.NewEnrollmentServiceClient(conn).EnrollConnector(ctx, req, opts...)
+	})
+	if err != nil {
+		t.Fatalf("enroll connector %s: %v", id, err)
+	}
+	return &Connector{
+		ID:         id,
+		SPIFFEID:   "spiffe://" + TrustDomain + "/connector/" + id,
+		Cert:       cert,
+		controller: c,
+	}
+}
+
+// Dial opens an mTLS connection to the controller as this connector.
+func (w *Connector) Dial(t testing.TB) *grpc.ClientConn {
+	t.Helper()
+	return w.controller.dial(t, &w.Cert)
+}
+
+// Renew replaces the connector certificate through the Renew RPC with a new
+// key pair, and returns the error instead of failing so that tests can
+// exercise refusals.
+func (w *Connector) Renew(t testing.TB) error {
+	t.Helper()
+	conn := w.Dial(t)
+	defer conn.Close()
+
+	cert, err := w.controller.requestCert(t, w.ID, controllerpb.NewEnrollmentServiceClient(conn).Renew)
+	if err != nil {
+		return err
+	}
+	w.Cert = cert
+	return nil
+}
+
+// Connect opens the control-plane stream and sends connector_hello. The
+// stream ends when ctx is cancelled.
+func (w *Connector) Connect(ctx context.Context, t testing.TB) controllerpb.ControlPlane_ConnectClient {
+	t.Helper()
+	conn := w.Dial(t)
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := controllerpb.NewControlPlaneClient(conn).Connect(ctx)
+	if err != nil {
+		t.Fatalf("connect control plane: %v", err)
+	}
+	if err := stream.Send(&controllerpb.ControlMessage{Type: "connector_hello", ClientTime: time.Now().UnixMilli()}); err != nil {
+		t.Fatalf("send connector_hello: %v", err)
+	}
+	return stream
+}
+
+func (c *Controller) dial(t testing.TB, cert *tls.Certificate) *grpc.ClientConn {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.CAPEM)
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		ServerName: "localhost",
+		MinVersion: tls.VersionTLS13,
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	conn, err := grpc.NewClient(c.Addr, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	if err != nil {
+		t.Fatalf("dial controller: %v", err)
+	}
+	return conn
+}
+
+// requestCert generates a key pair, sends its public key through call and
+// pairs the returned certificate with the private key.
+func (c *Controller) requestCert(
+	t testing.TB,
+	id string,
+	call func(context.Context, *controllerpb.EnrollRequest, ...grpc.CallOption) (*controllerpb.EnrollResponse, error),
+) (tls.Certificate, error) {
+	t.Helper()
+	key, pubPEM, err := generateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := call(ctx, &controllerpb.EnrollRequest{Id: id, PublicKey: pubPEM})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(resp.GetCertificate())
+	if block == nil {
+		t.Fatalf("controller returned invalid certificate PEM")
+	}
+	return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key}, nil
+}
+
+func generateKey() (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func issueCert(caInst *ca.CA, spiffeID string, ttl time.Duration) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM, err := ca.IssueWorkloadCert(caInst, spiffeID, &key.PublicKey, ttl, []string{"localhost"}, []net.IP{net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(certPEM)
+	return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key}, nil
+}
@@ -0,0 +1,169 @@
+package testutil
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+	"time"
+
+	controllerpb "controller/gen/controllerpb"
+	"controller/spiffeid"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEnrollHeartbeatRenew(t *testing.T) {
+	c := Start(t)
+	conn := c.EnrollConnector(t, "conn-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := conn.Connect(ctx, t)
+	if err := stream.Send(&controllerpb.ControlMessage{
+		Type:        "heartbeat",
+		ConnectorId: conn.ID,
+		PrivateIp:   "10.0.0.7",
+		Version:     "test-2",
+		ClientTime:  time.Now().UnixMilli(),
+	}); err != nil {
+		t.Fatalf("send heartbeat: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec, ok := c.Registry.Get(conn.ID)
+		if ok && rec.PrivateIP == "10.0.0.7" && rec.Version == "test-2" && !rec.LastSeen.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registry did not record the heartbeat: %+v (found %v)", rec, ok)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	before := conn.Cert.Certificate[0]
+	renewedAt := time.Now()
+	if err := conn.Renew(t); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(conn.Cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse renewed certificate: %v", err)
+	}
+	if string(leaf.Raw) == string(before) {
+		t.Fatal("renew returned the enrollment certificate")
+	}
+	id, err := spiffeid.FromLeaf(leaf)
+	if err != nil {
+		t.Fatalf("renewed certificate SPIFFE ID: %v", err)
+	}
+	if id.String() != conn.SPIFFEID {
+		t.Errorf("renewed SPIFFE ID = %s, want %s", id, conn.SPIFFEID)
+	}
+	// Connector certificates are renewed for 5 minutes.
+	if want := renewedAt.Add(5 * time.Minute); leaf.NotAfter.Before(want.Add(-2*time.Second)) || leaf.NotAfter.After(want.Add(2*time.Second)) {
+		t.Errorf("renewed NotAfter = %s, want about %s", leaf.NotAfter, want)
+	}
+}
+
+func TestRelayedTunnelerHeartbeatAttribution(t *testing.T) {
+	c := Start(t)
+	conn := c.EnrollConnector(t, "conn-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := conn.Connect(ctx, t)
+	relay := func(tunnelerID, connectorID string) {
+		t.Helper()
+		payload, _ := json.Marshal(map[string]string{
+			"tunneler_id":  tunnelerID,
+			"spiffe_id":    "spiffe://" + TrustDomain + "/tunneler/" + tunnelerID,
+			"connector_id": connectorID,
+		})
+		if err := stream.Send(&controllerpb.ControlMessage{Type: "tunneler_heartbeat", Payload: payload, Status: "ONLINE"}); err != nil {
+			t.Fatalf("send tunneler_heartbeat: %v", err)
+		}
+	}
+
+	// A heartbeat claiming another connector is dropped; one naming no
+	// connector is attributed to the stream's own identity. The stream is
+	// processed in order, so once the last one is recorded the first has
+	// been handled.
+	relay("t-spoofed", "conn-2")
+	relay("t-1", "")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recs := c.TunnelerStatus.List()
+		if len(recs) > 0 {
+			if len(recs) != 1 || recs[0].ID != "t-1" || recs[0].ConnectorID != conn.ID {
+				t.Fatalf("tunneler status = %+v, want only t-1 on %s", recs, conn.ID)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("relayed tunneler heartbeat was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlPlaneStreamLimit(t *testing.T) {
+	c := Start(t)
+	c.ControlPlane.MaxStreams = 1
+	first := c.EnrollConnector(t, "conn-1")
+	second := c.EnrollConnector(t, "conn-2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	heartbeat := func(stream controllerpb.ControlPlane_ConnectClient, version string) {
+		t.Helper()
+		if err := stream.Send(&controllerpb.ControlMessage{Type: "heartbeat", ConnectorId: first.ID, Version: version}); err != nil {
+			t.Fatalf("send heartbeat: %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if rec, ok := c.Registry.Get(first.ID); ok && rec.Version == version {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("heartbeat %s was not recorded", version)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	heartbeat(first.Connect(ctx, t), "v1")
+
+	// A second connector is over the limit.
+	rejected := second.Connect(ctx, t)
+	var err error
+	for err == nil {
+		_, err = rejected.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("stream over the limit ended with %v, want ResourceExhausted", err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() <= 0 {
+		t.Fatalf("rejection carries no retry delay: %v", status.Convert(err).Details())
+	}
+
+	// A connector replacing its own stream is admitted at the limit.
+	// The replaced stream is released once it closes.
+	heartbeat(first.Connect(ctx, t), "v2")
+	deadline := time.Now().Add(5 * time.Second)
+	for c.ControlPlane.ActiveStreams() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d streams active after replacement, want 1", c.ControlPlane.ActiveStreams())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
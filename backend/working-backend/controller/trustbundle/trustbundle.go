@@ -0,0 +1,174 @@
+// Package trustbundle signs and verifies CA trust bundles: the internal CA
+// certificates and trust domain in a JSON container signed by the CA key, so
+// clients can check a distributed CA against a pinned fingerprint or public
+// key before installing it. The connector's install-trust-bundle command
+// verifies with this package as well.
+package trustbundle
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"controller/ca"
+)
+
+// Version is the payload format version Sign writes and Verify accepts.
+const Version = 1
+
+// sigPrefix separates bundle signatures from other ca.SignBlob signatures,
+// such as signed enrollment responses.
+const sigPrefix = "grpccontroller trust-bundle\x00"
+
+// Payload is the signed content of a bundle.
+type Payload struct {
+	Version     int       `json:"version"`
+	TrustDomain string    `json:"trust_domain"`
+	IssuedAt    time.Time `json:"issued_at"`
+	// CAPEM holds one or more CA certificates, e.g. the current and the
+	// next CA during a rotation.
+	CAPEM string `json:"ca_pem"`
+}
+
+// Bundle is the container written to disk. Payload is the exact signed
+// JSON, base64-encoded so re-formatting the file cannot break the
+// signature. Signer is the certificate of the signing CA key.
+type Bundle struct {
+	Payload   []byte `json:"payload"`
+	Signer    string `json:"signer"`
+	Signature []byte `json:"signature"`
+}
+
+// Pin identifies the key a bundle must be signed with: the hex SHA-256 of
+// the signing CA certificate (ca_sha256 in GET /api/admin/info), or
+// its public key.
+type Pin struct {
+	Fingerprint string
+	PublicKey   crypto.PublicKey
+}
+
+// Sign builds a bundle of caPEM for trustDomain, signed by signer.
+func Sign(signer *ca.CA, trustDomain string, caPEM []byte, now time.Time) ([]byte, error) {
+	if _, err := ParseCAs(caPEM); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(Payload{
+		Version:     Version,
+		TrustDomain: trustDomain,
+		IssuedAt:    now.UTC().Truncate(time.Second),
+		CAPEM:       string(caPEM),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ca.SignBlob(signer, append([]byte(sigPrefix), payload...))
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(Bundle{
+		Payload:   payload,
+		Signer:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Cert.Raw})),
+		Signature: sig,
+	}, "", "  ")
+}
+
+// Verify checks that data is a bundle signed by the pinned key and returns
+// its payload. The CA certificates in the payload are parsed and must all
+// be CAs.
+func Verify(data []byte, pin Pin) (*Payload, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	block, _ := pem.Decode([]byte(b.Signer))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("bundle has no signer certificate")
+	}
+	signer, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signer certificate: %w", err)
+	}
+	if err := pin.check(signer); err != nil {
+		return nil, err
+	}
+	if err := ca.VerifyBlob(signer, append([]byte(sigPrefix), b.Payload...), b.Signature); err != nil {
+		return nil, err
+	}
+
+	var p Payload
+	if err := json.Unmarshal(b.Payload, &p); err != nil {
+		return nil, fmt.Errorf("parse payload: %w", err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d", p.Version)
+	}
+	if p.TrustDomain == "" {
+		return nil, errors.New("bundle has no trust domain")
+	}
+	if _, err := ParseCAs([]byte(p.CAPEM)); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Fingerprint returns the hex SHA-256 of a certificate's DER, the format
+// Pin.Fingerprint expects.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// check matches the signer certificate against the pin. Fingerprints may
+// carry a "sha256:" prefix, colons and upper case.
+func (p Pin) check(signer *x509.Certificate) error {
+	switch {
+	case p.Fingerprint != "":
+		want := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(p.Fingerprint, "sha256:"), ":", ""))
+		if got := Fingerprint(signer); got != want {
+			return fmt.Errorf("bundle signed by CA %s, not the pinned %s", got, want)
+		}
+	case p.PublicKey != nil:
+		key, ok := p.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !key.Equal(signer.PublicKey) {
+			return errors.New("bundle not signed by the pinned public key")
+		}
+	default:
+		return errors.New("no fingerprint or public key pinned")
+	}
+	return nil
+}
+
+// ParseCAs parses every certificate in caPEM and requires each to be a CA.
+// Clients use it to load the CAs of a verified payload.
+func ParseCAs(caPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := caPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse CA certificate: %w", err)
+		}
+		if !cert.IsCA {
+			return nil, fmt.Errorf("certificate %q is not a CA", cert.Subject.String())
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no CA certificate")
+	}
+	return certs, nil
+}
@@ -0,0 +1,109 @@
+package trustbundle
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"controller/ca"
+)
+
+func newTestCA(t *testing.T, algorithm string) (*ca.CA, []byte) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCAWithAlgorithm("bundle test ca", time.Hour, algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, certPEM
+}
+
+func TestVerify(t *testing.T) {
+	for _, alg := range []string{ca.KeyAlgorithmECDSA, ca.KeyAlgorithmEd25519} {
+		t.Run(alg, func(t *testing.T) {
+			signer, caPEM := newTestCA(t, alg)
+			other, _ := newTestCA(t, alg)
+			data, err := Sign(signer, "example.org", caPEM, time.Now())
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			for name, pin := range map[string]Pin{
+				"fingerprint": {Fingerprint: Fingerprint(signer.Cert)},
+				"formatted":   {Fingerprint: "sha256:" + strings.ToUpper(Fingerprint(signer.Cert))},
+				"public key":  {PublicKey: signer.Cert.PublicKey},
+			} {
+				p, err := Verify(data, pin)
+				if err != nil {
+					t.Fatalf("Verify pinned by %s: %v", name, err)
+				}
+				if p.TrustDomain != "example.org" || p.CAPEM != string(caPEM) {
+					t.Fatalf("Verify pinned by %s returned %+v", name, p)
+				}
+			}
+
+			var b Bundle
+			if err := json.Unmarshal(data, &b); err != nil {
+				t.Fatal(err)
+			}
+			tamper := func(f func(b *Bundle)) []byte {
+				c := b
+				c.Payload = append([]byte(nil), b.Payload...)
+				c.Signature = append([]byte(nil), b.Signature...)
+				f(&c)
+				out, err := json.Marshal(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			}
+			otherSigned, err := Sign(other, "example.org", caPEM, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pin := Pin{Fingerprint: Fingerprint(signer.Cert)}
+			cases := []struct {
+				name string
+				data []byte
+				pin  Pin
+			}{
+				{"tampered signature", tamper(func(b *Bundle) { b.Signature[len(b.Signature)-1] ^= 1 }), pin},
+				{"tampered payload", tamper(func(b *Bundle) {
+					b.Payload = []byte(strings.Replace(string(b.Payload), "example.org", "evil.example", 1))
+				}), pin},
+				{"missing signature", tamper(func(b *Bundle) { b.Signature = nil }), pin},
+				{"wrong signer", otherSigned, pin},
+				{"wrong signer by public key", otherSigned, Pin{PublicKey: signer.Cert.PublicKey}},
+				// The pinned certificate presented as the signer does not
+				// make another key's signature valid.
+				{"signer swapped", func() []byte {
+					var ob Bundle
+					if err := json.Unmarshal(otherSigned, &ob); err != nil {
+						t.Fatal(err)
+					}
+					ob.Signer = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Cert.Raw}))
+					out, _ := json.Marshal(ob)
+					return out
+				}(), pin},
+				{"no pin", data, Pin{}},
+			}
+			for _, tc := range cases {
+				if _, err := Verify(tc.data, tc.pin); err == nil {
+					t.Errorf("%s: Verify accepted the bundle", tc.name)
+				}
+			}
+		})
+	}
+}
+
+func TestSignRejectsNonCA(t *testing.T) {
+	signer, _ := newTestCA(t, ca.KeyAlgorithmECDSA)
+	if _, err := Sign(signer, "example.org", []byte("not a certificate"), time.Now()); err == nil {
+		t.Fatal("Sign accepted input without a CA certificate")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"controller/ca"
+	"controller/config"
+	"controller/spiffeid"
+)
+
+// certCheck is one line of the verify-cert report.
+type certCheck struct {
+	name   string
+	err    error
+	detail string
+}
+
+// runVerifyCert implements "controller verify-cert": it runs the checks the
+// runtime verifiers apply to a workload certificate and prints a pass/fail
+// line for each, so a "handshake failed" can be narrowed to a cause. It
+// returns the process exit code.
+func runVerifyCert(args []string, stdout, stderr io.Writer) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid configuration: %v\n", err)
+		return 2
+	}
+	fs := flag.NewFlagSet("verify-cert", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	certPath := fs.String("cert", "", "PEM file holding the certificate to check, optionally followed by intermediates")
+	caPath := fs.String("ca", "", "PEM file holding the trusted CA certificates")
+	trustDomain := fs.String("trust-domain", cfg.TrustDomain, "expected SPIFFE trust domain (default TRUST_DOMAIN, else mycorp.internal)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *certPath == "" || *caPath == "" || fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: controller verify-cert --cert <file> --ca <file> [--trust-domain <domain>]")
+		return 2
+	}
+	td := strings.TrimSuffix(strings.TrimSpace(*trustDomain), ".")
+	if td == "" {
+		td = "mycorp.internal"
+	}
+	spiffeid.Default = cfg.SPIFFEPolicy
+
+	checks := verifyCertFiles(*certPath, *caPath, td, cfg.EKUs, time.Now())
+	failed := 0
+	for _, c := range checks {
+		result, msg := "PASS", c.detail
+		if c.err != nil {
+			result, msg = "FAIL", c.err.Error()
+			failed++
+		}
+		fmt.Fprintf(stdout, "%s  %-14s %s\n", result, c.name, msg)
+	}
+	if failed > 0 {
+		fmt.Fprintf(stdout, "%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Fprintf(stdout, "all %d checks passed\n", len(checks))
+	return 0
+}
+
+// verifyCertFiles loads the certificate and CA bundle and checks the leaf.
+// Checks that depend on an earlier failure are not run.
+func verifyCertFiles(certPath, caPath, trustDomain string, ekus ca.EKUPolicy, now time.Time) []certCheck {
+	chain, err := readCertChain(certPath)
+	if err != nil {
+		return []certCheck{{name: "certificate", err: err}}
+	}
+	leaf := chain[0]
+	checks := []certCheck{{name: "certificate", detail: fmt.Sprintf("subject=%q serial=%s", leaf.Subject.String(), leaf.SerialNumber.String())}}
+
+	roots, err := readCertChain(caPath)
+	if err != nil {
+		return append(checks, certCheck{name: "ca", err: err})
+	}
+	checks = append(checks, certCheck{name: "ca", detail: fmt.Sprintf("%d certificate(s), first %q", len(roots), roots[0].Subject.String())})
+
+	return append(checks, checkLeaf(leaf, chain[1:], roots, trustDomain, ekus, now)...)
+}
+
+// checkLeaf runs the per-certificate checks: validity window, chain to the
+// CA, the SPIFFE SAN and ID, leaf shape, trust domain and extended key usage.
+func checkLeaf(leaf *x509.Certificate, intermediates, roots []*x509.Certificate, trustDomain string, ekus ca.EKUPolicy, now time.Time) []certCheck {
+	var checks []certCheck
+
+	validity := certCheck{name: "validity"}
+	switch {
+	case now.Before(leaf.NotBefore):
+		validity.err = fmt.Errorf("not valid until %s (%s from now); check the clocks", leaf.NotBefore.UTC().Format(time.RFC3339), leaf.NotBefore.Sub(now).Round(time.Second))
+	case !now.Before(leaf.NotAfter):
+		validity.err = fmt.Errorf("expired %s (%s ago)", leaf.NotAfter.UTC().Format(time.RFC3339), now.Sub(leaf.NotAfter).Round(time.Second))
+	default:
+		validity.detail = fmt.Sprintf("until %s (%s left)", leaf.NotAfter.UTC().Format(time.RFC3339), leaf.NotAfter.Sub(now).Round(time.Second))
+	}
+	checks = append(checks, validity)
+
+	// The chain is checked without key usages so a wrong EKU is reported
+	// by its own check rather than as an untrusted chain.
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, c := range roots {
+		opts.Roots.AddCert(c)
+	}
+	for _, c := range intermediates {
+		opts.Intermediates.AddCert(c)
+	}
+	if validity.err != nil {
+		// Report the chain independently of the expiry already flagged.
+		opts.CurrentTime = leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+	}
+	chain := certCheck{name: "chain"}
+	if verified, err := leaf.Verify(opts); err != nil {
+		chain.err = err
+	} else {
+		path := verified[0]
+		chain.detail = fmt.Sprintf("issued by %q", path[len(path)-1].Subject.String())
+	}
+	checks = append(checks, chain)
+
+	san := certCheck{name: "spiffe san"}
+	u, err := spiffeid.URIOf(leaf)
+	if err != nil {
+		san.err = fmt.Errorf("%v (URI SANs: %s)", err, uriList(leaf))
+		return append(checks, san)
+	}
+	san.detail = u.String()
+	checks = append(checks, san)
+
+	id, err := spiffeid.ParseURI(u)
+	if err != nil {
+		return append(checks, certCheck{name: "spiffe id", err: err})
+	}
+	checks = append(checks, certCheck{name: "spiffe id", detail: fmt.Sprintf("role=%s id=%s", id.Role, id.Name)})
+
+	shape := certCheck{name: "leaf", detail: "not a CA, digitalSignature key usage"}
+	if _, err := spiffeid.FromLeaf(leaf); err != nil {
+		shape.err = err
+	}
+	checks = append(checks, shape)
+
+	domain := certCheck{name: "trust domain", detail: id.TrustDomain}
+	if id.TrustDomain != trustDomain {
+		domain.err = fmt.Errorf("certificate is for %q, expected %q", id.TrustDomain, trustDomain)
+	}
+	checks = append(checks, domain)
+
+	return append(checks, checkEKU(leaf, id.Role, ekus))
+}
+
+// checkEKU requires the extended key usages the issuance policy grants the
+// certificate's role; peers reject a leaf without the usage for their side
+// of the handshake.
+func checkEKU(leaf *x509.Certificate, role string, ekus ca.EKUPolicy) certCheck {
+	check := certCheck{name: "eku"}
+	want, ok := ekus[role]
+	if !ok {
+		check.err = fmt.Errorf("role %q has no extended key usage policy", role)
+		return check
+	}
+	have := make(map[x509.ExtKeyUsage]bool, len(leaf.ExtKeyUsage))
+	for _, u := range leaf.ExtKeyUsage {
+		have[u] = true
+	}
+	var missing []string
+	for _, u := range want {
+		if !have[u] && !have[x509.ExtKeyUsageAny] {
+			missing = append(missing, ekuName(u))
+		}
+	}
+	if len(missing) > 0 {
+		check.err = fmt.Errorf("%s certificate lacks %s", role, strings.Join(missing, ", "))
+		return check
+	}
+	names := make([]string, 0, len(want))
+	for _, u := range want {
+		names = append(names, ekuName(u))
+	}
+	check.detail = strings.Join(names, ", ")
+	return check
+}
+
+func ekuName(u x509.ExtKeyUsage) string {
+	switch u {
+	case x509.ExtKeyUsageClientAuth:
+		return "clientAuth"
+	case x509.ExtKeyUsageServerAuth:
+		return "serverAuth"
+	}
+	return fmt.Sprintf("eku(%d)", u)
+}
+
+func uriList(cert *x509.Certificate) string {
+	if len(cert.URIs) == 0 {
+		return "none"
+	}
+	uris := make([]string, 0, len(cert.URIs))
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+	}
+	return strings.Join(uris, ", ")
+}
+
+// readCertChain parses every CERTIFICATE block in a PEM file.
+func readCertChain(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New(path + ": no PEM certificate found")
+	}
+	return certs, nil
+}
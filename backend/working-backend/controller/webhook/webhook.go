@@ -0,0 +1,179 @@
+// Package webhook delivers controller lifecycle events to an external HTTP
+// endpoint (Slack/PagerDuty relays, etc.). Delivery is best-effort: events are
+// queued and sent by a background worker so callers on the control plane never
+// block on webhook latency.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"controller/metrics"
+)
+
+// Event types emitted by the controller.
+const (
+	ConnectorOnline  = "connector_online"
+	ConnectorOffline = "connector_offline"
+	TunnelerEnrolled = "tunneler_enrolled"
+	TokenConsumed    = "token_consumed"
+
+	EnrollmentQuotaExceeded = "enrollment_quota_exceeded"
+	BreakGlassUsed          = "break_glass_used"
+	ConnectorDrained        = "connector_drained"
+)
+
+const (
+	queueSize   = 256
+	maxAttempts = 4
+)
+
+var (
+	webhookDelivered = metrics.NewCounter(
+		"controller_webhook_delivered_total",
+		"Webhook events delivered successfully.",
+	)
+	webhookFailed = metrics.NewCounter(
+		"controller_webhook_failed_total",
+		"Webhook events dropped after exhausting retries.",
+	)
+	webhookDropped = metrics.NewCounter(
+		"controller_webhook_queue_dropped_total",
+		"Webhook events dropped because the delivery queue was full.",
+	)
+)
+
+// Event is the JSON body POSTed to the webhook URL.
+type Event struct {
+	Type string            `json:"type"`
+	Time string            `json:"time"`
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Notifier queues events and POSTs them to a webhook URL.
+type Notifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan Event
+}
+
+// New returns a Notifier for url, or nil if url is empty. When secret is set,
+// each request carries an X-Webhook-Signature header of the form
+// "sha256=<hex HMAC-SHA256(secret, timestamp + "." + body)>" and the timestamp
+// in X-Webhook-Timestamp.
+func New(url, secret string) *Notifier {
+	if url == "" {
+		return nil
+	}
+	return &Notifier{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan Event, queueSize),
+	}
+}
+
+// Start runs the delivery worker until ctx is canceled.
+func (n *Notifier) Start(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-n.queue:
+				n.deliver(ctx, ev)
+			}
+		}
+	}()
+}
+
+// Notify enqueues an event without blocking. If the queue is full the event is
+// dropped and counted.
+func (n *Notifier) Notify(eventType string, data map[string]string) {
+	if n == nil {
+		return
+	}
+	ev := Event{
+		Type: eventType,
+		Time: time.Now().UTC().Format(time.RFC3339),
+		Data: data,
+	}
+	select {
+	case n.queue <- ev:
+	default:
+		webhookDropped.Inc()
+		log.Printf("webhook queue full, dropping event type=%s", eventType)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	backoff := 500 * time.Millisecond
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = n.post(ctx, body)
+		if err == nil {
+			webhookDelivered.Inc()
+			return
+		}
+		if attempt == maxAttempts {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+	webhookFailed.Inc()
+	log.Printf("webhook delivery failed: type=%s err=%v", ev.Type, err)
+}
+
+func (n *Notifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Webhook-Timestamp", ts)
+		req.Header.Set("X-Webhook-Signature", "sha256="+Sign(n.secret, ts, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign computes the hex HMAC-SHA256 signature over timestamp + "." + body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
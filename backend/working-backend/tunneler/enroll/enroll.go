@@ -2,20 +2,22 @@ package enroll
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"controller/enrolloutput"
+	"controller/enrollresponse"
 	controllerpb "controller/gen/controllerpb"
+	"controller/spiffeid"
+	"tunneler/internal/config"
 	"tunneler/internal/tlsutil"
 
 	"google.golang.org/grpc"
@@ -29,11 +31,31 @@ type Config struct {
 	TrustDomain    string
 	RootCAPEM      []byte
 	Token          string
+
+	// BootstrapAddr is the controller's enrollment-only listener
+	// (CONTROLLER_BOOTSTRAP_ADDR); empty enrolls via ControllerAddr.
+	BootstrapAddr string
+	// ControllerID, when set, is the exact SPIFFE ID the controller must
+	// present (EXPECTED_CONTROLLER_SPIFFE_ID).
+	ControllerID string
+	// ExtraSANs are requested at enrollment and renewal
+	// (TUNNELER_EXTRA_SANS).
+	ExtraSANs ExtraSANs
+	// KeyAlgorithm is the workload key type (KEY_ALGORITHM).
+	KeyAlgorithm string
+	// ResponseMaxSkew bounds how far the controller's server_time may be
+	// from the local clock (ENROLL_RESPONSE_MAX_SKEW).
+	ResponseMaxSkew time.Duration
 }
 
-// Run performs one-time tunneler enrollment with the controller.
-func Run() error {
-	cfg, err := ConfigFromEnv()
+// Run performs one-time tunneler enrollment with the controller. args are
+// the enroll subcommand flags (see enrolloutput.ParseFlags).
+func Run(args []string, c *config.Config) error {
+	out, err := enrolloutput.ParseFlags(args, c.OutputPassword, ReadCredential)
+	if err != nil {
+		return err
+	}
+	cfg, err := NewConfig(c)
 	if err != nil {
 		return err
 	}
@@ -41,38 +63,43 @@ func Run() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	cert, _, _, spiffeID, err := Enroll(ctx, cfg)
+	cert, certPEM, caPEM, spiffeID, err := Enroll(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	_ = cert
+	if err := out.Write(cert, certPEM, caPEM); err != nil {
+		return fmt.Errorf("write enrollment output: %w", err)
+	}
+	if out.ToStdout() {
+		log.Printf("Enrolled tunneler with SPIFFE ID: %s", spiffeID)
+		return nil
+	}
+	if out.Dir != "" {
+		log.Printf("wrote %s identity to %s", out.Format, out.Dir)
+	}
 	fmt.Printf("Enrolled tunneler with SPIFFE ID: %s\n", spiffeID)
 	return nil
 }
 
-// ConfigFromEnv builds Config from environment variables.
-func ConfigFromEnv() (Config, error) {
-	controllerAddr := os.Getenv("CONTROLLER_ADDR")
-	tunnelerID := os.Getenv("TUNNELER_ID")
-	trustDomain := os.Getenv("TRUST_DOMAIN")
-	token := os.Getenv("ENROLLMENT_TOKEN")
-	if trustDomain == "" {
-		trustDomain = "mycorp.internal"
+// NewConfig builds Config from the tunneler configuration, reading the
+// controller CA and the enrollment token, which is required.
+func NewConfig(c *config.Config) (Config, error) {
+	controllerID, err := ExpectedControllerID(c.ExpectedControllerID, c.TrustDomain)
+	if err != nil {
+		return Config{}, err
 	}
-	trustDomain = normalizeTrustDomain(trustDomain)
-
-	rootCAPEM, err := loadExplicitCA()
+	extraSANs, err := ResolveExtraSANs(c.ExtraSANs)
 	if err != nil {
 		return Config{}, err
 	}
 
-	if controllerAddr == "" {
-		return Config{}, fmt.Errorf("CONTROLLER_ADDR is not set")
-	}
-	if tunnelerID == "" {
-		return Config{}, fmt.Errorf("TUNNELER_ID is not set")
+	rootCAPEM, err := loadExplicitCA(c.CAPath)
+	if err != nil {
+		return Config{}, err
 	}
+
+	token := c.EnrollmentToken
 	if token == "" {
 		cred, err := ReadCredential("ENROLLMENT_TOKEN")
 		if err != nil {
@@ -85,32 +112,27 @@ func ConfigFromEnv() (Config, error) {
 	}
 
 	return Config{
-		ControllerAddr: controllerAddr,
-		TunnelerID:     tunnelerID,
-		TrustDomain:    trustDomain,
-		RootCAPEM:      rootCAPEM,
-		Token:          token,
+		ControllerAddr:  c.ControllerAddr,
+		BootstrapAddr:   c.BootstrapAddr,
+		TunnelerID:      c.TunnelerID,
+		TrustDomain:     c.TrustDomain,
+		ControllerID:    controllerID,
+		RootCAPEM:       rootCAPEM,
+		Token:           token,
+		ExtraSANs:       extraSANs,
+		KeyAlgorithm:    c.KeyAlgorithm,
+		ResponseMaxSkew: c.ResponseMaxSkew,
 	}, nil
 }
 
 // Enroll performs enrollment and returns the issued workload certificate.
 func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, string, error) {
 	// ---- generate key pair (in-memory only) ----
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	privKey, pubPEM, err := GenerateKey(cfg.KeyAlgorithm)
 	if err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to generate key pair: %w", err)
 	}
 
-	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
-	if err != nil {
-		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to marshal public key: %w", err)
-	}
-
-	pubPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubDER,
-	})
-
 	rootPool, err := tlsutil.RootPoolFromPEM(cfg.RootCAPEM)
 	if err != nil {
 		return tls.Certificate{}, nil, nil, "", err
@@ -121,14 +143,18 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		MinVersion: tls.VersionTLS13,
 		RootCAs:    rootPool,
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, cfg.TrustDomain, "controller")
+			return tlsutil.VerifyControllerSPIFFE(rawCerts, verifiedChains, cfg.TrustDomain, cfg.ControllerID)
 		},
 	}
 
 	// ---- connect to controller ----
+	addr := cfg.ControllerAddr
+	if cfg.BootstrapAddr != "" {
+		addr = cfg.BootstrapAddr
+	}
 	conn, err := grpc.DialContext(
 		ctx,
-		cfg.ControllerAddr,
+		addr,
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 	)
 	if err != nil {
@@ -138,14 +164,25 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 
 	client := controllerpb.NewEnrollmentServiceClient(conn)
 
+	nonce, err := enrollresponse.NewNonce()
+	if err != nil {
+		return tls.Certificate{}, nil, nil, "", err
+	}
 	resp, err := client.EnrollTunneler(ctx, &controllerpb.EnrollRequest{
 		Id:        cfg.TunnelerID,
 		PublicKey: pubPEM,
 		Token:     cfg.Token,
+		Nonce:     nonce,
+
+		ExtraUris:      cfg.ExtraSANs.URIs,
+		EmailAddresses: cfg.ExtraSANs.Emails,
 	})
 	if err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("enrollment RPC failed: %w", err)
 	}
+	if err := enrollresponse.Check(resp, nonce, cfg.RootCAPEM, cfg.ResponseMaxSkew); err != nil {
+		return tls.Certificate{}, nil, nil, "", err
+	}
 
 	if len(resp.Certificate) == 0 {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("controller returned empty certificate")
@@ -169,7 +206,8 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("failed to parse issued certificate: %w", err)
 	}
 
-	if len(cert.URIs) != 1 {
+	uri, err := spiffeid.URIOf(cert)
+	if err != nil {
 		return tls.Certificate{}, nil, nil, "", fmt.Errorf("issued certificate must contain exactly one SPIFFE ID")
 	}
 
@@ -178,23 +216,71 @@ func Enroll(ctx context.Context, cfg Config) (tls.Certificate, []byte, []byte, s
 		PrivateKey:  privKey,
 	}
 
-	return workloadCert, resp.Certificate, resp.CaCertificate, cert.URIs[0].String(), nil
+	return workloadCert, resp.Certificate, resp.CaCertificate, uri.String(), nil
+}
+
+const (
+	expectedControllerEnv = "EXPECTED_CONTROLLER_SPIFFE_ID"
+	extraSANsEnv          = "TUNNELER_EXTRA_SANS"
+)
+
+// ExtraSANs are additional non-SPIFFE URI and email SANs requested at
+// enrollment and every renewal.
+type ExtraSANs struct {
+	URIs   []string
+	Emails []string
 }
 
-func normalizeTrustDomain(v string) string {
+// ResolveExtraSANs parses TUNNELER_EXTRA_SANS, a comma-separated list of
+// uri:<uri> and email:<address> entries. The controller only grants SANs its
+// EXTRA_SAN_POLICY allows.
+func ResolveExtraSANs(spec string) (ExtraSANs, error) {
+	var sans ExtraSANs
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		typ, value, _ := strings.Cut(entry, ":")
+		switch {
+		case value == "":
+			return ExtraSANs{}, fmt.Errorf("%s: expected uri:<uri> or email:<address>, got %q", extraSANsEnv, entry)
+		case typ == "uri":
+			sans.URIs = append(sans.URIs, value)
+		case typ == "email":
+			sans.Emails = append(sans.Emails, value)
+		default:
+			return ExtraSANs{}, fmt.Errorf("%s: unknown SAN type %q (expected uri or email)", extraSANsEnv, typ)
+		}
+	}
+	return sans, nil
+}
+
+// ExpectedControllerID validates EXPECTED_CONTROLLER_SPIFFE_ID, the exact
+// SPIFFE ID the controller must present. It must be a controller ID in
+// trustDomain; empty accepts any controller in the trust domain.
+func ExpectedControllerID(v, trustDomain string) (string, error) {
 	v = strings.TrimSpace(v)
-	v = strings.TrimSuffix(v, ".")
-	return v
+	if v == "" {
+		return "", nil
+	}
+	id, err := spiffeid.Parse(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", expectedControllerEnv, err)
+	}
+	if id.Role != "controller" || id.TrustDomain != trustDomain {
+		return "", fmt.Errorf("%s must be a controller ID in trust domain %s, got %q", expectedControllerEnv, trustDomain, v)
+	}
+	return id.String(), nil
 }
 
-func loadExplicitCA() ([]byte, error) {
+func loadExplicitCA(caPath string) ([]byte, error) {
 	if cred, err := ReadCredential("CONTROLLER_CA"); err != nil {
 		return nil, err
 	} else if cred != "" {
 		return []byte(cred), nil
 	}
 
-	caPath := strings.TrimSpace(os.Getenv("CONTROLLER_CA_PATH"))
 	if caPath == "" {
 		return nil, fmt.Errorf("CONTROLLER_CA_PATH is not set (explicit controller trust is required)")
 	}
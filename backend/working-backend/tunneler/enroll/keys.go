@@ -0,0 +1,41 @@
+package enroll
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// GenerateKey creates a workload key pair of the given KEY_ALGORITHM
+// ("ecdsa", the default P-256, or "ed25519") and returns the private key and
+// the PEM-encoded PKIX public key sent to the controller.
+func GenerateKey(algorithm string) (crypto.Signer, []byte, error) {
+	var (
+		privKey crypto.Signer
+		err     error
+	)
+	switch algo := strings.ToLower(strings.TrimSpace(algorithm)); algo {
+	case "", "ecdsa", "ecdsa-p256":
+		privKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, privKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported KEY_ALGORITHM %q (expected ecdsa or ed25519)", algo)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(privKey.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return privKey, pubPEM, nil
+}
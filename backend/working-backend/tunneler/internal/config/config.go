@@ -0,0 +1,129 @@
+// Package config reads the tunneler configuration from the environment
+// and, optionally, a KEY=VALUE file named by TUNNELER_CONFIG_FILE. The
+// environment takes precedence over the file.
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"controller/dialaddr"
+	"controller/envconfig"
+	"controller/spiffeid"
+)
+
+// FileEnv names the optional config file.
+const FileEnv = "TUNNELER_CONFIG_FILE"
+
+// Config is the tunneler configuration shared by the enroll and run
+// commands. Load fills in defaults and validates every value.
+type Config struct {
+	ControllerAddr string
+	// BootstrapAddr is the controller's enrollment-only listener; empty
+	// enrolls via ControllerAddr.
+	BootstrapAddr string
+	TunnelerID    string
+	TrustDomain   string
+	SPIFFEPolicy  spiffeid.Policy
+	// ExpectedControllerID is the raw EXPECTED_CONTROLLER_SPIFFE_ID.
+	ExpectedControllerID string
+	// CAPath is CONTROLLER_CA_PATH, used when no CONTROLLER_CA systemd
+	// credential is provided.
+	CAPath string
+
+	// EnrollmentToken may also come from the ENROLLMENT_TOKEN credential.
+	EnrollmentToken string
+	ResponseMaxSkew time.Duration
+	// OutputPassword protects --output-format=pkcs12 output.
+	OutputPassword string
+	// ExtraSANs is the raw TUNNELER_EXTRA_SANS list.
+	ExtraSANs string
+	// KeyAlgorithm is "ecdsa" or "ed25519".
+	KeyAlgorithm string
+
+	// ConnectorAddr is host:port or unix:/path. With ConnectorDiscovery
+	// (CONNECTOR_DISCOVERY=controller) the address of ConnectorID is
+	// resolved from the controller instead.
+	ConnectorAddr      string
+	ConnectorDiscovery bool
+	ConnectorID        string
+	// ConnectorVerifySPIFFEOnly is set by CONNECTOR_VERIFY=spiffe.
+	ConnectorVerifySPIFFEOnly bool
+
+	RenewalMaxFailures    int
+	RenewalReenrollWithin time.Duration
+	RenewalRPCTimeout     time.Duration
+
+	// Forwards is the raw TUNNELER_FORWARDS list.
+	Forwards string
+
+	rendered string
+}
+
+// Load reads and validates the configuration. The error lists every invalid
+// variable. CONTROLLER_ADDR and TUNNELER_ID are required.
+func Load() (*Config, error) {
+	l, err := envconfig.New(FileEnv)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+
+	c.ControllerAddr = l.Addr("CONTROLLER_ADDR")
+	l.Require("CONTROLLER_ADDR")
+	c.BootstrapAddr = l.Addr("CONTROLLER_BOOTSTRAP_ADDR")
+	c.TunnelerID = l.Str("TUNNELER_ID", "")
+	l.Require("TUNNELER_ID")
+	c.TrustDomain = strings.TrimSuffix(l.Str("TRUST_DOMAIN", "mycorp.internal"), ".")
+	c.SPIFFEPolicy = spiffeid.Policy{
+		MaxLength: l.Int("SPIFFE_ID_MAX_LENGTH", spiffeid.DefaultMaxLength),
+		Strict:    l.Bool("SPIFFE_ID_STRICT", false),
+	}
+	if n := c.SPIFFEPolicy.MaxLength; n < 1 || n > spiffeid.DefaultMaxLength {
+		l.Fail("SPIFFE_ID_MAX_LENGTH", fmt.Errorf("must be between 1 and %d, got %d", spiffeid.DefaultMaxLength, n))
+	}
+	c.ExpectedControllerID = l.Str("EXPECTED_CONTROLLER_SPIFFE_ID", "")
+	c.CAPath = l.Str("CONTROLLER_CA_PATH", "")
+
+	c.EnrollmentToken = l.Raw("ENROLLMENT_TOKEN", true)
+	c.ResponseMaxSkew = l.Positive("ENROLL_RESPONSE_MAX_SKEW", 5*time.Minute)
+	c.OutputPassword = l.Raw("ENROLL_OUTPUT_PASSWORD", true)
+	c.ExtraSANs = l.Str("TUNNELER_EXTRA_SANS", "")
+	if c.KeyAlgorithm = l.OneOf("KEY_ALGORITHM", "ecdsa", "ecdsa", "ecdsa-p256", "ed25519"); c.KeyAlgorithm == "ecdsa-p256" {
+		c.KeyAlgorithm = "ecdsa"
+	}
+
+	if v := l.Str("CONNECTOR_ADDR", ""); strings.HasPrefix(v, dialaddr.UnixPrefix) {
+		if c.ConnectorAddr, err = dialaddr.ParseUnix("CONNECTOR_ADDR", v); err != nil {
+			l.Add(err)
+		}
+	} else {
+		c.ConnectorAddr = l.Addr("CONNECTOR_ADDR")
+	}
+	c.ConnectorDiscovery = l.OneOf("CONNECTOR_DISCOVERY", "static", "static", "controller") == "controller"
+	c.ConnectorID = l.Str("CONNECTOR_ID", "")
+	c.ConnectorVerifySPIFFEOnly = l.OneOf("CONNECTOR_VERIFY", "address", "address", "spiffe") == "spiffe"
+
+	if c.RenewalMaxFailures = l.Int("RENEWAL_MAX_FAILURES", 5); c.RenewalMaxFailures < 0 {
+		l.Fail("RENEWAL_MAX_FAILURES", fmt.Errorf("must not be negative"))
+	}
+	if c.RenewalReenrollWithin = l.Duration("RENEWAL_REENROLL_WITHIN", 5*time.Minute); c.RenewalReenrollWithin < 0 {
+		l.Fail("RENEWAL_REENROLL_WITHIN", fmt.Errorf("must not be negative"))
+	}
+	c.RenewalRPCTimeout = l.Positive("RENEWAL_RPC_TIMEOUT", 30*time.Second)
+
+	c.Forwards = l.Str("TUNNELER_FORWARDS", "")
+
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	c.rendered = l.Render()
+	return c, nil
+}
+
+// String lists the variables that were set as NAME=value pairs, with
+// secrets such as the enrollment token replaced by envconfig.Redacted, for logging.
+func (c *Config) String() string {
+	return c.rendered
+}
@@ -5,46 +5,67 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
-	"net/url"
-	"strings"
+	"fmt"
 	"sync"
 	"time"
+
+	"controller/spiffeid"
 )
 
+// CertOverlap is how long the previous certificate stays available after
+// Update, so handshakes negotiated against it still complete.
+const CertOverlap = time.Minute
+
 // CertStore keeps the current workload certificate in memory for rotation.
+// Each certificate is stored behind its own pointer and never modified, so
+// a handshake holding a certificate is unaffected by a concurrent Update.
 type CertStore struct {
 	mu       sync.RWMutex
-	cert     tls.Certificate
+	cert     *tls.Certificate
 	certPEM  []byte
 	notAfter time.Time
+
+	// prev is the certificate replaced by the last Update; it is served
+	// only until overlapUntil and only to peers that cannot use cert.
+	prev         *tls.Certificate
+	overlapUntil time.Time
 }
 
 // NewCertStore initializes a new CertStore.
 func NewCertStore(cert tls.Certificate, certPEM []byte, notAfter time.Time) *CertStore {
-	return &CertStore{cert: cert, certPEM: certPEM, notAfter: notAfter}
+	return &CertStore{cert: &cert, certPEM: certPEM, notAfter: notAfter}
 }
 
-// Update replaces the certificate in memory.
+// Update replaces the certificate in memory. The replaced certificate is
+// kept for CertOverlap.
 func (s *CertStore) Update(cert tls.Certificate, certPEM []byte, notAfter time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.cert = cert
+	s.prev = s.cert
+	s.overlapUntil = time.Now().Add(CertOverlap)
+	s.cert = &cert
 	s.certPEM = certPEM
 	s.notAfter = notAfter
 }
 
-// NotAfter returns the current certificate expiry.
+// NotAfter returns the expiry of the active certificate.
 func (s *CertStore) NotAfter() time.Time {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.notAfter
 }
 
-// GetClientCertificate returns the current certificate for client-side handshakes.
-func (s *CertStore) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+// GetClientCertificate returns the current certificate for client-side
+// handshakes, or the previous one during the overlap window if the server
+// cannot accept the current one.
+func (s *CertStore) GetClientCertificate(req *tls.CertificateRequestInfo) (*tls.Certificate, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return &s.cert, nil
+	if s.prev != nil && time.Now().Before(s.overlapUntil) && req != nil &&
+		req.SupportsCertificate(s.cert) != nil && req.SupportsCertificate(s.prev) == nil {
+		return s.prev, nil
+	}
+	return s.cert, nil
 }
 
 // RootPoolFromPEM builds a cert pool from PEM bytes.
@@ -85,6 +106,26 @@ func EqualCAPEM(a, b []byte) bool {
 	return string(ab.Bytes) == string(bb.Bytes)
 }
 
+// VerifyControllerSPIFFE is VerifyPeerSPIFFE for the controller role. A
+// non-empty expectedID pins the controller: its SPIFFE ID must equal
+// expectedID exactly, so another validly signed controller is refused.
+func VerifyControllerSPIFFE(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, trustDomain, expectedID string) error {
+	if err := VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller"); err != nil {
+		return err
+	}
+	if expectedID == "" {
+		return nil
+	}
+	id, err := spiffeid.FromLeaf(verifiedChains[0][0])
+	if err != nil {
+		return err
+	}
+	if id.String() != expectedID {
+		return fmt.Errorf("controller SPIFFE ID %s does not match the expected %s", id, expectedID)
+	}
+	return nil
+}
+
 // VerifyPeerSPIFFE validates SPIFFE identity using verified chains.
 func VerifyPeerSPIFFE(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, trustDomain, expectedRole string) error {
 	if len(rawCerts) == 0 {
@@ -95,34 +136,46 @@ func VerifyPeerSPIFFE(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, t
 		return errors.New("peer verification failed")
 	}
 
-	leaf := verifiedChains[0][0]
-	if len(leaf.URIs) != 1 {
-		return errors.New("exactly one SPIFFE ID is required")
-	}
-
-	uri := leaf.URIs[0]
-	if err := verifySPIFFEURI(uri, trustDomain, expectedRole); err != nil {
+	id, err := spiffeid.FromLeaf(verifiedChains[0][0])
+	if err != nil {
 		return err
 	}
-
+	if id.TrustDomain != trustDomain {
+		return errors.New("SPIFFE trust domain mismatch")
+	}
+	if expectedRole != "" && id.Role != expectedRole {
+		return errors.New("unexpected SPIFFE role")
+	}
 	return nil
 }
 
-func verifySPIFFEURI(uri *url.URL, trustDomain, expectedRole string) error {
-	if uri.Scheme != "spiffe" {
-		return errors.New("SPIFFE ID must use spiffe:// scheme")
+// VerifyPeerSPIFFEChain verifies rawCerts against roots and then the
+// SPIFFE identity of the leaf. It is for connections without a meaningful
+// host name, such as Unix sockets, where the TLS stack's own verification
+// is turned off.
+func VerifyPeerSPIFFEChain(rawCerts [][]byte, roots *x509.CertPool, trustDomain, expectedRole string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no peer certificates")
 	}
-	if uri.Host != trustDomain {
-		return errors.New("SPIFFE trust domain mismatch")
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
 	}
-	path := strings.TrimPrefix(uri.Path, "/")
-	parts := strings.Split(path, "/")
-	if len(parts) < 1 {
-		return errors.New("invalid SPIFFE path")
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
 	}
-	role := parts[0]
-	if expectedRole != "" && role != expectedRole {
-		return errors.New("unexpected SPIFFE role")
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return err
 	}
-	return nil
+	return VerifyPeerSPIFFE(rawCerts, chains, trustDomain, expectedRole)
 }
@@ -1,31 +1,49 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
+	"controller/spiffeid"
 	"tunneler/enroll"
+	"tunneler/internal/buildinfo"
+	"tunneler/internal/config"
 	"tunneler/run"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("missing command: enroll | run")
+		log.Fatal("missing command: enroll | run | version")
 	}
-
 	switch os.Args[1] {
 	case "enroll":
-		if err := enroll.Run(); err != nil {
+		if err := enroll.Run(os.Args[2:], loadConfig()); err != nil {
 			log.Fatalf("enrollment failed: %v", err)
 		}
 		log.Println("enrollment completed successfully")
 
 	case "run":
-		if err := run.Run(); err != nil {
+		if err := run.Run(loadConfig()); err != nil {
 			log.Fatalf("tunneler run failed: %v", err)
 		}
 
+	case "version", "--version":
+		fmt.Printf("tunneler %s\n", buildinfo.String())
+
 	default:
 		log.Fatalf("unknown command: %s", os.Args[1])
 	}
 }
+
+// loadConfig reads the configuration once for the enroll and run commands
+// and applies the process-wide SPIFFE ID policy.
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	log.Printf("configuration: %s", cfg)
+	spiffeid.Default = cfg.SPIFFEPolicy
+	return cfg
+}
@@ -0,0 +1,52 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+
+	controllerpb "controller/gen/controllerpb"
+)
+
+// Reason codes carried by a "disconnect" control message; they match the
+// controller's api.Disconnect* constants and are also sent by connectors.
+const (
+	disconnectShutdown         = "shutdown"
+	disconnectDuplicateID      = "duplicate_id"
+	disconnectRevoked          = "revoked"
+	disconnectOverload         = "overload"
+	disconnectProtocolMismatch = "protocol_mismatch"
+)
+
+// disconnectError reports that the peer closed the control-plane stream with
+// a reason code.
+type disconnectError struct {
+	reason  string
+	message string
+	// cause is the stream error that followed the disconnect message; it
+	// may carry a RetryInfo detail.
+	cause error
+}
+
+func (e *disconnectError) Error() string {
+	return fmt.Sprintf("disconnected by peer: reason=%s message=%q", e.reason, e.message)
+}
+
+func (e *disconnectError) Unwrap() error { return e.cause }
+
+// terminal reports whether reconnecting cannot succeed without operator
+// action.
+func (e *disconnectError) terminal() bool {
+	return e.reason == disconnectRevoked || e.reason == disconnectProtocolMismatch
+}
+
+func parseDisconnect(msg *controllerpb.ControlMessage) *disconnectError {
+	var payload struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(msg.GetPayload(), &payload)
+	if payload.Reason == "" {
+		payload.Reason = "unknown"
+	}
+	return &disconnectError{reason: payload.Reason, message: payload.Message}
+}
@@ -0,0 +1,133 @@
+package run
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	controllerpb "controller/gen/controllerpb"
+	"tunneler/internal/tlsutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const forwardBufferSize = 32 * 1024
+
+// forwardSpec exposes one connector backend on a local TCP address.
+type forwardSpec struct {
+	listenAddr string
+	target     string
+}
+
+// parseForwards parses TUNNELER_FORWARDS ("listen-host:port=target,...").
+func parseForwards(spec string) ([]forwardSpec, error) {
+	var specs []forwardSpec
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, target, ok := strings.Cut(item, "=")
+		addr, target = strings.TrimSpace(addr), strings.TrimSpace(target)
+		if !ok || target == "" {
+			return nil, fmt.Errorf("TUNNELER_FORWARDS: expected host:port=target, got %q", item)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("TUNNELER_FORWARDS: %s: %v", target, err)
+		}
+		specs = append(specs, forwardSpec{listenAddr: addr, target: target})
+	}
+	return specs, nil
+}
+
+// forwardLoop accepts local connections on spec.listenAddr and tunnels each
+// one through the connector to spec.target until ctx is canceled.
+func forwardLoop(ctx context.Context, spec forwardSpec, resolveAddr connectorAddrFunc, trustDomain string, spiffeOnly bool, store *tlsutil.CertStore, roots *x509.CertPool) {
+	lis, err := net.Listen("tcp", spec.listenAddr)
+	if err != nil {
+		log.Printf("forward %s -> %s disabled: %v", spec.listenAddr, spec.target, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+	log.Printf("forwarding %s -> %s", spec.listenAddr, spec.target)
+	for {
+		local, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("forward %s stopped: %v", spec.listenAddr, err)
+			}
+			return
+		}
+		go func() {
+			defer local.Close()
+			if err := forwardConn(ctx, local, spec.target, resolveAddr, trustDomain, spiffeOnly, store, roots); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("forward %s -> %s: %v", local.RemoteAddr(), spec.target, err)
+			}
+		}()
+	}
+}
+
+func forwardConn(ctx context.Context, local net.Conn, target string, resolveAddr connectorAddrFunc, trustDomain string, spiffeOnly bool, store *tlsutil.CertStore, roots *x509.CertPool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	connectorAddr, err := resolveAddr(ctx)
+	if err != nil {
+		return fmt.Errorf("resolve connector address: %w", err)
+	}
+	tlsConfig := connectorTLSConfig(connectorAddr, trustDomain, spiffeOnly, store, roots)
+	conn, err := grpc.DialContext(ctx, connectorAddr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := controllerpb.NewTunnelServiceClient(conn).Open(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&controllerpb.TunnelFrame{Target: target}); err != nil {
+		return err
+	}
+
+	// Local -> connector; CloseSend signals EOF so the connector half-closes
+	// the backend connection.
+	go func() {
+		buf := make([]byte, forwardBufferSize)
+		for {
+			n, err := local.Read(buf)
+			if n > 0 {
+				if sendErr := stream.Send(&controllerpb.TunnelFrame{Data: append([]byte(nil), buf[:n]...)}); sendErr != nil {
+					cancel()
+					return
+				}
+			}
+			if err != nil {
+				_ = stream.CloseSend()
+				return
+			}
+		}
+	}()
+
+	for {
+		frame, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := local.Write(frame.GetData()); err != nil {
+			return err
+		}
+	}
+}
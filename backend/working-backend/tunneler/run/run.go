@@ -2,9 +2,6 @@ package run
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -12,12 +9,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"strings"
+	"sync"
 	"time"
 
+	"controller/dialaddr"
 	controllerpb "controller/gen/controllerpb"
 	"tunneler/enroll"
+	"tunneler/internal/config"
 	"tunneler/internal/tlsutil"
 
 	"google.golang.org/grpc"
@@ -26,13 +24,18 @@ import (
 )
 
 // Run starts the tunneler client.
-func Run() error {
-	cfg, err := configFromEnv()
+func Run(c *config.Config) error {
+	cfg, err := newRuntimeConfig(c)
 	if err != nil {
 		return err
 	}
 
-	enrollCfg, err := enroll.ConfigFromEnv()
+	enrollCfg, err := enroll.NewConfig(c)
+	if err != nil {
+		return err
+	}
+	policy := newRenewalPolicy(c)
+	forwards, err := parseForwards(c.Forwards)
 	if err != nil {
 		return err
 	}
@@ -59,12 +62,26 @@ func Run() error {
 
 	log.Printf("tunneler enrolled as %s", spiffeID)
 
+	resolveAddr := staticConnectorAddr(cfg.connectorAddr)
+	if cfg.connectorDiscovery {
+		resolveAddr = discoverConnectorAddr(cfg.controllerAddr, cfg.trustDomain, enrollCfg.ControllerID, cfg.connectorID, cfg.connectorAddr, store, rootPool)
+		log.Printf("resolving connector %s address via controller", cfg.connectorID)
+	}
+
 	reloadCh := make(chan struct{}, 1)
-	go controlPlaneLoop(ctx, cfg.connectorAddr, cfg.trustDomain, store, rootPool, spiffeID, cfg.tunnelerID, reloadCh)
-	go renewalLoop(ctx, cfg.controllerAddr, cfg.tunnelerID, cfg.trustDomain, store, rootPool, caPEM, totalTTL, reloadCh)
+	fatalCh := make(chan error, 1)
+	go controlPlaneLoop(ctx, resolveAddr, cfg.trustDomain, cfg.spiffeOnly, store, rootPool, spiffeID, cfg.tunnelerID, reloadCh, fatalCh)
+	for _, spec := range forwards {
+		go forwardLoop(ctx, spec, resolveAddr, cfg.trustDomain, cfg.spiffeOnly, store, rootPool)
+	}
+	go renewalLoop(ctx, cfg.controllerAddr, cfg.tunnelerID, cfg.trustDomain, store, rootPool, caPEM, totalTTL, reloadCh, policy, enrollCfg)
 
-	<-ctx.Done()
-	return ctx.Err()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-fatalCh:
+		return err
+	}
 }
 
 type runtimeConfig struct {
@@ -72,37 +89,129 @@ type runtimeConfig struct {
 	connectorAddr  string
 	tunnelerID     string
 	trustDomain    string
+
+	// connectorDiscovery resolves the connector address from the controller
+	// (CONNECTOR_DISCOVERY=controller) instead of using a static CONNECTOR_ADDR.
+	connectorDiscovery bool
+	connectorID        string
+	// spiffeOnly verifies the connector by chain and SPIFFE id without
+	// matching the dialed host (CONNECTOR_VERIFY=spiffe).
+	spiffeOnly bool
 }
 
-func configFromEnv() (runtimeConfig, error) {
-	controllerAddr := os.Getenv("CONTROLLER_ADDR")
-	connectorAddr := os.Getenv("CONNECTOR_ADDR")
-	tunnelerID := os.Getenv("TUNNELER_ID")
-	trustDomain := os.Getenv("TRUST_DOMAIN")
+func newRuntimeConfig(c *config.Config) (runtimeConfig, error) {
+	if c.ConnectorDiscovery && c.ConnectorID == "" {
+		return runtimeConfig{}, fmt.Errorf("CONNECTOR_ID is required when CONNECTOR_DISCOVERY=controller")
+	}
+	if !c.ConnectorDiscovery && c.ConnectorAddr == "" {
+		return runtimeConfig{}, fmt.Errorf("CONNECTOR_ADDR is not set")
+	}
+	return runtimeConfig{
+		controllerAddr:     c.ControllerAddr,
+		connectorAddr:      c.ConnectorAddr,
+		tunnelerID:         c.TunnelerID,
+		trustDomain:        c.TrustDomain,
+		connectorDiscovery: c.ConnectorDiscovery,
+		connectorID:        c.ConnectorID,
+		spiffeOnly:         c.ConnectorVerifySPIFFEOnly,
+	}, nil
+}
 
-	if trustDomain == "" {
-		trustDomain = "mycorp.internal"
+// connectorTLSConfig is the client TLS config for dialing the connector at
+// connectorAddr. A Unix socket has no host name to check, and with
+// spiffeOnly the connector's certificate need not name the dialed host (a
+// connector enrolled without a private IP has no IP SAN), so the chain is
+// verified against roots explicitly together with the SPIFFE identity.
+func connectorTLSConfig(connectorAddr, trustDomain string, spiffeOnly bool, store *tlsutil.CertStore, roots *x509.CertPool) *tls.Config {
+	if _, ok := dialaddr.UnixPath(connectorAddr); ok || spiffeOnly {
+		return &tls.Config{
+			MinVersion:           tls.VersionTLS13,
+			GetClientCertificate: store.GetClientCertificate,
+			InsecureSkipVerify:   true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				return tlsutil.VerifyPeerSPIFFEChain(rawCerts, roots, trustDomain, "connector")
+			},
+		}
 	}
-	trustDomain = normalizeTrustDomain(trustDomain)
-	if controllerAddr == "" {
-		return runtimeConfig{}, fmt.Errorf("CONTROLLER_ADDR is not set")
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS13,
+		GetClientCertificate: store.GetClientCertificate,
+		RootCAs:              roots,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "connector")
+		},
 	}
-	if connectorAddr == "" {
-		return runtimeConfig{}, fmt.Errorf("CONNECTOR_ADDR is not set")
+}
+
+// connectorAddrFunc returns the connector address for the next connection attempt.
+type connectorAddrFunc func(ctx context.Context) (string, error)
+
+func staticConnectorAddr(addr string) connectorAddrFunc {
+	return func(context.Context) (string, error) {
+		return addr, nil
 	}
-	if tunnelerID == "" {
-		return runtimeConfig{}, fmt.Errorf("TUNNELER_ID is not set")
+}
+
+// discoverConnectorAddr asks the controller for the connector's current
+// address on every attempt, so a reconnect after failure picks up a moved
+// connector. The last known (or static fallback) address is used if the
+// controller cannot be reached.
+func discoverConnectorAddr(controllerAddr, trustDomain, controllerID, connectorID, fallback string, store *tlsutil.CertStore, roots *x509.CertPool) connectorAddrFunc {
+	var mu sync.Mutex
+	lastKnown := fallback
+	return func(ctx context.Context) (string, error) {
+		addr, err := resolveConnector(ctx, controllerAddr, trustDomain, controllerID, connectorID, store, roots)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if lastKnown == "" {
+				return "", err
+			}
+			log.Printf("connector address lookup failed, using last known %s: %v", lastKnown, err)
+			return lastKnown, nil
+		}
+		if addr != lastKnown {
+			log.Printf("connector %s address resolved to %s", connectorID, addr)
+		}
+		lastKnown = addr
+		return addr, nil
 	}
+}
 
-	return runtimeConfig{
-		controllerAddr: controllerAddr,
-		connectorAddr:  connectorAddr,
-		tunnelerID:     tunnelerID,
-		trustDomain:    trustDomain,
-	}, nil
+func resolveConnector(ctx context.Context, controllerAddr, trustDomain, controllerID, connectorID string, store *tlsutil.CertStore, roots *x509.CertPool) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tlsConfig := &tls.Config{
+		MinVersion:           tls.VersionTLS13,
+		GetClientCertificate: store.GetClientCertificate,
+		RootCAs:              roots,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return tlsutil.VerifyControllerSPIFFE(rawCerts, verifiedChains, trustDomain, controllerID)
+		},
+	}
+
+	conn, err := grpc.DialContext(
+		ctx,
+		controllerAddr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	resp, err := controllerpb.NewControlPlaneClient(conn).ResolveConnector(ctx, &controllerpb.ResolveConnectorRequest{ConnectorId: connectorID})
+	if err != nil {
+		return "", err
+	}
+	if resp.GetAddress() == "" {
+		return "", errors.New("controller returned empty connector address")
+	}
+	return resp.GetAddress(), nil
 }
 
-func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, spiffeID, tunnelerID string, reloadCh <-chan struct{}) {
+func controlPlaneLoop(ctx context.Context, resolveAddr connectorAddrFunc, trustDomain string, spiffeOnly bool, store *tlsutil.CertStore, roots *x509.CertPool, spiffeID, tunnelerID string, reloadCh <-chan struct{}, fatalCh chan<- error) {
 	backoff := 2 * time.Second
 	for {
 		select {
@@ -114,7 +223,12 @@ func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, st
 		sessionCtx, cancel := context.WithCancel(ctx)
 		errCh := make(chan error, 1)
 		go func() {
-			errCh <- connectToConnector(sessionCtx, connectorAddr, trustDomain, store, roots, spiffeID, tunnelerID)
+			connectorAddr, err := resolveAddr(sessionCtx)
+			if err != nil {
+				errCh <- fmt.Errorf("resolve connector address: %w", err)
+				return
+			}
+			errCh <- connectToConnector(sessionCtx, connectorAddr, trustDomain, spiffeOnly, store, roots, spiffeID, tunnelerID)
 		}()
 
 		select {
@@ -127,7 +241,14 @@ func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, st
 			<-errCh
 		case err := <-errCh:
 			cancel()
-			if err != nil && !errors.Is(err, context.Canceled) {
+			var disc *disconnectError
+			if errors.As(err, &disc) {
+				log.Printf("connector closed the control plane: reason=%s message=%q", disc.reason, disc.message)
+				if disc.terminal() {
+					fatalCh <- fmt.Errorf("connector closed the control plane with terminal reason %s: %s", disc.reason, disc.message)
+					return
+				}
+			} else if err != nil && !errors.Is(err, context.Canceled) {
 				log.Printf("connector connection ended: %v", err)
 			}
 		}
@@ -145,15 +266,8 @@ func controlPlaneLoop(ctx context.Context, connectorAddr, trustDomain string, st
 	}
 }
 
-func connectToConnector(ctx context.Context, connectorAddr, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, spiffeID, tunnelerID string) error {
-	tlsConfig := &tls.Config{
-		MinVersion:           tls.VersionTLS13,
-		GetClientCertificate: store.GetClientCertificate,
-		RootCAs:              roots,
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "connector")
-		},
-	}
+func connectToConnector(ctx context.Context, connectorAddr, trustDomain string, spiffeOnly bool, store *tlsutil.CertStore, roots *x509.CertPool, spiffeID, tunnelerID string) error {
+	tlsConfig := connectorTLSConfig(connectorAddr, trustDomain, spiffeOnly, store, roots)
 
 	conn, err := grpc.DialContext(
 		ctx,
@@ -182,15 +296,41 @@ func connectToConnector(ctx context.Context, connectorAddr, trustDomain string,
 
 	recvErr := make(chan error, 1)
 	go func() {
+		// A disconnect message precedes the stream's final status.
+		var disc *disconnectError
 		for {
-			_, err := stream.Recv()
+			msg, err := stream.Recv()
 			if err != nil {
+				if disc != nil {
+					disc.cause = err
+					err = disc
+				}
 				recvErr <- err
 				return
 			}
+			if msg.GetType() == "disconnect" {
+				disc = parseDisconnect(msg)
+			}
 		}
 	}()
 
+	heartbeat := func() error {
+		payload, _ := json.Marshal(map[string]string{
+			"tunneler_id": tunnelerID,
+			"spiffe_id":   spiffeID,
+		})
+		return stream.Send(&controllerpb.ControlMessage{
+			Type:    "tunneler_heartbeat",
+			Payload: payload,
+			Status:  "ONLINE",
+		})
+	}
+	// The first heartbeat goes out at once so the controller learns which
+	// connector this tunneler is on without waiting a full interval.
+	if err := heartbeat(); err != nil {
+		return err
+	}
+
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -201,22 +341,18 @@ func connectToConnector(ctx context.Context, connectorAddr, trustDomain string,
 		case err := <-recvErr:
 			return err
 		case <-ticker.C:
-			payload, _ := json.Marshal(map[string]string{
-				"tunneler_id": tunnelerID,
-				"spiffe_id":   spiffeID,
-			})
-			if err := stream.Send(&controllerpb.ControlMessage{
-				Type:    "tunneler_heartbeat",
-				Payload: payload,
-				Status:  "ONLINE",
-			}); err != nil {
+			if err := heartbeat(); err != nil {
 				return err
 			}
 		}
 	}
 }
 
-func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte, totalTTL time.Duration, reloadCh chan<- struct{}) {
+func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte, totalTTL time.Duration, reloadCh chan<- struct{}, policy renewalPolicy, enrollCfg enroll.Config) {
+	var (
+		failures     int
+		lastReenroll time.Time
+	)
 	for {
 		next := nextRenewal(store.NotAfter(), totalTTL)
 		timer := time.NewTimer(time.Until(next))
@@ -227,36 +363,53 @@ func renewalLoop(ctx context.Context, controllerAddr, tunnelerID, trustDomain st
 		case <-timer.C:
 		}
 
-		cert, certPEM, notAfter, notBefore, err := renewOnce(ctx, controllerAddr, tunnelerID, trustDomain, store, roots, caPEM)
+		// A failed or timed-out attempt is retried within 10s, as
+		// nextRenewal is already past the renewal point.
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.rpcTimeout)
+		cert, certPEM, notAfter, notBefore, err := renewOnce(attemptCtx, controllerAddr, tunnelerID, trustDomain, enrollCfg.ControllerID, enrollCfg.ExtraSANs, enrollCfg.KeyAlgorithm, store, roots, caPEM)
+		cancel()
 		if err != nil {
-			log.Printf("certificate renewal failed: %v", err)
-			continue
+			failures++
+			log.Printf("certificate renewal failed (%d consecutive): %v", failures, err)
+			if !policy.escalate(failures, store.NotAfter()) || time.Since(lastReenroll) < reenrollBackoff {
+				continue
+			}
+			log.Printf("ALARM: certificate renewal failed %d consecutive times, certificate expires %s; attempting re-enrollment",
+				failures, store.NotAfter().Format(time.RFC3339))
+			lastReenroll = time.Now()
+			cert, certPEM, err = reenroll(ctx, enrollCfg, policy, caPEM)
+			if err != nil {
+				log.Printf("ALARM: re-enrollment failed: %v; tunneler stops working at %s unless renewal recovers",
+					err, store.NotAfter().Format(time.RFC3339))
+				continue
+			}
+			leaf, err := parseLeafCert(certPEM)
+			if err != nil {
+				log.Printf("ALARM: re-enrollment returned an invalid certificate: %v", err)
+				continue
+			}
+			notAfter, notBefore = leaf.NotAfter, leaf.NotBefore
+			log.Printf("re-enrolled after %d failed renewals; certificate valid until %s", failures, notAfter.Format(time.RFC3339))
 		}
+		failures = 0
 
 		store.Update(cert, certPEM, notAfter)
 		totalTTL = notAfter.Sub(notBefore)
 	}
 }
 
-func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte) (tls.Certificate, []byte, time.Time, time.Time, error) {
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
-	}
-
-	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain, controllerID string, extraSANs enroll.ExtraSANs, keyAlgorithm string, store *tlsutil.CertStore, roots *x509.CertPool, caPEM []byte) (tls.Certificate, []byte, time.Time, time.Time, error) {
+	privKey, pubPEM, err := enroll.GenerateKey(keyAlgorithm)
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
 
-	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
-
 	tlsConfig := &tls.Config{
 		MinVersion:           tls.VersionTLS13,
 		GetClientCertificate: store.GetClientCertificate,
 		RootCAs:              roots,
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			return tlsutil.VerifyPeerSPIFFE(rawCerts, verifiedChains, trustDomain, "controller")
+			return tlsutil.VerifyControllerSPIFFE(rawCerts, verifiedChains, trustDomain, controllerID)
 		},
 	}
 
@@ -271,7 +424,12 @@ func renewOnce(ctx context.Context, controllerAddr, tunnelerID, trustDomain stri
 	defer conn.Close()
 
 	client := controllerpb.NewEnrollmentServiceClient(conn)
-	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{Id: tunnelerID, PublicKey: pubPEM})
+	resp, err := client.Renew(ctx, &controllerpb.EnrollRequest{
+		Id:             tunnelerID,
+		PublicKey:      pubPEM,
+		ExtraUris:      extraSANs.URIs,
+		EmailAddresses: extraSANs.Emails,
+	})
 	if err != nil {
 		return tls.Certificate{}, nil, time.Time{}, time.Time{}, err
 	}
@@ -319,9 +477,3 @@ func parseLeafCert(certPEM []byte) (*x509.Certificate, error) {
 	}
 	return x509.ParseCertificate(block.Bytes)
 }
-
-func normalizeTrustDomain(v string) string {
-	v = strings.TrimSpace(v)
-	v = strings.TrimSuffix(v, ".")
-	return v
-}
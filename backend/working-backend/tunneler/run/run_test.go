@@ -0,0 +1,120 @@
+package run
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller/ca"
+	"controller/dialaddr"
+	"tunneler/internal/tlsutil"
+)
+
+func newTestCA(t *testing.T) (*ca.CA, *x509.CertPool) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.GenerateSelfSignedCA("tunneler test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ca.LoadCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+	return c, pool
+}
+
+func issue(t *testing.T, c *ca.CA, spiffeID string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := ca.IssueWorkloadCert(c, spiffeID, &key.PublicKey, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key}
+}
+
+// serveUnix accepts TLS connections on a Unix socket with serverCert,
+// requiring a client certificate from roots, and completes each handshake.
+func serveUnix(t *testing.T, serverCert tls.Certificate, roots *x509.CertPool) string {
+	t.Helper()
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir.
+	dir, err := os.MkdirTemp("", "tun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "c.sock")
+	lis, err := tls.Listen("unix", path, &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return "unix:" + path
+}
+
+func TestConnectorTLSConfigUnixSocket(t *testing.T) {
+	c, roots := newTestCA(t)
+	client := issue(t, c, "spiffe://example.org/tunneler/t1")
+	store := tlsutil.NewCertStore(client, nil, time.Now().Add(time.Hour))
+	otherCA, _ := newTestCA(t)
+
+	tests := []struct {
+		name    string
+		server  tls.Certificate
+		wantErr bool
+	}{
+		{"connector", issue(t, c, "spiffe://example.org/connector/c1"), false},
+		{"wrong role", issue(t, c, "spiffe://example.org/controller/ctl"), true},
+		{"wrong trust domain", issue(t, c, "spiffe://other.org/connector/c1"), true},
+		{"untrusted CA", issue(t, otherCA, "spiffe://example.org/connector/c1"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveUnix(t, tt.server, roots)
+			cfg := connectorTLSConfig(addr, "example.org", false, store, roots)
+			if !cfg.InsecureSkipVerify || cfg.VerifyPeerCertificate == nil {
+				t.Fatal("a unix: address must verify the chain and SPIFFE ID itself")
+			}
+			path, _ := dialaddr.UnixPath(addr)
+			raw, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := tls.Client(raw, cfg)
+			defer conn.Close()
+			err = conn.Handshake()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("handshake err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
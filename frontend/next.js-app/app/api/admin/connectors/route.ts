@@ -1,6 +1,6 @@
 import { NextResponse } from "next/server"
 
-export async function GET() {
+export async function GET(request: Request) {
   const baseUrl = process.env.ADMIN_API_URL
   const authToken = process.env.ADMIN_AUTH_TOKEN
 
@@ -12,7 +12,8 @@ export async function GET() {
   }
 
   try {
-    const res = await fetch(`${baseUrl}/api/admin/connectors`, {
+    const { search } = new URL(request.url)
+    const res = await fetch(`${baseUrl}/api/admin/connectors${search}`, {
       headers: {
         Authorization: `Bearer ${authToken}`,
       },
@@ -27,7 +28,7 @@ export async function GET() {
       )
     }
 
-    const data = text ? JSON.parse(text) : []
+    const data = text ? JSON.parse(text) : { items: [], total: 0, online: 0 }
     return NextResponse.json(data, { status: 200 })
   } catch (error) {
     return NextResponse.json(
@@ -3,7 +3,7 @@ import { NextResponse } from "next/server"
 export const runtime = "nodejs"
 export const dynamic = "force-dynamic"
 
-export async function POST() {
+export async function POST(request: Request) {
   const baseUrl = process.env.ADMIN_API_URL
   const authToken = process.env.ADMIN_AUTH_TOKEN
 
@@ -15,7 +15,8 @@ export async function POST() {
   }
 
   try {
-    const res = await fetch(`${baseUrl}/api/admin/tokens`, {
+    const { search } = new URL(request.url)
+    const res = await fetch(`${baseUrl}/api/admin/tokens${search}`, {
       method: "POST",
       headers: {
         Authorization: `Bearer ${authToken}`,
@@ -1,6 +1,6 @@
 import { NextResponse } from "next/server"
 
-export async function GET() {
+export async function GET(request: Request) {
   const baseUrl = process.env.ADMIN_API_URL
   const authToken = process.env.ADMIN_AUTH_TOKEN
 
@@ -12,7 +12,8 @@ export async function GET() {
   }
 
   try {
-    const res = await fetch(`${baseUrl}/api/admin/tunnelers`, {
+    const { search } = new URL(request.url)
+    const res = await fetch(`${baseUrl}/api/admin/tunnelers${search}`, {
       headers: {
         Authorization: `Bearer ${authToken}`,
       },
@@ -27,7 +28,7 @@ export async function GET() {
       )
     }
 
-    const data = text ? JSON.parse(text) : []
+    const data = text ? JSON.parse(text) : { items: [], total: 0, online: 0 }
     return NextResponse.json(data, { status: 200 })
   } catch (error) {
     return NextResponse.json(
@@ -36,3 +37,40 @@ export async function GET() {
     )
   }
 }
+
+export async function POST(request: Request) {
+  const baseUrl = process.env.ADMIN_API_URL
+  const authToken = process.env.ADMIN_AUTH_TOKEN
+
+  if (!baseUrl || !authToken) {
+    return NextResponse.json(
+      { error: "ADMIN_API_URL or ADMIN_AUTH_TOKEN not configured" },
+      { status: 500 }
+    )
+  }
+
+  try {
+    const res = await fetch(`${baseUrl}/api/admin/tunnelers`, {
+      method: "POST",
+      headers: {
+        Authorization: `Bearer ${authToken}`,
+        "Content-Type": "application/json",
+      },
+      body: await request.text(),
+      cache: "no-store",
+    })
+
+    const body = await res.text()
+    return new Response(body, {
+      status: res.status,
+      headers: {
+        "Content-Type": res.headers.get("content-type") || "application/json",
+      },
+    })
+  } catch (error) {
+    return NextResponse.json(
+      { error: error instanceof Error ? error.message : "Upstream error" },
+      { status: 502 }
+    )
+  }
+}
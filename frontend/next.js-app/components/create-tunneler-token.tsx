@@ -5,6 +5,8 @@ import { Button } from "@/components/ui/button"
 import { Card, CardContent, CardHeader, CardTitle, CardDescription } from "@/components/ui/card"
 import { AlertTriangle, Check, Copy, KeyRound, Loader2 } from "lucide-react"
 
+const TUNNELER_ID = "tunneler-local-01"
+
 interface TokenResponse {
   token: string
   expires_at: string
@@ -23,7 +25,17 @@ export function CreateTunnelerToken() {
     setError(null)
 
     try {
-      const res = await fetch("/api/admin/tokens", { method: "POST" })
+      // The controller only enrolls pre-registered tunneler ids.
+      const reg = await fetch("/api/admin/tunnelers", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: TUNNELER_ID }),
+      })
+      if (!reg.ok) {
+        const message = await reg.text()
+        throw new Error(message || "Failed to register tunneler")
+      }
+      const res = await fetch("/api/admin/tokens?kind=tunneler", { method: "POST" })
       if (!res.ok) {
         const message = await res.text()
         throw new Error(message || "Failed to create token")
@@ -38,7 +50,7 @@ export function CreateTunnelerToken() {
   }
 
   const installCommand = token
-    ? `curl -fsSL https://raw.githubusercontent.com/sathiyaseelank-dot/grpccontroller/main/scripts/tunneler-setup.sh | sudo CONTROLLER_ADDR="127.0.0.1:8443" CONNECTOR_ADDR="127.0.0.1:9443" TUNNELER_ID="tunneler-local-01" ENROLLMENT_TOKEN="${token.token}" CONTROLLER_CA_PATH="/etc/grpcconnector/ca.crt" bash`
+    ? `curl -fsSL https://raw.githubusercontent.com/sathiyaseelank-dot/grpccontroller/main/scripts/tunneler-setup.sh | sudo CONTROLLER_ADDR="127.0.0.1:8443" CONNECTOR_ADDR="127.0.0.1:9443" TUNNELER_ID="${TUNNELER_ID}" ENROLLMENT_TOKEN="${token.token}" CONTROLLER_CA_PATH="/etc/grpcconnector/ca.crt" bash`
     : ""
 
   const handleCopy = async () => {
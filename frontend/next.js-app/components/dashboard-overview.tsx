@@ -19,9 +19,17 @@ interface Tunneler {
   last_seen: string
 }
 
+interface Page<T> {
+  items: T[]
+  total: number
+  online: number
+}
+
+const emptyPage = { items: [], total: 0, online: 0 }
+
 export function DashboardOverview() {
-  const [connectors, setConnectors] = useState<Connector[]>([])
-  const [tunnelers, setTunnelers] = useState<Tunneler[]>([])
+  const [connectors, setConnectors] = useState<Page<Connector>>(emptyPage)
+  const [tunnelers, setTunnelers] = useState<Page<Tunneler>>(emptyPage)
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState<string | null>(null)
   const [lastRefresh, setLastRefresh] = useState<Date>(new Date())
@@ -30,8 +38,8 @@ export function DashboardOverview() {
     try {
       setError(null)
       const [connectorsRes, tunnelersRes] = await Promise.all([
-        fetch("/api/admin/connectors"),
-        fetch("/api/admin/tunnelers"),
+        fetch("/api/admin/connectors?limit=1"),
+        fetch("/api/admin/tunnelers?limit=1"),
       ])
 
       if (!connectorsRes.ok) {
@@ -43,13 +51,13 @@ export function DashboardOverview() {
         throw new Error(message || "Failed to load tunnelers")
       }
 
-      const connectorsData: Connector[] = await connectorsRes.json()
-      const tunnelersData: Tunneler[] = await tunnelersRes.json()
+      const connectorsData: Page<Connector> = await connectorsRes.json()
+      const tunnelersData: Page<Tunneler> = await tunnelersRes.json()
       setConnectors(connectorsData)
       setTunnelers(tunnelersData)
     } catch (err) {
-      setConnectors([])
-      setTunnelers([])
+      setConnectors(emptyPage)
+      setTunnelers(emptyPage)
       setError(err instanceof Error ? err.message : "Failed to load dashboard data")
     } finally {
       setLoading(false)
@@ -63,13 +71,14 @@ export function DashboardOverview() {
     return () => clearInterval(interval)
   }, [fetchStats])
 
-  const connectorsOnline = connectors.filter((c) => c.status === "ONLINE").length
-  const connectorsOffline = connectors.filter((c) => c.status === "OFFLINE").length
-  const tunnelersOnline = tunnelers.filter((t) => t.status === "ONLINE").length
-  const tunnelersOffline = tunnelers.filter((t) => t.status === "OFFLINE").length
+  const connectorsOnline = connectors.online
+  const connectorsOffline = connectors.total - connectors.online
+  const tunnelersOnline = tunnelers.online
+  const tunnelersOffline = tunnelers.total - tunnelers.online
 
-  const latestConnector = connectors[0]
-  const latestTunneler = tunnelers[0]
+  // Both lists are ordered by last seen, so the first item is the latest.
+  const latestConnector = connectors.items[0]
+  const latestTunneler = tunnelers.items[0]
 
   return (
     <div className="flex flex-col gap-6">
@@ -90,7 +99,7 @@ export function DashboardOverview() {
           </CardHeader>
           <CardContent>
             <div className="text-3xl font-bold text-foreground">
-              {loading ? "--" : connectors.length}
+              {loading ? "--" : connectors.total}
             </div>
             <p className="mt-1 text-xs text-muted-foreground">Across all networks</p>
           </CardContent>
@@ -135,7 +144,7 @@ export function DashboardOverview() {
           </CardHeader>
           <CardContent>
             <div className="text-3xl font-bold text-foreground">
-              {loading ? "--" : tunnelers.length}
+              {loading ? "--" : tunnelers.total}
             </div>
             <p className="mt-1 text-xs text-muted-foreground">Across all connectors</p>
           </CardContent>
@@ -11,7 +11,7 @@ import {
 } from "@/components/ui/table"
 import { Badge } from "@/components/ui/badge"
 import { Card, CardContent, CardHeader, CardTitle, CardDescription } from "@/components/ui/card"
-import { Network, RefreshCw } from "lucide-react"
+import { ChevronLeft, ChevronRight, Network, RefreshCw } from "lucide-react"
 import { Button } from "@/components/ui/button"
 
 interface Tunneler {
@@ -21,8 +21,20 @@ interface Tunneler {
   last_seen: string
 }
 
+// pageSize matches the admin API's default page limit.
+const pageSize = 100
+
+interface TunnelerPage {
+  items: Tunneler[]
+  total: number
+  online: number
+}
+
 export function TunnelersTable() {
   const [tunnelers, setTunnelers] = useState<Tunneler[]>([])
+  const [total, setTotal] = useState(0)
+  const [offset, setOffset] = useState(0)
+  const [onlineCount, setOnlineCount] = useState(0)
   const [loading, setLoading] = useState(true)
   const [lastRefresh, setLastRefresh] = useState<Date>(new Date())
   const [error, setError] = useState<string | null>(null)
@@ -30,21 +42,30 @@ export function TunnelersTable() {
   const fetchTunnelers = useCallback(async () => {
     try {
       setError(null)
-      const res = await fetch("/api/admin/tunnelers")
+      const res = await fetch(`/api/admin/tunnelers?limit=${pageSize}&offset=${offset}`)
       if (!res.ok) {
         const message = await res.text()
         throw new Error(message || "Failed to load tunnelers")
       }
-      const data: Tunneler[] = await res.json()
-      setTunnelers(data)
+      const data: TunnelerPage = await res.json()
+      if (offset > 0 && offset >= data.total) {
+        // The list shrank below this page; show the last page instead.
+        setOffset(Math.max(0, Math.floor((data.total - 1) / pageSize) * pageSize))
+        return
+      }
+      setTunnelers(data.items)
+      setTotal(data.total)
+      setOnlineCount(data.online)
     } catch (err) {
       setTunnelers([])
+      setTotal(0)
+      setOnlineCount(0)
       setError(err instanceof Error ? err.message : "Failed to load tunnelers")
     } finally {
       setLoading(false)
       setLastRefresh(new Date())
     }
-  }, [])
+  }, [offset])
 
   useEffect(() => {
     fetchTunnelers()
@@ -52,8 +73,7 @@ export function TunnelersTable() {
     return () => clearInterval(interval)
   }, [fetchTunnelers])
 
-  const onlineCount = tunnelers.filter((t) => t.status === "ONLINE").length
-  const offlineCount = tunnelers.filter((t) => t.status === "OFFLINE").length
+  const offlineCount = total - onlineCount
 
   return (
     <div className="flex flex-col gap-6">
@@ -71,7 +91,7 @@ export function TunnelersTable() {
               <Network className="h-4 w-4 text-muted-foreground" />
             </div>
             <div>
-              <p className="text-2xl font-bold text-foreground">{tunnelers.length}</p>
+              <p className="text-2xl font-bold text-foreground">{total}</p>
               <p className="text-xs text-muted-foreground">Total</p>
             </div>
           </CardContent>
@@ -191,6 +211,37 @@ export function TunnelersTable() {
               </TableBody>
             </Table>
           )}
+          {!loading && !error && total > pageSize && (
+            <div className="flex items-center justify-between border-t border-border px-6 py-3">
+              <p className="text-sm text-muted-foreground">
+                Showing {offset + 1}–{offset + tunnelers.length} of {total}
+              </p>
+              <div className="flex gap-2">
+                <Button
+                  variant="outline"
+                  size="sm"
+                  onClick={() => setOffset(Math.max(0, offset - pageSize))}
+                  disabled={offset === 0}
+                  className="border-border text-foreground hover:bg-secondary bg-transparent"
+                  aria-label="Previous page"
+                >
+                  <ChevronLeft className="h-3.5 w-3.5" />
+                  Previous
+                </Button>
+                <Button
+                  variant="outline"
+                  size="sm"
+                  onClick={() => setOffset(offset + pageSize)}
+                  disabled={offset + pageSize >= total}
+                  className="border-border text-foreground hover:bg-secondary bg-transparent"
+                  aria-label="Next page"
+                >
+                  Next
+                  <ChevronRight className="h-3.5 w-3.5" />
+                </Button>
+              </div>
+            </div>
+          )}
         </CardContent>
       </Card>
     </div>